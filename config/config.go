@@ -0,0 +1,470 @@
+// Package config reads the server configuration from the environment (and
+// an optional .env file) once at startup, and validates it
+package config
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// RateLimit allows Requests per Window for a single client (IP or username)
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// SSO holds the Google OAuth2 client; it is disabled when ClientID is empty
+type SSO struct {
+	RedirectURL  string
+	ClientID     string
+	ClientSecret string
+}
+
+// Enabled reports whether Google SSO is configured
+func (s SSO) Enabled() bool {
+	return s.ClientID != ""
+}
+
+// XAPI is the Learning Record Store which receives xAPI statements; it is
+// disabled when Endpoint is empty
+type XAPI struct {
+	Endpoint string
+	Username string
+	Password string
+	// HomePage identifies this application in actor accounts and activity IDs
+	HomePage string
+	// StubAddr, if set, is where an in-memory LRS listens for development
+	StubAddr string
+}
+
+// LTI configures the LTI 1.3 tool
+type LTI struct {
+	// ToolURL is where platforms reach this application
+	ToolURL string
+	// PrivateKeyFile is the PEM RSA key the tool signs with; without one a
+	// key is generated at startup, and platforms must fetch it again
+	PrivateKeyFile string
+	// ModulePage is where a launch lands, with {id} replaced by the module ID
+	ModulePage string
+	// MockAddr, if set, is where a mock platform listens for development
+	MockAddr string
+}
+
+// Discussion configures the filter posts pass before they are saved
+type Discussion struct {
+	// BlockedWords may not appear in threads and replies
+	BlockedWords []string
+	// MaxLinks is how many links a post may contain; negative allows any number
+	MaxLinks int
+}
+
+// Notifications configures how users are told about replies, grades,
+// approvals and deadlines; email is disabled when SMTPAddr is empty
+type Notifications struct {
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+	// BaseURL is where links in notifications point
+	BaseURL string
+	// WebhookHosts are the hosts users may point Slack and Teams webhooks at
+	WebhookHosts []string
+	// DeadlineWindow is how long before a module's deadline learners are reminded
+	DeadlineWindow time.Duration
+	// DeadlineCheckInterval is how often deadlines are checked
+	DeadlineCheckInterval time.Duration
+}
+
+// Events configures the event streams clients keep open
+type Events struct {
+	// StreamTimeout ends each stream before HTTP_WRITE_TIMEOUT would cut it
+	// off; clients reconnect and carry on
+	StreamTimeout time.Duration
+	// HeartbeatInterval is how often an idle stream sends a comment, so that
+	// proxies keep it open
+	HeartbeatInterval time.Duration
+}
+
+// Storage configures where uploaded files are kept: in Bucket on Google
+// Cloud Storage if it is set, otherwise in Dir
+type Storage struct {
+	// Dir must not be inside the static directory, which anyone can read
+	Dir    string
+	Bucket string
+	// SignedURLExpiry is how long a download link from the bucket works
+	SignedURLExpiry time.Duration
+	// ClamAVAddr is the clamd which scans uploads: a unix socket path or
+	// host:port. Uploads are not scanned without one.
+	ClamAVAddr  string
+	ScanTimeout time.Duration
+
+	// MaxUploadSize is the largest file a resumable upload takes, in chunks
+	// of up to ChunkSize
+	MaxUploadSize int64
+	ChunkSize     int64
+	// CourseQuota is how much each course may store, unless an admin gives
+	// it another limit
+	CourseQuota int64
+	// UploadExpiry is how long an upload is kept after its last chunk
+	UploadExpiry              time.Duration
+	UploadExpiryCheckInterval time.Duration
+}
+
+// Config is everything the server reads from its environment
+type Config struct {
+	Port       string
+	SSLEnabled bool
+	CertFile   string
+	KeyFile    string
+
+	DatastoreProjectID string
+	CredentialsFile    string
+
+	StaticDir string
+	SSO       SSO
+	XAPI      XAPI
+	LTI       LTI
+
+	Discussion    Discussion
+	Notifications Notifications
+	Events        Events
+	Storage       Storage
+
+	// RateLimits overrides routes.DefaultRateLimits, keyed by route name
+	RateLimits                map[string]RateLimit
+	TrustedProxyHops          int
+	PermissionRefreshInterval time.Duration
+	SearchRebuildInterval     time.Duration
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	// TransferTimeout replaces ReadTimeout and WriteTimeout for uploads and downloads
+	TransferTimeout time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// Load reads .env if there is one, then the environment, and returns every
+// problem found rather than stopping at the first
+func Load() (*Config, error) {
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error loading .env file: %w", err)
+	}
+
+	var problems []string
+	env := &environment{problems: &problems}
+
+	cfg := &Config{
+		Port:       env.String("PORT", "8000"),
+		SSLEnabled: env.Bool("SSL_ENABLED", false),
+		CertFile:   env.String("CERT_FILE", ""),
+		KeyFile:    env.String("KEY_FILE", ""),
+
+		DatastoreProjectID: env.String("DATASTORE_PROJECT_ID", ""),
+		CredentialsFile:    env.String("GOOGLE_APPLICATION_CREDENTIALS", ""),
+
+		StaticDir: env.String("STATIC_DIR", "./static"),
+		SSO: SSO{
+			RedirectURL:  env.String("REDIRECT_URL", ""),
+			ClientID:     env.String("CLIENT_ID", ""),
+			ClientSecret: env.String("CLIENT_SECRET", ""),
+		},
+
+		XAPI: XAPI{
+			Endpoint: env.String("XAPI_ENDPOINT", ""),
+			Username: env.String("XAPI_USERNAME", ""),
+			Password: env.String("XAPI_PASSWORD", ""),
+			StubAddr: env.String("XAPI_STUB_ADDR", ""),
+		},
+
+		LTI: LTI{
+			PrivateKeyFile: env.String("LTI_PRIVATE_KEY_FILE", ""),
+			ModulePage:     env.String("LTI_MODULE_PAGE", "/app.html?module={id}"),
+			MockAddr:       env.String("LTI_MOCK_ADDR", ""),
+		},
+
+		Discussion: Discussion{
+			BlockedWords: strings.FieldsFunc(env.String("DISCUSSION_BLOCKED_WORDS", ""), func(r rune) bool { return r == ',' }),
+			MaxLinks:     env.Int("DISCUSSION_MAX_LINKS", 3),
+		},
+
+		Notifications: Notifications{
+			SMTPAddr:              env.String("SMTP_ADDR", ""),
+			SMTPUsername:          env.String("SMTP_USERNAME", ""),
+			SMTPPassword:          env.String("SMTP_PASSWORD", ""),
+			From:                  env.String("NOTIFICATION_FROM", "noreply@localhost"),
+			WebhookHosts:          strings.FieldsFunc(env.String("NOTIFICATION_WEBHOOK_HOSTS", "hooks.slack.com,webhook.office.com,logic.azure.com"), func(r rune) bool { return r == ',' }),
+			DeadlineWindow:        env.Duration("DEADLINE_REMINDER_WINDOW", 24*time.Hour),
+			DeadlineCheckInterval: env.Duration("DEADLINE_CHECK_INTERVAL", 15*time.Minute),
+		},
+
+		Events: Events{
+			StreamTimeout:     env.Duration("EVENTS_STREAM_TIMEOUT", 50*time.Second),
+			HeartbeatInterval: env.Duration("EVENTS_HEARTBEAT_INTERVAL", 15*time.Second),
+		},
+
+		Storage: Storage{
+			Dir:             env.String("STORAGE_DIR", "./uploads"),
+			Bucket:          env.String("STORAGE_BUCKET", ""),
+			SignedURLExpiry: env.Duration("STORAGE_SIGNED_URL_EXPIRY", 15*time.Minute),
+			ClamAVAddr:      env.String("CLAMAV_ADDR", ""),
+			ScanTimeout:     env.Duration("SCAN_TIMEOUT", time.Minute),
+
+			MaxUploadSize:             env.Size("UPLOAD_MAX_SIZE", 2<<30),
+			ChunkSize:                 env.Size("UPLOAD_CHUNK_SIZE", 8<<20),
+			CourseQuota:               env.Size("COURSE_STORAGE_QUOTA", 20<<30),
+			UploadExpiry:              env.Duration("UPLOAD_EXPIRY", 24*time.Hour),
+			UploadExpiryCheckInterval: env.Duration("UPLOAD_EXPIRY_CHECK_INTERVAL", time.Hour),
+		},
+
+		TrustedProxyHops:          env.Int("TRUSTED_PROXY_HOPS", 0),
+		PermissionRefreshInterval: env.Duration("PERMISSION_REFRESH_INTERVAL", time.Minute),
+		SearchRebuildInterval:     env.Duration("SEARCH_REBUILD_INTERVAL", 15*time.Minute),
+
+		ReadHeaderTimeout: env.Duration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       env.Duration("HTTP_READ_TIMEOUT", time.Minute),
+		WriteTimeout:      env.Duration("HTTP_WRITE_TIMEOUT", time.Minute),
+		TransferTimeout:   env.Duration("HTTP_TRANSFER_TIMEOUT", time.Hour),
+		IdleTimeout:       env.Duration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		ShutdownTimeout:   env.Duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+	}
+
+	cfg.XAPI.HomePage = env.String("XAPI_HOME_PAGE", "http://localhost:"+cfg.Port)
+	cfg.LTI.ToolURL = env.String("LTI_TOOL_URL", "http://localhost:"+cfg.Port)
+	cfg.Notifications.BaseURL = env.String("NOTIFICATION_BASE_URL", "http://localhost:"+cfg.Port)
+	// with only a stub, statements go to the stub
+	if cfg.XAPI.Endpoint == "" && cfg.XAPI.StubAddr != "" {
+		host, port, _ := strings.Cut(cfg.XAPI.StubAddr, ":")
+		if host == "" {
+			host = "localhost"
+		}
+		cfg.XAPI.Endpoint = "http://" + host + ":" + port
+	}
+
+	rateLimits, err := ParseRateLimits(env.String("RATE_LIMITS", ""))
+	if err != nil {
+		problems = append(problems, "RATE_LIMITS: "+err.Error())
+	}
+	cfg.RateLimits = rateLimits
+
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) validate() []string {
+	var problems []string
+
+	if _, err := strconv.ParseUint(cfg.Port, 10, 16); err != nil {
+		problems = append(problems, "PORT must be a port number")
+	}
+	if cfg.DatastoreProjectID == "" {
+		problems = append(problems, "DATASTORE_PROJECT_ID is required")
+	}
+	if cfg.CredentialsFile == "" {
+		problems = append(problems, "GOOGLE_APPLICATION_CREDENTIALS is required")
+	}
+	if cfg.SSLEnabled && (cfg.CertFile == "" || cfg.KeyFile == "") {
+		problems = append(problems, "CERT_FILE and KEY_FILE are required when SSL_ENABLED is true")
+	}
+	if cfg.SSO.Enabled() && (cfg.SSO.ClientSecret == "" || cfg.SSO.RedirectURL == "") {
+		problems = append(problems, "CLIENT_SECRET and REDIRECT_URL are required when CLIENT_ID is set")
+	}
+	for name, value := range map[string]string{"XAPI_ENDPOINT": cfg.XAPI.Endpoint, "XAPI_HOME_PAGE": cfg.XAPI.HomePage, "LTI_TOOL_URL": cfg.LTI.ToolURL,
+		"NOTIFICATION_BASE_URL": cfg.Notifications.BaseURL} {
+		if u, err := url.Parse(value); value != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+			problems = append(problems, name+" must be an http(s) URL")
+		}
+	}
+	if !strings.Contains(cfg.LTI.ModulePage, "{id}") {
+		problems = append(problems, "LTI_MODULE_PAGE must contain {id}")
+	}
+	if cfg.Events.StreamTimeout >= cfg.WriteTimeout {
+		problems = append(problems, "EVENTS_STREAM_TIMEOUT must be shorter than HTTP_WRITE_TIMEOUT")
+	}
+	if cfg.TransferTimeout < cfg.ReadTimeout || cfg.TransferTimeout < cfg.WriteTimeout {
+		problems = append(problems, "HTTP_TRANSFER_TIMEOUT must not be shorter than HTTP_READ_TIMEOUT or HTTP_WRITE_TIMEOUT")
+	}
+	if cfg.Storage.Bucket == "" && inside(cfg.Storage.Dir, cfg.StaticDir) {
+		problems = append(problems, "STORAGE_DIR must not be inside STATIC_DIR")
+	}
+	if cfg.Storage.SignedURLExpiry > 7*24*time.Hour {
+		problems = append(problems, "STORAGE_SIGNED_URL_EXPIRY must be at most 7 days")
+	}
+	for name, size := range map[string]int64{"UPLOAD_MAX_SIZE": cfg.Storage.MaxUploadSize, "UPLOAD_CHUNK_SIZE": cfg.Storage.ChunkSize,
+		"COURSE_STORAGE_QUOTA": cfg.Storage.CourseQuota} {
+		if size <= 0 {
+			problems = append(problems, name+" must be positive")
+		}
+	}
+	if cfg.Storage.ChunkSize < 1<<20 {
+		problems = append(problems, "UPLOAD_CHUNK_SIZE must be at least 1MB")
+	}
+	if cfg.Storage.ChunkSize > cfg.Storage.MaxUploadSize {
+		problems = append(problems, "UPLOAD_CHUNK_SIZE must not be larger than UPLOAD_MAX_SIZE")
+	}
+	if cfg.TrustedProxyHops < 0 {
+		problems = append(problems, "TRUSTED_PROXY_HOPS must not be negative")
+	}
+
+	for name, d := range map[string]time.Duration{
+		"PERMISSION_REFRESH_INTERVAL":  cfg.PermissionRefreshInterval,
+		"SEARCH_REBUILD_INTERVAL":      cfg.SearchRebuildInterval,
+		"DEADLINE_REMINDER_WINDOW":     cfg.Notifications.DeadlineWindow,
+		"DEADLINE_CHECK_INTERVAL":      cfg.Notifications.DeadlineCheckInterval,
+		"EVENTS_STREAM_TIMEOUT":        cfg.Events.StreamTimeout,
+		"EVENTS_HEARTBEAT_INTERVAL":    cfg.Events.HeartbeatInterval,
+		"STORAGE_SIGNED_URL_EXPIRY":    cfg.Storage.SignedURLExpiry,
+		"SCAN_TIMEOUT":                 cfg.Storage.ScanTimeout,
+		"UPLOAD_EXPIRY":                cfg.Storage.UploadExpiry,
+		"UPLOAD_EXPIRY_CHECK_INTERVAL": cfg.Storage.UploadExpiryCheckInterval,
+		"HTTP_READ_HEADER_TIMEOUT":     cfg.ReadHeaderTimeout,
+		"HTTP_READ_TIMEOUT":            cfg.ReadTimeout,
+		"HTTP_WRITE_TIMEOUT":           cfg.WriteTimeout,
+		"HTTP_TRANSFER_TIMEOUT":        cfg.TransferTimeout,
+		"HTTP_IDLE_TIMEOUT":            cfg.IdleTimeout,
+		"SHUTDOWN_TIMEOUT":             cfg.ShutdownTimeout,
+	} {
+		if d <= 0 {
+			problems = append(problems, name+" must be positive")
+		}
+	}
+	return problems
+}
+
+// inside reports whether dir is dir or below parent
+func inside(dir string, parent string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	parent, err = filepath.Abs(parent)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(parent, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ParseRateLimits reads limits in the form "/login_POST=10/1m,/genetic_POST=2/30s"
+func ParseRateLimits(s string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, spec, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid rate limit %q: expected route=requests/window", entry)
+		}
+
+		count, window, found := strings.Cut(spec, "/")
+		if !found {
+			return nil, fmt.Errorf("invalid rate limit %q: expected requests/window", entry)
+		}
+
+		requests, err := strconv.Atoi(count)
+		if err != nil || requests <= 0 {
+			return nil, fmt.Errorf("invalid request count in rate limit %q", entry)
+		}
+
+		duration, err := time.ParseDuration(window)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid window in rate limit %q", entry)
+		}
+
+		limits[strings.TrimSpace(name)] = RateLimit{Requests: requests, Window: duration}
+	}
+	return limits, nil
+}
+
+// environment reads typed variables, collecting parse errors
+type environment struct {
+	problems *[]string
+}
+
+func (e *environment) String(name string, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (e *environment) Bool(name string, fallback bool) bool {
+	s := e.String(name, "")
+	if s == "" {
+		return fallback
+	}
+	value, err := strconv.ParseBool(s)
+	if err != nil {
+		*e.problems = append(*e.problems, name+" must be true or false")
+		return fallback
+	}
+	return value
+}
+
+func (e *environment) Int(name string, fallback int) int {
+	s := e.String(name, "")
+	if s == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		*e.problems = append(*e.problems, name+" must be a whole number")
+		return fallback
+	}
+	return value
+}
+
+func (e *environment) Duration(name string, fallback time.Duration) time.Duration {
+	s := e.String(name, "")
+	if s == "" {
+		return fallback
+	}
+	value, err := time.ParseDuration(s)
+	if err != nil {
+		*e.problems = append(*e.problems, name+" must be a duration such as 30s or 1m")
+		return fallback
+	}
+	return value
+}
+
+// byteUnits are the suffixes Size understands, largest first
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+// Size reads a number of bytes, such as 8MB, 2GB or 4096
+func (e *environment) Size(name string, fallback int64) int64 {
+	s := e.String(name, "")
+	if s == "" {
+		return fallback
+	}
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(s), u.suffix) {
+			s, unit = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.size
+			break
+		}
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || value > math.MaxInt64/unit {
+		*e.problems = append(*e.problems, name+" must be a size such as 8MB or 2GB")
+		return fallback
+	}
+	return value * unit
+}
@@ -28,9 +28,7 @@ func (g *GeneticHandler) RunGenetic(w http.ResponseWriter, r *http.Request) {
 	resultSet := GeneticHandlerResponse{}
 
 	// Get the parameters from the form request
-	err := json.NewDecoder(r.Body).Decode(&genetic)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !DecodeJSON(w, r, &genetic) {
 		return
 	}
 
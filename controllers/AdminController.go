@@ -4,9 +4,6 @@ import (
 	"encoding/json"
 	"net/http"
 	"restAPI/models"
-	"strconv"
-
-	"github.com/gorilla/mux"
 )
 
 // AdminHandler handles administrative operations
@@ -33,51 +30,47 @@ func NewAdminHandler(userRepository models.UserRepository, courseRepository mode
 
 // GetSystemStats retrieves high-level system statistics
 func (h *AdminHandler) GetSystemStats(w http.ResponseWriter, r *http.Request) {
-	// Get counts of various entities
-	users, err := h.userRepository.GetAllUsers()
+	// Count users a page at a time: active users have completed at least one module
+	users, activeUsers, completedModules := 0, 0, 0
+	err := EachPage(models.QueryOptions{}, h.userRepository.ListUsers, func(page []*models.User) error {
+		for _, user := range page {
+			users++
+			if len(user.Modules) > 0 {
+				activeUsers++
+			}
+			completedModules += len(user.Modules)
+		}
+		return nil
+	})
 	if err != nil {
-		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve users", http.StatusInternalServerError)
 		return
 	}
 
-	courses, err := h.courseRepository.GetAllCourses()
+	courses, err := h.courseRepository.CountCourses(models.QueryOptions{})
 	if err != nil {
-		http.Error(w, "Failed to retrieve courses", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve courses", http.StatusInternalServerError)
 		return
 	}
 
 	modules, err := h.moduleRepository.GetAllModules()
 	if err != nil {
-		http.Error(w, "Failed to retrieve modules", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve modules", http.StatusInternalServerError)
 		return
 	}
 
-	elements, err := h.elementRepository.GetAllElements()
+	elements, err := h.elementRepository.CountElements(models.QueryOptions{})
 	if err != nil {
-		http.Error(w, "Failed to retrieve elements", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve elements", http.StatusInternalServerError)
 		return
 	}
 
-	projects, err := h.projectRepository.GetAllProjects()
+	projects, err := h.projectRepository.CountProjects(models.QueryOptions{})
 	if err != nil {
-		http.Error(w, "Failed to retrieve projects", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve projects", http.StatusInternalServerError)
 		return
 	}
 
-	// Count active users (users who have completed at least one module)
-	activeUsers := 0
-	for _, user := range users {
-		if len(user.Modules) > 0 {
-			activeUsers++
-		}
-	}
-
-	// Count completed modules
-	completedModules := 0
-	for _, user := range users {
-		completedModules += len(user.Modules)
-	}
-
 	// Count modules by purpose (based on properties)
 	quizModules := 0
 	projectModules := 0
@@ -101,11 +94,11 @@ func (h *AdminHandler) GetSystemStats(w http.ResponseWriter, r *http.Request) {
 	// Prepare stats
 	stats := map[string]interface{}{
 		"users": map[string]interface{}{
-			"total":  len(users),
+			"total":  users,
 			"active": activeUsers,
 		},
 		"courses": map[string]interface{}{
-			"total": len(courses),
+			"total": courses,
 		},
 		"modules": map[string]interface{}{
 			"total":     len(modules),
@@ -115,10 +108,10 @@ func (h *AdminHandler) GetSystemStats(w http.ResponseWriter, r *http.Request) {
 			"completed": completedModules,
 		},
 		"elements": map[string]interface{}{
-			"total": len(elements),
+			"total": elements,
 		},
 		"projects": map[string]interface{}{
-			"total": len(projects),
+			"total": projects,
 		},
 	}
 
@@ -129,17 +122,15 @@ func (h *AdminHandler) GetSystemStats(w http.ResponseWriter, r *http.Request) {
 
 // GetUserStats retrieves statistics for a specific user
 func (h *AdminHandler) GetUserStats(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID, err := strconv.ParseInt(vars["id"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	userID, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
 	// Get the user
 	user, err := h.userRepository.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		WriteError(w, "User not found", http.StatusNotFound)
 		return
 	}
 
@@ -187,17 +178,15 @@ func (h *AdminHandler) GetUserStats(w http.ResponseWriter, r *http.Request) {
 
 // GetCourseStats retrieves statistics for a specific course
 func (h *AdminHandler) GetCourseStats(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	courseID, err := strconv.ParseInt(vars["id"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid course ID", http.StatusBadRequest)
+	courseID, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
 	// Get the course
 	course, err := h.courseRepository.GetCourseByID(courseID)
 	if err != nil {
-		http.Error(w, "Course not found", http.StatusNotFound)
+		WriteError(w, "Course not found", http.StatusNotFound)
 		return
 	}
 
@@ -207,43 +196,43 @@ func (h *AdminHandler) GetCourseStats(w http.ResponseWriter, r *http.Request) {
 		modules = []*models.Module{}
 	}
 
-	// Get all users
-	users, err := h.userRepository.GetAllUsers()
-	if err != nil {
-		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
-		return
-	}
-
 	// Count users enrolled in this course
 	enrolledUsers := 0
 	completedUsers := 0
 	moduleCompletions := make(map[int64]int)
 
-	for _, user := range users {
-		userEnrolled := false
-		userCompletedAll := true
-
-		for _, module := range user.Modules {
-			// Check if module belongs to this course
-			for _, courseModule := range modules {
-				if module.ModuleID == courseModule.KeyID {
-					userEnrolled = true
-					moduleCompletions[module.ModuleID]++
-
-					// Check if user passed the module
-					if module.Score < courseModule.MinPassing {
-						userCompletedAll = false
+	err = EachPage(models.QueryOptions{}, h.userRepository.ListUsers, func(users []*models.User) error {
+		for _, user := range users {
+			userEnrolled := false
+			userCompletedAll := true
+
+			for _, module := range user.Modules {
+				// Check if module belongs to this course
+				for _, courseModule := range modules {
+					if module.ModuleID == courseModule.KeyID {
+						userEnrolled = true
+						moduleCompletions[module.ModuleID]++
+
+						// Check if user passed the module
+						if module.Score < courseModule.MinPassing {
+							userCompletedAll = false
+						}
 					}
 				}
 			}
-		}
 
-		if userEnrolled {
-			enrolledUsers++
-			if userCompletedAll && len(modules) > 0 {
-				completedUsers++
+			if userEnrolled {
+				enrolledUsers++
+				if userCompletedAll && len(modules) > 0 {
+					completedUsers++
+				}
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		WriteError(w, "Failed to retrieve users", http.StatusInternalServerError)
+		return
 	}
 
 	// Prepare stats
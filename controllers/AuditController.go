@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"restAPI/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fields never written to the audit log
+var redactedAuditFields = map[string]bool{
+	"password": true,
+}
+
+// Auditor appends entries to the audit log on behalf of other handlers
+type Auditor struct {
+	auditRepository models.AuditRepository
+}
+
+// NewAuditor creates a new Auditor
+func NewAuditor(auditRepository models.AuditRepository) *Auditor {
+	return &Auditor{auditRepository: auditRepository}
+}
+
+// Record appends an entry for the request's real and effective users.
+// Failing to write the audit log is logged, not returned to the client.
+func (a *Auditor) Record(r *http.Request, action string, targetKind string, targetID int64) {
+	a.RecordChange(r, action, targetKind, targetID, nil, nil)
+}
+
+// RecordChange appends an entry along with the fields that differ between
+// before and after (either may be nil, for creations and deletions)
+func (a *Auditor) RecordChange(r *http.Request, action string, targetKind string, targetID int64, before interface{}, after interface{}) {
+	entry := &models.AuditEntry{
+		Action:     action,
+		TargetKind: targetKind,
+		TargetID:   targetID,
+		IPAddress:  ClientIP(r),
+		// Datastore keeps microseconds, and the hash must survive the round trip
+		Timestamp: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	entry.Before, entry.After = auditDiff(before, after)
+
+	if user := RealUser(r); user != nil {
+		entry.Actor = user.Username
+	}
+	if user := CurrentUser(r); user != nil && user.Username != entry.Actor {
+		entry.OnBehalfOf = user.Username
+	}
+
+	if _, err := a.auditRepository.AppendAuditEntry(entry); err != nil {
+		log.Printf("Error writing audit entry %s: %v", action, err)
+	}
+}
+
+// auditDiff returns the JSON of the top-level fields which differ between
+// before and after, as they were and as they became
+func auditDiff(before interface{}, after interface{}) (string, string) {
+	beforeFields, afterFields := auditFields(before), auditFields(after)
+
+	changedBefore := map[string]interface{}{}
+	changedAfter := map[string]interface{}{}
+	for name, value := range beforeFields {
+		if other, ok := afterFields[name]; !ok || !reflect.DeepEqual(value, other) {
+			changedBefore[name] = value
+		}
+	}
+	for name, value := range afterFields {
+		if other, ok := beforeFields[name]; !ok || !reflect.DeepEqual(value, other) {
+			changedAfter[name] = value
+		}
+	}
+
+	return auditJSON(changedBefore), auditJSON(changedAfter)
+}
+
+// auditFields flattens a value to its JSON fields, without redacted ones
+func auditFields(value interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if value == nil || reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil() {
+		return fields
+	}
+
+	content, err := json.Marshal(value)
+	if err != nil || json.Unmarshal(content, &fields) != nil {
+		return map[string]interface{}{}
+	}
+	for name := range fields {
+		if redactedAuditFields[name] {
+			delete(fields, name)
+		}
+	}
+	return fields
+}
+
+func auditJSON(fields map[string]interface{}) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	content, _ := json.Marshal(fields)
+	return string(content)
+}
+
+// TrustedProxyHops is the number of trusted proxies in front of the server
+// which append to X-Forwarded-For; the header is ignored while it is 0
+var TrustedProxyHops int
+
+// ClientIP returns the remote address, or the address reported by the
+// trusted proxies in X-Forwarded-For when TrustedProxyHops is set. Entries
+// left of those the proxies appended come from the client and are ignored.
+func ClientIP(r *http.Request) string {
+	if TrustedProxyHops > 0 {
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(header, ",") {
+				if hop = strings.TrimSpace(hop); hop != "" {
+					hops = append(hops, hop)
+				}
+			}
+		}
+		if len(hops) >= TrustedProxyHops {
+			return hops[len(hops)-TrustedProxyHops]
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AuditHandler lets admins query, export and verify the audit log.
+// There is deliberately no way to modify or delete entries.
+type AuditHandler struct {
+	auditRepository models.AuditRepository
+}
+
+// NewAuditHandler ..
+func NewAuditHandler(auditRepository models.AuditRepository) *AuditHandler {
+	return &AuditHandler{auditRepository: auditRepository}
+}
+
+// AuditVerification is the result of walking the hash chain
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Entries  int    `json:"entries"`
+	HeadSeq  int64  `json:"head_seq"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// VerifyAuditChain checks that entries (in order) are numbered without gaps,
+// that each links to and hashes as recorded, and that the last one is the head
+func VerifyAuditChain(entries []*models.AuditEntry, head *models.AuditHead) AuditVerification {
+	result := AuditVerification{Valid: true, Entries: len(entries), HeadSeq: head.Seq}
+
+	fail := func(seq int64, reason string) AuditVerification {
+		result.Valid = false
+		result.BrokenAt = seq
+		result.Reason = reason
+		return result
+	}
+
+	prevHash := ""
+	for i, entry := range entries {
+		seq := int64(i + 1)
+		if entry.Seq != seq {
+			return fail(seq, fmt.Sprintf("expected entry %d, found %d", seq, entry.Seq))
+		}
+		if entry.PrevHash != prevHash {
+			return fail(seq, "previous hash does not match")
+		}
+		if entry.ComputeHash() != entry.Hash {
+			return fail(seq, "entry content does not match its hash")
+		}
+		prevHash = entry.Hash
+	}
+
+	if head.Seq != int64(len(entries)) || head.Hash != prevHash {
+		return fail(int64(len(entries))+1, "chain does not end at the recorded head")
+	}
+	return result
+}
+
+// parseAuditFilter reads actor, action, target_kind, target_id, since and
+// until (RFC 3339) from the query string
+func parseAuditFilter(r *http.Request) (models.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		Actor:      query.Get("actor"),
+		Action:     query.Get("action"),
+		TargetKind: query.Get("target_kind"),
+	}
+
+	if s := query.Get("target_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid target_id")
+		}
+		filter.TargetID = id
+	}
+
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if s := query.Get(name); s != "" {
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s, expected RFC 3339", name)
+			}
+			*t = parsed
+		}
+	}
+	return filter, nil
+}
+
+// GetAuditLog returns the entries matching the query string, oldest first
+func (h *AuditHandler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.auditRepository.GetAuditEntries(filter)
+	if err != nil {
+		WriteError(w, "Failed to retrieve audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// ExportAuditLog downloads the matching entries as JSON, or CSV with ?format=csv
+func (h *AuditHandler) ExportAuditLog(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.auditRepository.GetAuditEntries(filter)
+	if err != nil {
+		WriteError(w, "Failed to retrieve audit log", http.StatusInternalServerError)
+		return
+	}
+
+	filename := "audit-" + time.Now().UTC().Format("20060102T150405Z")
+	if r.URL.Query().Get("format") != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename+".json")
+		json.NewEncoder(w).Encode(entries)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename+".csv")
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"seq", "timestamp", "actor", "on_behalf_of", "action", "target_kind", "target_id",
+		"before", "after", "ip_address", "prev_hash", "hash"})
+	for _, e := range entries {
+		out.Write([]string{
+			strconv.FormatInt(e.Seq, 10), e.Timestamp.UTC().Format(time.RFC3339Nano), e.Actor, e.OnBehalfOf,
+			e.Action, e.TargetKind, strconv.FormatInt(e.TargetID, 10), e.Before, e.After, e.IPAddress,
+			e.PrevHash, e.Hash,
+		})
+	}
+	out.Flush()
+}
+
+// VerifyAuditLog walks the whole chain and reports where it is broken, if anywhere
+func (h *AuditHandler) VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.auditRepository.GetAllAuditEntries()
+	if err != nil {
+		WriteError(w, "Failed to retrieve audit log", http.StatusInternalServerError)
+		return
+	}
+
+	head, err := h.auditRepository.GetAuditHead()
+	if err != nil {
+		WriteError(w, "Failed to retrieve audit head", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VerifyAuditChain(entries, head))
+}
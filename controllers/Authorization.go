@@ -0,0 +1,523 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"restAPI/models"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Course-level roles, as stored in UserCourse.Role, from least to most
+// privileged. The user in Course.OwnerID is always the course owner.
+const (
+	CourseRoleLearner    = "learner"
+	CourseRoleTA         = "ta"
+	CourseRoleInstructor = "instructor"
+	CourseRoleOwner      = "owner"
+)
+
+// rank of each course role; "student" and "teaching_assistant" are accepted
+// spellings of learner and ta
+var courseRoleRank = map[string]int{
+	CourseRoleLearner:    1,
+	"student":            1,
+	CourseRoleTA:         2,
+	"teaching_assistant": 2,
+	CourseRoleInstructor: 3,
+	CourseRoleOwner:      4,
+}
+
+// Resource describes what a request touches: the courses the content
+// belongs to, the user who owns the content, and the learner whose
+// records are read or written
+type Resource struct {
+	CourseIDs []int64
+	OwnerID   int64
+	LearnerID int64
+}
+
+// PermissionLookup returns the named permissions granted by a user's roles
+type PermissionLookup interface {
+	GetPermissions(roles []string) map[string]bool
+}
+
+// ResourceResolver finds the Resource a request refers to
+type ResourceResolver func(a *Authorizer, r *http.Request) (Resource, error)
+
+// Authorizer checks ownership and course-level roles before a handler
+// reads or mutates course content and learner records
+type Authorizer struct {
+	courseRepository        models.CourseRepository
+	moduleRepository        models.ModuleRepository
+	elementRepository       models.ElementRepository
+	moduleElementRepository models.ModuleElementRepository
+	userCourseRepository    models.UserCourseRepository
+	threadRepository        models.ThreadRepository
+	replyRepository         models.ReplyRepository
+	messageRepository       models.MessageRepository
+	projectRepository       models.ProjectRepository
+	permissions             PermissionLookup
+}
+
+// NewAuthorizer creates a new Authorizer
+func NewAuthorizer(courseRepository models.CourseRepository, moduleRepository models.ModuleRepository,
+	elementRepository models.ElementRepository, moduleElementRepository models.ModuleElementRepository,
+	userCourseRepository models.UserCourseRepository, threadRepository models.ThreadRepository,
+	replyRepository models.ReplyRepository, messageRepository models.MessageRepository, projectRepository models.ProjectRepository,
+	permissions PermissionLookup) *Authorizer {
+	return &Authorizer{
+		courseRepository:        courseRepository,
+		moduleRepository:        moduleRepository,
+		elementRepository:       elementRepository,
+		moduleElementRepository: moduleElementRepository,
+		userCourseRepository:    userCourseRepository,
+		threadRepository:        threadRepository,
+		replyRepository:         replyRepository,
+		messageRepository:       messageRepository,
+		projectRepository:       projectRepository,
+		permissions:             permissions,
+	}
+}
+
+// authorizationCache holds the lookups and decisions made for one request
+type authorizationCache struct {
+	mu          sync.Mutex
+	admin       *bool
+	courseRoles map[int64]string
+	decisions   map[string]bool
+}
+
+const authorizationCacheKey contextKey = "authorization"
+
+// Middleware attaches a fresh decision cache to every request
+func (a *Authorizer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cache := &authorizationCache{courseRoles: map[int64]string{}, decisions: map[string]bool{}}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authorizationCacheKey, cache)))
+	})
+}
+
+func requestCache(r *http.Request) *authorizationCache {
+	if cache, ok := r.Context().Value(authorizationCacheKey).(*authorizationCache); ok {
+		return cache
+	}
+	return &authorizationCache{courseRoles: map[int64]string{}, decisions: map[string]bool{}}
+}
+
+// Require only calls next if the current user is an admin, owns the
+// resource, is the learner whose records are requested, or holds at
+// least minRole in one of the resource's courses
+func (a *Authorizer) Require(minRole string, resolve ResourceResolver, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := CurrentUser(r)
+		if user == nil {
+			WriteError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		resource, err := resolve(a, r)
+		if err != nil {
+			WriteError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if !a.Allowed(r, user, minRole, resource) {
+			WriteError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdmin only calls next if the current user is an admin, e.g. for
+// listings across every course
+func (a *Authorizer) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := CurrentUser(r)
+		if user == nil {
+			WriteError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !a.IsAdmin(r, user) {
+			WriteError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Allowed reports whether user may access resource with at least minRole,
+// caching the decision for the rest of the request
+func (a *Authorizer) Allowed(r *http.Request, user *models.User, minRole string, resource Resource) bool {
+	cache := requestCache(r)
+	key := fmt.Sprintf("%d|%s|%v|%d|%d", user.KeyID, minRole, resource.CourseIDs, resource.OwnerID, resource.LearnerID)
+
+	cache.mu.Lock()
+	decision, found := cache.decisions[key]
+	cache.mu.Unlock()
+	if found {
+		return decision
+	}
+
+	decision = a.decide(r, user, minRole, resource)
+
+	cache.mu.Lock()
+	cache.decisions[key] = decision
+	cache.mu.Unlock()
+	return decision
+}
+
+func (a *Authorizer) decide(r *http.Request, user *models.User, minRole string, resource Resource) bool {
+	if a.IsAdmin(r, user) {
+		return true
+	}
+
+	if resource.OwnerID != 0 && resource.OwnerID == user.KeyID {
+		return true
+	}
+
+	if resource.LearnerID != 0 && resource.LearnerID == user.KeyID {
+		return true
+	}
+
+	for _, courseID := range resource.CourseIDs {
+		if courseRoleRank[a.CourseRole(r, user, courseID)] >= courseRoleRank[minRole] {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the user's roles grant every permission
+func (a *Authorizer) IsAdmin(r *http.Request, user *models.User) bool {
+	cache := requestCache(r)
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.admin == nil {
+		admin := a.permissions.GetPermissions(user.GetRoles())[models.PermissionAll]
+		cache.admin = &admin
+	}
+	return *cache.admin
+}
+
+// CourseRole returns the user's role in the course, or "" if they have none
+func (a *Authorizer) CourseRole(r *http.Request, user *models.User, courseID int64) string {
+	cache := requestCache(r)
+	cache.mu.Lock()
+	role, found := cache.courseRoles[courseID]
+	cache.mu.Unlock()
+	if found {
+		return role
+	}
+
+	if course, err := a.courseRepository.GetCourseByID(courseID); err == nil && course.OwnerID == user.KeyID {
+		role = CourseRoleOwner
+	} else if userCourse, err := a.userCourseRepository.GetUserCourseByUserIDAndCourseID(user.KeyID, courseID); err == nil {
+		role = enrolledRole(userCourse)
+	}
+
+	cache.mu.Lock()
+	cache.courseRoles[courseID] = role
+	cache.mu.Unlock()
+	return role
+}
+
+// EnrolledCourseRoles returns the user's role in every course they are
+// enrolled in, with a single lookup. Courses they own are not included.
+func (a *Authorizer) EnrolledCourseRoles(user *models.User) (map[int64]string, error) {
+	userCourses, err := a.userCourseRepository.GetUserCoursesByUserID(user.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	roles := map[int64]string{}
+	for _, userCourse := range userCourses {
+		roles[userCourse.CourseID] = enrolledRole(userCourse)
+	}
+	return roles, nil
+}
+
+func enrolledRole(userCourse *models.UserCourse) string {
+	role := userCourse.Role
+	if role == "" {
+		role = CourseRoleLearner
+	}
+	// only Course.OwnerID owns a course
+	if role == CourseRoleOwner {
+		role = CourseRoleInstructor
+	}
+	return role
+}
+
+// routeID parses a numeric route variable
+func routeID(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return id, nil
+}
+
+// CourseParam resolves the course named by a route variable
+func CourseParam(name string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		courseID, err := routeID(r, name)
+		if err != nil {
+			return Resource{}, err
+		}
+		if _, err := a.courseRepository.GetCourseByID(courseID); err != nil {
+			return Resource{}, fmt.Errorf("course not found")
+		}
+		return Resource{CourseIDs: []int64{courseID}}, nil
+	}
+}
+
+// ModuleParam resolves the module named by a route variable to its course
+func ModuleParam(name string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		moduleID, err := routeID(r, name)
+		if err != nil {
+			return Resource{}, err
+		}
+		return a.moduleResource(moduleID)
+	}
+}
+
+// moduleResource resolves a module to its course and owner
+func (a *Authorizer) moduleResource(moduleID int64) (Resource, error) {
+	module, err := a.moduleRepository.GetModuleByID(moduleID)
+	if err != nil {
+		return Resource{}, fmt.Errorf("module not found")
+	}
+	return Resource{CourseIDs: []int64{module.CourseID}, OwnerID: module.OwnerID}, nil
+}
+
+// ModuleElementParam resolves the module element named by a route variable
+// to the course and owner of its module
+func ModuleElementParam(name string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		moduleElementID, err := routeID(r, name)
+		if err != nil {
+			return Resource{}, err
+		}
+		moduleElement, err := a.moduleElementRepository.GetModuleElementByID(moduleElementID)
+		if err != nil {
+			return Resource{}, fmt.Errorf("module element not found")
+		}
+		return a.moduleResource(moduleElement.ModuleID)
+	}
+}
+
+// ElementParam resolves the element named by a route variable to the
+// courses of every module it is used in
+func ElementParam(name string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		elementID, err := routeID(r, name)
+		if err != nil {
+			return Resource{}, err
+		}
+		return a.elementResource(elementID)
+	}
+}
+
+// elementResource resolves an element to its owner and the courses of every
+// module it is used in
+func (a *Authorizer) elementResource(elementID int64) (Resource, error) {
+	element, err := a.elementRepository.GetElementByID(elementID)
+	if err != nil {
+		return Resource{}, fmt.Errorf("element not found")
+	}
+
+	resource := Resource{OwnerID: element.OwnerID}
+	modules, _ := a.moduleElementRepository.GetModulesByElementID(elementID)
+	for _, module := range modules {
+		resource.CourseIDs = append(resource.CourseIDs, module.CourseID)
+	}
+	return resource, nil
+}
+
+// ThreadParam resolves the thread named by a route variable to its course
+func ThreadParam(name string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		thread, err := a.routeThread(r, name)
+		if err != nil {
+			return Resource{}, err
+		}
+		return a.moduleCourse(thread.ModuleID), nil
+	}
+}
+
+// ThreadAuthorParam resolves the thread named by a route variable to its
+// course and the thread's author
+func ThreadAuthorParam(name string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		thread, err := a.routeThread(r, name)
+		if err != nil {
+			return Resource{}, err
+		}
+		resource := a.moduleCourse(thread.ModuleID)
+		resource.OwnerID = thread.AuthorID
+		return resource, nil
+	}
+}
+
+func (a *Authorizer) routeThread(r *http.Request, name string) (*models.Thread, error) {
+	threadID, err := routeID(r, name)
+	if err != nil {
+		return nil, err
+	}
+	thread, err := a.threadRepository.GetThreadByID(threadID)
+	if err != nil {
+		return nil, fmt.Errorf("thread not found")
+	}
+	return thread, nil
+}
+
+// ReplyParam resolves the reply named by a route variable to its thread's
+// course and the reply's author
+func ReplyParam(name string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		replyID, err := routeID(r, name)
+		if err != nil {
+			return Resource{}, err
+		}
+		reply, err := a.replyRepository.GetReplyByID(replyID)
+		if err != nil {
+			return Resource{}, fmt.Errorf("reply not found")
+		}
+		thread, err := a.threadRepository.GetThreadByID(reply.ThreadID)
+		if err != nil {
+			return Resource{}, fmt.Errorf("thread not found")
+		}
+		resource := a.moduleCourse(thread.ModuleID)
+		resource.OwnerID = reply.AuthorID
+		return resource, nil
+	}
+}
+
+// MessageParam resolves the message named by a route variable to the course
+// of its conversation; it gives moderators no access to the conversation
+// itself, whose routes only let its participants in
+func MessageParam(name string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		messageID, err := routeID(r, name)
+		if err != nil {
+			return Resource{}, err
+		}
+		message, err := a.messageRepository.GetMessageByID(messageID)
+		if err != nil {
+			return Resource{}, fmt.Errorf("message not found")
+		}
+		conversation, err := a.messageRepository.GetConversationByID(message.ConversationID)
+		if err != nil {
+			return Resource{}, fmt.Errorf("conversation not found")
+		}
+		return Resource{CourseIDs: []int64{conversation.CourseID}}, nil
+	}
+}
+
+// moduleCourse resolves a module to its course, or to nothing if it is gone
+func (a *Authorizer) moduleCourse(moduleID int64) Resource {
+	module, err := a.moduleRepository.GetModuleByID(moduleID)
+	if err != nil {
+		return Resource{}
+	}
+	return Resource{CourseIDs: []int64{module.CourseID}}
+}
+
+// ProjectParam resolves the project named by a route variable to its
+// course and the learner who submitted it
+func ProjectParam(name string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		projectID, err := routeID(r, name)
+		if err != nil {
+			return Resource{}, err
+		}
+		project, err := a.projectRepository.GetProjectByID(projectID)
+		if err != nil {
+			return Resource{}, fmt.Errorf("project not found")
+		}
+		return Resource{CourseIDs: []int64{project.CourseID}, LearnerID: project.UserID}, nil
+	}
+}
+
+// UserCourseParam resolves the enrolment named by a route variable to its course
+func UserCourseParam(name string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		userCourse, err := a.routeUserCourse(r, name)
+		if err != nil {
+			return Resource{}, err
+		}
+		return Resource{CourseIDs: []int64{userCourse.CourseID}}, nil
+	}
+}
+
+// UserCourseLearnerParam resolves the enrolment named by a route variable to
+// its course and the enrolled learner
+func UserCourseLearnerParam(name string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		userCourse, err := a.routeUserCourse(r, name)
+		if err != nil {
+			return Resource{}, err
+		}
+		return Resource{CourseIDs: []int64{userCourse.CourseID}, LearnerID: userCourse.UserID}, nil
+	}
+}
+
+func (a *Authorizer) routeUserCourse(r *http.Request, name string) (*models.UserCourse, error) {
+	userCourseID, err := routeID(r, name)
+	if err != nil {
+		return nil, err
+	}
+	userCourse, err := a.userCourseRepository.GetUserCourseByID(userCourseID)
+	if err != nil {
+		return nil, fmt.Errorf("enrolment not found")
+	}
+	return userCourse, nil
+}
+
+// LearnerParam resolves the learner named by a route variable
+func LearnerParam(name string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		userID, err := routeID(r, name)
+		if err != nil {
+			return Resource{}, err
+		}
+		return Resource{LearnerID: userID}, nil
+	}
+}
+
+// LearnerInCourse resolves a learner's records within a course
+func LearnerInCourse(userParam string, courseParam string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		learner, err := LearnerParam(userParam)(a, r)
+		if err != nil {
+			return Resource{}, err
+		}
+		course, err := CourseParam(courseParam)(a, r)
+		if err != nil {
+			return Resource{}, err
+		}
+		return Resource{CourseIDs: course.CourseIDs, LearnerID: learner.LearnerID}, nil
+	}
+}
+
+// LearnerInModule resolves a learner's records within a module
+func LearnerInModule(userParam string, moduleParam string) ResourceResolver {
+	return func(a *Authorizer, r *http.Request) (Resource, error) {
+		learner, err := LearnerParam(userParam)(a, r)
+		if err != nil {
+			return Resource{}, err
+		}
+		module, err := ModuleParam(moduleParam)(a, r)
+		if err != nil {
+			return Resource{}, err
+		}
+		return Resource{CourseIDs: module.CourseIDs, LearnerID: learner.LearnerID}, nil
+	}
+}
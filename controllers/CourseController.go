@@ -2,9 +2,12 @@ package controllers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"restAPI/models"
-	"strconv"
+	"restAPI/notify"
+	"restAPI/push"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -12,25 +15,35 @@ import (
 // CourseHandler ..
 type CourseHandler struct {
 	courseRepository models.CourseRepository
+	auditor          *Auditor
+	notifier         *notify.Notifier
+	broker           push.Broker
+	authorizer       *Authorizer
 }
 
 // NewCourseHandler ..
-func NewCourseHandler(courseRepository models.CourseRepository) *CourseHandler {
-	return &CourseHandler{courseRepository: courseRepository}
+func NewCourseHandler(courseRepository models.CourseRepository, auditor *Auditor, notifier *notify.Notifier,
+	broker push.Broker, authorizer *Authorizer) *CourseHandler {
+	return &CourseHandler{courseRepository: courseRepository, auditor: auditor, notifier: notifier, broker: broker,
+		authorizer: authorizer}
 }
 
 // add course
 func (c *CourseHandler) CreateCourse(w http.ResponseWriter, r *http.Request) {
 	course := models.Course{}
-	err := json.NewDecoder(r.Body).Decode(&course)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !DecodeJSON(w, r, &course) {
 		return
 	}
 
+	// the creator owns the course, which waits for approval
+	course.OwnerID, course.Approved = 0, false
+	if user := CurrentUser(r); user != nil {
+		course.OwnerID = user.KeyID
+	}
+
 	key, err := c.courseRepository.CreateCourse(&course)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -40,37 +53,50 @@ func (c *CourseHandler) CreateCourse(w http.ResponseWriter, r *http.Request) {
 
 // remove course
 func (c *CourseHandler) DeleteCourse(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	before, _ := c.courseRepository.GetCourseByID(idInt)
 	err := c.courseRepository.DeleteCourse(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "course.delete", "Course", idInt, before, nil)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Course deleted"})
 }
 
-// get all courses
-func (c *CourseHandler) GetAllCourses(w http.ResponseWriter, r *http.Request) {
-	courses, err := c.courseRepository.GetAllCourses()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+// CourseListSpec lists the filters and sort orders of GET /course
+var CourseListSpec = ListSpec{
+	Filters: map[string]FilterParam{
+		"department": StringFilter("Department"),
+		"approved":   BoolFilter("Approved"),
+		"owner":      IDFilter("OwnerID"),
+	},
+	Sorts: map[string]string{
+		"name":       "Name",
+		"department": "Department",
+	},
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(courses)
+// GetAllCourses returns a page of courses, see ServeList
+func (c *CourseHandler) GetAllCourses(w http.ResponseWriter, r *http.Request) {
+	ServeList(w, r, CourseListSpec, c.courseRepository.ListCourses, c.courseRepository.CountCourses)
 }
 
 // get course by id
 func (c *CourseHandler) GetCourseByID(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	course, err := c.courseRepository.GetCourseByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -81,23 +107,44 @@ func (c *CourseHandler) GetCourseByID(w http.ResponseWriter, r *http.Request) {
 // update course
 func (c *CourseHandler) UpdateCourse(w http.ResponseWriter, r *http.Request) {
 
-	var id = mux.Vars(r)["id"]
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+
 	course := models.Course{}
-	err := json.NewDecoder(r.Body).Decode(&course)
+	if !DecodeJSON(w, r, &course) {
+		return
+	}
+
+	before, err := c.courseRepository.GetCourseByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		StorageError(w, err)
 		return
 	}
 
-	//convert id to int64
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	// approval is left to ApproveCourse, and only the owner or an admin
+	// hands the course to another owner
+	course.Approved = before.Approved
+	if course.OwnerID == 0 {
+		course.OwnerID = before.OwnerID
+	}
+	if course.OwnerID != before.OwnerID {
+		user := CurrentUser(r)
+		if user == nil || (user.KeyID != before.OwnerID && !c.authorizer.IsAdmin(r, user)) {
+			WriteError(w, "Only the owner can transfer the course", http.StatusForbidden)
+			return
+		}
+	}
 
 	key, err := c.courseRepository.UpdateCourse(idInt, &course)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "course.update", "Course", idInt, before, &course)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(key)
 }
@@ -106,7 +153,7 @@ func (c *CourseHandler) UpdateCourse(w http.ResponseWriter, r *http.Request) {
 func (c *CourseHandler) GetApprovedCourses(w http.ResponseWriter, r *http.Request) {
 	courses, err := c.courseRepository.GetApprovedCourses()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -118,7 +165,7 @@ func (c *CourseHandler) GetApprovedCourses(w http.ResponseWriter, r *http.Reques
 func (c *CourseHandler) GetUnapprovedCourses(w http.ResponseWriter, r *http.Request) {
 	courses, err := c.courseRepository.GetUnapprovedCourses()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -131,7 +178,7 @@ func (c *CourseHandler) GetCoursesByDepartment(w http.ResponseWriter, r *http.Re
 	var department = mux.Vars(r)["department"]
 	courses, err := c.courseRepository.GetCoursesByDepartment(department)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -141,52 +188,115 @@ func (c *CourseHandler) GetCoursesByDepartment(w http.ResponseWriter, r *http.Re
 
 // ApproveCourse approves a course
 func (c *CourseHandler) ApproveCourse(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 
 	// Get the course first
 	course, err := c.courseRepository.GetCourseByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
 	// Set approved to true
+	before := *course
 	course.Approved = true
 
 	// Update the course
 	key, err := c.courseRepository.UpdateCourse(idInt, course)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "course.approve", "Course", idInt, &before, course)
+	if !before.Approved {
+		c.notifyApproval(r, course)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(key)
 }
 
 // UnapproveeCourse unapproves a course
 func (c *CourseHandler) UnapproveeCourse(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 
 	// Get the course first
 	course, err := c.courseRepository.GetCourseByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
 	// Set approved to false
+	before := *course
 	course.Approved = false
 
 	// Update the course
 	key, err := c.courseRepository.UpdateCourse(idInt, course)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "course.unapprove", "Course", idInt, &before, course)
+	if before.Approved {
+		c.notifyApproval(r, course)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(key)
 }
+
+// Announce pushes an announcement to everyone following the course
+func (c *CourseHandler) Announce(w http.ResponseWriter, r *http.Request) {
+	courseID, ok := ParseID(w, r, "courseId")
+	if !ok {
+		return
+	}
+	announcement := models.Announcement{}
+	if !DecodeJSON(w, r, &announcement) {
+		return
+	}
+	if _, err := c.courseRepository.GetCourseByID(courseID); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	announcement.CourseID = courseID
+	announcement.Author = CurrentUser(r).Username
+	announcement.PostedOn = time.Now()
+	if !Impersonating(r) {
+		c.broker.Publish(push.CourseTopic(courseID), "announcement", &announcement)
+	}
+	c.auditor.RecordChange(r, "course.announce", "Course", courseID, nil, &announcement)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(announcement)
+}
+
+// notifyApproval tells the course's owner that it was approved or withdrawn
+func (c *CourseHandler) notifyApproval(r *http.Request, course *models.Course) {
+	if Impersonating(r) {
+		return
+	}
+	message := notify.Message{
+		UserID: course.OwnerID,
+		Event:  models.EventApproval,
+		Title:  "Your course " + course.Name + " was approved",
+		Body:   "Learners can now find and enrol in " + course.Name + ".",
+		Link:   fmt.Sprintf("/course/%d", course.KeyID),
+	}
+	if !course.Approved {
+		message.Title = "Your course " + course.Name + " is no longer approved"
+		message.Body = course.Name + " is hidden from learners until it is approved again."
+	}
+	c.notifier.Notify(message)
+}
@@ -0,0 +1,360 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"restAPI/coursepack"
+	"restAPI/models"
+	"restAPI/scan"
+	"restAPI/storage"
+	"restAPI/validation"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// largest package accepted by ImportCourse
+const maxCoursePackage = 200 << 20
+
+// where imported media is kept in the blob store; it is served at /media/{name}
+const mediaPrefix = "media"
+
+// CoursePackageHandler exports courses as packages and imports them (see package coursepack)
+type CoursePackageHandler struct {
+	courseRepository        models.CourseRepository
+	moduleRepository        models.ModuleRepository
+	elementRepository       models.ElementRepository
+	moduleElementRepository models.ModuleElementRepository
+	staticDir               string
+	blobs                   storage.BlobStore
+	scanner                 scan.Scanner
+	quarantine              *scan.Quarantine
+	auditor                 *Auditor
+}
+
+// NewCoursePackageHandler ..
+func NewCoursePackageHandler(courseRepository models.CourseRepository, moduleRepository models.ModuleRepository,
+	elementRepository models.ElementRepository, moduleElementRepository models.ModuleElementRepository,
+	staticDir string, blobs storage.BlobStore, scanner scan.Scanner, quarantine *scan.Quarantine, auditor *Auditor) *CoursePackageHandler {
+	return &CoursePackageHandler{
+		courseRepository:        courseRepository,
+		moduleRepository:        moduleRepository,
+		elementRepository:       elementRepository,
+		moduleElementRepository: moduleElementRepository,
+		staticDir:               staticDir,
+		blobs:                   blobs,
+		scanner:                 scanner,
+		quarantine:              quarantine,
+		auditor:                 auditor,
+	}
+}
+
+// CourseImport maps the IDs of an imported package to the entities created for them
+type CourseImport struct {
+	CourseID int64             `json:"course_id"`
+	Modules  map[int64]int64   `json:"modules"`
+	Elements map[int64]int64   `json:"elements"`
+	Media    map[string]string `json:"media"`
+}
+
+// ExportCourse downloads the course, its modules, their elements in order
+// and the elements' media as a zip archive
+func (h *CoursePackageHandler) ExportCourse(w http.ResponseWriter, r *http.Request) {
+	courseID, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	course, err := h.courseRepository.GetCourseByID(courseID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	course.KeyID = courseID
+
+	modules, err := h.moduleRepository.GetAllModulesByCourseID(courseID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	sort.SliceStable(modules, func(i, j int) bool { return modules[i].SortKey < modules[j].SortKey })
+
+	pkg := &coursepack.Package{Course: *course}
+	exported := map[int64]bool{}
+	for _, module := range modules {
+		placements, err := h.moduleElementRepository.GetModuleElementsByModuleID(module.KeyID)
+		if err != nil {
+			StorageError(w, err)
+			return
+		}
+		sort.SliceStable(placements, func(i, j int) bool { return placements[i].SortKey < placements[j].SortKey })
+
+		packaged := coursepack.Module{Module: *module, Elements: []coursepack.Placement{}}
+		for _, placement := range placements {
+			if !exported[placement.ElementID] {
+				element, err := h.elementRepository.GetElementByID(placement.ElementID)
+				if err != nil {
+					StorageError(w, err)
+					return
+				}
+				element.KeyID = placement.ElementID
+				pkg.Elements = append(pkg.Elements, *element)
+				exported[placement.ElementID] = true
+			}
+			packaged.Elements = append(packaged.Elements, coursepack.Placement{ElementID: placement.ElementID, SortKey: placement.SortKey})
+		}
+		pkg.Modules = append(pkg.Modules, packaged)
+	}
+
+	// build the archive first, so that a failure is still reported as JSON
+	ExtendDeadlines(r)
+	var archive bytes.Buffer
+	if err := coursepack.Write(&archive, pkg, h.openMedia); err != nil {
+		log.Printf("Error exporting course %d: %v", courseID, err)
+		WriteError(w, "Unable to export the course", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditor.Record(r, "course.export", "Course", courseID)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="course-%d.zip"`, courseID))
+	archive.WriteTo(w)
+}
+
+// openMedia opens a local media location: imported media from the blob
+// store, anything else under the static directory
+func (h *CoursePackageHandler) openMedia(location string) (io.ReadCloser, error) {
+	if name := strings.TrimPrefix(location, "/"); strings.HasPrefix(name, mediaPrefix+"/") {
+		reader, _, err := h.blobs.Open(context.Background(), name)
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidName) {
+			return nil, fs.ErrNotExist
+		}
+		return reader, err
+	}
+	// cleaning the rooted path keeps it inside the static directory
+	return os.Open(filepath.Join(h.staticDir, filepath.FromSlash(path.Clean(location))))
+}
+
+// GetMedia serves an image or video imported with a course
+func (h *CoursePackageHandler) GetMedia(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	serveBlob(w, r, h.blobs, mediaPrefix+"/"+name, name, false, 0)
+}
+
+// LegacyMedia serves the media earlier versions imported into
+// /uploads/media in the static directory, sandboxed like the media in the
+// blob store
+func LegacyMedia(staticDir string) http.Handler {
+	files := http.FileServer(http.Dir(staticDir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		ExtendDeadlines(r)
+		files.ServeHTTP(w, r)
+	})
+}
+
+// ImportCourse creates a new course from an uploaded package (form field
+// "file"), owned by the current user and not approved. Everything is
+// validated before anything is written, and what was written is removed
+// again if a write fails.
+func (h *CoursePackageHandler) ImportCourse(w http.ResponseWriter, r *http.Request) {
+	ExtendDeadlines(r)
+	r.Body = http.MaxBytesReader(w, r.Body, maxCoursePackage+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		WriteError(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		WriteError(w, "Missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		WriteError(w, "Unable to read file", http.StatusBadRequest)
+		return
+	}
+	// the package's media are served to anyone who can see the course
+	if err := scan.Verify(r.Context(), h.scanner, bytes.NewReader(data)); err != nil {
+		uploadError(w, err)
+		return
+	}
+
+	pkg, media, err := coursepack.Read(data)
+	if err != nil {
+		WriteAPIError(w, http.StatusUnprocessableEntity, APIError{Code: CodeValidationFailed, Message: err.Error()})
+		return
+	}
+
+	var fields []validation.FieldError
+	prefixed := func(prefix string, errs []validation.FieldError) {
+		for _, field := range errs {
+			fields = append(fields, validation.FieldError{Field: prefix + "." + field.Field, Message: field.Message})
+		}
+	}
+	prefixed("course", validation.Struct(&pkg.Course))
+	for i := range pkg.Modules {
+		prefixed(fmt.Sprintf("modules[%d]", i), validation.Struct(&pkg.Modules[i].Module))
+	}
+	for i := range pkg.Elements {
+		prefixed(fmt.Sprintf("elements[%d]", i), validation.Struct(&pkg.Elements[i]))
+	}
+	if len(fields) > 0 {
+		WriteAPIError(w, http.StatusUnprocessableEntity, APIError{Code: CodeValidationFailed, Message: "The package is invalid", Fields: fields})
+		return
+	}
+	// media must be images or videos whose content matches their extension
+	for _, m := range pkg.Media {
+		content := media[m.File]
+		if _, err := scan.Check(bytes.NewReader(content), int64(len(content)), m.File, "media"); err != nil {
+			uploadError(w, err)
+			return
+		}
+	}
+
+	var ownerID int64
+	if user := CurrentUser(r); user != nil {
+		ownerID = user.KeyID
+	}
+	result := CourseImport{Modules: map[int64]int64{}, Elements: map[int64]int64{}, Media: map[string]string{}}
+	undo := &courseImportUndo{h: h}
+
+	// media first, content-addressed so that identical files are shared, and
+	// scanned in quarantine before they can be served
+	for _, m := range pkg.Media {
+		ext := strings.ToLower(path.Ext(m.File))
+		name := mediaPrefix + "/" + m.SHA256 + ext
+		if _, err := h.blobs.Stat(r.Context(), name); errors.Is(err, storage.ErrNotFound) {
+			info := storage.Info{ContentType: scan.Extensions[ext], Filename: m.SHA256 + ext}
+			if err := h.quarantine.Admit(r.Context(), name, bytes.NewReader(media[m.File]), info); err != nil {
+				undo.rollback()
+				uploadError(w, err)
+				return
+			}
+			undo.blobs = append(undo.blobs, name)
+		} else if err != nil {
+			undo.fail(w, err)
+			return
+		}
+		result.Media[m.Location] = "/" + name
+	}
+
+	for _, source := range pkg.Elements {
+		element := source
+		element.KeyID = 0
+		element.OwnerID = ownerID
+		element.ProjectID = 0
+		if location, ok := result.Media[element.ImageLocation]; ok {
+			element.ImageLocation = location
+		}
+		if location, ok := result.Media[element.VideoLocation]; ok {
+			element.VideoLocation = location
+		}
+		key, err := h.elementRepository.CreateElement(&element)
+		if err != nil {
+			undo.fail(w, err)
+			return
+		}
+		undo.elements = append(undo.elements, key.ID)
+		result.Elements[source.KeyID] = key.ID
+	}
+
+	course := pkg.Course
+	course.KeyID = 0
+	course.OwnerID = ownerID
+	course.Approved = false
+	course.Modules = nil
+	courseKey, err := h.courseRepository.CreateCourse(&course)
+	if err != nil {
+		undo.fail(w, err)
+		return
+	}
+	undo.course = courseKey.ID
+	result.CourseID = courseKey.ID
+
+	for _, source := range pkg.Modules {
+		module := source.Module
+		module.KeyID = 0
+		module.CourseID = courseKey.ID
+		module.OwnerID = ownerID
+		module.ThreadIDs = nil
+		key, err := h.moduleRepository.CreateModule(&module)
+		if err != nil {
+			undo.fail(w, err)
+			return
+		}
+		undo.modules = append(undo.modules, key.ID)
+		result.Modules[source.KeyID] = key.ID
+		course.Modules = append(course.Modules, key.ID)
+
+		for _, placement := range source.Elements {
+			meKey, err := h.moduleElementRepository.CreateModuleElement(&models.ModuleElement{
+				ModuleID: key.ID, ElementID: result.Elements[placement.ElementID], SortKey: placement.SortKey,
+			})
+			if err != nil {
+				undo.fail(w, err)
+				return
+			}
+			undo.moduleElements = append(undo.moduleElements, meKey.ID)
+		}
+	}
+
+	if _, err := h.courseRepository.UpdateCourse(courseKey.ID, &course); err != nil {
+		undo.fail(w, err)
+		return
+	}
+
+	h.auditor.RecordChange(r, "course.import", "Course", courseKey.ID, nil, map[string]interface{}{
+		"name": course.Name, "modules": len(result.Modules), "elements": len(result.Elements), "media": len(result.Media),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+// courseImportUndo removes what a failed import wrote
+type courseImportUndo struct {
+	h              *CoursePackageHandler
+	blobs          []string
+	elements       []int64
+	course         int64
+	modules        []int64
+	moduleElements []int64
+}
+
+func (u *courseImportUndo) fail(w http.ResponseWriter, err error) {
+	u.rollback()
+	StorageError(w, err)
+}
+
+func (u *courseImportUndo) rollback() {
+	for _, id := range u.moduleElements {
+		u.h.moduleElementRepository.DeleteModuleElement(id)
+	}
+	for _, id := range u.modules {
+		u.h.moduleRepository.DeleteModule(id)
+	}
+	if u.course != 0 {
+		u.h.courseRepository.DeleteCourse(u.course)
+	}
+	for _, id := range u.elements {
+		u.h.elementRepository.DeleteElement(id)
+	}
+	for _, name := range u.blobs {
+		u.h.blobs.Delete(context.Background(), name)
+	}
+}
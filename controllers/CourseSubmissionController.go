@@ -2,10 +2,9 @@ package controllers
 
 import (
 	"encoding/json"
-	"fmt"
+	"log"
 	"net/http"
 	"restAPI/models"
-	"strconv"
 
 	"github.com/gorilla/mux"
 )
@@ -29,17 +28,16 @@ func (h *CourseSubmissionHandler) SubmitCourse(w http.ResponseWriter, r *http.Re
 	// Get the user ID from the session
 	userID, ok := r.Context().Value("userID").(int64)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		WriteError(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	// Just log the userID to avoid the "unused" error
-	fmt.Printf("User ID submitting course: %d\n", userID)
+	log.Printf("User ID submitting course: %d", userID)
 
 	// Create a new course with pending approval
 	var course models.Course
-	if err := json.NewDecoder(r.Body).Decode(&course); err != nil {
-		http.Error(w, "Invalid course data: "+err.Error(), http.StatusBadRequest)
+	if !DecodeJSON(w, r, &course) {
 		return
 	}
 
@@ -48,14 +46,14 @@ func (h *CourseSubmissionHandler) SubmitCourse(w http.ResponseWriter, r *http.Re
 
 	// Validate department
 	if course.Department == "" {
-		http.Error(w, "Department is required", http.StatusBadRequest)
+		WriteError(w, "Department is required", http.StatusBadRequest)
 		return
 	}
 
 	// Save the new course
 	key, err := h.courseRepo.CreateCourse(&course)
 	if err != nil {
-		http.Error(w, "Failed to create course: "+err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -71,7 +69,7 @@ func (h *CourseSubmissionHandler) SubmitCourse(w http.ResponseWriter, r *http.Re
 func (h *CourseSubmissionHandler) GetPendingCourses(w http.ResponseWriter, r *http.Request) {
 	courses, err := h.courseRepo.GetUnapprovedCourses()
 	if err != nil {
-		http.Error(w, "Failed to get pending courses: "+err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -82,17 +80,15 @@ func (h *CourseSubmissionHandler) GetPendingCourses(w http.ResponseWriter, r *ht
 // ApproveCourse approves a course submission
 func (h *CourseSubmissionHandler) ApproveCourse(w http.ResponseWriter, r *http.Request) {
 	// Get the course ID from the URL
-	vars := mux.Vars(r)
-	courseID, err := strconv.ParseInt(vars["id"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid course ID", http.StatusBadRequest)
+	courseID, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
 	// Get the course
 	course, err := h.courseRepo.GetCourseByID(courseID)
 	if err != nil {
-		http.Error(w, "Course not found: "+err.Error(), http.StatusNotFound)
+		StorageError(w, err)
 		return
 	}
 
@@ -102,7 +98,7 @@ func (h *CourseSubmissionHandler) ApproveCourse(w http.ResponseWriter, r *http.R
 	// Save the updated course
 	_, err = h.courseRepo.UpdateCourse(courseID, course)
 	if err != nil {
-		http.Error(w, "Failed to approve course: "+err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -114,10 +110,8 @@ func (h *CourseSubmissionHandler) ApproveCourse(w http.ResponseWriter, r *http.R
 // RejectCourse rejects a course submission
 func (h *CourseSubmissionHandler) RejectCourse(w http.ResponseWriter, r *http.Request) {
 	// Get the course ID from the URL
-	vars := mux.Vars(r)
-	courseID, err := strconv.ParseInt(vars["id"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid course ID", http.StatusBadRequest)
+	courseID, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
@@ -133,7 +127,7 @@ func (h *CourseSubmissionHandler) RejectCourse(w http.ResponseWriter, r *http.Re
 	// Get the course
 	course, err := h.courseRepo.GetCourseByID(courseID)
 	if err != nil {
-		http.Error(w, "Course not found: "+err.Error(), http.StatusNotFound)
+		StorageError(w, err)
 		return
 	}
 
@@ -143,13 +137,13 @@ func (h *CourseSubmissionHandler) RejectCourse(w http.ResponseWriter, r *http.Re
 	if requestBody.Reason != "" {
 		// You might want to add a RejectionReason field to your Course model
 		// For now, we'll just demonstrate the concept
-		fmt.Printf("Rejection reason: %s\n", requestBody.Reason)
+		log.Printf("Rejection reason: %s", requestBody.Reason)
 	}
 
 	// Save the updated course
 	_, err = h.courseRepo.UpdateCourse(courseID, course)
 	if err != nil {
-		http.Error(w, "Failed to reject course: "+err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -162,7 +156,7 @@ func (h *CourseSubmissionHandler) RejectCourse(w http.ResponseWriter, r *http.Re
 func (h *CourseSubmissionHandler) GetApprovedCourses(w http.ResponseWriter, r *http.Request) {
 	courses, err := h.courseRepo.GetApprovedCourses()
 	if err != nil {
-		http.Error(w, "Failed to get approved courses: "+err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -177,7 +171,7 @@ func (h *CourseSubmissionHandler) GetCoursesByDepartment(w http.ResponseWriter,
 
 	courses, err := h.courseRepo.GetCoursesByDepartment(department)
 	if err != nil {
-		http.Error(w, "Failed to get courses: "+err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
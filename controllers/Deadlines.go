@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransferTimeout is how long an upload or a download may take, in place of
+// the server's HTTP_READ_TIMEOUT and HTTP_WRITE_TIMEOUT
+var TransferTimeout time.Duration
+
+type connContextKey struct{}
+
+// WithConn keeps the connection in the context of its requests, so handlers
+// can move its deadlines; set it as the server's ConnContext
+func WithConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, conn)
+}
+
+// ExtendDeadlines gives a request which uploads or downloads a file
+// TransferTimeout from now to read its body and write its response. The
+// server sets both deadlines again before the next request on the connection.
+func ExtendDeadlines(r *http.Request) {
+	conn, ok := r.Context().Value(connContextKey{}).(net.Conn)
+	if !ok || TransferTimeout <= 0 {
+		return
+	}
+	deadline := time.Now().Add(TransferTimeout)
+	conn.SetReadDeadline(deadline)
+	conn.SetWriteDeadline(deadline)
+}
@@ -57,22 +57,21 @@ func (h *DepartmentHandler) AddDepartment(w http.ResponseWriter, r *http.Request
 		Name string `json:"name"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+	if !DecodeJSON(w, r, &requestBody) {
 		return
 	}
 
 	// Validate department name
 	departmentName := strings.TrimSpace(requestBody.Name)
 	if departmentName == "" {
-		http.Error(w, "Department name cannot be empty", http.StatusBadRequest)
+		WriteError(w, "Department name cannot be empty", http.StatusBadRequest)
 		return
 	}
 
 	// Check if department already exists
 	for _, dept := range h.departments {
 		if strings.ToLower(dept) == strings.ToLower(departmentName) {
-			http.Error(w, "Department already exists", http.StatusConflict)
+			WriteError(w, "Department already exists", http.StatusConflict)
 			return
 		}
 	}
@@ -100,20 +99,20 @@ func (h *DepartmentHandler) DeleteDepartment(w http.ResponseWriter, r *http.Requ
 	// For this example, we'll parse it from the query string
 	id := r.URL.Query().Get("id")
 	if id == "" {
-		http.Error(w, "Department ID is required", http.StatusBadRequest)
+		WriteError(w, "Department ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Convert ID to int
 	var departmentID int
 	if _, err := fmt.Sscanf(id, "%d", &departmentID); err != nil {
-		http.Error(w, "Invalid department ID", http.StatusBadRequest)
+		WriteError(w, "Invalid department ID", http.StatusBadRequest)
 		return
 	}
 
 	// Validate ID
 	if departmentID < 0 || departmentID >= len(h.departments) {
-		http.Error(w, "Department not found", http.StatusNotFound)
+		WriteError(w, "Department not found", http.StatusNotFound)
 		return
 	}
 
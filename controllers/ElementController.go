@@ -4,33 +4,34 @@ import (
 	"encoding/json"
 	"net/http"
 	"restAPI/models"
-	"strconv"
-
-	"github.com/gorilla/mux"
 )
 
 // ElementHandler ..
 type ElementHandler struct {
 	elementRepository models.ElementRepository
+	auditor           *Auditor
 }
 
 // NewElementHandler ..
-func NewElementHandler(elementRepository models.ElementRepository) *ElementHandler {
-	return &ElementHandler{elementRepository: elementRepository}
+func NewElementHandler(elementRepository models.ElementRepository, auditor *Auditor) *ElementHandler {
+	return &ElementHandler{elementRepository: elementRepository, auditor: auditor}
 }
 
 // add element
 func (c *ElementHandler) CreateElement(w http.ResponseWriter, r *http.Request) {
 	element := models.Element{}
-	err := json.NewDecoder(r.Body).Decode(&element)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !DecodeJSON(w, r, &element) {
 		return
 	}
 
+	// the creator owns the element unless an owner was given
+	if user := CurrentUser(r); user != nil && element.OwnerID == 0 {
+		element.OwnerID = user.KeyID
+	}
+
 	key, err := c.elementRepository.CreateElement(&element)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -40,37 +41,49 @@ func (c *ElementHandler) CreateElement(w http.ResponseWriter, r *http.Request) {
 
 // remove element
 func (c *ElementHandler) DeleteElement(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	before, _ := c.elementRepository.GetElementByID(idInt)
 	err := c.elementRepository.DeleteElement(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "element.delete", "Element", idInt, before, nil)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Element deleted"})
 }
 
-// get all elements
-func (c *ElementHandler) GetAllElements(w http.ResponseWriter, r *http.Request) {
-	elements, err := c.elementRepository.GetAllElements()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+// ElementListSpec lists the filters and sort orders of GET /element
+var ElementListSpec = ListSpec{
+	Filters: map[string]FilterParam{
+		"type":  StringFilter("Type"),
+		"owner": IDFilter("OwnerID"),
+	},
+	Sorts: map[string]string{
+		"type": "Type",
+		"text": "Text",
+	},
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(elements)
+// GetAllElements returns a page of elements, see ServeList
+func (c *ElementHandler) GetAllElements(w http.ResponseWriter, r *http.Request) {
+	ServeList(w, r, ElementListSpec, c.elementRepository.ListElements, c.elementRepository.CountElements)
 }
 
 // get element by id
 func (c *ElementHandler) GetElementByID(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	element, err := c.elementRepository.GetElementByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -81,23 +94,31 @@ func (c *ElementHandler) GetElementByID(w http.ResponseWriter, r *http.Request)
 // update element
 func (c *ElementHandler) UpdateElement(w http.ResponseWriter, r *http.Request) {
 
-	var id = mux.Vars(r)["id"]
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+
 	element := models.Element{}
-	err := json.NewDecoder(r.Body).Decode(&element)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !DecodeJSON(w, r, &element) {
 		return
 	}
 
-	//convert id to int64
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	before, err := c.elementRepository.GetElementByID(idInt)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	element.OwnerID = before.OwnerID
 
 	key, err := c.elementRepository.UpdateElement(idInt, &element)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "element.update", "Element", idInt, before, &element)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(key)
 }
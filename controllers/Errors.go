@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"restAPI/validation"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"github.com/gorilla/mux"
+)
+
+// Error codes returned in the "code" field of an APIError
+const (
+	CodeBadRequest       = "bad_request"
+	CodeInvalidJSON      = "invalid_json"
+	CodeInvalidID        = "invalid_id"
+	CodeValidationFailed = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodeTooLarge         = "too_large"
+	CodeQuotaExceeded    = "quota_exceeded"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal"
+	CodeUnavailable      = "unavailable"
+)
+
+// APIError is the JSON body of every error response
+type APIError struct {
+	Code    string                  `json:"code"`
+	Message string                  `json:"message"`
+	Fields  []validation.FieldError `json:"fields,omitempty"`
+}
+
+// codes used by WriteError for each status
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusUnprocessableEntity:   CodeValidationFailed,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// WriteAPIError writes err as JSON with the given status
+func WriteAPIError(w http.ResponseWriter, status int, err APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(err)
+}
+
+// WriteError replaces http.Error: same arguments, JSON body, with a code derived from status
+func WriteError(w http.ResponseWriter, message string, status int) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeBadRequest
+		if status >= http.StatusInternalServerError {
+			code = CodeInternal
+		}
+	}
+	WriteAPIError(w, status, APIError{Code: code, Message: message})
+}
+
+// StorageError reports a repository error: missing entities are a 404, anything
+// else is logged and reported as a 500 without the Datastore message
+func StorageError(w http.ResponseWriter, err error) {
+	if err == datastore.ErrNoSuchEntity {
+		WriteError(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Storage error: %v", err)
+	WriteError(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// ParseID reads a numeric route variable, writing a 400 and returning false if it is not a positive integer
+func ParseID(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+	if err != nil || id <= 0 {
+		WriteAPIError(w, http.StatusBadRequest, APIError{
+			Code:    CodeInvalidID,
+			Message: "Invalid " + name,
+			Fields:  []validation.FieldError{{Field: name, Message: "must be a positive integer"}},
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// ParseFormID reads a numeric form value the same way; an empty value is 0
+func ParseFormID(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	s := r.FormValue(name)
+	if s == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 0 {
+		WriteAPIError(w, http.StatusBadRequest, APIError{
+			Code:    CodeInvalidID,
+			Message: "Invalid " + name,
+			Fields:  []validation.FieldError{{Field: name, Message: "must be a positive integer"}},
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// DecodeJSON decodes the request body into dst and validates it, writing a
+// 400 for malformed JSON or a 422 listing the invalid fields, and returning
+// false in either case
+func DecodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		WriteAPIError(w, http.StatusBadRequest, APIError{Code: CodeInvalidJSON, Message: "Invalid JSON: " + err.Error()})
+		return false
+	}
+
+	if fields := validation.Struct(dst); len(fields) > 0 {
+		WriteAPIError(w, http.StatusUnprocessableEntity, APIError{
+			Code:    CodeValidationFailed,
+			Message: "Some fields are invalid",
+			Fields:  fields,
+		})
+		return false
+	}
+	return true
+}
+
+// wantsHTML reports whether the client is a browser navigating to a page
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
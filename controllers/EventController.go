@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"restAPI/push"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how many modules and courses one stream may follow
+const maxStreamTopics = 50
+
+// EventHandler streams events to clients as Server-Sent Events
+type EventHandler struct {
+	broker            push.Broker
+	authorizer        *Authorizer
+	streamTimeout     time.Duration
+	heartbeatInterval time.Duration
+}
+
+// NewEventHandler ..
+func NewEventHandler(broker push.Broker, authorizer *Authorizer, streamTimeout time.Duration,
+	heartbeatInterval time.Duration) *EventHandler {
+	return &EventHandler{
+		broker:            broker,
+		authorizer:        authorizer,
+		streamTimeout:     streamTimeout,
+		heartbeatInterval: heartbeatInterval,
+	}
+}
+
+// StreamEvents sends the user's grades, and the discussions of ?module= and
+// announcements of ?course= (comma-separated IDs) which they may see, until
+// the stream times out; the client then reconnects with Last-Event-ID
+func (h *EventHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	moduleIDs, err := parseIDList(r.URL.Query().Get("module"))
+	if err != nil {
+		WriteError(w, "Invalid module: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	courseIDs, err := parseIDList(r.URL.Query().Get("course"))
+	if err != nil {
+		WriteError(w, "Invalid course: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(moduleIDs)+len(courseIDs) > maxStreamTopics {
+		WriteError(w, fmt.Sprintf("At most %d modules and courses can be followed", maxStreamTopics), http.StatusBadRequest)
+		return
+	}
+
+	topics := []string{push.UserTopic(user.KeyID)}
+	for _, moduleID := range moduleIDs {
+		resource := h.authorizer.moduleCourse(moduleID)
+		if len(resource.CourseIDs) == 0 || !h.authorizer.Allowed(r, user, CourseRoleLearner, resource) {
+			WriteError(w, fmt.Sprintf("You cannot follow module %d", moduleID), http.StatusForbidden)
+			return
+		}
+		topics = append(topics, push.ModuleTopic(moduleID))
+	}
+	for _, courseID := range courseIDs {
+		if !h.authorizer.Allowed(r, user, CourseRoleLearner, Resource{CourseIDs: []int64{courseID}}) {
+			WriteError(w, fmt.Sprintf("You cannot follow course %d", courseID), http.StatusForbidden)
+			return
+		}
+		topics = append(topics, push.CourseTopic(courseID))
+	}
+
+	// EventSource sends Last-Event-ID when it reconnects; the query parameter
+	// lets a page resume after a reload
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		if lastID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			WriteError(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	subscription := h.broker.Subscribe(topics, lastID)
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	timeout := time.NewTimer(h.streamTimeout)
+	defer timeout.Stop()
+	heartbeat := time.NewTicker(h.heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-subscription.Events:
+			if !ok {
+				return
+			}
+			if push.WriteSSE(w, event) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-timeout.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// parseIDList reads comma-separated positive IDs
+func parseIDList(s string) ([]int64, error) {
+	var ids []int64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("%q is not an ID", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
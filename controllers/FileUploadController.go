@@ -1,28 +1,40 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"restAPI/models"
+	"restAPI/scan"
+	"restAPI/storage"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/mux"
 )
 
-// FileUploadHandler handles file upload operations
+// FileUploadHandler handles file upload operations. Files are kept in a blob
+// store, never under the static directory, so that only the handlers below
+// can hand them out.
 type FileUploadHandler struct {
 	projectRepository       models.ProjectRepository
 	moduleRepository        models.ModuleRepository
 	userRepository          models.UserRepository
 	moduleElementRepository models.ModuleElementRepository
+	blobs                   storage.BlobStore
+	quarantine              *scan.Quarantine
+	quota                   *CourseQuota
+	signedURLExpiry         time.Duration
 }
 
 // FileMetadata represents metadata about an uploaded file
@@ -34,47 +46,130 @@ type FileMetadata struct {
 	FilePath    string `json:"file_path"`
 }
 
-// NewFileUploadHandler creates a new file upload handler
+// NewFileUploadHandler creates a new file upload handler storing files in
+// blobs once quarantine passed them, within their course's quota; downloads
+// are redirected to URLs signed for signedURLExpiry when the store can sign them
 func NewFileUploadHandler(projectRepository models.ProjectRepository, moduleRepository models.ModuleRepository,
-	userRepository models.UserRepository, moduleElementRepository models.ModuleElementRepository) *FileUploadHandler {
+	userRepository models.UserRepository, moduleElementRepository models.ModuleElementRepository,
+	blobs storage.BlobStore, quarantine *scan.Quarantine, quota *CourseQuota, signedURLExpiry time.Duration) *FileUploadHandler {
 	return &FileUploadHandler{
 		projectRepository:       projectRepository,
 		moduleRepository:        moduleRepository,
 		userRepository:          userRepository,
 		moduleElementRepository: moduleElementRepository,
+		blobs:                   blobs,
+		quarantine:              quarantine,
+		quota:                   quota,
+		signedURLExpiry:         signedURLExpiry,
 	}
 }
 
-// validateFile checks if the file is valid
-func validateFile(file multipart.File, header *multipart.FileHeader) error {
+// projectsPrefix is where project files are kept in the blob store
+const projectsPrefix = "projects"
+
+// validateFile checks the size of a file and that its content is of a type
+// allowed for use (see scan.Allowed), returning that type
+func validateFile(file multipart.File, header *multipart.FileHeader, use string) (string, error) {
 	// Check file size (10MB limit)
 	if header.Size > 10<<20 {
-		return fmt.Errorf("file too large: maximum size is 10MB")
+		return "", &scan.Rejected{Reason: "file too large: maximum size is 10MB"}
+	}
+
+	// Check file type, by its content
+	return scan.Check(file, header.Size, header.Filename, use)
+}
+
+// storeUpload validates a file for use and, once the scanner passed it in
+// quarantine, puts it in the blob store under prefix with a new unique
+// name. It returns the name and the file's content type; see uploadError.
+func storeUpload(ctx context.Context, quarantine *scan.Quarantine, file multipart.File, header *multipart.FileHeader,
+	use string, prefix string) (string, string, error) {
+	contentType, err := validateFile(file, header, use)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
 	}
 
-	// Check file type
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	allowedExts := map[string]bool{
-		".pdf": true, ".doc": true, ".docx": true, ".txt": true,
-		".jpg": true, ".jpeg": true, ".png": true, ".gif": true,
-		".zip": true, ".rar": true, ".7z": true,
-		".mp4": true, ".mov": true, ".avi": true,
-		".mp3": true, ".wav": true,
+	name := prefix + "/" + uuid.New().String() + filepath.Ext(header.Filename)
+	info := storage.Info{ContentType: contentType, Filename: filepath.Base(header.Filename)}
+	if err := quarantine.Admit(ctx, name, file, info); err != nil {
+		return "", "", err
+	}
+	return name, contentType, nil
+}
+
+// uploadError answers an upload storeUpload refused: 422 for a file which
+// must not be kept, 503 when it could not be scanned
+func uploadError(w http.ResponseWriter, err error) {
+	var rejected *scan.Rejected
+	switch {
+	case errors.As(err, &rejected):
+		WriteError(w, rejected.Reason, http.StatusUnprocessableEntity)
+	case errors.Is(err, scan.ErrScanFailed):
+		log.Printf("Unable to scan upload: %v", err)
+		WriteError(w, "The file could not be checked for malware; try again later", http.StatusServiceUnavailable)
+	default:
+		log.Printf("Unable to save file on server: %v", err)
+		WriteError(w, "Unable to save file on server", http.StatusInternalServerError)
+	}
+}
+
+// serveBlob sends a stored file as filename. Downloads (attachment) are
+// redirected to a signed URL when the store can make one; everything else
+// streams through the server.
+func serveBlob(w http.ResponseWriter, r *http.Request, blobs storage.BlobStore, name string, filename string,
+	attachment bool, signedURLExpiry time.Duration) {
+	if attachment {
+		signed, err := blobs.SignedURL(name, signedURLExpiry)
+		if err == nil {
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, signed, http.StatusFound)
+			return
+		}
+		if !errors.Is(err, storage.ErrNoSignedURLs) {
+			log.Printf("Error signing a URL, serving %s instead: %v", name, err)
+		}
 	}
 
-	if !allowedExts[ext] {
-		return fmt.Errorf("file type not allowed: %s", ext)
+	ExtendDeadlines(r)
+	reader, info, err := blobs.Open(r.Context(), name)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidName) {
+		WriteError(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening %s: %v", name, err)
+		WriteError(w, "Unable to read file", http.StatusInternalServerError)
+		return
 	}
+	defer reader.Close()
 
-	return nil
+	disposition := "inline"
+	if attachment {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// uploads are not pages of this site, even when a browser shows them
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", info.Updated, seeker)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, reader)
 }
 
 // UploadProject handles file upload for projects
 func (h *FileUploadHandler) UploadProject(w http.ResponseWriter, r *http.Request) {
+	ExtendDeadlines(r)
 	// Parse multipart form with 10MB limit
 	err := r.ParseMultipartForm(10 << 20)
 	if err != nil {
-		http.Error(w, "Unable to parse form: "+err.Error(), http.StatusBadRequest)
+		WriteError(w, "Unable to parse form", http.StatusBadRequest)
 		return
 	}
 
@@ -86,51 +181,56 @@ func (h *FileUploadHandler) UploadProject(w http.ResponseWriter, r *http.Request
 	projectDescription := r.FormValue("description")
 
 	if userIDStr == "" || moduleIDStr == "" || courseIDStr == "" || projectName == "" {
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		WriteError(w, "Missing required fields", http.StatusBadRequest)
 		return
 	}
 
-	userID, _ := strconv.ParseInt(userIDStr, 10, 64)
-	moduleID, _ := strconv.ParseInt(moduleIDStr, 10, 64)
-	courseID, _ := strconv.ParseInt(courseIDStr, 10, 64)
-
-	// Get the uploaded file
-	file, handler, err := r.FormFile("projectFile")
-	if err != nil {
-		http.Error(w, "Error retrieving file: "+err.Error(), http.StatusBadRequest)
+	userID, ok := ParseFormID(w, r, "userId")
+	if !ok {
 		return
 	}
-	defer file.Close()
-
-	// Validate the file
-	if err := validateFile(file, handler); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	moduleID, ok := ParseFormID(w, r, "moduleId")
+	if !ok {
 		return
 	}
-
-	// Create upload directory if it doesn't exist
-	uploadDir := "./static/uploads/projects"
-	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
-		http.Error(w, "Unable to create upload directory: "+err.Error(), http.StatusInternalServerError)
+	courseID, ok := ParseFormID(w, r, "courseId")
+	if !ok {
 		return
 	}
 
-	// Generate a unique filename
-	fileExt := filepath.Ext(handler.Filename)
-	uniqueFilename := uuid.New().String() + fileExt
-	filePath := filepath.Join(uploadDir, uniqueFilename)
+	// an optional element of the module the file answers, whose type decides which files it takes
+	elementID, ok := ParseFormID(w, r, "elementId")
+	if !ok {
+		return
+	}
+	use := "project"
+	if elementID != 0 {
+		element, found := h.uploadElement(moduleID, elementID)
+		if !found {
+			WriteError(w, "elementId is not a project or file element of the module", http.StatusUnprocessableEntity)
+			return
+		}
+		use = element.Type
+	}
 
-	// Create the file on the server
-	dst, err := os.Create(filePath)
+	// Get the uploaded file
+	file, handler, err := r.FormFile("projectFile")
 	if err != nil {
-		http.Error(w, "Unable to create file on server: "+err.Error(), http.StatusInternalServerError)
+		WriteError(w, "Missing projectFile", http.StatusBadRequest)
 		return
 	}
-	defer dst.Close()
+	defer file.Close()
 
-	// Copy the file to the destination
-	if _, err = io.Copy(dst, file); err != nil {
-		http.Error(w, "Unable to save file on server: "+err.Error(), http.StatusInternalServerError)
+	// Make room for the file in the course's quota
+	if !reserveStorage(w, r, h.quota, courseID, handler.Size) {
+		return
+	}
+
+	// Validate, scan and store the file
+	blobName, _, err := storeUpload(r.Context(), h.quarantine, file, handler, use, projectsPrefix)
+	if err != nil {
+		h.quota.Release(courseID, handler.Size)
+		uploadError(w, err)
 		return
 	}
 
@@ -138,7 +238,9 @@ func (h *FileUploadHandler) UploadProject(w http.ResponseWriter, r *http.Request
 	project := &models.Project{
 		Name:        projectName,
 		Description: projectDescription,
-		File:        "/uploads/projects/" + uniqueFilename, // Store relative path
+		File:        blobName,
+		FileName:    filepath.Base(handler.Filename),
+		Size:        handler.Size,
 		Date:        time.Now(),
 		UserID:      userID,
 		CourseID:    courseID,
@@ -148,10 +250,35 @@ func (h *FileUploadHandler) UploadProject(w http.ResponseWriter, r *http.Request
 	// Save to database
 	key, err := h.projectRepository.CreateProject(project)
 	if err != nil {
-		http.Error(w, "Error saving project: "+err.Error(), http.StatusInternalServerError)
+		h.blobs.Delete(context.Background(), blobName)
+		h.quota.Release(courseID, handler.Size)
+		StorageError(w, err)
 		return
 	}
 
+	h.recordSubmission(userID, moduleID, elementID, key.ID)
+
+	// Return success response with metadata
+	metadata := FileMetadata{
+		FileName:    handler.Filename,
+		FileSize:    handler.Size,
+		ContentType: handler.Header.Get("Content-Type"),
+		UploadDate:  time.Now().Format(time.RFC3339),
+		FilePath:    fmt.Sprintf("/project/%d/file", key.ID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"message":   "Project uploaded successfully",
+		"projectId": key.ID,
+		"metadata":  metadata,
+	})
+}
+
+// recordSubmission updates the user's module record with the project as the
+// answer to elementID, or to the module's project elements if it is 0
+func (h *FileUploadHandler) recordSubmission(userID int64, moduleID int64, elementID int64, projectID int64) {
 	// Update user module record with the project reference
 	user, err := h.userRepository.GetUserByID(userID)
 	if err == nil && user != nil {
@@ -172,14 +299,14 @@ func (h *FileUploadHandler) UploadProject(w http.ResponseWriter, r *http.Request
 				elements, err := h.moduleElementRepository.GetElementsByModuleID(moduleID)
 				if err == nil {
 					for _, element := range elements {
-						if element.Type == "project" || element.Type == "file" {
+						if (element.Type == "project" || element.Type == "file") && (elementID == 0 || element.KeyID == elementID) {
 							// Found a project element, update its answer
 							elementIDStr := strconv.FormatInt(element.KeyID, 10)
 							answer, exists := module.Answers[elementIDStr]
 
 							if !exists || answer.ProjectID == 0 {
 								answer = models.Answer{
-									ProjectID: key.ID,
+									ProjectID: projectID,
 									Correct:   true, // Auto-approve submission (instructor will review later)
 								}
 								module.Answers[elementIDStr] = answer
@@ -194,7 +321,7 @@ func (h *FileUploadHandler) UploadProject(w http.ResponseWriter, r *http.Request
 					// Create a generic element ID if none found
 					elementIDStr := "project_" + strconv.FormatInt(moduleID, 10)
 					module.Answers[elementIDStr] = models.Answer{
-						ProjectID: key.ID,
+						ProjectID: projectID,
 						Correct:   true,
 					}
 				}
@@ -204,92 +331,70 @@ func (h *FileUploadHandler) UploadProject(w http.ResponseWriter, r *http.Request
 				// Update the user
 				_, err = h.userRepository.UpdateUser(userID, user)
 				if err != nil {
-					fmt.Printf("Warning: Failed to update user with project: %v\n", err)
+					log.Printf("Warning: Failed to update user with project: %v", err)
 				}
 				break
 			}
 		}
 	}
+}
 
-	// Return success response with metadata
-	metadata := FileMetadata{
-		FileName:    handler.Filename,
-		FileSize:    handler.Size,
-		ContentType: handler.Header.Get("Content-Type"),
-		UploadDate:  time.Now().Format(time.RFC3339),
-		FilePath:    project.File,
+// uploadElement finds a project or file element of a module
+func (h *FileUploadHandler) uploadElement(moduleID int64, elementID int64) (*models.Element, bool) {
+	elements, err := h.moduleElementRepository.GetElementsByModuleID(moduleID)
+	if err != nil {
+		return nil, false
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":   true,
-		"message":   "Project uploaded successfully",
-		"projectId": key.ID,
-		"metadata":  metadata,
-	})
+	for _, element := range elements {
+		if element.KeyID == elementID && (element.Type == "project" || element.Type == "file") {
+			return element, true
+		}
+	}
+	return nil, false
 }
 
 // GetProjectFile serves the project file
 func (h *FileUploadHandler) GetProjectFile(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	projectID, err := strconv.ParseInt(vars["id"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+	projectID, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
 	project, err := h.projectRepository.GetProjectByID(projectID)
 	if err != nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		WriteError(w, "Project not found", http.StatusNotFound)
 		return
 	}
-
-	// Check if file exists
-	filePath := filepath.Join("./static", project.File)
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
-	}
-
-	// Get the content type
-	contentType := getContentType(filePath)
-
-	// Set appropriate headers for file download
-	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%s", filepath.Base(project.File)))
-	w.Header().Set("Content-Type", contentType)
-
-	// Serve the file
-	http.ServeFile(w, r, filePath)
+	h.serveProjectFile(w, r, project, false)
 }
 
 // DownloadProjectFile forces download of the project file
 func (h *FileUploadHandler) DownloadProjectFile(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	projectID, err := strconv.ParseInt(vars["id"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+	projectID, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
 	project, err := h.projectRepository.GetProjectByID(projectID)
 	if err != nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		WriteError(w, "Project not found", http.StatusNotFound)
 		return
 	}
+	h.serveProjectFile(w, r, project, true)
+}
 
-	// Check if file exists
-	filePath := filepath.Join("./static", project.File)
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		http.Error(w, "File not found", http.StatusNotFound)
+// serveProjectFile sends a project's file, which must be one uploaded for a
+// project: File can also be set through PUT /project/{id}
+func (h *FileUploadHandler) serveProjectFile(w http.ResponseWriter, r *http.Request, project *models.Project, attachment bool) {
+	if !strings.HasPrefix(project.File, projectsPrefix+"/") {
+		WriteError(w, "File not found", http.StatusNotFound)
 		return
 	}
-
-	// Set appropriate headers for file download
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filepath.Base(project.File)))
-	w.Header().Set("Content-Type", "application/octet-stream")
-
-	// Serve the file
-	http.ServeFile(w, r, filePath)
+	filename := project.FileName
+	if filename == "" {
+		filename = path.Base(project.File)
+	}
+	serveBlob(w, r, h.blobs, project.File, filename, attachment, h.signedURLExpiry)
 }
 
 // getContentType determines the MIME type of a file
@@ -325,17 +430,15 @@ func getContentType(filePath string) string {
 
 // ListUserProjects returns all projects for a specific user
 func (h *FileUploadHandler) ListUserProjects(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID, err := strconv.ParseInt(vars["userId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	userID, ok := ParseID(w, r, "userId")
+	if !ok {
 		return
 	}
 
 	// Get all projects for this user
 	projects, err := h.projectRepository.GetProjectsByUserID(userID)
 	if err != nil {
-		http.Error(w, "Error retrieving projects: "+err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -351,12 +454,9 @@ func (h *FileUploadHandler) ListUserProjects(w http.ResponseWriter, r *http.Requ
 	enrichedProjects := make([]EnrichedProject, 0, len(projects))
 	for _, project := range projects {
 		// Get file information
-		filePath := filepath.Join("./static", project.File)
-		fileInfo, err := os.Stat(filePath)
-
 		fileSize := int64(0)
-		if err == nil {
-			fileSize = fileInfo.Size()
+		if info, err := h.blobs.Stat(r.Context(), project.File); err == nil {
+			fileSize = info.Size
 		}
 
 		// Get module information
@@ -392,24 +492,22 @@ func (h *FileUploadHandler) ListUserProjects(w http.ResponseWriter, r *http.Requ
 
 // ListModuleProjects returns all projects for a specific module
 func (h *FileUploadHandler) ListModuleProjects(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	moduleID, err := strconv.ParseInt(vars["moduleId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid module ID", http.StatusBadRequest)
+	moduleID, ok := ParseID(w, r, "moduleId")
+	if !ok {
 		return
 	}
 
 	// Get all projects for this module
 	projects, err := h.projectRepository.GetProjectsByModuleID(moduleID)
 	if err != nil {
-		http.Error(w, "Error retrieving projects: "+err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
 	// Get module information
 	module, err := h.moduleRepository.GetModuleByID(moduleID)
 	if err != nil {
-		http.Error(w, "Module not found", http.StatusNotFound)
+		WriteError(w, "Module not found", http.StatusNotFound)
 		return
 	}
 
@@ -426,76 +524,51 @@ func (h *FileUploadHandler) ListModuleProjects(w http.ResponseWriter, r *http.Re
 	json.NewEncoder(w).Encode(result)
 }
 
-// DeleteProject deletes a project and its associated file
-func (h *FileUploadHandler) DeleteProject(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	projectID, err := strconv.ParseInt(vars["id"], 10, 64)
+// MigrateProjectFiles moves project files uploaded by earlier versions out of
+// staticDir, where anyone could download them, into the blob store. It is
+// safe to run on every start.
+func MigrateProjectFiles(projects models.ProjectRepository, blobs storage.BlobStore, staticDir string) {
+	all, err := projects.GetAllProjects()
 	if err != nil {
-		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		log.Printf("Error migrating project files: %v", err)
 		return
 	}
 
-	// Get the project
-	project, err := h.projectRepository.GetProjectByID(projectID)
-	if err != nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
-		return
-	}
-
-	// Delete the file if it exists
-	filePath := filepath.Join("./static", project.File)
-	if _, err := os.Stat(filePath); err == nil {
-		err = os.Remove(filePath)
+	moved := 0
+	for _, project := range all {
+		if !strings.HasPrefix(project.File, "/uploads/projects/") {
+			continue
+		}
+		// names that climb out of the old directory are not ours to move
+		file := path.Clean(project.File)
+		if !strings.HasPrefix(file, "/uploads/projects/") {
+			log.Printf("Not migrating the file of project %d: %q is outside /uploads/projects", project.KeyID, project.File)
+			continue
+		}
+		legacy := filepath.Join(staticDir, filepath.FromSlash(file))
+		f, err := os.Open(legacy)
 		if err != nil {
-			// Log error but continue with deletion from database
-			fmt.Printf("Warning: Failed to delete project file: %v\n", err)
+			log.Printf("Error migrating the file of project %d: %v", project.KeyID, err)
+			continue
+		}
+		name := projectsPrefix + "/" + path.Base(file)
+		info := storage.Info{ContentType: getContentType(name), Filename: project.FileName}
+		err = blobs.Put(context.Background(), name, f, info)
+		f.Close()
+		if err != nil {
+			log.Printf("Error migrating the file of project %d: %v", project.KeyID, err)
+			continue
 		}
-	}
-
-	// Delete project from database
-	err = h.projectRepository.DeleteProject(projectID)
-	if err != nil {
-		http.Error(w, "Error deleting project: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Also update any user modules that reference this project
-	// This could be optimized by having a direct query for users with this project ID
-	// but for now we'll do a targeted search using the project metadata
-	userID := project.UserID
-	moduleID := project.ModuleID
-
-	if userID > 0 && moduleID > 0 {
-		user, err := h.userRepository.GetUserByID(userID)
-		if err == nil && user != nil {
-			for i, module := range user.Modules {
-				if module.ModuleID == moduleID {
-					// Look for answers that reference this project
-					updated := false
-					for elementID, answer := range module.Answers {
-						if answer.ProjectID == projectID {
-							delete(module.Answers, elementID)
-							updated = true
-						}
-					}
 
-					if updated {
-						user.Modules[i] = module
-						// Update the user
-						_, err = h.userRepository.UpdateUser(userID, user)
-						if err != nil {
-							fmt.Printf("Warning: Failed to update user after project deletion: %v\n", err)
-						}
-					}
-					break
-				}
-			}
+		project.File = name
+		if _, err := projects.UpdateProject(project.KeyID, project); err != nil {
+			log.Printf("Error migrating the file of project %d: %v", project.KeyID, err)
+			continue
 		}
+		os.Remove(legacy)
+		moved++
+	}
+	if moved > 0 {
+		log.Printf("Moved %d project files out of the static directory", moved)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "Project deleted successfully",
-	})
 }
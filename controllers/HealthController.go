@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// HealthCheck reports whether a dependency (e.g. Datastore) is usable
+type HealthCheck func(ctx context.Context) error
+
+// HealthHandler serves liveness and readiness probes
+type HealthHandler struct {
+	checks   map[string]HealthCheck
+	timeout  time.Duration
+	draining int32
+}
+
+// NewHealthHandler creates a HealthHandler running the named checks for /readyz
+func NewHealthHandler(checks map[string]HealthCheck) *HealthHandler {
+	return &HealthHandler{checks: checks, timeout: 2 * time.Second}
+}
+
+// Drain makes /readyz fail, so load balancers stop sending traffic while
+// the server shuts down
+func (h *HealthHandler) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Liveness reports that the process is up
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Readiness runs every check and fails if any does, or if the server is draining
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	status := http.StatusOK
+	results := map[string]string{}
+
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := h.checks[name](ctx); err != nil {
+			// the probe is public, so the cause only goes to the log
+			log.Printf("Readiness check %s failed: %v", name, err)
+			results[name] = "failing"
+			status = http.StatusServiceUnavailable
+		} else {
+			results[name] = "ok"
+		}
+	}
+
+	overall := "ok"
+	if atomic.LoadInt32(&h.draining) == 1 {
+		overall = "draining"
+		status = http.StatusServiceUnavailable
+	} else if status != http.StatusOK {
+		overall = "unavailable"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": overall, "checks": results})
+}
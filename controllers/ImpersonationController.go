@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"restAPI/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImpersonationHandler lets support staff see the application as a given
+// user. The impersonated session is read-only (enforced by the permissions
+// middleware), and starting or ending it is written to the audit log.
+type ImpersonationHandler struct {
+	userRepository models.UserRepository
+	auditor        *Auditor
+	sessions       *SessionStore
+}
+
+// NewImpersonationHandler creates a new ImpersonationHandler
+func NewImpersonationHandler(userRepository models.UserRepository, auditor *Auditor, sessions *SessionStore) *ImpersonationHandler {
+	return &ImpersonationHandler{
+		userRepository: userRepository,
+		auditor:        auditor,
+		sessions:       sessions,
+	}
+}
+
+// SessionInfo describes the current session, including both identities
+type SessionInfo struct {
+	Username     string    `json:"username"`
+	Impersonator string    `json:"impersonator,omitempty"`
+	Impersonated bool      `json:"impersonated"`
+	Expires      time.Time `json:"expires"`
+}
+
+// GetSessionInfo returns the current session, so clients can show when it is impersonated
+func (h *ImpersonationHandler) GetSessionInfo(w http.ResponseWriter, r *http.Request) {
+	_, session := GetSession(r)
+	if *session == (Session{}) || Expired(session) {
+		WriteError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SessionInfo{
+		Username:     session.username,
+		Impersonator: session.impersonator,
+		Impersonated: session.Impersonated(),
+		Expires:      session.expiry,
+	})
+}
+
+// StartImpersonation replaces the admin's session cookie with a new
+// session for the given user, remembering the admin's own session
+func (h *ImpersonationHandler) StartImpersonation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	originalToken, session := GetSession(r)
+	admin := RealUser(r)
+	if admin == nil || *session == (Session{}) {
+		WriteError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if session.Impersonated() {
+		WriteError(w, "Already impersonating "+session.username, http.StatusConflict)
+		return
+	}
+
+	user, err := h.userRepository.GetUserByID(userID)
+	if err != nil {
+		WriteError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if user.Username == admin.Username {
+		WriteError(w, "Cannot impersonate yourself", http.StatusBadRequest)
+		return
+	}
+
+	sessionToken := uuid.NewString()
+	expiresAt := time.Now().Add(360 * time.Second)
+
+	h.sessions.Set(sessionToken, &Session{
+		username:      user.Username,
+		expiry:        expiresAt,
+		impersonator:  admin.Username,
+		originalToken: originalToken,
+	})
+
+	SetSessionCookie(w, sessionToken, expiresAt)
+
+	h.auditor.Record(WithUser(WithRealUser(r, admin), user), "impersonation.start", "User", userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SessionInfo{
+		Username:     user.Username,
+		Impersonator: admin.Username,
+		Impersonated: true,
+		Expires:      expiresAt,
+	})
+}
+
+// EndImpersonation drops the impersonated session and restores the admin's own session
+func (h *ImpersonationHandler) EndImpersonation(w http.ResponseWriter, r *http.Request) {
+	sessionToken, session := GetSession(r)
+	if !session.Impersonated() {
+		WriteError(w, "Not impersonating", http.StatusBadRequest)
+		return
+	}
+
+	h.sessions.Delete(sessionToken)
+
+	user, _ := h.userRepository.GetUserByUsername(session.username)
+	admin, _ := h.userRepository.GetUserByUsername(session.impersonator)
+	if admin != nil && user != nil {
+		h.auditor.Record(WithUser(WithRealUser(r, admin), user), "impersonation.end", "User", user.KeyID)
+	}
+
+	// restore the admin's session if it is still alive, otherwise log out
+	if original, ok := h.sessions.Get(session.originalToken); ok && !Expired(original) {
+		expiresAt := time.Now().Add(360 * time.Second)
+		h.sessions.Extend(session.originalToken, expiresAt)
+		SetSessionCookie(w, session.originalToken, expiresAt)
+	} else {
+		ClearSessionCookie(w)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Impersonation ended"})
+}
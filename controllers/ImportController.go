@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"restAPI/models"
+	"restAPI/questionbank"
+	"restAPI/validation"
+	"strconv"
+)
+
+// largest question bank accepted by ImportQuestions
+const maxQuestionBank = 10 << 20
+
+// ImportHandler imports question banks into modules
+type ImportHandler struct {
+	elementRepository       models.ElementRepository
+	moduleElementRepository models.ModuleElementRepository
+	auditor                 *Auditor
+}
+
+// NewImportHandler ..
+func NewImportHandler(elementRepository models.ElementRepository, moduleElementRepository models.ModuleElementRepository, auditor *Auditor) *ImportHandler {
+	return &ImportHandler{
+		elementRepository:       elementRepository,
+		moduleElementRepository: moduleElementRepository,
+		auditor:                 auditor,
+	}
+}
+
+// ImportedElement is an element created (or, in a dry run, to be created) by an import
+type ImportedElement struct {
+	Source          string         `json:"source"`
+	ElementID       int64          `json:"element_id,omitempty"`
+	ModuleElementID int64          `json:"module_element_id,omitempty"`
+	SortKey         int            `json:"sort_key"`
+	Element         models.Element `json:"element"`
+	Warnings        []string       `json:"warnings,omitempty"`
+}
+
+// ImportReport lists what an import created and what it skipped
+type ImportReport struct {
+	Format      string                     `json:"format"`
+	DryRun      bool                       `json:"dry_run"`
+	Imported    []ImportedElement          `json:"imported"`
+	Unsupported []questionbank.Unsupported `json:"unsupported"`
+}
+
+// ImportQuestions parses the uploaded file (form field "file") as QTI 2.1 or
+// GIFT ("format", detected if not given), creates an Element for each
+// question and appends them to the module. With dry_run=true nothing is
+// written and the report previews the import.
+func (h *ImportHandler) ImportQuestions(w http.ResponseWriter, r *http.Request) {
+	moduleID, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxQuestionBank+1<<20)
+	if err := r.ParseMultipartForm(maxQuestionBank); err != nil {
+		WriteError(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		WriteError(w, "Missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxQuestionBank+1))
+	if err != nil {
+		WriteError(w, "Unable to read file", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxQuestionBank {
+		WriteError(w, "File too large: maximum size is 10MB", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	dryRun := false
+	if s := r.FormValue("dry_run"); s != "" {
+		if dryRun, err = strconv.ParseBool(s); err != nil {
+			WriteAPIError(w, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: "Invalid dry_run",
+				Fields: []validation.FieldError{{Field: "dry_run", Message: "must be true or false"}}})
+			return
+		}
+	}
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = questionbank.Detect(header.Filename, data)
+	}
+	bank, err := questionbank.Parse(format, data)
+	if err != nil {
+		WriteAPIError(w, http.StatusUnprocessableEntity, APIError{Code: CodeValidationFailed, Message: err.Error()})
+		return
+	}
+
+	// append after the module's current elements
+	existing, err := h.moduleElementRepository.GetModuleElementsByModuleID(moduleID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	sortKey := 0
+	for _, me := range existing {
+		if me.SortKey > sortKey {
+			sortKey = me.SortKey
+		}
+	}
+
+	report := ImportReport{Format: format, DryRun: dryRun, Imported: []ImportedElement{}, Unsupported: bank.Unsupported}
+	var ownerID int64
+	if user := CurrentUser(r); user != nil {
+		ownerID = user.KeyID
+	}
+	for _, item := range bank.Items {
+		element := item.Element
+		element.OwnerID = ownerID
+		if fields := validation.Struct(&element); len(fields) > 0 {
+			report.Unsupported = append(report.Unsupported, questionbank.Unsupported{
+				Source: item.Source, Reason: fields[0].Field + " " + fields[0].Message,
+			})
+			continue
+		}
+
+		sortKey++
+		imported := ImportedElement{Source: item.Source, SortKey: sortKey, Element: element, Warnings: item.Warnings}
+		if !dryRun {
+			key, err := h.elementRepository.CreateElement(&element)
+			if err != nil {
+				StorageError(w, err)
+				return
+			}
+			imported.ElementID = key.ID
+			imported.Element.KeyID = key.ID
+
+			meKey, err := h.moduleElementRepository.CreateModuleElement(&models.ModuleElement{
+				ModuleID: moduleID, ElementID: key.ID, SortKey: sortKey,
+			})
+			if err != nil {
+				StorageError(w, err)
+				return
+			}
+			imported.ModuleElementID = meKey.ID
+		}
+		report.Imported = append(report.Imported, imported)
+	}
+
+	if !dryRun {
+		h.auditor.RecordChange(r, "module.import", "Module", moduleID, nil, map[string]interface{}{
+			"format": format, "imported": len(report.Imported), "unsupported": len(report.Unsupported),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
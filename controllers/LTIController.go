@@ -0,0 +1,536 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"restAPI/lti"
+	"restAPI/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// LTIHandler lets LTI 1.3 platforms (Canvas, Blackboard, Moodle..) launch
+// modules: it provisions users from the launch, deep links modules into the
+// platform and posts module scores back to its gradebook
+type LTIHandler struct {
+	ltiRepository        models.LTIRepository
+	userRepository       models.UserRepository
+	userCourseRepository models.UserCourseRepository
+	courseRepository     models.CourseRepository
+	moduleRepository     models.ModuleRepository
+	userHandler          *UserHandler
+	tool                 *lti.Tool
+	modulePage           string
+	auditor              *Auditor
+	authorizer           *Authorizer
+}
+
+// NewLTIHandler ..
+func NewLTIHandler(ltiRepository models.LTIRepository, userRepository models.UserRepository,
+	userCourseRepository models.UserCourseRepository, courseRepository models.CourseRepository,
+	moduleRepository models.ModuleRepository, userHandler *UserHandler, tool *lti.Tool, modulePage string,
+	auditor *Auditor, authorizer *Authorizer) *LTIHandler {
+	return &LTIHandler{
+		ltiRepository:        ltiRepository,
+		userRepository:       userRepository,
+		userCourseRepository: userCourseRepository,
+		courseRepository:     courseRepository,
+		moduleRepository:     moduleRepository,
+		userHandler:          userHandler,
+		tool:                 tool,
+		modulePage:           modulePage,
+		auditor:              auditor,
+		authorizer:           authorizer,
+	}
+}
+
+// LTITool is what a platform needs to register the tool
+type LTITool struct {
+	LoginURL          string `json:"login_url"`
+	LaunchURL         string `json:"launch_url"`
+	DeepLinkingURL    string `json:"deep_linking_url"`
+	KeySetURL         string `json:"key_set_url"`
+	CustomParameters  string `json:"custom_parameters"`
+	SupportedMessages string `json:"supported_messages"`
+}
+
+// GetTool describes the tool's registration
+func (h *LTIHandler) GetTool(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LTITool{
+		LoginURL:          h.tool.LoginURL(),
+		LaunchURL:         h.tool.LaunchURL(),
+		DeepLinkingURL:    h.tool.LaunchURL(),
+		KeySetURL:         h.tool.KeySetURL(),
+		CustomParameters:  "module_id (set by deep linking)",
+		SupportedMessages: lti.ResourceLinkLaunch + ", " + lti.DeepLinkingLaunch,
+	})
+}
+
+// GetKeySet serves the tool's public keys
+func (h *LTIHandler) GetKeySet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(h.tool.JWKS())
+}
+
+// Login answers a platform's login initiation by sending the browser to the
+// platform's authorization endpoint
+func (h *LTIHandler) Login(w http.ResponseWriter, r *http.Request) {
+	login, err := lti.ParseLogin(r)
+	if err != nil {
+		WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	platform, err := h.ltiRepository.GetLTIPlatformByIssuer(login.Issuer, login.ClientID)
+	if err != nil {
+		WriteError(w, "Unknown platform", http.StatusBadRequest)
+		return
+	}
+	redirect, err := h.tool.AuthRedirect(platform, login)
+	if err != nil {
+		log.Printf("Error starting LTI login: %v", err)
+		WriteError(w, "Unable to start the login", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// Launch validates a launch, provisions its user and signs them in. A
+// resource link launch goes on to the module; a deep linking launch shows
+// instructors the modules they can add to the platform.
+func (h *LTIHandler) Launch(w http.ResponseWriter, r *http.Request) {
+	claims, platform, err := h.tool.Launch(r, h.ltiRepository.GetLTIPlatformByIssuer)
+	if err != nil {
+		WriteError(w, "Invalid launch: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.provision(r, claims)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	if claims.MessageType == lti.DeepLinkingLaunch {
+		if !claims.Instructor() {
+			WriteError(w, "Only instructors can add modules", http.StatusForbidden)
+			return
+		}
+		h.userHandler.IssueToken(w, r, user, true)
+		h.showModules(w, lti.NewSelection(claims, platform, user.KeyID))
+		return
+	}
+
+	link, err := h.resourceLink(claims, platform)
+	if err != nil {
+		WriteError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	module, err := h.moduleRepository.GetModuleByID(link.ModuleID)
+	if err != nil {
+		WriteError(w, "The linked module no longer exists", http.StatusNotFound)
+		return
+	}
+	// custom parameters can be edited on the platform, so only approved courses can be launched
+	if course, err := h.courseRepository.GetCourseByID(module.CourseID); err != nil || !course.Approved {
+		WriteError(w, "The linked module's course is not available", http.StatusForbidden)
+		return
+	}
+	if err := h.enrol(user, module.CourseID, claims.Instructor()); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	h.userHandler.IssueToken(w, r, user, true)
+	http.Redirect(w, r, strings.ReplaceAll(h.modulePage, "{id}", strconv.FormatInt(link.ModuleID, 10)), http.StatusSeeOther)
+}
+
+// provision returns the user a launch's subject was provisioned as, creating
+// them on their first launch and keeping their name and email current
+func (h *LTIHandler) provision(r *http.Request, claims *lti.Claims) (*models.User, error) {
+	identity, err := h.ltiRepository.GetLTIIdentity(claims.Issuer, claims.Subject)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+
+	if identity != nil {
+		user, err := h.userRepository.GetUserByID(identity.UserID)
+		if err == nil {
+			user.KeyID = identity.UserID
+			if claims.GivenName != user.Firstname || claims.FamilyName != user.Lastname || claims.Email != user.Email {
+				user.Firstname, user.Lastname, user.Email = claims.GivenName, claims.FamilyName, claims.Email
+				if _, err := h.userRepository.UpdateUser(user.KeyID, user); err != nil {
+					return nil, err
+				}
+			}
+			return user, nil
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return nil, err
+		}
+		// the user was deleted: provision them again
+	}
+
+	// usernames are derived from the platform identity, so they never collide with local ones
+	sum := sha256.Sum256([]byte(claims.Issuer + "\x00" + claims.Subject))
+	user := &models.User{
+		Username:  "lti-" + hex.EncodeToString(sum[:8]),
+		Email:     claims.Email,
+		Firstname: claims.GivenName,
+		Lastname:  claims.FamilyName,
+		CreatedOn: time.Now(),
+	}
+	key, err := h.userRepository.CreateUser(user)
+	if err != nil {
+		return nil, err
+	}
+	user.KeyID = key.ID
+
+	if err := h.ltiRepository.SaveLTIIdentity(&models.LTIIdentity{Issuer: claims.Issuer, Subject: claims.Subject, UserID: user.KeyID}); err != nil {
+		return nil, err
+	}
+	h.auditor.RecordChange(WithUser(r, user), "lti.provision", "User", user.KeyID, nil, user)
+	return user, nil
+}
+
+// resourceLink finds the module a resource link launches, from the
+// module_id custom parameter deep linking set or an earlier launch, and
+// records the link's line item for posting scores
+func (h *LTIHandler) resourceLink(claims *lti.Claims, platform *models.LTIPlatform) (*models.LTILink, error) {
+	link, err := h.ltiRepository.GetLTILink(platform.KeyID, claims.DeploymentID, claims.ResourceLink.ID)
+	if err != nil {
+		link = &models.LTILink{PlatformID: platform.KeyID, DeploymentID: claims.DeploymentID, ResourceLinkID: claims.ResourceLink.ID}
+	}
+	if moduleID, err := strconv.ParseInt(claims.Custom["module_id"], 10, 64); err == nil {
+		link.ModuleID = moduleID
+	}
+	if link.ModuleID == 0 {
+		return nil, errors.New("this link does not launch a module; add it with deep linking")
+	}
+
+	link.LineItem = ""
+	if claims.Endpoint != nil && claims.Endpoint.LineItem != "" {
+		for _, scope := range claims.Endpoint.Scope {
+			if scope == lti.ScoreScope {
+				link.LineItem = claims.Endpoint.LineItem
+			}
+		}
+	}
+	link.UpdatedOn = time.Now()
+	if err := h.ltiRepository.SaveLTILink(link); err != nil {
+		log.Printf("Error saving LTI link: %v", err)
+	}
+	return link, nil
+}
+
+// enrol adds the user to the course if they are not enrolled yet. Platform
+// instructors become TAs: they see their learners' results but cannot
+// change the course.
+func (h *LTIHandler) enrol(user *models.User, courseID int64, instructor bool) error {
+	if _, err := h.userCourseRepository.GetUserCourseByUserIDAndCourseID(user.KeyID, courseID); err == nil {
+		return nil
+	}
+	role := CourseRoleLearner
+	if instructor {
+		role = CourseRoleTA
+	}
+	_, err := h.userCourseRepository.CreateUserCourse(&models.UserCourse{UserID: user.KeyID, CourseID: courseID, Role: role, StartedOn: time.Now()})
+	return err
+}
+
+var modulePicker = template.Must(template.New("picker").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Add modules</title>
+<style>body{font-family:sans-serif;max-width:48em;margin:2em auto}li{list-style:none;margin:.3em 0}</style></head>
+<body>
+<h1>Add modules</h1>
+<form method="post" action="/lti/deeplink">
+<input type="hidden" name="selection" value="{{.Selection}}">
+{{range .Courses}}<h2>{{.Name}}</h2>
+<ul>{{range .Modules}}<li><label><input type="{{if $.Multiple}}checkbox{{else}}radio{{end}}" name="module" value="{{.KeyID}}"> {{.Name}}</label></li>
+{{else}}<li>No modules</li>{{end}}</ul>
+{{else}}<p>There are no approved courses.</p>{{end}}
+<button type="submit">Add</button>
+</form>
+</body></html>
+`))
+
+// showModules lets an instructor choose which modules of the approved
+// courses to add to the platform
+func (h *LTIHandler) showModules(w http.ResponseWriter, selection lti.Selection) {
+	sealed, err := h.tool.Seal(selection)
+	if err != nil {
+		log.Printf("Error sealing LTI selection: %v", err)
+		WriteError(w, "Unable to start deep linking", http.StatusInternalServerError)
+		return
+	}
+	courses, err := h.courseRepository.GetApprovedCourses()
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	type courseModules struct {
+		Name    string
+		Modules []*models.Module
+	}
+	var listed []courseModules
+	for _, course := range courses {
+		modules, err := h.moduleRepository.GetAllModulesByCourseID(course.KeyID)
+		if err != nil {
+			StorageError(w, err)
+			return
+		}
+		sort.SliceStable(modules, func(i, j int) bool { return modules[i].SortKey < modules[j].SortKey })
+		listed = append(listed, courseModules{Name: course.Name, Modules: modules})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	modulePicker.Execute(w, map[string]interface{}{"Selection": sealed, "Multiple": selection.Multiple, "Courses": listed})
+}
+
+// DeepLink returns the chosen modules (form fields "module") to the platform
+// as graded resource links
+func (h *LTIHandler) DeepLink(w http.ResponseWriter, r *http.Request) {
+	selection, err := h.tool.Open(r.FormValue("selection"))
+	if err != nil {
+		WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	platform, err := h.ltiRepository.GetLTIPlatformByID(selection.PlatformID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		WriteError(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+	ids := r.Form["module"]
+	if !selection.Multiple && len(ids) > 1 {
+		ids = ids[:1]
+	}
+	items := []lti.LinkItem{}
+	for _, id := range ids {
+		moduleID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			WriteError(w, "Invalid module", http.StatusBadRequest)
+			return
+		}
+		module, err := h.moduleRepository.GetModuleByID(moduleID)
+		if err != nil {
+			StorageError(w, err)
+			return
+		}
+		items = append(items, lti.LinkItem{
+			Title:    module.Name,
+			Text:     module.Description,
+			URL:      h.tool.LaunchURL(),
+			Custom:   map[string]string{"module_id": id},
+			LineItem: &lti.LineItem{ScoreMaximum: 100, Label: module.Name, ResourceID: "module-" + id},
+		})
+	}
+
+	response, err := h.tool.DeepLinkingResponse(platform, selection, items)
+	if err != nil {
+		log.Printf("Error signing LTI deep linking response: %v", err)
+		WriteError(w, "Unable to answer the platform", http.StatusInternalServerError)
+		return
+	}
+	lti.AutoPost(w, "Adding modules", selection.ReturnURL, map[string]string{"JWT": response})
+}
+
+// PublishScore posts a module score to the gradebook of every platform the
+// user launched the module from. It runs in the background and logs failures.
+func (h *LTIHandler) PublishScore(userID int64, moduleID int64, percentage int, passed bool) {
+	go func() {
+		links, err := h.ltiRepository.GetLTILinksByModuleID(moduleID)
+		if err != nil || len(links) == 0 {
+			return
+		}
+		identities, err := h.ltiRepository.GetLTIIdentitiesByUserID(userID)
+		if err != nil || len(identities) == 0 {
+			return
+		}
+
+		for _, link := range links {
+			if link.LineItem == "" {
+				continue
+			}
+			platform, err := h.ltiRepository.GetLTIPlatformByID(link.PlatformID)
+			if err != nil {
+				continue
+			}
+			for _, identity := range identities {
+				if identity.Issuer != platform.Issuer {
+					continue
+				}
+				comment := "Not passed"
+				if passed {
+					comment = "Passed"
+				}
+				err := h.tool.PostScore(platform, link.LineItem, lti.Score{
+					UserID:           identity.Subject,
+					ScoreGiven:       float64(percentage),
+					ScoreMaximum:     100,
+					Comment:          comment,
+					ActivityProgress: "Completed",
+					GradingProgress:  "FullyGraded",
+					Timestamp:        time.Now().UTC().Format(time.RFC3339),
+				})
+				if err != nil {
+					log.Printf("Error posting the score of user %d in module %d to %s: %v", userID, moduleID, platform.Issuer, err)
+				}
+			}
+		}
+	}()
+}
+
+// requireAdmin answers 403 unless the current user is an admin: whoever
+// registers a platform decides whose id_tokens log users in
+func (h *LTIHandler) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	user := CurrentUser(r)
+	if user == nil || !h.authorizer.IsAdmin(r, user) {
+		WriteError(w, "Only admins can manage LTI platforms", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// GetPlatforms lists the registered platforms
+func (h *LTIHandler) GetPlatforms(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	platforms, err := h.ltiRepository.GetAllLTIPlatforms()
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	if platforms == nil {
+		platforms = []*models.LTIPlatform{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(platforms)
+}
+
+// CreatePlatform registers a platform
+func (h *LTIHandler) CreatePlatform(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	var platform models.LTIPlatform
+	if !DecodeJSON(w, r, &platform) {
+		return
+	}
+	if existing, err := h.ltiRepository.GetLTIPlatformByIssuer(platform.Issuer, platform.ClientID); err == nil {
+		WriteError(w, "Platform "+strconv.FormatInt(existing.KeyID, 10)+" has this issuer and client ID", http.StatusConflict)
+		return
+	}
+	platform.CreatedOn = time.Now()
+	key, err := h.ltiRepository.CreateLTIPlatform(&platform)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	platform.KeyID = key.ID
+
+	h.auditor.RecordChange(r, "lti.platform.create", "LTIPlatform", key.ID, nil, &platform)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(platform)
+}
+
+// UpdatePlatform replaces a platform's registration; the issuer and client
+// ID are fixed once users were provisioned from the platform
+func (h *LTIHandler) UpdatePlatform(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	id, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	before, err := h.ltiRepository.GetLTIPlatformByID(id)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	var platform models.LTIPlatform
+	if !DecodeJSON(w, r, &platform) {
+		return
+	}
+	if platform.Issuer != before.Issuer || platform.ClientID != before.ClientID {
+		linked, err := h.ltiRepository.HasLTIIdentities(before.Issuer)
+		if err != nil {
+			StorageError(w, err)
+			return
+		}
+		if linked {
+			WriteError(w, "Users were provisioned from this platform; its issuer and client ID cannot change", http.StatusConflict)
+			return
+		}
+		if existing, err := h.ltiRepository.GetLTIPlatformByIssuer(platform.Issuer, platform.ClientID); err == nil && existing.KeyID != id {
+			WriteError(w, "Platform "+strconv.FormatInt(existing.KeyID, 10)+" has this issuer and client ID", http.StatusConflict)
+			return
+		}
+	}
+	platform.KeyID, platform.CreatedOn = id, before.CreatedOn
+	if _, err := h.ltiRepository.UpdateLTIPlatform(id, &platform); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	h.auditor.RecordChange(r, "lti.platform.update", "LTIPlatform", id, before, &platform)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(platform)
+}
+
+// DeletePlatform removes a platform; its users and their results are kept
+func (h *LTIHandler) DeletePlatform(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	id, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	before, err := h.ltiRepository.GetLTIPlatformByID(id)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	if err := h.ltiRepository.DeleteLTIPlatform(id); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	h.auditor.RecordChange(r, "lti.platform.delete", "LTIPlatform", id, before, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Platform deleted"})
+}
+
+// EnsurePlatform registers a platform unless one with its issuer and client
+// ID already is, e.g. the mock platform at startup
+func (h *LTIHandler) EnsurePlatform(platform models.LTIPlatform) error {
+	if _, err := h.ltiRepository.GetLTIPlatformByIssuer(platform.Issuer, platform.ClientID); err == nil {
+		return nil
+	}
+	platform.CreatedOn = time.Now()
+	_, err := h.ltiRepository.CreateLTIPlatform(&platform)
+	return err
+}
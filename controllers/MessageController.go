@@ -0,0 +1,626 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"restAPI/models"
+	"restAPI/notify"
+	"restAPI/push"
+	"restAPI/scan"
+	"restAPI/storage"
+	"restAPI/validation"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// the largest request carrying a message and its attachments
+const maxMessageRequest = models.MaxAttachments*(10<<20) + 1<<20
+
+// MessageHandler lets users of a course write to each other privately, and
+// instructors write to a whole course. Only participants see a conversation.
+type MessageHandler struct {
+	messageRepository    models.MessageRepository
+	userRepository       models.UserRepository
+	userCourseRepository models.UserCourseRepository
+	courseRepository     models.CourseRepository
+	reportRepository     models.ReportRepository
+	blobs                storage.BlobStore
+	quarantine           *scan.Quarantine
+	signedURLExpiry      time.Duration
+	auditor              *Auditor
+	notifier             *notify.Notifier
+	broker               push.Broker
+}
+
+// NewMessageHandler creates a message handler keeping attachments in blobs
+// once quarantine passed them; their downloads are signed for
+// signedURLExpiry if the store can sign them
+func NewMessageHandler(messageRepository models.MessageRepository, userRepository models.UserRepository,
+	userCourseRepository models.UserCourseRepository, courseRepository models.CourseRepository,
+	reportRepository models.ReportRepository, blobs storage.BlobStore, quarantine *scan.Quarantine,
+	signedURLExpiry time.Duration, auditor *Auditor, notifier *notify.Notifier, broker push.Broker) *MessageHandler {
+	return &MessageHandler{
+		messageRepository:    messageRepository,
+		userRepository:       userRepository,
+		userCourseRepository: userCourseRepository,
+		courseRepository:     courseRepository,
+		reportRepository:     reportRepository,
+		blobs:                blobs,
+		quarantine:           quarantine,
+		signedURLExpiry:      signedURLExpiry,
+		auditor:              auditor,
+		notifier:             notifier,
+		broker:               broker,
+	}
+}
+
+// NewConversation is the body of POST /conversation
+type NewConversation struct {
+	CourseID    int64  `json:"course_id" validate:"required"`
+	RecipientID int64  `json:"recipient_id" validate:"required"`
+	Subject     string `json:"subject" validate:"max=200"`
+	Body        string `json:"body" validate:"required,max=5000"`
+}
+
+// NewBroadcast is the body of POST /course/{courseId}/broadcast
+type NewBroadcast struct {
+	Subject string `json:"subject" validate:"required,max=200"`
+	Body    string `json:"body" validate:"required,max=5000"`
+}
+
+// MessageReport is the body of POST /conversation/{id}/message/{messageId}/report
+type MessageReport struct {
+	Reason string `json:"reason" validate:"required,max=1000"`
+	// Block also blocks the sender
+	Block bool `json:"block"`
+}
+
+// ConversationListSpec lists the filters and sort orders of GET /conversation
+var ConversationListSpec = ListSpec{
+	Filters: map[string]FilterParam{
+		"course":    IDFilter("CourseID"),
+		"broadcast": BoolFilter("Broadcast"),
+	},
+	Sorts: map[string]string{
+		"last_message_on": "LastMessageOn",
+	},
+}
+
+// MessageListSpec lists the sort orders of GET /conversation/{id}/message
+var MessageListSpec = ListSpec{
+	Sorts: map[string]string{
+		"sent_on": "SentOn",
+	},
+}
+
+// GetConversations returns a page of the user's inbox, latest first
+func (h *MessageHandler) GetConversations(w http.ResponseWriter, r *http.Request) {
+	userID := CurrentUser(r).KeyID
+	list := func(opts models.QueryOptions) ([]*models.Participant, string, error) {
+		if opts.Order == "" {
+			opts.Order = "-LastMessageOn"
+		}
+		return h.messageRepository.ListParticipants(opts.Where("UserID", userID))
+	}
+	count := func(opts models.QueryOptions) (int, error) {
+		return h.messageRepository.CountParticipants(opts.Where("UserID", userID))
+	}
+	ServeList(w, r, ConversationListSpec, list, count)
+}
+
+// GetUnreadMessageCount returns how many messages the user has not read
+func (h *MessageHandler) GetUnreadMessageCount(w http.ResponseWriter, r *http.Request) {
+	unread, err := h.messageRepository.CountUnreadMessages(CurrentUser(r).KeyID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"unread": unread})
+}
+
+// CreateConversation starts a conversation with another user of a course
+// the sender is in, with its first message
+func (h *MessageHandler) CreateConversation(w http.ResponseWriter, r *http.Request) {
+	request := NewConversation{}
+	files, ok := decodeMessageRequest(w, r, &request)
+	if !ok {
+		return
+	}
+
+	user := CurrentUser(r)
+	if request.RecipientID == user.KeyID {
+		WriteError(w, "You cannot write to yourself", http.StatusUnprocessableEntity)
+		return
+	}
+	if !h.inCourse(user.KeyID, request.CourseID) || !h.inCourse(request.RecipientID, request.CourseID) {
+		WriteError(w, "You can only write to people in your courses", http.StatusForbidden)
+		return
+	}
+	recipient, err := h.userRepository.GetUserByID(request.RecipientID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	if !h.mayWrite(w, user.KeyID, recipient.KeyID) {
+		return
+	}
+
+	conversation := &models.Conversation{
+		CourseID:       request.CourseID,
+		Subject:        request.Subject,
+		ParticipantIDs: []int64{user.KeyID, recipient.KeyID},
+		CreatedBy:      user.KeyID,
+		CreatedOn:      time.Now(),
+	}
+	usernames := map[int64]string{user.KeyID: user.Username, recipient.KeyID: recipient.Username}
+	h.start(w, r, conversation, usernames, request.Body, files)
+}
+
+// Broadcast writes to everyone in a course; they cannot answer it
+func (h *MessageHandler) Broadcast(w http.ResponseWriter, r *http.Request) {
+	courseID, ok := ParseID(w, r, "courseId")
+	if !ok {
+		return
+	}
+	request := NewBroadcast{}
+	files, ok := decodeMessageRequest(w, r, &request)
+	if !ok {
+		return
+	}
+
+	roster, err := h.userCourseRepository.GetUsersByCourseID(courseID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	user := CurrentUser(r)
+	conversation := &models.Conversation{
+		CourseID:       courseID,
+		Subject:        request.Subject,
+		ParticipantIDs: []int64{user.KeyID},
+		CreatedBy:      user.KeyID,
+		CreatedOn:      time.Now(),
+		Broadcast:      true,
+	}
+	usernames := map[int64]string{user.KeyID: user.Username}
+	for _, member := range roster {
+		if _, found := usernames[member.KeyID]; !found {
+			conversation.ParticipantIDs = append(conversation.ParticipantIDs, member.KeyID)
+			usernames[member.KeyID] = member.Username
+		}
+	}
+	if len(conversation.ParticipantIDs) == 1 {
+		WriteError(w, "Nobody is enrolled in the course", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if conversation = h.start(w, r, conversation, usernames, request.Body, files); conversation != nil {
+		h.auditor.RecordChange(r, "message.broadcast", "Conversation", conversation.KeyID, nil,
+			map[string]interface{}{"course_id": courseID, "subject": request.Subject, "recipients": len(conversation.ParticipantIDs) - 1})
+	}
+}
+
+// start stores a conversation and its first message, answering with the
+// conversation, which it returns unless it failed
+func (h *MessageHandler) start(w http.ResponseWriter, r *http.Request, conversation *models.Conversation,
+	usernames map[int64]string, body string, files []*multipart.FileHeader) *models.Conversation {
+	attachments, ok := h.storeAttachments(w, r, files)
+	if !ok {
+		return nil
+	}
+	if _, err := h.messageRepository.CreateConversation(conversation, usernames); err != nil {
+		h.removeAttachments(attachments)
+		StorageError(w, err)
+		return nil
+	}
+	if _, ok := h.send(w, r, CurrentUser(r), conversation, body, attachments); !ok {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(conversation)
+	return conversation
+}
+
+// GetConversation returns a conversation the user takes part in
+func (h *MessageHandler) GetConversation(w http.ResponseWriter, r *http.Request) {
+	conversation, ok := h.conversation(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// GetMessages returns a page of a conversation's messages, latest first
+func (h *MessageHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
+	conversation, ok := h.conversation(w, r)
+	if !ok {
+		return
+	}
+	list := func(opts models.QueryOptions) ([]*models.Message, string, error) {
+		if opts.Order == "" {
+			opts.Order = "-SentOn"
+		}
+		return h.messageRepository.ListMessages(opts.Where("ConversationID", conversation.KeyID))
+	}
+	count := func(opts models.QueryOptions) (int, error) {
+		return h.messageRepository.CountMessages(opts.Where("ConversationID", conversation.KeyID))
+	}
+	ServeList(w, r, MessageListSpec, list, count)
+}
+
+// SendMessage adds a message to a conversation
+func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
+	conversation, ok := h.conversation(w, r)
+	if !ok {
+		return
+	}
+	request := models.Message{}
+	files, ok := decodeMessageRequest(w, r, &request)
+	if !ok {
+		return
+	}
+
+	user := CurrentUser(r)
+	if conversation.Broadcast && conversation.CreatedBy != user.KeyID {
+		WriteError(w, "Broadcasts cannot be answered; start a conversation with the sender instead", http.StatusConflict)
+		return
+	}
+	if !conversation.Broadcast {
+		for _, id := range conversation.ParticipantIDs {
+			if id != user.KeyID && !h.mayWrite(w, user.KeyID, id) {
+				return
+			}
+		}
+	}
+
+	attachments, ok := h.storeAttachments(w, r, files)
+	if !ok {
+		return
+	}
+	message, ok := h.send(w, r, user, conversation, request.Body, attachments)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(message)
+}
+
+// send stores a message, then tells the other participants about it,
+// writing an error and returning false if it could not be stored
+func (h *MessageHandler) send(w http.ResponseWriter, r *http.Request, sender *models.User, conversation *models.Conversation, body string,
+	attachments []models.Attachment) (*models.Message, bool) {
+	message := &models.Message{
+		ConversationID: conversation.KeyID,
+		SenderID:       sender.KeyID,
+		Sender:         sender.Username,
+		Body:           body,
+		Attachments:    attachments,
+		SentOn:         time.Now(),
+	}
+	if _, err := h.messageRepository.SendMessage(message); err != nil {
+		h.removeAttachments(attachments)
+		StorageError(w, err)
+		return nil, false
+	}
+
+	if Impersonating(r) {
+		return message, true
+	}
+	title := "New message from " + sender.Username
+	if conversation.Subject != "" {
+		title += ": " + conversation.Subject
+	}
+	var recipients []int64
+	for _, id := range conversation.ParticipantIDs {
+		if id == sender.KeyID {
+			continue
+		}
+		h.broker.Publish(push.UserTopic(id), "message.created", message)
+		recipients = append(recipients, id)
+	}
+	// a broadcast to a whole course is one job for the notifier
+	h.notifier.NotifyAll(recipients, notify.Message{
+		Event: models.EventMessage,
+		Title: title,
+		Body:  body,
+		Link:  fmt.Sprintf("/conversation/%d", conversation.KeyID),
+	})
+	return message, true
+}
+
+// MarkConversationRead clears the user's unread count of a conversation
+func (h *MessageHandler) MarkConversationRead(w http.ResponseWriter, r *http.Request) {
+	conversation, ok := h.conversation(w, r)
+	if !ok {
+		return
+	}
+	if err := h.messageRepository.MarkConversationRead(conversation.KeyID, CurrentUser(r).KeyID); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Conversation read"})
+}
+
+// GetAttachment downloads a file sent with a message
+func (h *MessageHandler) GetAttachment(w http.ResponseWriter, r *http.Request) {
+	message, ok := h.conversationMessage(w, r)
+	if !ok {
+		return
+	}
+	index, err := strconv.Atoi(mux.Vars(r)["index"])
+	if err != nil || index < 0 || index >= len(message.Attachments) {
+		WriteError(w, "Not found", http.StatusNotFound)
+		return
+	}
+	attachment := message.Attachments[index]
+	serveBlob(w, r, h.blobs, attachment.Path, attachment.Name, true, h.signedURLExpiry)
+}
+
+// ReportMessage sends a message the user received to the course's
+// moderation queue, and with block also blocks its sender
+func (h *MessageHandler) ReportMessage(w http.ResponseWriter, r *http.Request) {
+	conversation, ok := h.conversation(w, r)
+	if !ok {
+		return
+	}
+	message, ok := h.conversationMessage(w, r)
+	if !ok {
+		return
+	}
+	request := MessageReport{}
+	if !DecodeJSON(w, r, &request) {
+		return
+	}
+
+	user := CurrentUser(r)
+	if message.SenderID == user.KeyID {
+		WriteError(w, "You cannot report your own message", http.StatusUnprocessableEntity)
+		return
+	}
+
+	report := models.Report{
+		Kind:       models.PostMessage,
+		PostID:     message.KeyID,
+		CourseID:   conversation.CourseID,
+		ReporterID: user.KeyID,
+		Reporter:   user.Username,
+		Reason:     request.Reason,
+		Excerpt:    message.Body,
+		CreatedOn:  time.Now(),
+	}
+	if _, err := h.reportRepository.CreateReport(&report); err != nil {
+		StorageError(w, err)
+		return
+	}
+	if request.Block {
+		block := &models.Block{BlockerID: user.KeyID, BlockedID: message.SenderID, CreatedOn: time.Now()}
+		if err := h.messageRepository.BlockUser(block); err != nil {
+			StorageError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Reported"})
+}
+
+// GetBlocks returns the users the current user blocked
+func (h *MessageHandler) GetBlocks(w http.ResponseWriter, r *http.Request) {
+	blocks, err := h.messageRepository.GetBlocksByBlockerID(CurrentUser(r).KeyID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blocks)
+}
+
+// BlockUser stops a user from writing to the current user
+func (h *MessageHandler) BlockUser(w http.ResponseWriter, r *http.Request) {
+	blockedID, ok := ParseID(w, r, "userId")
+	if !ok {
+		return
+	}
+	user := CurrentUser(r)
+	if blockedID == user.KeyID {
+		WriteError(w, "You cannot block yourself", http.StatusUnprocessableEntity)
+		return
+	}
+	if _, err := h.userRepository.GetUserByID(blockedID); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	block := &models.Block{BlockerID: user.KeyID, BlockedID: blockedID, CreatedOn: time.Now()}
+	if err := h.messageRepository.BlockUser(block); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(block)
+}
+
+// UnblockUser lets a blocked user write to the current user again
+func (h *MessageHandler) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	blockedID, ok := ParseID(w, r, "userId")
+	if !ok {
+		return
+	}
+	if err := h.messageRepository.UnblockUser(CurrentUser(r).KeyID, blockedID); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "User unblocked"})
+}
+
+// conversation reads the conversation named by the route, writing a 404
+// unless the current user takes part in it
+func (h *MessageHandler) conversation(w http.ResponseWriter, r *http.Request) (*models.Conversation, bool) {
+	id, ok := ParseID(w, r, "id")
+	if !ok {
+		return nil, false
+	}
+	conversation, err := h.messageRepository.GetConversationByID(id)
+	if err != nil {
+		StorageError(w, err)
+		return nil, false
+	}
+	// other users are not told that the conversation exists
+	if !conversation.HasParticipant(CurrentUser(r).KeyID) {
+		WriteError(w, "Not found", http.StatusNotFound)
+		return nil, false
+	}
+	return conversation, true
+}
+
+// conversationMessage reads the message named by the route, writing a 404
+// unless it belongs to a conversation of the current user named by the route
+func (h *MessageHandler) conversationMessage(w http.ResponseWriter, r *http.Request) (*models.Message, bool) {
+	conversation, ok := h.conversation(w, r)
+	if !ok {
+		return nil, false
+	}
+	id, ok := ParseID(w, r, "messageId")
+	if !ok {
+		return nil, false
+	}
+	message, err := h.messageRepository.GetMessageByID(id)
+	if err != nil {
+		StorageError(w, err)
+		return nil, false
+	}
+	if message.ConversationID != conversation.KeyID {
+		WriteError(w, "Not found", http.StatusNotFound)
+		return nil, false
+	}
+	return message, true
+}
+
+// inCourse reports whether the user owns the course or is enrolled in it
+func (h *MessageHandler) inCourse(userID int64, courseID int64) bool {
+	if course, err := h.courseRepository.GetCourseByID(courseID); err == nil && course.OwnerID == userID {
+		return true
+	}
+	_, err := h.userCourseRepository.GetUserCourseByUserIDAndCourseID(userID, courseID)
+	return err == nil
+}
+
+// mayWrite writes a 403 and returns false if either user blocked the other
+func (h *MessageHandler) mayWrite(w http.ResponseWriter, senderID int64, recipientID int64) bool {
+	blocked, err := h.messageRepository.IsBlocked(recipientID, senderID)
+	if err != nil {
+		StorageError(w, err)
+		return false
+	}
+	if blocked {
+		WriteError(w, "This user does not accept your messages", http.StatusForbidden)
+		return false
+	}
+	if blocked, err = h.messageRepository.IsBlocked(senderID, recipientID); err != nil {
+		StorageError(w, err)
+		return false
+	}
+	if blocked {
+		WriteError(w, "You blocked this user", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// decodeMessageRequest reads dst from a JSON body, or from the "message"
+// field of a multipart form whose "attachments" are returned; it writes an
+// error and returns false if either is invalid
+func decodeMessageRequest(w http.ResponseWriter, r *http.Request, dst interface{}) ([]*multipart.FileHeader, bool) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return nil, DecodeJSON(w, r, dst)
+	}
+
+	ExtendDeadlines(r)
+	r.Body = http.MaxBytesReader(w, r.Body, maxMessageRequest)
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		WriteError(w, "Unable to parse form", http.StatusBadRequest)
+		return nil, false
+	}
+	if err := json.NewDecoder(strings.NewReader(r.FormValue("message"))).Decode(dst); err != nil {
+		WriteAPIError(w, http.StatusBadRequest, APIError{Code: CodeInvalidJSON, Message: "Invalid JSON in message: " + err.Error()})
+		return nil, false
+	}
+	if fields := validation.Struct(dst); len(fields) > 0 {
+		WriteAPIError(w, http.StatusUnprocessableEntity, APIError{
+			Code:    CodeValidationFailed,
+			Message: "Some fields are invalid",
+			Fields:  fields,
+		})
+		return nil, false
+	}
+
+	files := r.MultipartForm.File["attachments"]
+	if len(files) > models.MaxAttachments {
+		WriteError(w, fmt.Sprintf("At most %d attachments can be sent", models.MaxAttachments), http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	return files, true
+}
+
+// storeAttachments validates, scans and stores files as the upload
+// subsystem does, writing an error and returning false if any is refused
+func (h *MessageHandler) storeAttachments(w http.ResponseWriter, r *http.Request, files []*multipart.FileHeader) ([]models.Attachment, bool) {
+	attachments := []models.Attachment{}
+	for _, header := range files {
+		file, err := header.Open()
+		if err != nil {
+			h.removeAttachments(attachments)
+			WriteError(w, "Unable to read attachment", http.StatusBadRequest)
+			return nil, false
+		}
+		name, contentType, err := storeUpload(r.Context(), h.quarantine, file, header, "message", "messages")
+		file.Close()
+		if err != nil {
+			h.removeAttachments(attachments)
+			uploadError(w, err)
+			return nil, false
+		}
+		attachments = append(attachments, models.Attachment{
+			Name:        filepath.Base(header.Filename),
+			Size:        header.Size,
+			ContentType: contentType,
+			Path:        name,
+		})
+	}
+	return attachments, true
+}
+
+// removeAttachments deletes the files of a message which was not sent
+func (h *MessageHandler) removeAttachments(attachments []models.Attachment) {
+	for _, attachment := range attachments {
+		if err := h.blobs.Delete(context.Background(), attachment.Path); err != nil {
+			log.Printf("Error removing attachment %s: %v", attachment.Path, err)
+		}
+	}
+}
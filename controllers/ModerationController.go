@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"restAPI/models"
+	"restAPI/push"
+	"time"
+)
+
+// ModerationHandler takes reports on threads and replies, and lets course
+// instructors work through them: hiding, restoring, locking and pinning
+type ModerationHandler struct {
+	threadRepository models.ThreadRepository
+	replyRepository  models.ReplyRepository
+	reportRepository models.ReportRepository
+	moduleRepository models.ModuleRepository
+	auditor          *Auditor
+	broker           push.Broker
+}
+
+// NewModerationHandler ..
+func NewModerationHandler(threadRepository models.ThreadRepository, replyRepository models.ReplyRepository,
+	reportRepository models.ReportRepository, moduleRepository models.ModuleRepository, auditor *Auditor,
+	broker push.Broker) *ModerationHandler {
+	return &ModerationHandler{
+		threadRepository: threadRepository,
+		replyRepository:  replyRepository,
+		reportRepository: reportRepository,
+		moduleRepository: moduleRepository,
+		auditor:          auditor,
+		broker:           broker,
+	}
+}
+
+// ReportThread records the current user's report of a thread
+func (h *ModerationHandler) ReportThread(w http.ResponseWriter, r *http.Request) {
+	id, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	thread, err := h.threadRepository.GetThreadByID(id)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	h.report(w, r, models.PostThread, id, id, thread.ModuleID)
+}
+
+// ReportReply records the current user's report of a reply
+func (h *ModerationHandler) ReportReply(w http.ResponseWriter, r *http.Request) {
+	reply, thread, ok := h.threadReply(w, r)
+	if !ok {
+		return
+	}
+	h.report(w, r, models.PostReply, reply.KeyID, reply.ThreadID, thread.ModuleID)
+}
+
+func (h *ModerationHandler) report(w http.ResponseWriter, r *http.Request, kind string, postID int64, threadID int64, moduleID int64) {
+	report := models.Report{}
+	if !DecodeJSON(w, r, &report) {
+		return
+	}
+	module, err := h.moduleRepository.GetModuleByID(moduleID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	user := CurrentUser(r)
+	report = models.Report{
+		Kind:       kind,
+		PostID:     postID,
+		ThreadID:   threadID,
+		CourseID:   module.CourseID,
+		ReporterID: user.KeyID,
+		Reporter:   user.Username,
+		Reason:     report.Reason,
+		CreatedOn:  time.Now(),
+	}
+	if _, err := h.reportRepository.CreateReport(&report); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Reported"})
+}
+
+// ReportListSpec lists the filters and sort orders of GET /course/{courseId}/report
+var ReportListSpec = ListSpec{
+	Filters: map[string]FilterParam{
+		"kind":     StringFilter("Kind"),
+		"thread":   IDFilter("ThreadID"),
+		"resolved": BoolFilter("Resolved"),
+	},
+	Sorts: map[string]string{
+		"created_on": "CreatedOn",
+	},
+}
+
+// GetReports returns a page of a course's moderation queue: its open reports,
+// oldest first, or with ?resolved=true those already dealt with
+func (h *ModerationHandler) GetReports(w http.ResponseWriter, r *http.Request) {
+	courseID, ok := ParseID(w, r, "courseId")
+	if !ok {
+		return
+	}
+	scope := func(opts models.QueryOptions) models.QueryOptions {
+		if r.URL.Query().Get("resolved") == "" {
+			opts = opts.Where("Resolved", false)
+		}
+		return opts.Where("CourseID", courseID)
+	}
+	list := func(opts models.QueryOptions) ([]*models.Report, string, error) {
+		if opts.Order == "" {
+			opts.Order = "CreatedOn"
+		}
+		return h.reportRepository.ListReports(scope(opts))
+	}
+	count := func(opts models.QueryOptions) (int, error) {
+		return h.reportRepository.CountReports(scope(opts))
+	}
+	ServeList(w, r, ReportListSpec, list, count)
+}
+
+// HideThread hides a thread from everyone but moderators, resolving its reports
+func (h *ModerationHandler) HideThread(w http.ResponseWriter, r *http.Request) {
+	h.moderateThread(w, r, "thread.hide", func(thread *models.Thread) { thread.Hidden = true }, models.ReportHidden)
+}
+
+// RestoreThread shows a hidden thread again
+func (h *ModerationHandler) RestoreThread(w http.ResponseWriter, r *http.Request) {
+	h.moderateThread(w, r, "thread.restore", func(thread *models.Thread) { thread.Hidden = false }, "")
+}
+
+// LockThread stops replies, edits and votes on a thread
+func (h *ModerationHandler) LockThread(w http.ResponseWriter, r *http.Request) {
+	h.moderateThread(w, r, "thread.lock", func(thread *models.Thread) { thread.Closed = true }, "")
+}
+
+// UnlockThread reopens a locked thread
+func (h *ModerationHandler) UnlockThread(w http.ResponseWriter, r *http.Request) {
+	h.moderateThread(w, r, "thread.unlock", func(thread *models.Thread) { thread.Closed = false }, "")
+}
+
+// PinThread keeps a thread at the top of its module's threads
+func (h *ModerationHandler) PinThread(w http.ResponseWriter, r *http.Request) {
+	h.moderateThread(w, r, "thread.pin", func(thread *models.Thread) { thread.Pinned = true }, "")
+}
+
+// UnpinThread returns a pinned thread to its place
+func (h *ModerationHandler) UnpinThread(w http.ResponseWriter, r *http.Request) {
+	h.moderateThread(w, r, "thread.unpin", func(thread *models.Thread) { thread.Pinned = false }, "")
+}
+
+// DismissThreadReports resolves a thread's reports, leaving it as it is
+func (h *ModerationHandler) DismissThreadReports(w http.ResponseWriter, r *http.Request) {
+	h.moderateThread(w, r, "thread.dismiss", nil, models.ReportDismissed)
+}
+
+// HideReply hides a reply from everyone but moderators, resolving its reports
+func (h *ModerationHandler) HideReply(w http.ResponseWriter, r *http.Request) {
+	h.moderateReply(w, r, "reply.hide", func(reply *models.Reply) { reply.Hidden = true }, models.ReportHidden)
+}
+
+// RestoreReply shows a hidden reply again
+func (h *ModerationHandler) RestoreReply(w http.ResponseWriter, r *http.Request) {
+	h.moderateReply(w, r, "reply.restore", func(reply *models.Reply) { reply.Hidden = false }, "")
+}
+
+// DismissReplyReports resolves a reply's reports, leaving it as it is
+func (h *ModerationHandler) DismissReplyReports(w http.ResponseWriter, r *http.Request) {
+	h.moderateReply(w, r, "reply.dismiss", nil, models.ReportDismissed)
+}
+
+// DismissMessageReports resolves the reports on a private message
+func (h *ModerationHandler) DismissMessageReports(w http.ResponseWriter, r *http.Request) {
+	id, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	resolved, err := h.reportRepository.ResolveReports(models.PostMessage, id, models.ReportDismissed, CurrentUser(r).KeyID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	h.auditor.RecordChange(r, "message.dismiss", "Message", id, map[string]bool{"reported": true}, map[string]bool{"reported": false})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"resolved": resolved})
+}
+
+// moderateThread applies change (if any) to the thread named by the route,
+// then resolves its open reports with resolution (if any), and answers with
+// the thread
+func (h *ModerationHandler) moderateThread(w http.ResponseWriter, r *http.Request, action string,
+	change func(thread *models.Thread), resolution string) {
+	id, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	thread, err := h.threadRepository.GetThreadByID(id)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	before := threadFlags(thread)
+
+	if change != nil {
+		if thread, err = h.threadRepository.ModerateThread(id, change); err != nil {
+			StorageError(w, err)
+			return
+		}
+	}
+	if resolution != "" {
+		if _, err := h.reportRepository.ResolveReports(models.PostThread, id, resolution, CurrentUser(r).KeyID); err != nil {
+			StorageError(w, err)
+			return
+		}
+		thread.Reported = false
+	}
+	h.auditor.RecordChange(r, action, "Thread", id, before, threadFlags(thread))
+
+	thread.KeyID = id
+	if change != nil {
+		publishThread(r, h.broker, "thread.updated", thread)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(thread)
+}
+
+// moderateReply is moderateThread for the reply named by the route
+func (h *ModerationHandler) moderateReply(w http.ResponseWriter, r *http.Request, action string,
+	change func(reply *models.Reply), resolution string) {
+	reply, thread, ok := h.threadReply(w, r)
+	if !ok {
+		return
+	}
+	id, before := reply.KeyID, replyFlags(reply)
+
+	if change != nil {
+		var err error
+		if reply, err = h.replyRepository.ModerateReply(id, change); err != nil {
+			StorageError(w, err)
+			return
+		}
+	}
+	if resolution != "" {
+		if _, err := h.reportRepository.ResolveReports(models.PostReply, id, resolution, CurrentUser(r).KeyID); err != nil {
+			StorageError(w, err)
+			return
+		}
+		reply.Reported = false
+	}
+	h.auditor.RecordChange(r, action, "Reply", id, before, replyFlags(reply))
+	if change != nil {
+		publishReply(r, h.broker, "reply.updated", thread.ModuleID, reply)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// threadFlags and replyFlags are what the audit log records of a moderated post
+func threadFlags(thread *models.Thread) map[string]bool {
+	return map[string]bool{"hidden": thread.Hidden, "reported": thread.Reported, "locked": thread.Closed, "pinned": thread.Pinned}
+}
+
+func replyFlags(reply *models.Reply) map[string]bool {
+	return map[string]bool{"hidden": reply.Hidden, "reported": reply.Reported}
+}
+
+// threadReply reads the reply named by the route and its thread, writing a
+// 404 unless the reply belongs to the thread named by the route
+func (h *ModerationHandler) threadReply(w http.ResponseWriter, r *http.Request) (*models.Reply, *models.Thread, bool) {
+	threadID, ok := ParseID(w, r, "threadId")
+	if !ok {
+		return nil, nil, false
+	}
+	id, ok := ParseID(w, r, "id")
+	if !ok {
+		return nil, nil, false
+	}
+	reply, err := h.replyRepository.GetReplyByID(id)
+	if err != nil {
+		StorageError(w, err)
+		return nil, nil, false
+	}
+	if reply.ThreadID != threadID {
+		WriteError(w, "Not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	thread, err := h.threadRepository.GetThreadByID(threadID)
+	if err != nil {
+		StorageError(w, err)
+		return nil, nil, false
+	}
+	return reply, thread, true
+}
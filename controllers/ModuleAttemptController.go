@@ -2,12 +2,14 @@ package controllers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"restAPI/models"
+	"restAPI/push"
+	"restAPI/scorm"
+	"restAPI/xapi"
 	"strconv"
 	"time"
-
-	"github.com/gorilla/mux"
 )
 
 // ModuleAttemptHandler manages user attempts and submissions for modules
@@ -16,6 +18,17 @@ type ModuleAttemptHandler struct {
 	elementRepo       models.ElementRepository
 	moduleElementRepo models.ModuleElementRepository
 	userRepo          models.UserRepository
+	scormAttemptRepo  models.ScormAttemptRepository
+	auditor           *Auditor
+	emitter           *xapi.Emitter
+	scores            ScorePublisher
+	broker            push.Broker
+}
+
+// ScorePublisher passes graded submissions on to other systems, such as the
+// LMS a module was launched from
+type ScorePublisher interface {
+	PublishScore(userID int64, moduleID int64, percentage int, passed bool)
 }
 
 // ModuleSubmission represents a user's module submission with answers
@@ -36,35 +49,39 @@ type ModuleResult struct {
 
 // NewModuleAttemptHandler creates a new module attempt handler
 func NewModuleAttemptHandler(moduleRepo models.ModuleRepository, elementRepo models.ElementRepository,
-	moduleElementRepo models.ModuleElementRepository, userRepo models.UserRepository) *ModuleAttemptHandler {
+	moduleElementRepo models.ModuleElementRepository, userRepo models.UserRepository, scormAttemptRepo models.ScormAttemptRepository,
+	auditor *Auditor, emitter *xapi.Emitter, scores ScorePublisher, broker push.Broker) *ModuleAttemptHandler {
 	return &ModuleAttemptHandler{
 		moduleRepo:        moduleRepo,
 		elementRepo:       elementRepo,
 		moduleElementRepo: moduleElementRepo,
 		userRepo:          userRepo,
+		scormAttemptRepo:  scormAttemptRepo,
+		auditor:           auditor,
+		emitter:           emitter,
+		scores:            scores,
+		broker:            broker,
 	}
 }
 
 // StartModule initializes a module session for a user
 func (h *ModuleAttemptHandler) StartModule(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	moduleID, err := strconv.ParseInt(vars["id"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid module ID", http.StatusBadRequest)
+	moduleID, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
 	// Get the module to get time limit and other settings
 	module, err := h.moduleRepo.GetModuleByID(moduleID)
 	if err != nil {
-		http.Error(w, "Module not found", http.StatusNotFound)
+		WriteError(w, "Module not found", http.StatusNotFound)
 		return
 	}
 
 	// Get the module elements (questions/content)
 	moduleElements, err := h.moduleElementRepo.GetModuleElementsByModuleID(moduleID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve module elements", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve module elements", http.StatusInternalServerError)
 		return
 	}
 
@@ -107,6 +124,14 @@ func (h *ModuleAttemptHandler) StartModule(w http.ResponseWriter, r *http.Reques
 		StartTime: time.Now(),
 	}
 
+	if user := CurrentUser(r); user != nil && h.emitter.Enabled() && !Impersonating(r) {
+		h.emitter.Send(xapi.Statement{
+			Actor:  h.emitter.Learner(user.KeyID, user.Username),
+			Verb:   xapi.Attempted,
+			Object: h.emitter.Module(moduleID, module.Name),
+		})
+	}
+
 	// Return the module session
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(moduleSession)
@@ -114,45 +139,40 @@ func (h *ModuleAttemptHandler) StartModule(w http.ResponseWriter, r *http.Reques
 
 // SubmitModule handles a module submission and returns the results
 func (h *ModuleAttemptHandler) SubmitModule(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	moduleID, err := strconv.ParseInt(vars["id"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid module ID", http.StatusBadRequest)
+	moduleID, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
-	userID, err := strconv.ParseInt(vars["userId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	userID, ok := ParseID(w, r, "userId")
+	if !ok {
 		return
 	}
 
 	// Decode the submission
 	var submission ModuleSubmission
-	err = json.NewDecoder(r.Body).Decode(&submission)
-	if err != nil {
-		http.Error(w, "Invalid module submission", http.StatusBadRequest)
+	if !DecodeJSON(w, r, &submission) {
 		return
 	}
 
 	// Get the module for grading criteria
 	module, err := h.moduleRepo.GetModuleByID(moduleID)
 	if err != nil {
-		http.Error(w, "Module not found", http.StatusNotFound)
+		WriteError(w, "Module not found", http.StatusNotFound)
 		return
 	}
 
 	// Get the user to update their modules
 	user, err := h.userRepo.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		WriteError(w, "User not found", http.StatusNotFound)
 		return
 	}
 
 	// Get all elements for this module for grading
 	moduleElements, err := h.moduleElementRepo.GetModuleElementsByModuleID(moduleID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve module elements", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve module elements", http.StatusInternalServerError)
 		return
 	}
 
@@ -172,6 +192,20 @@ func (h *ModuleAttemptHandler) SubmitModule(w http.ResponseWriter, r *http.Reque
 
 		// Look up the user's answer
 		elementIDStr := strconv.FormatInt(me.ElementID, 10)
+
+		// a SCORM element's answer is the runtime data its SCO stored
+		if element.Type == "scorm" {
+			attempt, err := h.scormAttemptRepo.GetScormAttempt(userID, moduleID, me.ElementID)
+			if err != nil {
+				delete(submission.Answers, elementIDStr)
+				continue
+			}
+			if submission.Answers == nil {
+				submission.Answers = map[string]models.Answer{}
+			}
+			submission.Answers[elementIDStr] = models.Answer{CMI: attempt.CMI}
+		}
+
 		userAnswer, found := submission.Answers[elementIDStr]
 		if !found {
 			continue // Skip if no answer provided
@@ -219,6 +253,14 @@ func (h *ModuleAttemptHandler) SubmitModule(w http.ResponseWriter, r *http.Reque
 				userAnswer.Correct = true
 				submission.Answers[elementIDStr] = userAnswer
 			}
+		case "scorm":
+			// passed, or completed without a failure where the SCO has no mastery score
+			status := scorm.StatusOf(element.ScormVersion, (&models.ScormAttempt{CMI: userAnswer.CMI}).Data())
+			if status.Passed || (status.Completed && !status.Failed) {
+				score++
+				userAnswer.Correct = true
+				submission.Answers[elementIDStr] = userAnswer
+			}
 		}
 	}
 
@@ -248,8 +290,10 @@ func (h *ModuleAttemptHandler) SubmitModule(w http.ResponseWriter, r *http.Reque
 
 	// Check if this module already exists for this user
 	moduleFound := false
+	var previous *models.UserModule
 	for i, m := range user.Modules {
 		if m.ModuleID == moduleID {
+			previous = &m
 			user.Modules[i] = userModule
 			moduleFound = true
 			break
@@ -263,10 +307,25 @@ func (h *ModuleAttemptHandler) SubmitModule(w http.ResponseWriter, r *http.Reque
 	// Update the user
 	_, err = h.userRepo.UpdateUser(userID, user)
 	if err != nil {
-		http.Error(w, "Failed to update user progress", http.StatusInternalServerError)
+		WriteError(w, "Failed to update user progress", http.StatusInternalServerError)
 		return
 	}
 
+	h.auditor.RecordChange(r, "attempt.grade", "User", userID, previous, &userModule)
+
+	// the runtime data is now part of the attempt
+	if err := h.scormAttemptRepo.DeleteScormAttempts(userID, moduleID); err != nil {
+		log.Printf("Error deleting SCORM runtime data: %v", err)
+	}
+
+	if !Impersonating(r) {
+		h.sendResult(userID, user.Username, moduleID, module.Name, percentage, passed, submission.TimeSpent)
+		h.scores.PublishScore(userID, moduleID, percentage, passed)
+		h.broker.Publish(push.UserTopic(userID), "module.graded", map[string]interface{}{
+			"module_id": moduleID, "score": score, "max_score": maxScore, "percentage": percentage, "passed": passed,
+		})
+	}
+
 	// Prepare the result
 	result := ModuleResult{
 		Score:        score,
@@ -288,23 +347,20 @@ func (h *ModuleAttemptHandler) SubmitModule(w http.ResponseWriter, r *http.Reque
 
 // GetModuleResults gets a user's results for a specific module
 func (h *ModuleAttemptHandler) GetModuleResults(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	moduleID, err := strconv.ParseInt(vars["id"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid module ID", http.StatusBadRequest)
+	moduleID, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
-	userID, err := strconv.ParseInt(vars["userId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	userID, ok := ParseID(w, r, "userId")
+	if !ok {
 		return
 	}
 
 	// Get the user to check their modules
 	user, err := h.userRepo.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		WriteError(w, "User not found", http.StatusNotFound)
 		return
 	}
 
@@ -318,21 +374,21 @@ func (h *ModuleAttemptHandler) GetModuleResults(w http.ResponseWriter, r *http.R
 	}
 
 	if userModule == nil {
-		http.Error(w, "No results found for this module", http.StatusNotFound)
+		WriteError(w, "No results found for this module", http.StatusNotFound)
 		return
 	}
 
 	// Get the module for reference
 	module, err := h.moduleRepo.GetModuleByID(moduleID)
 	if err != nil {
-		http.Error(w, "Module not found", http.StatusNotFound)
+		WriteError(w, "Module not found", http.StatusNotFound)
 		return
 	}
 
 	// Get all elements for this module
 	elements, err := h.moduleElementRepo.GetElementsByModuleID(moduleID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve module elements", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve module elements", http.StatusInternalServerError)
 		return
 	}
 
@@ -355,24 +411,15 @@ func (h *ModuleAttemptHandler) GetModuleResults(w http.ResponseWriter, r *http.R
 
 // GetModuleAnalytics gets aggregate statistics for a module
 func (h *ModuleAttemptHandler) GetModuleAnalytics(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	moduleID, err := strconv.ParseInt(vars["id"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid module ID", http.StatusBadRequest)
-		return
-	}
-
-	// Get all users
-	users, err := h.userRepo.GetAllUsers()
-	if err != nil {
-		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+	moduleID, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
 	// Get the module
 	module, err := h.moduleRepo.GetModuleByID(moduleID)
 	if err != nil {
-		http.Error(w, "Module not found", http.StatusNotFound)
+		WriteError(w, "Module not found", http.StatusNotFound)
 		return
 	}
 
@@ -389,31 +436,38 @@ func (h *ModuleAttemptHandler) GetModuleAnalytics(w http.ResponseWriter, r *http
 		PercentCorrect float64
 	})
 
-	// Collect data
-	for _, user := range users {
-		for _, m := range user.Modules {
-			if m.ModuleID == moduleID {
-				totalAttempts++
-				if m.Score >= module.MinPassing {
-					totalPassed++
-				}
-				averageScore += m.Score
-				averageTime += m.TimePassed
-
-				// Element-specific stats
-				for elementID, answer := range m.Answers {
-					stats := elementStats[elementID]
-					stats.Attempts++
-					if answer.Correct {
-						stats.Correct++
+	// Collect data, a page of users at a time
+	err = EachPage(models.QueryOptions{}, h.userRepo.ListUsers, func(users []*models.User) error {
+		for _, user := range users {
+			for _, m := range user.Modules {
+				if m.ModuleID == moduleID {
+					totalAttempts++
+					if m.Score >= module.MinPassing {
+						totalPassed++
 					}
-					if stats.Attempts > 0 {
-						stats.PercentCorrect = float64(stats.Correct) / float64(stats.Attempts) * 100
+					averageScore += m.Score
+					averageTime += m.TimePassed
+
+					// Element-specific stats
+					for elementID, answer := range m.Answers {
+						stats := elementStats[elementID]
+						stats.Attempts++
+						if answer.Correct {
+							stats.Correct++
+						}
+						if stats.Attempts > 0 {
+							stats.PercentCorrect = float64(stats.Correct) / float64(stats.Attempts) * 100
+						}
+						elementStats[elementID] = stats
 					}
-					elementStats[elementID] = stats
 				}
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		WriteError(w, "Failed to retrieve users", http.StatusInternalServerError)
+		return
 	}
 
 	// Calculate averages
@@ -473,32 +527,32 @@ func (h *ModuleAttemptHandler) GetModuleAnalytics(w http.ResponseWriter, r *http
 
 // ResetModuleAttempt allows a user to reset their module attempt
 func (h *ModuleAttemptHandler) ResetModuleAttempt(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	moduleID, err := strconv.ParseInt(vars["id"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid module ID", http.StatusBadRequest)
+	moduleID, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
-	userID, err := strconv.ParseInt(vars["userId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	userID, ok := ParseID(w, r, "userId")
+	if !ok {
 		return
 	}
 
 	// Get the user
 	user, err := h.userRepo.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		WriteError(w, "User not found", http.StatusNotFound)
 		return
 	}
 
 	// Remove this module from the user's modules
+	var removed *models.UserModule
 	if user.Modules != nil {
 		updatedModules := []models.UserModule{}
-		for _, m := range user.Modules {
+		for i, m := range user.Modules {
 			if m.ModuleID != moduleID {
 				updatedModules = append(updatedModules, m)
+			} else {
+				removed = &user.Modules[i]
 			}
 		}
 		user.Modules = updatedModules
@@ -507,10 +561,40 @@ func (h *ModuleAttemptHandler) ResetModuleAttempt(w http.ResponseWriter, r *http
 	// Update the user
 	_, err = h.userRepo.UpdateUser(userID, user)
 	if err != nil {
-		http.Error(w, "Failed to reset module attempt", http.StatusInternalServerError)
+		WriteError(w, "Failed to reset module attempt", http.StatusInternalServerError)
 		return
 	}
 
+	h.auditor.RecordChange(r, "attempt.reset", "User", userID, removed, nil)
+
+	if err := h.scormAttemptRepo.DeleteScormAttempts(userID, moduleID); err != nil {
+		log.Printf("Error deleting SCORM runtime data: %v", err)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Module attempt reset successfully"})
 }
+
+// sendResult reports a graded submission to the LRS: completed, with the
+// score, then passed or failed
+func (h *ModuleAttemptHandler) sendResult(userID int64, username string, moduleID int64, moduleName string, percentage int, passed bool, timeSpent int) {
+	if !h.emitter.Enabled() {
+		return
+	}
+	actor := h.emitter.Learner(userID, username)
+	object := h.emitter.Module(moduleID, moduleName)
+	completion := true
+	result := &xapi.Result{
+		Score:      &xapi.Score{Scaled: float64(percentage) / 100, Raw: float64(percentage), Min: 0, Max: 100},
+		Success:    &passed,
+		Completion: &completion,
+		Duration:   xapi.Duration(timeSpent),
+	}
+
+	h.emitter.Send(xapi.Statement{Actor: actor, Verb: xapi.Completed, Object: object, Result: result})
+	verb := xapi.Failed
+	if passed {
+		verb = xapi.Passed
+	}
+	h.emitter.Send(xapi.Statement{Actor: actor, Verb: verb, Object: object, Result: result})
+}
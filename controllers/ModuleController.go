@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"net/http"
 	"restAPI/models"
-	"strconv"
 
 	"github.com/gorilla/mux"
 )
@@ -13,47 +12,57 @@ import (
 type ModuleHandler struct {
 	moduleRepository models.ModuleRepository
 	courseRepository models.CourseRepository
+	auditor          *Auditor
 }
 
 // NewModuleHandler ..
-func NewModuleHandler(moduleRepository models.ModuleRepository, courseRepository models.CourseRepository) *ModuleHandler {
-	return &ModuleHandler{moduleRepository: moduleRepository, courseRepository: courseRepository}
+func NewModuleHandler(moduleRepository models.ModuleRepository, courseRepository models.CourseRepository, auditor *Auditor) *ModuleHandler {
+	return &ModuleHandler{moduleRepository: moduleRepository, courseRepository: courseRepository, auditor: auditor}
 }
 
 // add module
 func (c *ModuleHandler) CreateModule(w http.ResponseWriter, r *http.Request) {
 	module := models.Module{}
-	err := json.NewDecoder(r.Body).Decode(&module)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !DecodeJSON(w, r, &module) {
 		return
 	}
 
 	// If there was a param "courseId" then use it for the course_id in module
 	if id := mux.Vars(r)["courseId"]; id != "" {
-		idInt, _ := strconv.ParseInt(id, 10, 64)
+		idInt, ok := ParseID(w, r, "courseId")
+		if !ok {
+			return
+		}
 		module.CourseID = idInt
 	}
 
+	// the creator owns the module unless an owner was given
+	if user := CurrentUser(r); user != nil && module.OwnerID == 0 {
+		module.OwnerID = user.KeyID
+	}
+
 	key, err := c.moduleRepository.CreateModule(&module)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
 	// If courseId is not nil, update the modules for the course with this id by adding key.ID to the modules list
 	if id := mux.Vars(r)["courseId"]; id != "" {
 		// convert id to int64
-		idInt, _ := strconv.ParseInt(id, 10, 64)
+		idInt, ok := ParseID(w, r, "courseId")
+		if !ok {
+			return
+		}
 		course, err := c.courseRepository.GetCourseByID(idInt)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			StorageError(w, err)
 			return
 		}
 		course.Modules = append(course.Modules, key.ID)
 		_, err = c.courseRepository.UpdateCourse(idInt, course)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			StorageError(w, err)
 			return
 		}
 	}
@@ -64,22 +73,30 @@ func (c *ModuleHandler) CreateModule(w http.ResponseWriter, r *http.Request) {
 
 // remove module
 func (c *ModuleHandler) DeleteModule(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	before, _ := c.moduleRepository.GetModuleByID(idInt)
 	err := c.moduleRepository.DeleteModule(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "module.delete", "Module", idInt, before, nil)
+
 	// If courseId is not nil, remove key.ID from the course modules list
 	if courseId := mux.Vars(r)["courseId"]; courseId != "" {
 		// convert id to int64
-		courseIdInt, _ := strconv.ParseInt(courseId, 10, 64)
+		courseIdInt, ok := ParseID(w, r, "courseId")
+		if !ok {
+			return
+		}
 
 		course, err := c.courseRepository.GetCourseByID(courseIdInt)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			StorageError(w, err)
 			return
 		}
 
@@ -99,7 +116,7 @@ func (c *ModuleHandler) DeleteModule(w http.ResponseWriter, r *http.Request) {
 
 		_, err = c.courseRepository.UpdateCourse(idInt, course)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			StorageError(w, err)
 			return
 		}
 	}
@@ -112,7 +129,7 @@ func (c *ModuleHandler) DeleteModule(w http.ResponseWriter, r *http.Request) {
 func (c *ModuleHandler) GetAllModules(w http.ResponseWriter, r *http.Request) {
 	modules, err := c.moduleRepository.GetAllModules()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -122,11 +139,13 @@ func (c *ModuleHandler) GetAllModules(w http.ResponseWriter, r *http.Request) {
 
 // get module by id
 func (c *ModuleHandler) GetModuleByID(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	module, err := c.moduleRepository.GetModuleByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -137,33 +156,46 @@ func (c *ModuleHandler) GetModuleByID(w http.ResponseWriter, r *http.Request) {
 // update module
 func (c *ModuleHandler) UpdateModule(w http.ResponseWriter, r *http.Request) {
 
-	var id = mux.Vars(r)["id"]
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+
 	module := models.Module{}
-	err := json.NewDecoder(r.Body).Decode(&module)
+	if !DecodeJSON(w, r, &module) {
+		return
+	}
+
+	before, err := c.moduleRepository.GetModuleByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		StorageError(w, err)
 		return
 	}
 
-	//convert id to int64
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	// the course was checked for this module, and threads are attached by
+	// posting them; neither moves, nor does ownership
+	module.CourseID, module.OwnerID, module.ThreadIDs = before.CourseID, before.OwnerID, before.ThreadIDs
 
 	key, err := c.moduleRepository.UpdateModule(idInt, &module)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "module.update", "Module", idInt, before, &module)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(key)
 }
 
 func (c *ModuleHandler) GetAllModulesByCourseID(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["courseId"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "courseId")
+	if !ok {
+		return
+	}
 	modules, err := c.moduleRepository.GetAllModulesByCourseID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
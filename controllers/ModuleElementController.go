@@ -4,34 +4,52 @@ import (
 	"encoding/json"
 	"net/http"
 	"restAPI/models"
-	"strconv"
-
-	"github.com/gorilla/mux"
 )
 
 // ModuleElementHandler ..
 type ModuleElementHandler struct {
 	moduleElementRepository models.ModuleElementRepository
+	authorizer              *Authorizer
 }
 
 // NewModuleElementHandler ..
-func NewModuleElementHandler(moduleElementRepository models.ModuleElementRepository) *ModuleElementHandler {
+func NewModuleElementHandler(moduleElementRepository models.ModuleElementRepository, authorizer *Authorizer) *ModuleElementHandler {
 	return &ModuleElementHandler{
 		moduleElementRepository: moduleElementRepository,
+		authorizer:              authorizer,
 	}
 }
 
 func (h *ModuleElementHandler) CreateModuleElement(w http.ResponseWriter, r *http.Request) {
 	moduleElement := models.ModuleElement{}
-	err := json.NewDecoder(r.Body).Decode(&moduleElement)
+	if !DecodeJSON(w, r, &moduleElement) {
+		return
+	}
+
+	// linking needs instructor rights on the module and on the element
+	user := CurrentUser(r)
+	if user == nil {
+		WriteError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	module, err := h.authorizer.moduleResource(moduleElement.ModuleID)
+	if err != nil {
+		WriteError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	element, err := h.authorizer.elementResource(moduleElement.ElementID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		WriteError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !h.authorizer.Allowed(r, user, CourseRoleInstructor, module) || !h.authorizer.Allowed(r, user, CourseRoleInstructor, element) {
+		WriteError(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
 	key, err := h.moduleElementRepository.CreateModuleElement(&moduleElement)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -44,7 +62,7 @@ func (h *ModuleElementHandler) CreateModuleElement(w http.ResponseWriter, r *htt
 func (h *ModuleElementHandler) GetAllModuleElements(w http.ResponseWriter, r *http.Request) {
 	moduleElements, err := h.moduleElementRepository.GetAllModuleElements()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -53,11 +71,13 @@ func (h *ModuleElementHandler) GetAllModuleElements(w http.ResponseWriter, r *ht
 }
 
 func (h *ModuleElementHandler) DeleteModuleElement(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	err := h.moduleElementRepository.DeleteModuleElement(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -66,18 +86,27 @@ func (h *ModuleElementHandler) DeleteModuleElement(w http.ResponseWriter, r *htt
 }
 
 func (h *ModuleElementHandler) UpdateModuleElement(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	moduleElement := models.ModuleElement{}
-	err := json.NewDecoder(r.Body).Decode(&moduleElement)
+	if !DecodeJSON(w, r, &moduleElement) {
+		return
+	}
+
+	// only the position can change; the link stays on its module and element
+	existing, err := h.moduleElementRepository.GetModuleElementByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		StorageError(w, err)
 		return
 	}
+	moduleElement.ModuleID = existing.ModuleID
+	moduleElement.ElementID = existing.ElementID
 
 	key, err := h.moduleElementRepository.UpdateModuleElement(idInt, &moduleElement)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 	moduleElement.KeyID = key.ID
@@ -87,13 +116,17 @@ func (h *ModuleElementHandler) UpdateModuleElement(w http.ResponseWriter, r *htt
 }
 
 func (h *ModuleElementHandler) GetModuleElementByModuleIDAndElementID(w http.ResponseWriter, r *http.Request) {
-	var moduleID = mux.Vars(r)["moduleID"]
-	var elementID = mux.Vars(r)["elementID"]
-	moduleIDInt, _ := strconv.ParseInt(moduleID, 10, 64)
-	elementIDInt, _ := strconv.ParseInt(elementID, 10, 64)
+	moduleIDInt, ok := ParseID(w, r, "moduleID")
+	if !ok {
+		return
+	}
+	elementIDInt, ok := ParseID(w, r, "elementID")
+	if !ok {
+		return
+	}
 	moduleElement, err := h.moduleElementRepository.GetModuleElementByModuleIDAndElementID(moduleIDInt, elementIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -102,11 +135,13 @@ func (h *ModuleElementHandler) GetModuleElementByModuleIDAndElementID(w http.Res
 }
 
 func (h *ModuleElementHandler) GetModuleElementsByElementID(w http.ResponseWriter, r *http.Request) {
-	var elementID = mux.Vars(r)["id"]
-	elementIDInt, _ := strconv.ParseInt(elementID, 10, 64)
+	elementIDInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	moduleElements, err := h.moduleElementRepository.GetModuleElementsByElementID(elementIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -115,11 +150,13 @@ func (h *ModuleElementHandler) GetModuleElementsByElementID(w http.ResponseWrite
 }
 
 func (h *ModuleElementHandler) GetModuleElementsByModuleID(w http.ResponseWriter, r *http.Request) {
-	var userID = mux.Vars(r)["id"]
-	userIDInt, _ := strconv.ParseInt(userID, 10, 64)
+	userIDInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	moduleElements, err := h.moduleElementRepository.GetModuleElementsByModuleID(userIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -128,11 +165,13 @@ func (h *ModuleElementHandler) GetModuleElementsByModuleID(w http.ResponseWriter
 }
 
 func (h *ModuleElementHandler) GetElementsByModuleID(w http.ResponseWriter, r *http.Request) {
-	var userID = mux.Vars(r)["id"]
-	userIDInt, _ := strconv.ParseInt(userID, 10, 64)
+	userIDInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	elements, err := h.moduleElementRepository.GetElementsByModuleID(userIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -141,11 +180,13 @@ func (h *ModuleElementHandler) GetElementsByModuleID(w http.ResponseWriter, r *h
 }
 
 func (h *ModuleElementHandler) GetModulesByElementID(w http.ResponseWriter, r *http.Request) {
-	var elementID = mux.Vars(r)["id"]
-	elementIDInt, _ := strconv.ParseInt(elementID, 10, 64)
+	elementIDInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	modules, err := h.moduleElementRepository.GetModulesByElementID(elementIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -154,11 +195,13 @@ func (h *ModuleElementHandler) GetModulesByElementID(w http.ResponseWriter, r *h
 }
 
 func (h *ModuleElementHandler) GetElementsByInstructorID(w http.ResponseWriter, r *http.Request) {
-	var elementID = mux.Vars(r)["id"]
-	elementIDInt, _ := strconv.ParseInt(elementID, 10, 64)
+	elementIDInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	users, err := h.moduleElementRepository.GetElementsByInstructorID(elementIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"restAPI/models"
+	"restAPI/notify"
+	"restAPI/validation"
+)
+
+// NotificationHandler serves the current user's inbox and notification preferences
+type NotificationHandler struct {
+	notificationRepository models.NotificationRepository
+	webhookHosts           []string
+}
+
+// NewNotificationHandler ..
+func NewNotificationHandler(notificationRepository models.NotificationRepository, webhookHosts []string) *NotificationHandler {
+	return &NotificationHandler{notificationRepository: notificationRepository, webhookHosts: webhookHosts}
+}
+
+// NotificationListSpec lists the filters and sort orders of GET /notification
+var NotificationListSpec = ListSpec{
+	Filters: map[string]FilterParam{
+		"event": StringFilter("Event"),
+		"read":  BoolFilter("Read"),
+	},
+	Sorts: map[string]string{
+		"created_on": "CreatedOn",
+	},
+}
+
+// GetNotifications returns a page of the user's notifications, newest first;
+// X-Total-Count with ?read=false is the unread count
+func (h *NotificationHandler) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := CurrentUser(r).KeyID
+	list := func(opts models.QueryOptions) ([]*models.Notification, string, error) {
+		if opts.Order == "" {
+			opts.Order = "-CreatedOn"
+		}
+		return h.notificationRepository.ListNotifications(opts.Where("UserID", userID))
+	}
+	count := func(opts models.QueryOptions) (int, error) {
+		return h.notificationRepository.CountNotifications(opts.Where("UserID", userID))
+	}
+	ServeList(w, r, NotificationListSpec, list, count)
+}
+
+// MarkRead is the body of PUT /notification/read
+type MarkRead struct {
+	IDs []int64 `json:"ids" validate:"max=500"`
+}
+
+// MarkNotificationsRead marks the given notifications as read, or all of
+// them without ids, and returns how many changed
+func (h *NotificationHandler) MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	body := MarkRead{}
+	if !DecodeJSON(w, r, &body) {
+		return
+	}
+
+	marked, err := h.notificationRepository.MarkNotificationsRead(CurrentUser(r).KeyID, body.IDs)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"marked": marked})
+}
+
+// GetNotificationPreferences returns the user's channels for every event
+func (h *NotificationHandler) GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	preferences, err := h.notificationRepository.GetNotificationPreferences(CurrentUser(r).KeyID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preferences)
+}
+
+// UpdateNotificationPreferences replaces the user's preferences; events left
+// out get the defaults, and webhooks must be on an allowed host
+func (h *NotificationHandler) UpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	preferences := models.NotificationPreferences{}
+	if !DecodeJSON(w, r, &preferences) {
+		return
+	}
+
+	var fields []validation.FieldError
+	webhooks := map[string]string{"slack_webhook": preferences.SlackWebhook, "teams_webhook": preferences.TeamsWebhook}
+	for _, name := range sortedKeys(webhooks) {
+		if webhooks[name] != "" && !notify.AllowedWebhook(webhooks[name], h.webhookHosts) {
+			fields = append(fields, validation.FieldError{Field: name, Message: "must be an https URL on an allowed host"})
+		}
+	}
+	if len(fields) > 0 {
+		WriteAPIError(w, http.StatusUnprocessableEntity, APIError{
+			Code:    CodeValidationFailed,
+			Message: "Some fields are invalid",
+			Fields:  fields,
+		})
+		return
+	}
+
+	preferences.UserID = CurrentUser(r).KeyID
+	preferences.Complete()
+	if err := h.notificationRepository.SaveNotificationPreferences(&preferences); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preferences)
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"restAPI/models"
+	"restAPI/validation"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Page sizes of list endpoints, see ParseListOptions
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 500
+)
+
+// FilterParam is a query string parameter a list endpoint filters on
+type FilterParam struct {
+	Field string // Datastore property
+	Type  string // JSON type of the value, for the API documentation
+	Parse func(s string) (interface{}, error)
+}
+
+// StringFilter filters on a string property
+func StringFilter(field string) FilterParam {
+	return FilterParam{Field: field, Type: "string", Parse: func(s string) (interface{}, error) { return s, nil }}
+}
+
+// BoolFilter filters on a boolean property
+func BoolFilter(field string) FilterParam {
+	return FilterParam{Field: field, Type: "boolean", Parse: func(s string) (interface{}, error) { return strconv.ParseBool(s) }}
+}
+
+// IDFilter filters on an int64 property, such as an owner or course ID
+func IDFilter(field string) FilterParam {
+	return FilterParam{Field: field, Type: "integer", Parse: func(s string) (interface{}, error) { return strconv.ParseInt(s, 10, 64) }}
+}
+
+// ListSpec is what a list endpoint accepts: filters by query parameter, and
+// the properties it may be sorted on by ?sort=name or ?sort=-name
+type ListSpec struct {
+	Filters map[string]FilterParam
+	Sorts   map[string]string
+}
+
+// ParseListOptions reads limit, cursor, sort and the filters of spec from the
+// query string, writing a 400 and returning false if any is invalid
+func ParseListOptions(w http.ResponseWriter, r *http.Request, spec ListSpec) (models.QueryOptions, bool) {
+	query := r.URL.Query()
+	opts := models.QueryOptions{Limit: DefaultPageSize, Cursor: query.Get("cursor")}
+	var fields []validation.FieldError
+
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > MaxPageSize {
+			fields = append(fields, validation.FieldError{Field: "limit", Message: "must be between 1 and " + strconv.Itoa(MaxPageSize)})
+		}
+		opts.Limit = limit
+	}
+
+	if s := query.Get("sort"); s != "" {
+		property, ok := spec.Sorts[strings.TrimPrefix(s, "-")]
+		if !ok {
+			fields = append(fields, validation.FieldError{Field: "sort", Message: "must be one of " + strings.Join(sortedKeys(spec.Sorts), ", ")})
+		} else if strings.HasPrefix(s, "-") {
+			opts.Order = "-" + property
+		} else {
+			opts.Order = property
+		}
+	}
+
+	for _, name := range sortedKeys(spec.Filters) {
+		s := query.Get(name)
+		if s == "" {
+			continue
+		}
+		value, err := spec.Filters[name].Parse(s)
+		if err != nil {
+			fields = append(fields, validation.FieldError{Field: name, Message: "is invalid"})
+			continue
+		}
+		opts = opts.Where(spec.Filters[name].Field, value)
+	}
+
+	if len(fields) > 0 {
+		WriteAPIError(w, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: "Invalid list parameters", Fields: fields})
+		return opts, false
+	}
+	return opts, true
+}
+
+// ServeList writes one page of a list as a JSON array. The cursor of the next
+// page, if any, is in the X-Next-Cursor header, and the first page also
+// carries the number of matching entities in X-Total-Count.
+func ServeList[T any](w http.ResponseWriter, r *http.Request, spec ListSpec,
+	list func(models.QueryOptions) ([]*T, string, error), count func(models.QueryOptions) (int, error)) {
+	opts, ok := ParseListOptions(w, r, spec)
+	if !ok {
+		return
+	}
+
+	items, next, err := list(opts)
+	if err == models.ErrInvalidCursor {
+		WriteError(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	if opts.Cursor == "" && count != nil {
+		total, err := count(opts)
+		if err != nil {
+			StorageError(w, err)
+			return
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// EachPage calls fn with successive pages of a list until it is exhausted,
+// so that aggregations need not hold every entity in memory
+func EachPage[T any](opts models.QueryOptions, list func(models.QueryOptions) ([]*T, string, error), fn func(items []*T) error) error {
+	if opts.Limit == 0 {
+		opts.Limit = MaxPageSize
+	}
+	for {
+		items, next, err := list(opts)
+		if err != nil {
+			return err
+		}
+		if err := fn(items); err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		opts.Cursor = next
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"restAPI/models"
+	"sort"
+	"strings"
+)
+
+// RoutePermits decides whether a user may use a route, given the legacy
+// bitmask of their roles and the named permissions their roles grant.
+// Public routes are open to everyone; any other route is denied unless
+// the user holds PermissionAll, one of the route's Permissions, or a role
+// matching the route's legacy PermissionLevel.
+func RoutePermits(route *models.Route, roleKey int, permissions map[string]bool) bool {
+	if route == nil {
+		return permissions[models.PermissionAll]
+	}
+
+	if route.Public || permissions[models.PermissionAll] {
+		return true
+	}
+
+	for _, permission := range route.Permissions {
+		if permissions[permission] {
+			return true
+		}
+	}
+
+	return route.PermissionLevel > 0 && (roleKey&route.PermissionLevel) != 0
+}
+
+// UserPermissions are granted to RoleUser, and so to everyone signed in;
+// course content and learner records are further checked per course
+var UserPermissions = []string{
+	models.PermissionSignedIn, models.PermissionUserRead, models.PermissionUserWrite,
+	models.PermissionCourseWrite, models.PermissionModuleWrite, models.PermissionElementWrite,
+	models.PermissionThreadWrite, models.PermissionProjectWrite, models.PermissionAttemptSubmit,
+	models.PermissionGrade,
+}
+
+// writePermissions names the permission for changes under the first
+// segment of a route's path
+var writePermissions = map[string]string{
+	"user":          models.PermissionUserWrite,
+	"course":        models.PermissionCourseWrite,
+	"usercourse":    models.PermissionCourseWrite,
+	"module":        models.PermissionModuleWrite,
+	"element":       models.PermissionElementWrite,
+	"moduleelement": models.PermissionElementWrite,
+	"thread":        models.PermissionThreadWrite,
+	"reply":         models.PermissionThreadWrite,
+	"project":       models.PermissionProjectWrite,
+	"upload":        models.PermissionProjectWrite,
+}
+
+// RoutePermission names the permission which grants a route (path_METHOD)
+// when it is first stored, from its path and method. Administration, role
+// and route management are left to the permissions made for them, and
+// admin-only routes (including LTI platform registration) return "".
+func RoutePermission(name string) string {
+	path, method := name, ""
+	if i := strings.LastIndex(name, "_"); i >= 0 {
+		path, method = name[:i], name[i+1:]
+	}
+	read := method == http.MethodGet
+	first := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+
+	switch {
+	case path == "/metrics", first == "admin" && read:
+		return models.PermissionAdminRead
+	case first == "admin", path == "/lti/platform", strings.HasPrefix(path, "/lti/platform/"):
+		return ""
+	case first == "role":
+		return models.PermissionRoleManage
+	case first == "route":
+		return models.PermissionRouteManage
+	case strings.HasSuffix(path, "/approve"), strings.HasSuffix(path, "/unapprove"):
+		return models.PermissionCourseApprove
+	case strings.HasSuffix(path, "/submit"):
+		return models.PermissionAttemptSubmit
+	case strings.HasSuffix(path, "/progress") && !read:
+		return models.PermissionGrade
+	case read && first == "user":
+		return models.PermissionUserRead
+	case read:
+		return models.PermissionSignedIn
+	}
+	if permission, ok := writePermissions[first]; ok {
+		return permission
+	}
+	return models.PermissionSignedIn
+}
+
+// PermissionHandler reports how routes and roles fit together
+type PermissionHandler struct {
+	routeRepository models.RouteRepository
+	roleRepository  models.RoleRepository
+}
+
+// NewPermissionHandler ..
+func NewPermissionHandler(routeRepository models.RouteRepository, roleRepository models.RoleRepository) *PermissionHandler {
+	return &PermissionHandler{routeRepository: routeRepository, roleRepository: roleRepository}
+}
+
+// RoutePermissions is one row of the permission matrix
+type RoutePermissions struct {
+	Route           string          `json:"route"`
+	Public          bool            `json:"public"`
+	PermissionLevel int             `json:"numeric_value,omitempty"`
+	Permissions     []string        `json:"permissions,omitempty"`
+	Roles           map[string]bool `json:"roles"`
+}
+
+// GetPermissionMatrix returns, for every route, which roles may use it
+func (h *PermissionHandler) GetPermissionMatrix(w http.ResponseWriter, r *http.Request) {
+	routes, err := h.routeRepository.GetAllRoutes()
+	if err != nil {
+		WriteError(w, "Failed to retrieve routes", http.StatusInternalServerError)
+		return
+	}
+
+	roles, err := h.roleRepository.GetAllRoles()
+	if err != nil {
+		WriteError(w, "Failed to retrieve roles", http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Name < routes[j].Name })
+
+	matrix := make([]RoutePermissions, 0, len(routes))
+	for _, route := range routes {
+		row := RoutePermissions{
+			Route:           route.Name,
+			Public:          route.Public,
+			PermissionLevel: route.PermissionLevel,
+			Permissions:     route.Permissions,
+			Roles:           make(map[string]bool, len(roles)),
+		}
+
+		for _, role := range roles {
+			permissions := map[string]bool{}
+			for _, permission := range role.Permissions {
+				permissions[permission] = true
+			}
+			row.Roles[role.Name] = RoutePermits(route, role.NumericValue, permissions)
+		}
+
+		matrix = append(matrix, row)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matrix)
+}
@@ -2,12 +2,13 @@ package controllers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"restAPI/models"
-	"strconv"
+	"restAPI/notify"
+	"restAPI/push"
+	"restAPI/xapi"
 	"time"
-
-	"github.com/gorilla/mux"
 )
 
 // ProgressHandler ..
@@ -16,16 +17,25 @@ type ProgressHandler struct {
 	courseRepository     models.CourseRepository
 	moduleRepository     models.ModuleRepository
 	userCourseRepository models.UserCourseRepository
+	auditor              *Auditor
+	emitter              *xapi.Emitter
+	notifier             *notify.Notifier
+	broker               push.Broker
 }
 
 // NewProgressHandler ..
 func NewProgressHandler(userRepository models.UserRepository, courseRepository models.CourseRepository,
-	moduleRepository models.ModuleRepository, userCourseRepository models.UserCourseRepository) *ProgressHandler {
+	moduleRepository models.ModuleRepository, userCourseRepository models.UserCourseRepository, auditor *Auditor,
+	emitter *xapi.Emitter, notifier *notify.Notifier, broker push.Broker) *ProgressHandler {
 	return &ProgressHandler{
 		userRepository:       userRepository,
 		courseRepository:     courseRepository,
 		moduleRepository:     moduleRepository,
 		userCourseRepository: userCourseRepository,
+		auditor:              auditor,
+		emitter:              emitter,
+		notifier:             notifier,
+		broker:               broker,
 	}
 }
 
@@ -56,24 +66,22 @@ type CourseProgressSummary struct {
 
 // GetUserProgress returns the user's overall progress
 func (h *ProgressHandler) GetUserProgress(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID, err := strconv.ParseInt(vars["userId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	userID, ok := ParseID(w, r, "userId")
+	if !ok {
 		return
 	}
 
 	// Get the user
 	user, err := h.userRepository.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		WriteError(w, "User not found", http.StatusNotFound)
 		return
 	}
 
 	// Get the user's courses
 	userCourses, err := h.userCourseRepository.GetUserCoursesByUserID(userID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve user courses", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve user courses", http.StatusInternalServerError)
 		return
 	}
 
@@ -181,44 +189,41 @@ func (h *ProgressHandler) GetUserProgress(w http.ResponseWriter, r *http.Request
 
 // GetCourseProgress returns the user's progress in a specific course
 func (h *ProgressHandler) GetCourseProgress(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID, err := strconv.ParseInt(vars["userId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	userID, ok := ParseID(w, r, "userId")
+	if !ok {
 		return
 	}
 
-	courseID, err := strconv.ParseInt(vars["courseId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid course ID", http.StatusBadRequest)
+	courseID, ok := ParseID(w, r, "courseId")
+	if !ok {
 		return
 	}
 
 	// Get the user
 	user, err := h.userRepository.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		WriteError(w, "User not found", http.StatusNotFound)
 		return
 	}
 
 	// Get the user course record
 	userCourse, err := h.userCourseRepository.GetUserCourseByUserIDAndCourseID(userID, courseID)
 	if err != nil {
-		http.Error(w, "User is not enrolled in this course", http.StatusNotFound)
+		WriteError(w, "User is not enrolled in this course", http.StatusNotFound)
 		return
 	}
 
 	// Get the course
 	course, err := h.courseRepository.GetCourseByID(courseID)
 	if err != nil {
-		http.Error(w, "Course not found", http.StatusNotFound)
+		WriteError(w, "Course not found", http.StatusNotFound)
 		return
 	}
 
 	// Get all modules for this course
 	modules, err := h.moduleRepository.GetAllModulesByCourseID(courseID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve course modules", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve course modules", http.StatusInternalServerError)
 		return
 	}
 
@@ -298,48 +303,45 @@ func (h *ProgressHandler) GetCourseProgress(w http.ResponseWriter, r *http.Reque
 
 // UpdateUserCourseProgress updates the progress of a user in a course
 func (h *ProgressHandler) UpdateUserCourseProgress(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID, err := strconv.ParseInt(vars["userId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	userID, ok := ParseID(w, r, "userId")
+	if !ok {
 		return
 	}
 
-	courseID, err := strconv.ParseInt(vars["courseId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid course ID", http.StatusBadRequest)
+	courseID, ok := ParseID(w, r, "courseId")
+	if !ok {
 		return
 	}
 
 	// Get the user course record
 	userCourse, err := h.userCourseRepository.GetUserCourseByUserIDAndCourseID(userID, courseID)
 	if err != nil {
-		http.Error(w, "User is not enrolled in this course", http.StatusNotFound)
+		WriteError(w, "User is not enrolled in this course", http.StatusNotFound)
 		return
 	}
 
 	// Get the course modules
-	_, err = h.courseRepository.GetCourseByID(courseID)
+	course, err := h.courseRepository.GetCourseByID(courseID)
 	if err != nil {
-		http.Error(w, "Course not found", http.StatusNotFound)
+		WriteError(w, "Course not found", http.StatusNotFound)
 		return
 	}
 
 	modules, err := h.moduleRepository.GetAllModulesByCourseID(courseID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve course modules", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve course modules", http.StatusInternalServerError)
 		return
 	}
 
 	if len(modules) == 0 {
-		http.Error(w, "Course has no modules", http.StatusBadRequest)
+		WriteError(w, "Course has no modules", http.StatusBadRequest)
 		return
 	}
 
 	// Get the user
 	user, err := h.userRepository.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		WriteError(w, "User not found", http.StatusNotFound)
 		return
 	}
 
@@ -363,6 +365,7 @@ func (h *ProgressHandler) UpdateUserCourseProgress(w http.ResponseWriter, r *htt
 	// Update course progress
 	if completedModules > 0 {
 		// If not started yet, set start date
+		before := *userCourse
 		if userCourse.StartedOn.IsZero() {
 			userCourse.StartedOn = time.Now()
 		}
@@ -378,9 +381,21 @@ func (h *ProgressHandler) UpdateUserCourseProgress(w http.ResponseWriter, r *htt
 		// Update the user course record
 		_, err = h.userCourseRepository.UpdateUserCourse(userCourse.KeyID, userCourse)
 		if err != nil {
-			http.Error(w, "Failed to update course progress", http.StatusInternalServerError)
+			WriteError(w, "Failed to update course progress", http.StatusInternalServerError)
 			return
 		}
+
+		h.auditor.RecordChange(r, "progress.grade", "UserCourse", userCourse.KeyID, &before, userCourse)
+
+		if before.CompletedOn.IsZero() && !userCourse.CompletedOn.IsZero() && !Impersonating(r) {
+			h.sendCompletion(userID, user.Username, courseID, course.Name, userCourse.Grade)
+		}
+		if (userCourse.Grade != before.Grade || userCourse.CompletedOn != before.CompletedOn) && !Impersonating(r) {
+			h.notifyGrade(userID, course, userCourse)
+			h.broker.Publish(push.UserTopic(userID), "course.graded", map[string]interface{}{
+				"course_id": courseID, "grade": userCourse.Grade, "completed_on": userCourse.CompletedOn,
+			})
+		}
 	}
 
 	// Return success
@@ -396,3 +411,36 @@ func (h *ProgressHandler) UpdateUserCourseProgress(w http.ResponseWriter, r *htt
 		},
 	})
 }
+
+// sendCompletion reports a newly completed course to the LRS; completing
+// every module means passing each of them, so the course is passed too
+func (h *ProgressHandler) sendCompletion(userID int64, username string, courseID int64, courseName string, grade int) {
+	if !h.emitter.Enabled() {
+		return
+	}
+	actor := h.emitter.Learner(userID, username)
+	object := h.emitter.Course(courseID, courseName)
+	success, completion := true, true
+	result := &xapi.Result{
+		Score:      &xapi.Score{Scaled: float64(grade) / 100, Raw: float64(grade), Min: 0, Max: 100},
+		Success:    &success,
+		Completion: &completion,
+	}
+	h.emitter.Send(xapi.Statement{Actor: actor, Verb: xapi.Completed, Object: object, Result: result})
+	h.emitter.Send(xapi.Statement{Actor: actor, Verb: xapi.Passed, Object: object, Result: result})
+}
+
+// notifyGrade tells a learner their grade in a course
+func (h *ProgressHandler) notifyGrade(userID int64, course *models.Course, userCourse *models.UserCourse) {
+	message := notify.Message{
+		UserID: userID,
+		Event:  models.EventGrade,
+		Title:  "New grade in " + course.Name,
+		Body:   fmt.Sprintf("Your grade in %s is now %d.", course.Name, userCourse.Grade),
+		Link:   fmt.Sprintf("/course/%d", course.KeyID),
+	}
+	if !userCourse.CompletedOn.IsZero() {
+		message.Body = fmt.Sprintf("You completed %s with a grade of %d.", course.Name, userCourse.Grade)
+	}
+	h.notifier.Notify(message)
+}
@@ -2,35 +2,40 @@ package controllers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"restAPI/models"
-	"strconv"
-
-	"github.com/gorilla/mux"
+	"restAPI/storage"
+	"strings"
 )
 
 // ProjectHandler ..
 type ProjectHandler struct {
 	projectRepository models.ProjectRepository
+	userRepository    models.UserRepository
+	blobs             storage.BlobStore
+	quota             *CourseQuota
+	auditor           *Auditor
 }
 
 // NewProjectHandler ..
-func NewProjectHandler(projectRepository models.ProjectRepository) *ProjectHandler {
-	return &ProjectHandler{projectRepository: projectRepository}
+func NewProjectHandler(projectRepository models.ProjectRepository, userRepository models.UserRepository, blobs storage.BlobStore,
+	quota *CourseQuota, auditor *Auditor) *ProjectHandler {
+	return &ProjectHandler{projectRepository: projectRepository, userRepository: userRepository, blobs: blobs, quota: quota, auditor: auditor}
 }
 
 // add project
 func (c *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
 	project := models.Project{}
-	err := json.NewDecoder(r.Body).Decode(&project)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !DecodeJSON(w, r, &project) {
 		return
 	}
+	// only uploads set the file and its size, which counts against the course's quota
+	project.File, project.FileName, project.Size = "", "", 0
 
 	key, err := c.projectRepository.CreateProject(&project)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -38,39 +43,99 @@ func (c *ProjectHandler) CreateProject(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(key)
 }
 
-// remove project
+// remove project, its file and the answers that point to it
 func (c *ProjectHandler) DeleteProject(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
-	err := c.projectRepository.DeleteProject(idInt)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	before, err := c.projectRepository.GetProjectByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	// Delete the file if it exists, once its size is known
+	size := c.quota.projectSize(r.Context(), before)
+	if strings.HasPrefix(before.File, projectsPrefix+"/") {
+		if err := c.blobs.Delete(r.Context(), before.File); err != nil {
+			// Log error but continue with deletion from database
+			log.Printf("Warning: Failed to delete project file: %v", err)
+		}
+	}
+
+	err = c.projectRepository.DeleteProject(idInt)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	c.quota.Release(before.CourseID, size)
+	c.removeAnswers(idInt, before)
+
+	c.auditor.RecordChange(r, "project.delete", "Project", idInt, before, nil)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Project deleted"})
 }
 
-// get all projects
-func (c *ProjectHandler) GetAllProjects(w http.ResponseWriter, r *http.Request) {
-	projects, err := c.projectRepository.GetAllProjects()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// removeAnswers drops the answers in the learner's module progress that
+// reference a deleted project
+func (c *ProjectHandler) removeAnswers(projectID int64, project *models.Project) {
+	if project.UserID == 0 || project.ModuleID == 0 {
+		return
+	}
+	user, err := c.userRepository.GetUserByID(project.UserID)
+	if err != nil || user == nil {
+		return
+	}
+	for i, module := range user.Modules {
+		if module.ModuleID != project.ModuleID {
+			continue
+		}
+		updated := false
+		for elementID, answer := range module.Answers {
+			if answer.ProjectID == projectID {
+				delete(module.Answers, elementID)
+				updated = true
+			}
+		}
+		if updated {
+			user.Modules[i] = module
+			if _, err := c.userRepository.UpdateUser(project.UserID, user); err != nil {
+				log.Printf("Warning: Failed to update user after project deletion: %v", err)
+			}
+		}
 		return
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(projects)
+// ProjectListSpec lists the filters and sort orders of GET /project
+var ProjectListSpec = ListSpec{
+	Filters: map[string]FilterParam{
+		"user":   IDFilter("UserID"),
+		"course": IDFilter("CourseID"),
+		"module": IDFilter("ModuleID"),
+	},
+	Sorts: map[string]string{
+		"name": "Name",
+		"date": "Date",
+	},
+}
+
+// GetAllProjects returns a page of projects, see ServeList
+func (c *ProjectHandler) GetAllProjects(w http.ResponseWriter, r *http.Request) {
+	ServeList(w, r, ProjectListSpec, c.projectRepository.ListProjects, c.projectRepository.CountProjects)
 }
 
 // get project by id
 func (c *ProjectHandler) GetProjectByID(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	project, err := c.projectRepository.GetProjectByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -81,33 +146,42 @@ func (c *ProjectHandler) GetProjectByID(w http.ResponseWriter, r *http.Request)
 // update project
 func (c *ProjectHandler) UpdateProject(w http.ResponseWriter, r *http.Request) {
 
-	var id = mux.Vars(r)["id"]
-	project := models.Project{}
-	err := json.NewDecoder(r.Body).Decode(&project)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
-	//convert id to int64
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	project := models.Project{}
+	if !DecodeJSON(w, r, &project) {
+		return
+	}
 
+	// the file stays the one that was uploaded
+	before, _ := c.projectRepository.GetProjectByID(idInt)
+	project.File, project.FileName, project.Size = "", "", 0
+	if before != nil {
+		project.File, project.FileName, project.Size = before.File, before.FileName, before.Size
+	}
 	key, err := c.projectRepository.UpdateProject(idInt, &project)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "project.update", "Project", idInt, before, &project)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(key)
 }
 
 func (c *ProjectHandler) GetProjectsByCourseID(w http.ResponseWriter, r *http.Request) {
-	var courseID = mux.Vars(r)["courseID"]
-	courseIDInt, _ := strconv.ParseInt(courseID, 10, 64)
+	courseIDInt, ok := ParseID(w, r, "courseID")
+	if !ok {
+		return
+	}
 	projects, err := c.projectRepository.GetProjectsByCourseID(courseIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -116,11 +190,13 @@ func (c *ProjectHandler) GetProjectsByCourseID(w http.ResponseWriter, r *http.Re
 }
 
 func (c *ProjectHandler) GetProjectsByUserID(w http.ResponseWriter, r *http.Request) {
-	var userID = mux.Vars(r)["id"]
-	userIDInt, _ := strconv.ParseInt(userID, 10, 64)
+	userIDInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	projects, err := c.projectRepository.GetProjectsByUserID(userIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -129,13 +205,17 @@ func (c *ProjectHandler) GetProjectsByUserID(w http.ResponseWriter, r *http.Requ
 }
 
 func (c *ProjectHandler) GetProjectByUserIDandModuleID(w http.ResponseWriter, r *http.Request) {
-	var userID = mux.Vars(r)["userID"]
-	userIDInt, _ := strconv.ParseInt(userID, 10, 64)
-	var moduleID = mux.Vars(r)["moduleID"]
-	moduleIDInt, _ := strconv.ParseInt(moduleID, 10, 64)
+	userIDInt, ok := ParseID(w, r, "userID")
+	if !ok {
+		return
+	}
+	moduleIDInt, ok := ParseID(w, r, "moduleID")
+	if !ok {
+		return
+	}
 	project, err := c.projectRepository.GetProjectByUserIDandModuleID(userIDInt, moduleIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -144,11 +224,13 @@ func (c *ProjectHandler) GetProjectByUserIDandModuleID(w http.ResponseWriter, r
 }
 
 func (c *ProjectHandler) GetProjectsByModuleID(w http.ResponseWriter, r *http.Request) {
-	var moduleID = mux.Vars(r)["moduleID"]
-	moduleIDInt, _ := strconv.ParseInt(moduleID, 10, 64)
+	moduleIDInt, ok := ParseID(w, r, "moduleID")
+	if !ok {
+		return
+	}
 	projects, err := c.projectRepository.GetProjectsByModuleID(moduleIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
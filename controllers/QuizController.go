@@ -6,8 +6,6 @@ import (
 	"restAPI/models"
 	"strconv"
 	"time"
-
-	"github.com/gorilla/mux"
 )
 
 type QuizHandler struct {
@@ -45,24 +43,22 @@ func NewQuizHandler(moduleRepo models.ModuleRepository, elementRepo models.Eleme
 
 // StartQuiz initializes a quiz session for a user
 func (h *QuizHandler) StartQuiz(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	moduleID, err := strconv.ParseInt(vars["moduleId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid module ID", http.StatusBadRequest)
+	moduleID, ok := ParseID(w, r, "moduleId")
+	if !ok {
 		return
 	}
 
 	// Get the module to get time limit and other quiz settings
 	module, err := h.moduleRepo.GetModuleByID(moduleID)
 	if err != nil {
-		http.Error(w, "Module not found", http.StatusNotFound)
+		WriteError(w, "Module not found", http.StatusNotFound)
 		return
 	}
 
 	// Get the module elements (questions)
 	moduleElements, err := h.moduleElementRepo.GetModuleElementsByModuleID(moduleID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve quiz questions", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve quiz questions", http.StatusInternalServerError)
 		return
 	}
 
@@ -102,45 +98,40 @@ func (h *QuizHandler) StartQuiz(w http.ResponseWriter, r *http.Request) {
 
 // SubmitQuiz handles a quiz submission and returns the results
 func (h *QuizHandler) SubmitQuiz(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	moduleID, err := strconv.ParseInt(vars["moduleId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid module ID", http.StatusBadRequest)
+	moduleID, ok := ParseID(w, r, "moduleId")
+	if !ok {
 		return
 	}
 
-	userID, err := strconv.ParseInt(vars["userId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	userID, ok := ParseID(w, r, "userId")
+	if !ok {
 		return
 	}
 
 	// Decode the submission
 	var submission QuizSubmission
-	err = json.NewDecoder(r.Body).Decode(&submission)
-	if err != nil {
-		http.Error(w, "Invalid quiz submission", http.StatusBadRequest)
+	if !DecodeJSON(w, r, &submission) {
 		return
 	}
 
 	// Get the module for grading criteria
 	module, err := h.moduleRepo.GetModuleByID(moduleID)
 	if err != nil {
-		http.Error(w, "Module not found", http.StatusNotFound)
+		WriteError(w, "Module not found", http.StatusNotFound)
 		return
 	}
 
 	// Get the user to update their modules
 	user, err := h.userRepo.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		WriteError(w, "User not found", http.StatusNotFound)
 		return
 	}
 
 	// Get all elements for this module for grading
 	moduleElements, err := h.moduleElementRepo.GetModuleElementsByModuleID(moduleID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve quiz elements", http.StatusInternalServerError)
+		WriteError(w, "Failed to retrieve quiz elements", http.StatusInternalServerError)
 		return
 	}
 
@@ -230,7 +221,7 @@ func (h *QuizHandler) SubmitQuiz(w http.ResponseWriter, r *http.Request) {
 	// Update the user
 	_, err = h.userRepo.UpdateUser(userID, user)
 	if err != nil {
-		http.Error(w, "Failed to update user progress", http.StatusInternalServerError)
+		WriteError(w, "Failed to update user progress", http.StatusInternalServerError)
 		return
 	}
 
@@ -255,23 +246,20 @@ func (h *QuizHandler) SubmitQuiz(w http.ResponseWriter, r *http.Request) {
 
 // GetQuizResults gets a user's quiz results for a module
 func (h *QuizHandler) GetQuizResults(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	moduleID, err := strconv.ParseInt(vars["moduleId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid module ID", http.StatusBadRequest)
+	moduleID, ok := ParseID(w, r, "moduleId")
+	if !ok {
 		return
 	}
 
-	userID, err := strconv.ParseInt(vars["userId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	userID, ok := ParseID(w, r, "userId")
+	if !ok {
 		return
 	}
 
 	// Get the user to check their modules
 	user, err := h.userRepo.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		WriteError(w, "User not found", http.StatusNotFound)
 		return
 	}
 
@@ -285,14 +273,14 @@ func (h *QuizHandler) GetQuizResults(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if userModule == nil {
-		http.Error(w, "No quiz results found for this module", http.StatusNotFound)
+		WriteError(w, "No quiz results found for this module", http.StatusNotFound)
 		return
 	}
 
 	// Get the module for reference
 	module, err := h.moduleRepo.GetModuleByID(moduleID)
 	if err != nil {
-		http.Error(w, "Module not found", http.StatusNotFound)
+		WriteError(w, "Module not found", http.StatusNotFound)
 		return
 	}
 
@@ -313,17 +301,8 @@ func (h *QuizHandler) GetQuizResults(w http.ResponseWriter, r *http.Request) {
 
 // GetQuizAnalytics gets aggregate statistics for a quiz
 func (h *QuizHandler) GetQuizAnalytics(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	moduleID, err := strconv.ParseInt(vars["moduleId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid module ID", http.StatusBadRequest)
-		return
-	}
-
-	// Get all users
-	users, err := h.userRepo.GetAllUsers()
-	if err != nil {
-		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+	moduleID, ok := ParseID(w, r, "moduleId")
+	if !ok {
 		return
 	}
 
@@ -340,29 +319,36 @@ func (h *QuizHandler) GetQuizAnalytics(w http.ResponseWriter, r *http.Request) {
 		PercentCorrect float64
 	})
 
-	// Collect data
-	for _, user := range users {
-		for _, m := range user.Modules {
-			if m.ModuleID == moduleID {
-				totalAttempts++
-				if m.Score >= 70 { // Assuming 70% is passing
-					totalPassed++
-				}
-				averageScore += m.Score
-				averageTime += m.TimePassed
-
-				// Question-specific stats
-				for questionID, answer := range m.Answers {
-					stats := questionStats[questionID]
-					stats.Attempts++
-					if answer.Correct {
-						stats.Correct++
+	// Collect data, a page of users at a time
+	err := EachPage(models.QueryOptions{}, h.userRepo.ListUsers, func(users []*models.User) error {
+		for _, user := range users {
+			for _, m := range user.Modules {
+				if m.ModuleID == moduleID {
+					totalAttempts++
+					if m.Score >= 70 { // Assuming 70% is passing
+						totalPassed++
+					}
+					averageScore += m.Score
+					averageTime += m.TimePassed
+
+					// Question-specific stats
+					for questionID, answer := range m.Answers {
+						stats := questionStats[questionID]
+						stats.Attempts++
+						if answer.Correct {
+							stats.Correct++
+						}
+						stats.PercentCorrect = float64(stats.Correct) / float64(stats.Attempts) * 100
+						questionStats[questionID] = stats
 					}
-					stats.PercentCorrect = float64(stats.Correct) / float64(stats.Attempts) * 100
-					questionStats[questionID] = stats
 				}
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		WriteError(w, "Failed to retrieve users", http.StatusInternalServerError)
+		return
 	}
 
 	// Calculate averages
@@ -420,23 +406,20 @@ func (h *QuizHandler) GetQuizAnalytics(w http.ResponseWriter, r *http.Request) {
 
 // ResetQuiz allows a user to reset their quiz attempt
 func (h *QuizHandler) ResetQuiz(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	moduleID, err := strconv.ParseInt(vars["moduleId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid module ID", http.StatusBadRequest)
+	moduleID, ok := ParseID(w, r, "moduleId")
+	if !ok {
 		return
 	}
 
-	userID, err := strconv.ParseInt(vars["userId"], 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	userID, ok := ParseID(w, r, "userId")
+	if !ok {
 		return
 	}
 
 	// Get the user
 	user, err := h.userRepo.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		WriteError(w, "User not found", http.StatusNotFound)
 		return
 	}
 
@@ -454,7 +437,7 @@ func (h *QuizHandler) ResetQuiz(w http.ResponseWriter, r *http.Request) {
 	// Update the user
 	_, err = h.userRepo.UpdateUser(userID, user)
 	if err != nil {
-		http.Error(w, "Failed to reset quiz", http.StatusInternalServerError)
+		WriteError(w, "Failed to reset quiz", http.StatusInternalServerError)
 		return
 	}
 
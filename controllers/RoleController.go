@@ -4,55 +4,61 @@ import (
 	"encoding/json"
 	"net/http"
 	"restAPI/models"
-	"strconv"
 
 	"github.com/gorilla/mux"
 )
 
 // This controller keeps track of a list of roles
-// Each role is mapped to a specific bit in a bitmask
-// Each user will have a corresponding bitmask to determine which roles they have
-// This allows us to easily check if a user has a specific role
+// Each role grants a list of named permissions (see models.Permission*),
+// which are matched against the permissions a route requires.
+// Roles may still carry a legacy NumericValue, mapped to a specific bit in a
+// bitmask, for routes which only have a PermissionLevel
 
 // RoleHandler ..
 type RoleHandler struct {
 	roleRepository models.RoleRepository
+	auditor        *Auditor
 }
 
 // NewRoleHandler ..
-func NewRoleHandler(roleRepository models.RoleRepository) *RoleHandler {
-	return &RoleHandler{roleRepository: roleRepository}
+func NewRoleHandler(roleRepository models.RoleRepository, auditor *Auditor) *RoleHandler {
+	return &RoleHandler{roleRepository: roleRepository, auditor: auditor}
 }
 
 // add role
 func (c *RoleHandler) CreateRole(w http.ResponseWriter, r *http.Request) {
 	role := models.Role{}
-	err := json.NewDecoder(r.Body).Decode(&role)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !DecodeJSON(w, r, &role) {
 		return
 	}
 
 	key, err := c.roleRepository.CreateRole(&role)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "role.create", "Role", key.ID, nil, &role)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(key)
 }
 
 // remove role
 func (c *RoleHandler) DeleteRole(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	before, _ := c.roleRepository.GetRoleByID(idInt)
 	err := c.roleRepository.DeleteRole(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "role.delete", "Role", idInt, before, nil)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Role deleted"})
 }
@@ -61,7 +67,7 @@ func (c *RoleHandler) DeleteRole(w http.ResponseWriter, r *http.Request) {
 func (c *RoleHandler) GetAllRoles(w http.ResponseWriter, r *http.Request) {
 	roles, err := c.roleRepository.GetAllRoles()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -71,11 +77,13 @@ func (c *RoleHandler) GetAllRoles(w http.ResponseWriter, r *http.Request) {
 
 // get role by id
 func (c *RoleHandler) GetRoleByID(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	role, err := c.roleRepository.GetRoleByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -86,23 +94,25 @@ func (c *RoleHandler) GetRoleByID(w http.ResponseWriter, r *http.Request) {
 // update role
 func (c *RoleHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
 
-	var id = mux.Vars(r)["id"]
-	role := models.Role{}
-	err := json.NewDecoder(r.Body).Decode(&role)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
-	//convert id to int64
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	role := models.Role{}
+	if !DecodeJSON(w, r, &role) {
+		return
+	}
 
+	before, _ := c.roleRepository.GetRoleByID(idInt)
 	key, err := c.roleRepository.UpdateRole(idInt, &role)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "role.update", "Role", idInt, before, &role)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(key)
 }
@@ -112,7 +122,7 @@ func (c *RoleHandler) GetRoleByName(w http.ResponseWriter, r *http.Request) {
 	var name = mux.Vars(r)["name"]
 	role, err := c.roleRepository.GetRoleByName(name)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -125,9 +135,46 @@ func (c *RoleHandler) GetRoleKey(roles []string) int {
 	var roleKey int
 	for _, userRole := range roles {
 		// What is the NumericValue for this role?
-		role, _ := c.roleRepository.GetRoleByName(userRole)
-		roleKey += role.NumericValue
+		role, err := c.roleRepository.GetRoleByName(userRole)
+		if err != nil || role == nil {
+			continue
+		}
+		roleKey |= role.NumericValue
 	}
 	return roleKey
 
 }
+
+// GetPermissions returns the union of the named permissions granted by roles
+func (c *RoleHandler) GetPermissions(roles []string) map[string]bool {
+
+	permissions := map[string]bool{}
+	for _, userRole := range roles {
+		role, err := c.roleRepository.GetRoleByName(userRole)
+		if err != nil || role == nil {
+			continue
+		}
+		for _, permission := range role.Permissions {
+			permissions[permission] = true
+		}
+	}
+	return permissions
+}
+
+// EnsureRole creates the role if it does not exist yet, or grants it the
+// given permissions if it exists without any
+func (c *RoleHandler) EnsureRole(role models.Role) error {
+	existing, err := c.roleRepository.GetRoleByName(role.Name)
+	if err != nil || existing == nil {
+		_, err = c.roleRepository.CreateRole(&role)
+		return err
+	}
+
+	if len(existing.Permissions) > 0 {
+		return nil
+	}
+
+	existing.Permissions = role.Permissions
+	_, err = c.roleRepository.UpdateRole(existing.KeyID, existing)
+	return err
+}
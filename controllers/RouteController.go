@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"net/http"
 	"restAPI/models"
-	"strconv"
 
 	"github.com/gorilla/mux"
 )
@@ -17,42 +16,50 @@ import (
 // RouteHandler ..
 type RouteHandler struct {
 	RouteRepository models.RouteRepository
+	auditor         *Auditor
 }
 
 // NewRouteHandler ..
-func NewRouteHandler(RouteRepository models.RouteRepository) *RouteHandler {
-	return &RouteHandler{RouteRepository: RouteRepository}
+func NewRouteHandler(RouteRepository models.RouteRepository, auditor *Auditor) *RouteHandler {
+	return &RouteHandler{RouteRepository: RouteRepository, auditor: auditor}
 }
 
 // add Route
 func (c *RouteHandler) CreateRoute(w http.ResponseWriter, r *http.Request) {
 	Route := models.Route{}
-	err := json.NewDecoder(r.Body).Decode(&Route)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !DecodeJSON(w, r, &Route) {
 		return
 	}
+	// routes configured through the API are never migrated
+	Route.Managed = true
 
 	key, err := c.RouteRepository.CreateRoute(&Route)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "route.create", "Route", key.ID, nil, &Route)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(key)
 }
 
 // remove Route
 func (c *RouteHandler) DeleteRoute(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	before, _ := c.RouteRepository.GetRouteByID(idInt)
 	err := c.RouteRepository.DeleteRoute(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "route.delete", "Route", idInt, before, nil)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Route deleted"})
 }
@@ -61,7 +68,7 @@ func (c *RouteHandler) DeleteRoute(w http.ResponseWriter, r *http.Request) {
 func (c *RouteHandler) GetAllRoutes(w http.ResponseWriter, r *http.Request) {
 	Routes, err := c.RouteRepository.GetAllRoutes()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -71,11 +78,13 @@ func (c *RouteHandler) GetAllRoutes(w http.ResponseWriter, r *http.Request) {
 
 // get Route by id
 func (c *RouteHandler) GetRouteByID(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	Route, err := c.RouteRepository.GetRouteByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -86,23 +95,26 @@ func (c *RouteHandler) GetRouteByID(w http.ResponseWriter, r *http.Request) {
 // update Route
 func (c *RouteHandler) UpdateRoute(w http.ResponseWriter, r *http.Request) {
 
-	var id = mux.Vars(r)["id"]
-	Route := models.Route{}
-	err := json.NewDecoder(r.Body).Decode(&Route)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
 		return
 	}
 
-	//convert id to int64
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	Route := models.Route{}
+	if !DecodeJSON(w, r, &Route) {
+		return
+	}
+	Route.Managed = true
 
+	before, _ := c.RouteRepository.GetRouteByID(idInt)
 	key, err := c.RouteRepository.UpdateRoute(idInt, &Route)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	c.auditor.RecordChange(r, "route.update", "Route", idInt, before, &Route)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(key)
 }
@@ -112,7 +124,7 @@ func (c *RouteHandler) GetRouteByName(w http.ResponseWriter, r *http.Request) {
 	var name = mux.Vars(r)["name"]
 	Route, err := c.RouteRepository.GetRouteByName(name)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -6,12 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"os"
 	"restAPI/models"
 
-	"github.com/joho/godotenv"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
@@ -19,17 +16,13 @@ import (
 var ssogolang *oauth2.Config
 var RandomString = "asdf"
 
-func init() {
-
-	err := godotenv.Load("./.env")
-	if err != nil {
-		log.Fatal("Error loading .env file: " + err.Error())
-	}
-
+// ConfigureSSO sets up the Google OAuth2 client; until it is called the
+// /sso and /callback routes report that SSO is not configured
+func ConfigureSSO(redirectURL string, clientID string, clientSecret string) {
 	ssogolang = &oauth2.Config{
-		RedirectURL:  os.Getenv("REDIRECT_URL"),
-		ClientID:     os.Getenv("CLIENT_ID"),
-		ClientSecret: os.Getenv("CLIENT_SECRET"),
+		RedirectURL:  redirectURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
 		Scopes:       []string{"email profile"}, // "https://www.googleapis.com/auth/userinfo.email"
 		Endpoint:     google.Endpoint,
 		// oauth2.Endpoint{
@@ -42,17 +35,25 @@ func init() {
 
 // create func SSO as http middleware
 func SSO(w http.ResponseWriter, r *http.Request) {
+	if ssogolang == nil {
+		WriteError(w, "SSO is not configured", http.StatusNotFound)
+		return
+	}
 	url := ssogolang.AuthCodeURL(RandomString)
-	fmt.Println(url)
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
 func (h *UserHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	if ssogolang == nil {
+		WriteError(w, "SSO is not configured", http.StatusNotFound)
+		return
+	}
 	state := r.FormValue("state")
 	code := r.FormValue("code")
 	data, err := GetUserData(state, code)
 	if err != nil {
-		log.Fatal(err)
+		WriteError(w, "SSO login failed", http.StatusUnauthorized)
+		return
 	}
 
 	// create struct to match google data
@@ -70,7 +71,8 @@ func (h *UserHandler) Callback(w http.ResponseWriter, r *http.Request) {
 	gUser := googleUser{}
 	err = json.Unmarshal([]byte(data), &gUser)
 	if err != nil {
-		log.Fatal(err)
+		WriteError(w, "Invalid SSO user data", http.StatusBadGateway)
+		return
 	}
 
 	user := models.User{
@@ -80,13 +82,18 @@ func (h *UserHandler) Callback(w http.ResponseWriter, r *http.Request) {
 		Lastname:  gUser.FamilyName,
 	}
 
-	h.CreateIfNotExists(w, r, &user)
+	// the Google account signs in as the user created on its first login
+	if _, err := h.CreateIfNotExists(&user); err != nil {
+		StorageError(w, err)
+		return
+	}
 	h.IssueToken(w, r, &user, true)
 
 	// convert user to json string
 	userJSON, err := json.Marshal(user)
 	if err != nil {
-		log.Fatal(err)
+		StorageError(w, err)
+		return
 	}
 
 	// obfuscate the &user into a string
@@ -0,0 +1,294 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"restAPI/models"
+	"restAPI/scan"
+	"restAPI/scorm"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// largest package accepted by UploadPackage
+const maxScormPackage = 500 << 20
+
+// where SCORM packages are unpacked, under the static directory
+const scormDir = "/uploads/scorm"
+
+// ScormHandler hosts SCORM packages in elements and stores the runtime data
+// their SCOs report during an attempt
+type ScormHandler struct {
+	elementRepository       models.ElementRepository
+	moduleElementRepository models.ModuleElementRepository
+	scormAttemptRepository  models.ScormAttemptRepository
+	staticDir               string
+	scanner                 scan.Scanner
+	auditor                 *Auditor
+}
+
+// NewScormHandler ..
+func NewScormHandler(elementRepository models.ElementRepository, moduleElementRepository models.ModuleElementRepository,
+	scormAttemptRepository models.ScormAttemptRepository, staticDir string, scanner scan.Scanner, auditor *Auditor) *ScormHandler {
+	return &ScormHandler{
+		elementRepository:       elementRepository,
+		moduleElementRepository: moduleElementRepository,
+		scormAttemptRepository:  scormAttemptRepository,
+		staticDir:               staticDir,
+		scanner:                 scanner,
+		auditor:                 auditor,
+	}
+}
+
+// RuntimeData is what the runtime API reads and writes: the CMI data of an
+// element, and whether the SCO has terminated
+type RuntimeData struct {
+	CMI    map[string]string `json:"cmi"`
+	Finish bool              `json:"finish"`
+}
+
+// UploadPackage unpacks a zipped SCORM package (form field "file") and turns
+// the element into a scorm element launching its first SCO. Packages are
+// stored by checksum, so uploading the same package again reuses it.
+func (h *ScormHandler) UploadPackage(w http.ResponseWriter, r *http.Request) {
+	elementID, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ExtendDeadlines(r)
+	r.Body = http.MaxBytesReader(w, r.Body, maxScormPackage+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		WriteError(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		WriteError(w, "Missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		WriteError(w, "Unable to read file", http.StatusBadRequest)
+		return
+	}
+	// packages are unpacked where anyone can load them
+	if err := scan.Verify(r.Context(), h.scanner, bytes.NewReader(data)); err != nil {
+		uploadError(w, err)
+		return
+	}
+
+	pkg, err := scorm.ReadManifest(data)
+	if err != nil {
+		WriteAPIError(w, http.StatusUnprocessableEntity, APIError{Code: CodeValidationFailed, Message: err.Error()})
+		return
+	}
+
+	element, err := h.elementRepository.GetElementByID(elementID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	before := *element
+
+	sum := sha256.Sum256(data)
+	location := scormDir + "/" + hex.EncodeToString(sum[:])
+	target := filepath.Join(h.staticDir, filepath.FromSlash(location))
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		// unpack next to the target and rename, so a package is never served half written
+		tmp := target + ".tmp" + time.Now().Format("150405.000000000")
+		if err := scorm.Extract(data, tmp); err != nil {
+			os.RemoveAll(tmp)
+			WriteAPIError(w, http.StatusUnprocessableEntity, APIError{Code: CodeValidationFailed, Message: err.Error()})
+			return
+		}
+		if err := os.Rename(tmp, target); err != nil {
+			os.RemoveAll(tmp)
+			if _, statErr := os.Stat(target); statErr != nil {
+				log.Printf("Error storing SCORM package: %v", err)
+				WriteError(w, "Unable to store package", http.StatusInternalServerError)
+				return
+			}
+			// another upload of the same package got there first
+		}
+	}
+
+	element.Type = "scorm"
+	element.PackageLocation = location + "/" + pkg.Launch
+	element.ScormVersion = pkg.Version
+	if element.Text == "" {
+		element.Text = pkg.Title
+	}
+	if _, err := h.elementRepository.UpdateElement(elementID, element); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	h.auditor.RecordChange(r, "element.scorm", "Element", elementID, &before, element)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(element)
+}
+
+// GetRuntimeData returns the CMI data an element has stored in the user's
+// current attempt at a module
+func (h *ScormHandler) GetRuntimeData(w http.ResponseWriter, r *http.Request) {
+	userID, moduleID, elementID, ok := h.runtimeIDs(w, r)
+	if !ok {
+		return
+	}
+
+	data := RuntimeData{CMI: map[string]string{}}
+	attempt, err := h.scormAttemptRepository.GetScormAttempt(userID, moduleID, elementID)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		StorageError(w, err)
+		return
+	}
+	if attempt != nil {
+		data.CMI, data.Finish = attempt.Data(), attempt.Finished
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// SaveRuntimeData stores the CMI data the runtime API commits; values not
+// sent are kept. Submitting the module grades the element from this data.
+func (h *ScormHandler) SaveRuntimeData(w http.ResponseWriter, r *http.Request) {
+	userID, moduleID, elementID, ok := h.runtimeIDs(w, r)
+	if !ok {
+		return
+	}
+
+	var data RuntimeData
+	r.Body = http.MaxBytesReader(w, r.Body, 8<<20)
+	if !DecodeJSON(w, r, &data) {
+		return
+	}
+	if err := scorm.ValidateCMI(data.CMI); err != nil {
+		WriteAPIError(w, http.StatusUnprocessableEntity, APIError{Code: CodeValidationFailed, Message: err.Error()})
+		return
+	}
+
+	attempt, err := h.scormAttemptRepository.GetScormAttempt(userID, moduleID, elementID)
+	if err == datastore.ErrNoSuchEntity {
+		attempt = &models.ScormAttempt{UserID: userID, ModuleID: moduleID, ElementID: elementID}
+	} else if err != nil {
+		StorageError(w, err)
+		return
+	}
+	attempt.Merge(data.CMI)
+	if err := scorm.ValidateCMI(attempt.Data()); err != nil {
+		WriteAPIError(w, http.StatusUnprocessableEntity, APIError{Code: CodeValidationFailed, Message: err.Error()})
+		return
+	}
+	attempt.Finished = attempt.Finished || data.Finish
+	attempt.UpdatedOn = time.Now()
+
+	if err := h.scormAttemptRepository.SaveScormAttempt(attempt); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RuntimeData{CMI: attempt.Data(), Finish: attempt.Finished})
+}
+
+// runtimeIDs reads the route's IDs and checks the element is a scorm element of the module
+func (h *ScormHandler) runtimeIDs(w http.ResponseWriter, r *http.Request) (int64, int64, int64, bool) {
+	userID, ok := ParseID(w, r, "userId")
+	if !ok {
+		return 0, 0, 0, false
+	}
+	moduleID, ok := ParseID(w, r, "moduleId")
+	if !ok {
+		return 0, 0, 0, false
+	}
+	elementID, ok := ParseID(w, r, "elementId")
+	if !ok {
+		return 0, 0, 0, false
+	}
+
+	moduleElements, err := h.moduleElementRepository.GetModuleElementsByModuleID(moduleID)
+	if err != nil {
+		StorageError(w, err)
+		return 0, 0, 0, false
+	}
+	found := false
+	for _, me := range moduleElements {
+		found = found || me.ElementID == elementID
+	}
+	if !found {
+		WriteError(w, "Element is not part of this module", http.StatusNotFound)
+		return 0, 0, 0, false
+	}
+
+	element, err := h.elementRepository.GetElementByID(elementID)
+	if err != nil {
+		StorageError(w, err)
+		return 0, 0, 0, false
+	}
+	if element.Type != "scorm" {
+		WriteError(w, "Element is not a SCORM package", http.StatusBadRequest)
+		return 0, 0, 0, false
+	}
+	return userID, moduleID, elementID, true
+}
+
+// ScormPackages serves the unpacked SCORM packages in staticDir. Their HTML
+// and JavaScript come from instructors, so every response is sandboxed into
+// an origin of its own, and HTML pages load the runtime API adapter, which
+// talks to the framing page by postMessage.
+func ScormPackages(staticDir string) http.Handler {
+	root := http.Dir(filepath.Join(staticDir, filepath.FromSlash(scormDir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "sandbox allow-scripts allow-forms allow-popups")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		ExtendDeadlines(r)
+
+		name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, scormDir))
+		f, err := root.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		// packages are only reached through their launch paths, never listed
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		if ext := strings.ToLower(path.Ext(name)); ext != ".html" && ext != ".htm" {
+			http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+			return
+		}
+		page, err := io.ReadAll(f)
+		if err != nil {
+			log.Printf("Error reading SCORM page %s: %v", name, err)
+			WriteError(w, "Unable to read page", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(scorm.InjectRuntime(page)))
+	})
+}
+
+// ScormRuntime serves the JavaScript runtime API adapter for SCORM elements
+func ScormRuntime(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(scorm.Runtime)
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"restAPI/search"
+	"restAPI/validation"
+	"strconv"
+	"strings"
+)
+
+// SearchHandler serves full-text search over the content a user may see
+type SearchHandler struct {
+	index      *search.Index
+	authorizer *Authorizer
+}
+
+// NewSearchHandler ..
+func NewSearchHandler(index *search.Index, authorizer *Authorizer) *SearchHandler {
+	return &SearchHandler{index: index, authorizer: authorizer}
+}
+
+// SearchResult is one ranked match; Snippet is HTML with the matches in <mark>
+type SearchResult struct {
+	Kind    string  `json:"kind"`
+	ID      int64   `json:"id"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+	URL     string  `json:"url"`
+}
+
+// the minimum course role needed to see each kind, as for its GET route
+var searchRoles = map[string]string{
+	search.KindCourse:  CourseRoleLearner,
+	search.KindModule:  CourseRoleLearner,
+	search.KindElement: CourseRoleTA,
+	search.KindThread:  CourseRoleLearner,
+}
+
+// Search returns up to limit (default 20) results for ?q=, optionally only of
+// the comma-separated kinds in ?kind=
+func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var fields []validation.FieldError
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		fields = append(fields, validation.FieldError{Field: "q", Message: "is required"})
+	}
+
+	limit := 20
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 100 {
+			fields = append(fields, validation.FieldError{Field: "limit", Message: "must be between 1 and 100"})
+		}
+		limit = n
+	}
+
+	var kinds []string
+	if s := query.Get("kind"); s != "" {
+		for _, kind := range strings.Split(s, ",") {
+			if _, ok := searchRoles[kind]; !ok {
+				fields = append(fields, validation.FieldError{Field: "kind", Message: "must be course, module, element or thread"})
+				break
+			}
+			kinds = append(kinds, kind)
+		}
+	}
+
+	if len(fields) > 0 {
+		WriteAPIError(w, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: "Invalid search", Fields: fields})
+		return
+	}
+
+	// the user's courses are read once; every hit is checked against them
+	var viewer *searcher
+	if user := CurrentUser(r); user != nil {
+		roles, err := h.authorizer.EnrolledCourseRoles(user)
+		if err != nil {
+			StorageError(w, err)
+			return
+		}
+		for _, courseID := range h.index.OwnedCourses(user.KeyID) {
+			roles[courseID] = CourseRoleOwner
+		}
+		viewer = &searcher{userID: user.KeyID, admin: h.authorizer.IsAdmin(r, user), roles: roles}
+	}
+	hits := h.index.Search(q, kinds, limit, viewer.visible)
+
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, SearchResult{
+			Kind:    hit.Document.Kind,
+			ID:      hit.Document.ID,
+			Title:   hit.Document.Title,
+			Snippet: hit.Snippet,
+			Score:   hit.Score,
+			URL:     "/" + hit.Document.Kind + "/" + strconv.FormatInt(hit.Document.ID, 10),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// searcher is the signed-in user running a search, with their role in each
+// course they own or are enrolled in
+type searcher struct {
+	userID int64
+	admin  bool
+	roles  map[int64]string
+}
+
+// visible applies the same ownership and course role checks as the routes
+// which read each kind; approved courses are visible to everyone, even
+// without a searcher
+func (s *searcher) visible(doc *search.Document) bool {
+	if doc.Public {
+		return true
+	}
+	if s == nil {
+		return false
+	}
+	if s.admin || (doc.OwnerID != 0 && doc.OwnerID == s.userID) {
+		return true
+	}
+	for _, courseID := range doc.CourseIDs {
+		if courseRoleRank[s.roles[courseID]] >= courseRoleRank[searchRoles[doc.Kind]] {
+			return true
+		}
+	}
+	return false
+}
@@ -1,36 +1,105 @@
 package controllers
 
 import (
+	"context"
 	"net/http"
+	"restAPI/models"
+	"strconv"
+	"sync"
 	"time"
 )
 
 type Session struct {
 	username string
 	expiry   time.Time
+
+	// set when an admin impersonates username: the admin's username and
+	// the token of their own session, which is restored afterwards
+	impersonator  string
+	originalToken string
+}
+
+// SessionStore tracks user sessions in memory; requests read and change
+// it concurrently, so it is only used through its methods
+type SessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewSessionStore returns an empty SessionStore
+func NewSessionStore() *SessionStore {
+	return &SessionStore{sessions: map[string]*Session{}}
 }
 
-// Track user sessions in memory
-var Sessions = map[string]*Session{}
+// Sessions are the sessions of every signed-in user
+var Sessions = NewSessionStore()
+
+// Get returns a copy of the session with the token
+func (s *SessionStore) Get(token string) (*Session, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[token]
+	if !ok || session == nil {
+		return nil, false
+	}
+	copied := *session
+	return &copied, true
+}
+
+// Set stores the session under the token
+func (s *SessionStore) Set(token string, session *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[token] = session
+}
 
-func (s *Session) SetTime(time time.Time) {
-	s.expiry = time
+// Delete removes the session with the token, if any
+func (s *SessionStore) Delete(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+// Extend moves the expiry of the session with the token, if any
+func (s *SessionStore) Extend(token string, expiry time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[token]; ok && session != nil {
+		session.expiry = expiry
+	}
 }
 
 func (s *Session) GetUsername() string {
 	return s.username
 }
 
+// GetImpersonator returns the real user behind an impersonated session, or ""
+func (s *Session) GetImpersonator() string {
+	return s.impersonator
+}
+
+// Impersonated reports whether the session was started by an admin on behalf of another user
+func (s *Session) Impersonated() bool {
+	return s.impersonator != ""
+}
+
 func Expired(s *Session) bool {
 	return s.expiry.Before(time.Now())
 }
 
+// GetSession returns the session token and session of the request,
+// or an empty session if there is no token or the token is unknown
 func GetSession(r *http.Request) (string, *Session) {
 	sessionToken := GetSessionToken(r)
 	if sessionToken == "" {
 		return "", &Session{}
 	}
-	return sessionToken, Sessions[sessionToken]
+
+	session, ok := Sessions.Get(sessionToken)
+	if !ok {
+		return sessionToken, &Session{}
+	}
+	return sessionToken, session
 }
 
 func GetSessionToken(r *http.Request) string {
@@ -43,3 +112,70 @@ func GetSessionToken(r *http.Request) string {
 
 	return sessionToken
 }
+
+// SetSessionCookie hands the session token to the browser. Scripts cannot
+// read it; they see session_expires instead, which holds no secret.
+func SetSessionCookie(w http.ResponseWriter, sessionToken string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Path:     "/",
+		Value:    sessionToken,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_expires",
+		Path:     "/",
+		Value:    strconv.FormatInt(expiresAt.Unix(), 10),
+		Expires:  expiresAt,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ClearSessionCookie removes the session cookies from the browser
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: "session_token", Path: "/", MaxAge: -1, HttpOnly: true})
+	http.SetCookie(w, &http.Cookie{Name: "session_expires", Path: "/", MaxAge: -1})
+}
+
+type contextKey string
+
+const (
+	userContextKey     contextKey = "user"
+	realUserContextKey contextKey = "realUser"
+)
+
+// WithUser returns a copy of the request carrying the authenticated user
+func WithUser(r *http.Request, user *models.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+}
+
+// CurrentUser returns the user attached by the permissions middleware, or nil.
+// While impersonating, this is the impersonated (effective) user.
+func CurrentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userContextKey).(*models.User)
+	return user
+}
+
+// WithRealUser returns a copy of the request carrying the admin behind an impersonated session
+func WithRealUser(r *http.Request, user *models.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), realUserContextKey, user))
+}
+
+// Impersonating reports whether an admin is making the request as another
+// user; nothing is then sent in that user's name: no xAPI statements,
+// notifications or pushed events
+func Impersonating(r *http.Request) bool {
+	realUser, user := RealUser(r), CurrentUser(r)
+	return realUser != nil && user != nil && realUser.KeyID != user.KeyID
+}
+
+// RealUser returns the user actually making the request: the impersonating
+// admin if there is one, otherwise the same user as CurrentUser
+func RealUser(r *http.Request) *models.User {
+	if user, ok := r.Context().Value(realUserContextKey).(*models.User); ok {
+		return user
+	}
+	return CurrentUser(r)
+}
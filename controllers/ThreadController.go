@@ -2,9 +2,16 @@ package controllers
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"restAPI/models"
-	"strconv"
+	"restAPI/moderation"
+	"restAPI/notify"
+	"restAPI/push"
+	"restAPI/validation"
+	"sort"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -12,51 +19,88 @@ import (
 // ThreadHandler ..
 type ThreadHandler struct {
 	threadRepository models.ThreadRepository
+	replyRepository  models.ReplyRepository
+	voteRepository   models.VoteRepository
+	reportRepository models.ReportRepository
 	moduleRepository models.ModuleRepository
+	filter           *moderation.Filter
+	authorizer       *Authorizer
+	notifier         *notify.Notifier
+	broker           push.Broker
 }
 
 // NewThreadHandler ..
-func NewThreadHandler(threadRepository models.ThreadRepository, moduleRepository models.ModuleRepository) *ThreadHandler {
-	return &ThreadHandler{threadRepository: threadRepository, moduleRepository: moduleRepository}
+func NewThreadHandler(threadRepository models.ThreadRepository, replyRepository models.ReplyRepository,
+	voteRepository models.VoteRepository, reportRepository models.ReportRepository, moduleRepository models.ModuleRepository,
+	filter *moderation.Filter, authorizer *Authorizer, notifier *notify.Notifier,
+	broker push.Broker) *ThreadHandler {
+	return &ThreadHandler{
+		threadRepository: threadRepository,
+		replyRepository:  replyRepository,
+		voteRepository:   voteRepository,
+		reportRepository: reportRepository,
+		moduleRepository: moduleRepository,
+		filter:           filter,
+		authorizer:       authorizer,
+		notifier:         notifier,
+		broker:           broker,
+	}
 }
 
 // add thread
 func (c *ThreadHandler) CreateThread(w http.ResponseWriter, r *http.Request) {
 	thread := models.Thread{}
-	err := json.NewDecoder(r.Body).Decode(&thread)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !DecodeJSON(w, r, &thread) {
+		return
+	}
+	if !c.screen(w, map[string]string{"title": thread.Title, "body": thread.Body}) {
 		return
 	}
 
 	// If there was a param "moduleId" then use it for the module_id in thread
 	if id := mux.Vars(r)["moduleId"]; id != "" {
-		idInt, _ := strconv.ParseInt(id, 10, 64)
+		idInt, ok := ParseID(w, r, "moduleId")
+		if !ok {
+			return
+		}
 		thread.ModuleID = idInt
 	}
+	// replies and votes are counted as they are cast, and only moderators flag threads
+	user := CurrentUser(r)
+	now := time.Now()
+	thread.Author, thread.AuthorID = user.Username, user.KeyID
+	thread.CreatedOn, thread.BumpedOn = now, now
+	thread.ReplyCount, thread.Upvotes, thread.Downvotes = 0, 0, 0
+	thread.Closed, thread.Pinned, thread.Hidden, thread.Reported = false, false, false, false
+	thread.Rank()
 
 	key, err := c.threadRepository.CreateThread(&thread)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
 	// If id is not nil, update the threads for the module with this id by adding key.ID to the modules list
 	if id := mux.Vars(r)["moduleId"]; id != "" {
 		// convert id to int64
-		idInt, _ := strconv.ParseInt(id, 10, 64)
+		idInt, ok := ParseID(w, r, "moduleId")
+		if !ok {
+			return
+		}
 		module, err := c.moduleRepository.GetModuleByID(idInt)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			StorageError(w, err)
 			return
 		}
 		module.ThreadIDs = append(module.ThreadIDs, key.ID)
 		_, err = c.moduleRepository.UpdateModule(idInt, module)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			StorageError(w, err)
 			return
 		}
 	}
+	thread.KeyID = key.ID
+	publishThread(r, c.broker, "thread.created", &thread)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(key.ID)
@@ -64,70 +108,117 @@ func (c *ThreadHandler) CreateThread(w http.ResponseWriter, r *http.Request) {
 
 // remove thread
 func (c *ThreadHandler) DeleteThread(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
-	err := c.threadRepository.DeleteThread(idInt)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	existing, err := c.threadRepository.GetThreadByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
+		return
+	}
+	if id := mux.Vars(r)["moduleId"]; id != "" && id != fmt.Sprint(existing.ModuleID) {
+		WriteError(w, "Thread is not part of this module", http.StatusNotFound)
+		return
+	}
+	err = c.threadRepository.DeleteThread(idInt)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	if err := c.replyRepository.DeleteRepliesByThreadID(idInt); err != nil {
+		StorageError(w, err)
+		return
+	}
+	if err := c.voteRepository.DeleteVotesByThreadID(idInt); err != nil {
+		StorageError(w, err)
+		return
+	}
+	if err := c.reportRepository.DeleteReportsByThreadID(idInt); err != nil {
+		StorageError(w, err)
 		return
 	}
 
-	// If moduleId is not nil, remove key.ID from the module modules list
-	if moduleId := mux.Vars(r)["moduleId"]; moduleId != "" {
-		// convert id to int64
-		moduleIdInt, _ := strconv.ParseInt(moduleId, 10, 64)
-
-		module, err := c.moduleRepository.GetModuleByID(moduleIdInt)
+	// remove the thread from the list of the module it was posted in
+	if existing.ModuleID != 0 {
+		module, err := c.moduleRepository.GetModuleByID(existing.ModuleID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			StorageError(w, err)
 			return
 		}
 
 		j := 0
-
 		for _, thread := range module.ThreadIDs {
 			if thread != idInt {
 				module.ThreadIDs[j] = thread
 				j++
 			}
 		}
+		module.ThreadIDs = module.ThreadIDs[:j]
 
-		// module.Threads = lo.Filter(module.Threads, func(module int64, _ int) bool {
-		// 	return (module != idInt)
-		// })
-
-		_, err = c.moduleRepository.UpdateModule(idInt, module)
+		_, err = c.moduleRepository.UpdateModule(existing.ModuleID, module)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			StorageError(w, err)
 			return
 		}
 	}
 
+	if existing.ModuleID != 0 && !Impersonating(r) {
+		c.broker.Publish(push.ModuleTopic(existing.ModuleID), "thread.deleted", map[string]int64{"id": idInt})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Thread deleted"})
 }
 
-// get all threads
+// ThreadListSpec lists the filters and sort orders of GET /thread
+var ThreadListSpec = ListSpec{
+	Filters: map[string]FilterParam{
+		"module":   IDFilter("ModuleID"),
+		"author":   StringFilter("Author"),
+		"closed":   BoolFilter("Closed"),
+		"pinned":   BoolFilter("Pinned"),
+		"hidden":   BoolFilter("Hidden"),
+		"reported": BoolFilter("Reported"),
+	},
+	Sorts: map[string]string{
+		"title":      "Title",
+		"created_on": "CreatedOn",
+		"bumped_on":  "BumpedOn",
+		"upvotes":    "Upvotes",
+		"score":      "Score",
+		"hot":        "Hot",
+	},
+}
+
+// GetAllThreads returns a page of threads, see ServeList
 func (c *ThreadHandler) GetAllThreads(w http.ResponseWriter, r *http.Request) {
-	threads, err := c.threadRepository.GetAllThreads()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	list := func(opts models.QueryOptions) ([]*models.Thread, string, error) {
+		threads, next, err := c.threadRepository.ListThreads(opts)
+		c.redactThreads(r, threads)
+		return threads, next, err
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(threads)
+	ServeList(w, r, ThreadListSpec, list, c.threadRepository.CountThreads)
 }
 
 // get thread by id
 func (c *ThreadHandler) GetThreadByID(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	thread, err := c.threadRepository.GetThreadByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
+	// under /module/{moduleId} the thread must belong to that module
+	if id := mux.Vars(r)["moduleId"]; id != "" && id != fmt.Sprint(thread.ModuleID) {
+		WriteError(w, "Thread is not part of this module", http.StatusNotFound)
+		return
+	}
+	thread.KeyID = idInt
+	c.redactThreads(r, []*models.Thread{thread})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(thread)
@@ -136,22 +227,41 @@ func (c *ThreadHandler) GetThreadByID(w http.ResponseWriter, r *http.Request) {
 // update thread
 func (c *ThreadHandler) UpdateThread(w http.ResponseWriter, r *http.Request) {
 
-	var id = mux.Vars(r)["id"]
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+
 	thread := models.Thread{}
-	err := json.NewDecoder(r.Body).Decode(&thread)
+	if !DecodeJSON(w, r, &thread) {
+		return
+	}
+	if !c.screen(w, map[string]string{"title": thread.Title, "body": thread.Body}) {
+		return
+	}
+	existing, err := c.threadRepository.GetThreadByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		StorageError(w, err)
+		return
+	}
+	// under /module/{moduleId} the thread must belong to that module
+	if id := mux.Vars(r)["moduleId"]; id != "" && id != fmt.Sprint(existing.ModuleID) {
+		WriteError(w, "Thread is not part of this module", http.StatusNotFound)
+		return
+	}
+	if existing.Closed && !c.moderates(r, existing.ModuleID) {
+		WriteError(w, "The thread is locked", http.StatusConflict)
 		return
 	}
-
-	//convert id to int64
-	idInt, _ := strconv.ParseInt(id, 10, 64)
 
 	key, err := c.threadRepository.UpdateThread(idInt, &thread)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
+	if updated, err := c.threadRepository.GetThreadByID(idInt); err == nil {
+		publishThread(r, c.broker, "thread.updated", updated)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(key)
@@ -159,14 +269,475 @@ func (c *ThreadHandler) UpdateThread(w http.ResponseWriter, r *http.Request) {
 
 // GetAllThreadsByModuleID
 func (c *ThreadHandler) GetAllThreadsByModuleID(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["moduleId"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "moduleId")
+	if !ok {
+		return
+	}
 	threads, err := c.threadRepository.GetAllThreadsByModuleID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
+	c.redactThreads(r, threads)
+	// pinned threads come first
+	sort.SliceStable(threads, func(i, j int) bool { return threads[i].Pinned && !threads[j].Pinned })
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(threads)
 }
+
+// ReplyListSpec lists the filters and sort orders of GET /thread/{threadId}/reply
+var ReplyListSpec = ListSpec{
+	Filters: map[string]FilterParam{
+		"parent": IDFilter("ParentID"),
+	},
+	Sorts: map[string]string{
+		"created_on": "CreatedOn",
+		"score":      "Score",
+	},
+}
+
+// GetReplies returns a page of a thread's replies, oldest first unless sorted
+// otherwise; ?parent=0 lists the replies to the thread itself and ?parent=id
+// the replies to a reply
+func (c *ThreadHandler) GetReplies(w http.ResponseWriter, r *http.Request) {
+	threadID, ok := ParseID(w, r, "threadId")
+	if !ok {
+		return
+	}
+	thread, err := c.threadRepository.GetThreadByID(threadID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	moderator := c.moderates(r, thread.ModuleID)
+	list := func(opts models.QueryOptions) ([]*models.Reply, string, error) {
+		if opts.Order == "" {
+			opts.Order = "CreatedOn"
+		}
+		replies, next, err := c.replyRepository.ListReplies(opts.Where("ThreadID", threadID))
+		for _, reply := range replies {
+			if reply.Hidden && !moderator {
+				reply.Redact()
+			}
+		}
+		return replies, next, err
+	}
+	count := func(opts models.QueryOptions) (int, error) {
+		return c.replyRepository.CountReplies(opts.Where("ThreadID", threadID))
+	}
+	ServeList(w, r, ReplyListSpec, list, count)
+}
+
+// GetReply returns a reply with its edit history
+func (c *ThreadHandler) GetReply(w http.ResponseWriter, r *http.Request) {
+	reply, ok := c.threadReply(w, r)
+	if !ok {
+		return
+	}
+	if reply.Hidden {
+		if thread, err := c.threadRepository.GetThreadByID(reply.ThreadID); err != nil || !c.moderates(r, thread.ModuleID) {
+			reply.Redact()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// CreateReply posts a reply to a thread, or with parent_id to another reply
+// of the thread
+func (c *ThreadHandler) CreateReply(w http.ResponseWriter, r *http.Request) {
+	threadID, ok := ParseID(w, r, "threadId")
+	if !ok {
+		return
+	}
+	posted := models.Reply{}
+	if !DecodeJSON(w, r, &posted) {
+		return
+	}
+	if !c.screen(w, map[string]string{"body": posted.Body}) {
+		return
+	}
+
+	thread, err := c.threadRepository.GetThreadByID(threadID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	if !c.open(w, thread) {
+		return
+	}
+
+	user := CurrentUser(r)
+	now := time.Now()
+	reply := models.Reply{
+		ThreadID:  threadID,
+		ParentID:  posted.ParentID,
+		Body:      posted.Body,
+		Author:    user.Username,
+		AuthorID:  user.KeyID,
+		CreatedOn: now,
+		BumpedOn:  now,
+	}
+	var parentAuthorID int64
+	if reply.ParentID != 0 {
+		parent, err := c.replyRepository.GetReplyByID(reply.ParentID)
+		if err != nil || parent.ThreadID != threadID {
+			WriteError(w, "The parent reply is not in this thread", http.StatusUnprocessableEntity)
+			return
+		}
+		if parent.Deleted {
+			WriteError(w, "The reply was deleted", http.StatusConflict)
+			return
+		}
+		if parent.Depth+1 >= models.MaxReplyDepth {
+			WriteError(w, "Replies cannot be nested any deeper", http.StatusUnprocessableEntity)
+			return
+		}
+		reply.Depth = parent.Depth + 1
+		parentAuthorID = parent.AuthorID
+	}
+
+	key, err := c.replyRepository.CreateReply(&reply)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	c.notifyReply(r, thread, parentAuthorID, &reply)
+	reply.KeyID = key.ID
+	publishReply(r, c.broker, "reply.created", thread.ModuleID, &reply)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key.ID)
+}
+
+// notifyReply tells the thread's author, and the author of the reply
+// answered, about a new reply; nobody is told about their own reply
+func (c *ThreadHandler) notifyReply(r *http.Request, thread *models.Thread, parentAuthorID int64, reply *models.Reply) {
+	if Impersonating(r) {
+		return
+	}
+	link := fmt.Sprintf("/thread/%d", thread.KeyID)
+	if parentAuthorID != 0 && parentAuthorID != reply.AuthorID {
+		c.notifier.Notify(notify.Message{
+			UserID: parentAuthorID,
+			Event:  models.EventReply,
+			Title:  reply.Author + " answered your reply in " + thread.Title,
+			Body:   reply.Body,
+			Link:   link,
+		})
+	}
+	if thread.AuthorID != 0 && thread.AuthorID != reply.AuthorID && thread.AuthorID != parentAuthorID {
+		c.notifier.Notify(notify.Message{
+			UserID: thread.AuthorID,
+			Event:  models.EventReply,
+			Title:  reply.Author + " replied to " + thread.Title,
+			Body:   reply.Body,
+			Link:   link,
+		})
+	}
+}
+
+// UpdateReply changes the body of a reply, keeping the previous body in its history
+func (c *ThreadHandler) UpdateReply(w http.ResponseWriter, r *http.Request) {
+	reply, ok := c.threadReply(w, r)
+	if !ok {
+		return
+	}
+	edited := models.Reply{}
+	if !DecodeJSON(w, r, &edited) {
+		return
+	}
+	if !c.screen(w, map[string]string{"body": edited.Body}) {
+		return
+	}
+	thread, err := c.threadRepository.GetThreadByID(reply.ThreadID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	if !c.open(w, thread) {
+		return
+	}
+	if reply.Hidden {
+		WriteError(w, "The reply is hidden", http.StatusConflict)
+		return
+	}
+
+	reply, err = c.replyRepository.EditReply(reply.KeyID, edited.Body, CurrentUser(r).KeyID)
+	if err == models.ErrReplyDeleted {
+		WriteError(w, "The reply was deleted", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	publishReply(r, c.broker, "reply.updated", thread.ModuleID, reply)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// DeleteReply removes a reply's body; the reply stays as a placeholder so
+// that the replies answering it keep their place
+func (c *ThreadHandler) DeleteReply(w http.ResponseWriter, r *http.Request) {
+	reply, ok := c.threadReply(w, r)
+	if !ok {
+		return
+	}
+	if err := c.replyRepository.DeleteReply(reply.KeyID); err != nil {
+		StorageError(w, err)
+		return
+	}
+	if thread, err := c.threadRepository.GetThreadByID(reply.ThreadID); err == nil && thread.ModuleID != 0 && !Impersonating(r) {
+		c.broker.Publish(push.ModuleTopic(thread.ModuleID), "reply.deleted",
+			map[string]int64{"id": reply.KeyID, "thread_id": reply.ThreadID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Reply deleted"})
+}
+
+// threadReply reads the reply named by the route, writing a 404 unless it
+// belongs to the thread named by the route
+func (c *ThreadHandler) threadReply(w http.ResponseWriter, r *http.Request) (*models.Reply, bool) {
+	threadID, ok := ParseID(w, r, "threadId")
+	if !ok {
+		return nil, false
+	}
+	id, ok := ParseID(w, r, "id")
+	if !ok {
+		return nil, false
+	}
+	reply, err := c.replyRepository.GetReplyByID(id)
+	if err != nil {
+		StorageError(w, err)
+		return nil, false
+	}
+	if reply.ThreadID != threadID {
+		WriteError(w, "Not found", http.StatusNotFound)
+		return nil, false
+	}
+	return reply, true
+}
+
+// screen runs the named texts of a post through the content filter, writing
+// a 422 and returning false if any is rejected
+func (c *ThreadHandler) screen(w http.ResponseWriter, texts map[string]string) bool {
+	var fields []validation.FieldError
+	for _, name := range sortedKeys(texts) {
+		if message := c.filter.Check(texts[name]); message != "" {
+			fields = append(fields, validation.FieldError{Field: name, Message: message})
+		}
+	}
+	if len(fields) > 0 {
+		WriteAPIError(w, http.StatusUnprocessableEntity, APIError{
+			Code:    CodeValidationFailed,
+			Message: "The post was rejected by the content filter",
+			Fields:  fields,
+		})
+		return false
+	}
+	return true
+}
+
+// open writes a 409 and returns false if the thread takes no more posts or votes
+func (c *ThreadHandler) open(w http.ResponseWriter, thread *models.Thread) bool {
+	switch {
+	case thread.Closed:
+		WriteError(w, "The thread is locked", http.StatusConflict)
+		return false
+	case thread.Hidden:
+		WriteError(w, "The thread is hidden", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// moderates reports whether the current user may moderate the module's
+// threads: an instructor of its course, or an admin
+func (c *ThreadHandler) moderates(r *http.Request, moduleID int64) bool {
+	user := CurrentUser(r)
+	return user != nil && c.authorizer.Allowed(r, user, CourseRoleInstructor, c.authorizer.moduleCourse(moduleID))
+}
+
+// redactThreads removes what hidden threads say, unless the current user moderates them
+func (c *ThreadHandler) redactThreads(r *http.Request, threads []*models.Thread) {
+	for _, thread := range threads {
+		if thread.Hidden && !c.moderates(r, thread.ModuleID) {
+			thread.Redact()
+		}
+	}
+}
+
+// publishThread sends a thread to the followers of its module, without what
+// it says if it is hidden
+func publishThread(r *http.Request, broker push.Broker, kind string, thread *models.Thread) {
+	if thread.ModuleID == 0 || Impersonating(r) {
+		return
+	}
+	published := *thread
+	if published.Hidden {
+		published.Redact()
+	}
+	broker.Publish(push.ModuleTopic(thread.ModuleID), kind, &published)
+}
+
+// publishReply sends a reply to the followers of its thread's module,
+// without what it says if it is hidden
+func publishReply(r *http.Request, broker push.Broker, kind string, moduleID int64, reply *models.Reply) {
+	if moduleID == 0 || Impersonating(r) {
+		return
+	}
+	published := *reply
+	if published.Hidden {
+		published.Redact()
+	}
+	broker.Publish(push.ModuleTopic(moduleID), kind, &published)
+}
+
+// MigrateThreads brings threads saved by earlier versions up to date: it
+// moves the replies embedded in them into Reply entities, and ranks threads
+// saved before votes were ranked. It is safe to run on every start and on
+// several instances at once.
+func MigrateThreads(threads models.ThreadRepository, replies models.ReplyRepository) {
+	all, err := threads.GetAllThreads()
+	if err != nil {
+		log.Printf("Error migrating threads: %v", err)
+		return
+	}
+
+	moved, ranked := 0, 0
+	for _, thread := range all {
+		if len(thread.Replies) > 0 {
+			n, err := replies.MigrateReplies(thread.KeyID)
+			if err != nil {
+				log.Printf("Error migrating the replies of thread %d: %v", thread.KeyID, err)
+				continue
+			}
+			moved += n
+		}
+		if thread.Hot == 0 {
+			if err := threads.RankThread(thread.KeyID); err != nil {
+				log.Printf("Error ranking thread %d: %v", thread.KeyID, err)
+				continue
+			}
+			ranked++
+		}
+	}
+	if moved > 0 || ranked > 0 {
+		log.Printf("Migrated %d replies out of their threads and ranked %d threads", moved, ranked)
+	}
+}
+
+// VoteOnThread records the current user's vote on a thread, replacing any
+// earlier vote of theirs
+func (c *ThreadHandler) VoteOnThread(w http.ResponseWriter, r *http.Request) {
+	id, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	vote := models.Vote{}
+	if !DecodeJSON(w, r, &vote) {
+		return
+	}
+	vote.Kind, vote.PostID, vote.ThreadID = models.PostThread, id, id
+	c.castVote(w, r, &vote)
+}
+
+// RetractThreadVote withdraws the current user's vote on a thread
+func (c *ThreadHandler) RetractThreadVote(w http.ResponseWriter, r *http.Request) {
+	id, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	c.castVote(w, r, &models.Vote{Kind: models.PostThread, PostID: id, ThreadID: id})
+}
+
+// VoteOnReply records the current user's vote on a reply, replacing any
+// earlier vote of theirs
+func (c *ThreadHandler) VoteOnReply(w http.ResponseWriter, r *http.Request) {
+	reply, ok := c.threadReply(w, r)
+	if !ok {
+		return
+	}
+	vote := models.Vote{}
+	if !DecodeJSON(w, r, &vote) {
+		return
+	}
+	if reply.Deleted {
+		WriteError(w, "The reply was deleted", http.StatusConflict)
+		return
+	}
+	vote.Kind, vote.PostID, vote.ThreadID = models.PostReply, reply.KeyID, reply.ThreadID
+	c.castVote(w, r, &vote)
+}
+
+// RetractReplyVote withdraws the current user's vote on a reply
+func (c *ThreadHandler) RetractReplyVote(w http.ResponseWriter, r *http.Request) {
+	reply, ok := c.threadReply(w, r)
+	if !ok {
+		return
+	}
+	c.castVote(w, r, &models.Vote{Kind: models.PostReply, PostID: reply.KeyID, ThreadID: reply.ThreadID})
+}
+
+// castVote casts vote as the current user and answers with the post's tally
+func (c *ThreadHandler) castVote(w http.ResponseWriter, r *http.Request, vote *models.Vote) {
+	thread, err := c.threadRepository.GetThreadByID(vote.ThreadID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	if !c.open(w, thread) {
+		return
+	}
+
+	vote.UserID = CurrentUser(r).KeyID
+	tally, err := c.voteRepository.CastVote(vote)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	if thread.ModuleID != 0 && !Impersonating(r) {
+		kind := "thread.voted"
+		if vote.Kind == models.PostReply {
+			kind = "reply.voted"
+		}
+		c.broker.Publish(push.ModuleTopic(thread.ModuleID), kind, map[string]interface{}{
+			"id": vote.PostID, "thread_id": vote.ThreadID,
+			"upvotes": tally.Upvotes, "downvotes": tally.Downvotes, "score": tally.Score,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tally)
+}
+
+// GetVotes returns the current user's votes on a thread and its replies
+func (c *ThreadHandler) GetVotes(w http.ResponseWriter, r *http.Request) {
+	id, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	votes, err := c.voteRepository.GetVotesByUserAndThread(CurrentUser(r).KeyID, id)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	mine := models.UserVotes{Replies: map[int64]int{}}
+	for _, vote := range votes {
+		if vote.Kind == models.PostThread {
+			mine.Thread = vote.Value
+		} else {
+			mine.Replies[vote.PostID] = vote.Value
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mine)
+}
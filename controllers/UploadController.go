@@ -0,0 +1,617 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"restAPI/models"
+	"restAPI/scan"
+	"restAPI/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/google/uuid"
+)
+
+// CourseQuota counts the files of each course against its storage quota
+type CourseQuota struct {
+	uploadRepository  models.UploadRepository
+	projectRepository models.ProjectRepository
+	blobs             storage.BlobStore
+	limit             int64
+}
+
+// NewCourseQuota creates a CourseQuota allowing each course limit bytes,
+// unless an admin gave it another limit
+func NewCourseQuota(uploadRepository models.UploadRepository, projectRepository models.ProjectRepository,
+	blobs storage.BlobStore, limit int64) *CourseQuota {
+	return &CourseQuota{
+		uploadRepository:  uploadRepository,
+		projectRepository: projectRepository,
+		blobs:             blobs,
+		limit:             limit,
+	}
+}
+
+// Reserve counts bytes against a course's quota, returning
+// models.ErrQuotaExceeded if there is no room for them
+func (q *CourseQuota) Reserve(ctx context.Context, courseID int64, bytes int64) error {
+	stored, err := q.stored(ctx, courseID)
+	if err != nil {
+		return err
+	}
+	_, err = q.uploadRepository.ReserveCourseStorage(courseID, bytes, q.limit, stored)
+	return err
+}
+
+// Release stops counting bytes against a course's quota. Failing is logged:
+// the course is left with less room than it has.
+func (q *CourseQuota) Release(courseID int64, bytes int64) {
+	if courseID == 0 || bytes <= 0 {
+		return
+	}
+	if err := q.uploadRepository.ReleaseCourseStorage(courseID, bytes); err != nil {
+		log.Printf("Error releasing %d bytes of the storage of course %d: %v", bytes, courseID, err)
+	}
+}
+
+// ReleaseProject stops counting a project's file against its course's quota
+func (q *CourseQuota) ReleaseProject(ctx context.Context, project *models.Project) {
+	q.Release(project.CourseID, q.projectSize(ctx, project))
+}
+
+// Usage returns what a course stores, with the limit which applies to it
+func (q *CourseQuota) Usage(ctx context.Context, courseID int64) (*models.CourseStorage, error) {
+	usage, err := q.uploadRepository.GetCourseStorage(courseID)
+	if err == datastore.ErrNoSuchEntity {
+		used, err := q.stored(ctx, courseID)
+		if err != nil {
+			return nil, err
+		}
+		usage = &models.CourseStorage{CourseID: courseID, Used: used}
+	} else if err != nil {
+		return nil, err
+	}
+	if usage.Limit == 0 {
+		usage.Limit = q.limit
+	}
+	return usage, nil
+}
+
+// SetLimit replaces a course's quota; 0 restores the default
+func (q *CourseQuota) SetLimit(ctx context.Context, courseID int64, limit int64) (*models.CourseStorage, error) {
+	stored, err := q.stored(ctx, courseID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := q.uploadRepository.SetCourseStorageLimit(courseID, limit, stored); err != nil {
+		return nil, err
+	}
+	return q.Usage(ctx, courseID)
+}
+
+// stored returns what a course stored before its storage was first counted:
+// the files of its projects. Once it was counted, it returns 0.
+func (q *CourseQuota) stored(ctx context.Context, courseID int64) (int64, error) {
+	if _, err := q.uploadRepository.GetCourseStorage(courseID); err == nil {
+		return 0, nil
+	} else if err != datastore.ErrNoSuchEntity {
+		return 0, err
+	}
+
+	projects, err := q.projectRepository.GetProjectsByCourseID(courseID)
+	if err != nil {
+		return 0, err
+	}
+	var used int64
+	for _, project := range projects {
+		used += q.projectSize(ctx, project)
+	}
+	return used, nil
+}
+
+// projectSize returns the size of a project's file; projects uploaded before
+// sizes were recorded are looked up in the blob store
+func (q *CourseQuota) projectSize(ctx context.Context, project *models.Project) int64 {
+	if project.Size > 0 || !strings.HasPrefix(project.File, projectsPrefix+"/") {
+		return project.Size
+	}
+	info, err := q.blobs.Stat(ctx, project.File)
+	if err != nil {
+		return 0
+	}
+	return info.Size
+}
+
+// uploadsPrefix is where the chunks of uploads are kept in the blob store
+const uploadsPrefix = "uploads"
+
+// UploadHandler takes project files too large for one request in chunks,
+// so that an upload which failed can be resumed where it stopped, and
+// assembles them into the blob store
+type UploadHandler struct {
+	uploadRepository models.UploadRepository
+	moduleRepository models.ModuleRepository
+	files            *FileUploadHandler
+	blobs            storage.BlobStore
+	quarantine       *scan.Quarantine
+	quota            *CourseQuota
+	auditor          *Auditor
+	maxSize          int64
+	chunkSize        int64
+	expiry           time.Duration
+}
+
+// NewUploadHandler creates an UploadHandler taking files of up to maxSize
+// in chunks of up to chunkSize, which creates projects through files. An
+// upload expires when no chunk arrived for expiry.
+func NewUploadHandler(uploadRepository models.UploadRepository, moduleRepository models.ModuleRepository,
+	files *FileUploadHandler, blobs storage.BlobStore, quarantine *scan.Quarantine, quota *CourseQuota, auditor *Auditor,
+	maxSize int64, chunkSize int64, expiry time.Duration) *UploadHandler {
+	return &UploadHandler{
+		uploadRepository: uploadRepository,
+		moduleRepository: moduleRepository,
+		files:            files,
+		blobs:            blobs,
+		quarantine:       quarantine,
+		quota:            quota,
+		auditor:          auditor,
+		maxSize:          maxSize,
+		chunkSize:        chunkSize,
+		expiry:           expiry,
+	}
+}
+
+// NewUpload is the body of POST /course/{courseId}/upload
+type NewUpload struct {
+	ModuleID    int64  `json:"module_id" validate:"required"`
+	ElementID   int64  `json:"element_id,omitempty"`
+	Name        string `json:"name" validate:"required,max=200"`
+	Description string `json:"description,omitempty"`
+	FileName    string `json:"file_name" validate:"required,max=255"`
+	Size        int64  `json:"size" validate:"required,min=1"`
+}
+
+// StorageLimit is the body of PUT /admin/course/{id}/storage
+type StorageLimit struct {
+	Limit int64 `json:"limit" validate:"min=0"`
+}
+
+// CreateUpload starts an upload of a project file to a module of the
+// course, holding room for the whole file in the course's quota
+func (h *UploadHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	courseID, ok := ParseID(w, r, "courseId")
+	if !ok {
+		return
+	}
+	request := NewUpload{}
+	if !DecodeJSON(w, r, &request) {
+		return
+	}
+	if request.Size > h.maxSize {
+		WriteError(w, fmt.Sprintf("File too large: maximum size is %d bytes", h.maxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	module, err := h.moduleRepository.GetModuleByID(request.ModuleID)
+	if err != nil || module.CourseID != courseID {
+		WriteError(w, "module_id is not a module of the course", http.StatusUnprocessableEntity)
+		return
+	}
+	if request.ElementID != 0 {
+		if _, found := h.files.uploadElement(request.ModuleID, request.ElementID); !found {
+			WriteError(w, "element_id is not a project or file element of the module", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	if !reserveStorage(w, r, h.quota, courseID, request.Size) {
+		return
+	}
+	now := time.Now()
+	upload := &models.Upload{
+		UserID:      CurrentUser(r).KeyID,
+		CourseID:    courseID,
+		ModuleID:    request.ModuleID,
+		ElementID:   request.ElementID,
+		Name:        request.Name,
+		Description: request.Description,
+		FileName:    filepath.Base(request.FileName),
+		Size:        request.Size,
+		Status:      models.UploadOpen,
+		CreatedOn:   now,
+		ExpiresOn:   now.Add(h.expiry),
+	}
+	if _, err := h.uploadRepository.CreateUpload(upload); err != nil {
+		h.quota.Release(courseID, request.Size)
+		StorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/upload/%d", upload.KeyID))
+	w.Header().Set("Upload-Chunk-Size", strconv.FormatInt(h.chunkSize, 10))
+	writeUpload(w, upload, http.StatusCreated)
+}
+
+// reserveStorage holds bytes of a course's quota, writing a 413 if there is no room
+func reserveStorage(w http.ResponseWriter, r *http.Request, quota *CourseQuota, courseID int64, bytes int64) bool {
+	err := quota.Reserve(r.Context(), courseID, bytes)
+	if err == models.ErrQuotaExceeded {
+		WriteAPIError(w, http.StatusRequestEntityTooLarge, APIError{
+			Code:    CodeQuotaExceeded,
+			Message: "The course has no room left for this file",
+		})
+		return false
+	}
+	if err != nil {
+		StorageError(w, err)
+		return false
+	}
+	return true
+}
+
+// GetUpload reports how far an upload got; a client resumes it from
+// Upload-Offset, and once it is complete, finds the project in project_id
+func (h *UploadHandler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	upload, ok := h.upload(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Chunk-Size", strconv.FormatInt(h.chunkSize, 10))
+	writeUpload(w, upload, http.StatusOK)
+}
+
+// SendChunk appends the request body to an upload. It must start at the
+// upload's offset, given in Upload-Offset, and come with its SHA-256 in
+// Upload-Checksum ("sha256 <base64>"). Every chunk but the last is exactly
+// chunkSize, which bounds how many chunks the upload records. The last chunk
+// starts the assembly.
+func (h *UploadHandler) SendChunk(w http.ResponseWriter, r *http.Request) {
+	upload, ok := h.upload(w, r)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		WriteError(w, "Upload-Offset must be the number of bytes already sent", http.StatusBadRequest)
+		return
+	}
+	algorithm, digest, _ := strings.Cut(r.Header.Get("Upload-Checksum"), " ")
+	checksum, err := base64.StdEncoding.DecodeString(digest)
+	if algorithm != "sha256" || err != nil || len(checksum) != sha256.Size {
+		WriteError(w, "Upload-Checksum must be \"sha256\" and the chunk's base64 digest", http.StatusBadRequest)
+		return
+	}
+	if upload.Status != models.UploadOpen || offset != upload.Offset {
+		uploadConflict(w, upload)
+		return
+	}
+
+	// every chunk is stored under a name of its own, so that a retry racing
+	// it cannot overwrite the one which was recorded
+	chunk := fmt.Sprintf("%s/%d/%020d-%s", uploadsPrefix, upload.KeyID, offset, uuid.New().String())
+	ExtendDeadlines(r)
+	hash := sha256.New()
+	var length byteCounter
+	body := io.TeeReader(http.MaxBytesReader(w, r.Body, h.chunkSize), io.MultiWriter(hash, &length))
+	if err := h.blobs.Put(r.Context(), chunk, body, storage.Info{ContentType: "application/octet-stream"}); err != nil {
+		h.blobs.Delete(context.Background(), chunk)
+		if int64(length) >= h.chunkSize {
+			WriteError(w, fmt.Sprintf("Chunk too large: maximum size is %d bytes", h.chunkSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("Error storing a chunk of upload %d: %v", upload.KeyID, err)
+		WriteError(w, "Unable to save the chunk", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case length == 0:
+		WriteError(w, "The chunk is empty", http.StatusBadRequest)
+	case !bytes.Equal(hash.Sum(nil), checksum):
+		WriteError(w, "The chunk does not match Upload-Checksum; send it again", http.StatusUnprocessableEntity)
+	case offset+int64(length) > upload.Size:
+		WriteError(w, "The chunk goes past the end of the file", http.StatusRequestEntityTooLarge)
+	case offset+int64(length) < upload.Size && int64(length) != h.chunkSize:
+		WriteError(w, fmt.Sprintf("Every chunk but the last must be %d bytes", h.chunkSize), http.StatusBadRequest)
+	default:
+		advanced, err := h.uploadRepository.AdvanceUpload(upload.KeyID, offset, int64(length), chunk, time.Now().Add(h.expiry))
+		if err == nil {
+			if advanced.Status == models.UploadAssembling {
+				go h.assemble(advanced)
+			}
+			writeUpload(w, advanced, http.StatusOK)
+			return
+		}
+		h.blobs.Delete(context.Background(), chunk)
+		if err != models.ErrOffsetMismatch {
+			StorageError(w, err)
+			return
+		}
+		// another request got there first
+		if current, err := h.uploadRepository.GetUploadByID(upload.KeyID); err == nil {
+			upload = current
+		}
+		uploadConflict(w, upload)
+		return
+	}
+	h.blobs.Delete(context.Background(), chunk)
+}
+
+// CancelUpload abandons an upload, deleting its chunks and giving the room
+// it held back to the course. An upload being assembled cannot be cancelled.
+func (h *UploadHandler) CancelUpload(w http.ResponseWriter, r *http.Request) {
+	upload, ok := h.upload(w, r)
+	if !ok {
+		return
+	}
+	removed, err := h.uploadRepository.RemoveUpload(upload.KeyID)
+	if err == models.ErrUploadAssembling {
+		WriteError(w, "The upload is being assembled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	h.discard(r.Context(), removed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Upload cancelled"})
+}
+
+// GetCourseStorage reports how much of its quota a course uses
+func (h *UploadHandler) GetCourseStorage(w http.ResponseWriter, r *http.Request) {
+	courseID, ok := ParseID(w, r, "courseId")
+	if !ok {
+		return
+	}
+	usage, err := h.quota.Usage(r.Context(), courseID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// SetCourseStorageLimit gives a course a quota of its own; 0 restores the default
+func (h *UploadHandler) SetCourseStorageLimit(w http.ResponseWriter, r *http.Request) {
+	courseID, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	request := StorageLimit{}
+	if !DecodeJSON(w, r, &request) {
+		return
+	}
+	before, err := h.quota.Usage(r.Context(), courseID)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	usage, err := h.quota.SetLimit(r.Context(), courseID, request.Limit)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	h.auditor.RecordChange(r, "course.storage", "CourseStorage", courseID, before, usage)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// ExpireUploadsEvery removes, every interval until done is closed, the
+// uploads which expired: abandoned ones with their chunks, giving the room
+// they held back to their course, and finished ones clients no longer need
+func (h *UploadHandler) ExpireUploadsEvery(interval time.Duration, done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			h.expireUploads()
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func (h *UploadHandler) expireUploads() {
+	expired, err := h.uploadRepository.GetExpiredUploads(time.Now())
+	if err != nil {
+		log.Printf("Error loading expired uploads: %v", err)
+		return
+	}
+	for _, upload := range expired {
+		// another instance may have removed it
+		removed, err := h.uploadRepository.RemoveUpload(upload.KeyID)
+		if err == datastore.ErrNoSuchEntity {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error removing expired upload %d: %v", upload.KeyID, err)
+			continue
+		}
+		h.discard(context.Background(), removed)
+	}
+}
+
+// discard deletes the chunks of an upload which was removed before it was
+// assembled, and gives the room it held back to its course
+func (h *UploadHandler) discard(ctx context.Context, upload *models.Upload) {
+	if upload.Status != models.UploadOpen && upload.Status != models.UploadAssembling {
+		return
+	}
+	h.deleteChunks(ctx, upload)
+	h.quota.Release(upload.CourseID, upload.Size)
+}
+
+func (h *UploadHandler) deleteChunks(ctx context.Context, upload *models.Upload) {
+	for _, chunk := range upload.Chunks {
+		if err := h.blobs.Delete(ctx, chunk); err != nil {
+			log.Printf("Warning: Failed to delete a chunk of upload %d: %v", upload.KeyID, err)
+		}
+	}
+}
+
+// assemble joins the chunks of an upload into a project file, checked and
+// scanned like any other upload, and records it as a project. The chunks
+// are deleted either way; a file which is refused gives its room back.
+func (h *UploadHandler) assemble(upload *models.Upload) {
+	ctx := context.Background()
+	projectID, err := h.store(ctx, upload)
+	h.deleteChunks(ctx, upload)
+
+	upload.Chunks = nil
+	// clients have until then to see how it went
+	upload.ExpiresOn = time.Now().Add(h.expiry)
+	if err != nil {
+		upload.Status = models.UploadFailed
+		upload.Error = assemblyError(upload, err)
+		h.quota.Release(upload.CourseID, upload.Size)
+	} else {
+		upload.Status = models.UploadComplete
+		upload.ProjectID = projectID
+	}
+	if err := h.uploadRepository.UpdateUpload(upload); err != nil {
+		log.Printf("Error updating upload %d: %v", upload.KeyID, err)
+	}
+}
+
+// store joins the chunks of an upload in a temporary file, then stores it
+// like storeUpload and creates its project
+func (h *UploadHandler) store(ctx context.Context, upload *models.Upload) (int64, error) {
+	file, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	for _, chunk := range upload.Chunks {
+		reader, _, err := h.blobs.Open(ctx, chunk)
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.Copy(file, reader)
+		reader.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	use := "project"
+	if upload.ElementID != 0 {
+		element, found := h.files.uploadElement(upload.ModuleID, upload.ElementID)
+		if !found {
+			return 0, &scan.Rejected{Reason: "the element is no longer a project or file element of the module"}
+		}
+		use = element.Type
+	}
+	contentType, err := scan.Check(file, upload.Size, upload.FileName, use)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	name := projectsPrefix + "/" + uuid.New().String() + filepath.Ext(upload.FileName)
+	info := storage.Info{ContentType: contentType, Filename: upload.FileName}
+	if err := h.quarantine.Admit(ctx, name, file, info); err != nil {
+		return 0, err
+	}
+
+	project := &models.Project{
+		Name:        upload.Name,
+		Description: upload.Description,
+		File:        name,
+		FileName:    upload.FileName,
+		Size:        upload.Size,
+		Date:        time.Now(),
+		UserID:      upload.UserID,
+		CourseID:    upload.CourseID,
+		ModuleID:    upload.ModuleID,
+	}
+	key, err := h.files.projectRepository.CreateProject(project)
+	if err != nil {
+		h.blobs.Delete(ctx, name)
+		return 0, err
+	}
+	h.files.recordSubmission(upload.UserID, upload.ModuleID, upload.ElementID, key.ID)
+	return key.ID, nil
+}
+
+// assemblyError is what the client is told of an upload which failed, like
+// uploadError tells it of one sent at once
+func assemblyError(upload *models.Upload, err error) string {
+	var rejected *scan.Rejected
+	switch {
+	case errors.As(err, &rejected):
+		return rejected.Reason
+	case errors.Is(err, scan.ErrScanFailed):
+		log.Printf("Unable to scan upload %d: %v", upload.KeyID, err)
+		return "The file could not be checked for malware; upload it again later"
+	default:
+		log.Printf("Unable to assemble upload %d: %v", upload.KeyID, err)
+		return "Unable to save file on server"
+	}
+}
+
+// upload reads the upload named by the route, writing a 404 unless it is
+// the current user's
+func (h *UploadHandler) upload(w http.ResponseWriter, r *http.Request) (*models.Upload, bool) {
+	id, ok := ParseID(w, r, "id")
+	if !ok {
+		return nil, false
+	}
+	upload, err := h.uploadRepository.GetUploadByID(id)
+	if err != nil {
+		StorageError(w, err)
+		return nil, false
+	}
+	if upload.UserID != CurrentUser(r).KeyID {
+		WriteError(w, "Not found", http.StatusNotFound)
+		return nil, false
+	}
+	return upload, true
+}
+
+// uploadConflict answers a chunk which does not start where the upload stands
+func uploadConflict(w http.ResponseWriter, upload *models.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Status != models.UploadOpen {
+		WriteError(w, "The upload is "+upload.Status, http.StatusConflict)
+		return
+	}
+	WriteError(w, fmt.Sprintf("The upload is at offset %d", upload.Offset), http.StatusConflict)
+}
+
+func writeUpload(w http.ResponseWriter, upload *models.Upload, status int) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(upload)
+}
+
+// byteCounter counts the bytes written to it
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
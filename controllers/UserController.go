@@ -16,14 +16,20 @@ import (
 // UserHandler will hold everything that controller needs
 type UserHandler struct {
 	userRepository models.UserRepository
-	sessions       *map[string]*Session
+	sessions       *SessionStore
+	permissions    PermissionLookup
+	auditor        *Auditor
 }
 
-// NewUserHandler returns a new UserHandler
-func NewUserHandler(userRepository models.UserRepository, Sessions *map[string]*Session) *UserHandler {
+// NewUserHandler returns a new UserHandler; permissions tell admins, who
+// may change anything about a user, from users editing their profile
+func NewUserHandler(userRepository models.UserRepository, sessions *SessionStore, permissions PermissionLookup,
+	auditor *Auditor) *UserHandler {
 	return &UserHandler{
 		userRepository: userRepository,
-		sessions:       Sessions,
+		sessions:       sessions,
+		permissions:    permissions,
+		auditor:        auditor,
 	}
 }
 
@@ -31,31 +37,35 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	// decode the request body into a new user struct
 	user := models.User{}
-	err := json.NewDecoder(r.Body).Decode(&user)
+	if !DecodeJSON(w, r, &user) {
+		return
+	}
+	// roles and progress are not for new users to choose
+	user.Roles = nil
+	user.Modules = nil
 
+	created, err := h.CreateIfNotExists(&user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		StorageError(w, err)
+		return
+	}
+	if !created {
+		WriteError(w, "Username "+user.Username+" is taken", http.StatusConflict)
 		return
 	}
-
-	h.CreateIfNotExists(w, r, &user)
 	h.IssueToken(w, r, &user, false)
 }
 
-func (h *UserHandler) CreateIfNotExists(w http.ResponseWriter, r *http.Request, user *models.User) {
-
-	// See if user exists in database, if not, create
-	_, err := h.userRepository.GetUserByUsername(user.Username)
-	if err != nil {
-
-		// save the user to the database
-		_, err := h.userRepository.CreateUser(user)
-
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+// CreateIfNotExists creates the user unless one has their username, and
+// reports whether it did
+func (h *UserHandler) CreateIfNotExists(user *models.User) (bool, error) {
+	if _, err := h.userRepository.GetUserByUsername(user.Username); err == nil {
+		return false, nil
 	}
+	if _, err := h.userRepository.CreateUser(user); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // Get
@@ -67,7 +77,7 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	user, err := h.userRepository.GetUserByID(idInt)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -79,48 +89,75 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(&user)
 }
 
-// GetAll
-func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	// get all users from the database
-	users, err := h.userRepository.GetAllUsers()
-
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+// UserListSpec lists the filters and sort orders of GET /user
+var UserListSpec = ListSpec{
+	Filters: map[string]FilterParam{
+		"username": StringFilter("Username"),
+		"email":    StringFilter("Email"),
+	},
+	Sorts: map[string]string{
+		"username":   "Username",
+		"created_on": "CreatedOn",
+	},
+}
 
-	// return the users
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(users)
+// GetAllUsers returns a page of users, see ServeList
+func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
+	ServeList(w, r, UserListSpec, h.userRepository.ListUsers, h.userRepository.CountUsers)
 }
 
 // Update
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 
-	var id = mux.Vars(r)["id"]
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 
 	// decode the request body into a new user struct
-	user := models.User{}
-	err := json.NewDecoder(r.Body).Decode(&user)
+	update := models.User{}
+	if !DecodeJSON(w, r, &update) {
+		return
+	}
 
+	before, err := h.userRepository.GetUserByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		StorageError(w, err)
 		return
 	}
 
-	//convert id to int64
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	// users only change their profile; admins may change the rest, which
+	// is kept when they leave it out
+	user := *before
+	user.Email = update.Email
+	user.Firstname = update.Firstname
+	user.Lastname = update.Lastname
+	user.Bio = update.Bio
+	user.Avatar = update.Avatar
+	if h.permissions.GetPermissions(CurrentUser(r).GetRoles())[models.PermissionAll] {
+		user.Username = update.Username
+		if update.Password != "" {
+			user.Password = update.Password
+		}
+		if update.Roles != nil {
+			user.Roles = update.Roles
+		}
+		if update.Modules != nil {
+			user.Modules = update.Modules
+		}
+	}
 
 	// update the user in the database
 	_, err = h.userRepository.UpdateUser(idInt, &user)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
 	user.KeyID = idInt
+	h.auditor.RecordChange(r, "user.update", "User", idInt, before, &user)
+	user.Password = ""
 
 	// return the updated user
 	w.Header().Set("Content-Type", "application/json")
@@ -131,15 +168,20 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 // Delete
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	// delete the user from the database
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	before, _ := h.userRepository.GetUserByID(idInt)
 	err := h.userRepository.DeleteUser(idInt)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	h.auditor.RecordChange(r, "user.delete", "User", idInt, before, nil)
+
 	// return a status notifying the client the user was deleted
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -148,25 +190,20 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 
 // Create a struct that models the structure of a user in the request body
 type Credentials struct {
-	Password string `json:"password"`
-	Username string `json:"username"`
+	Password string `json:"password" validate:"required"`
+	Username string `json:"username" validate:"required"`
 }
 
 func (h *UserHandler) IssueToken(w http.ResponseWriter, r *http.Request, user *models.User, skipResponse bool) {
 	sessionToken := uuid.NewString()
 	expiresAt := time.Now().Add(360 * time.Second)
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_token",
-		Path:    "/",
-		Value:   sessionToken,
-		Expires: expiresAt,
-	})
+	SetSessionCookie(w, sessionToken, expiresAt)
 
-	(*h.sessions)[sessionToken] = &Session{
+	h.sessions.Set(sessionToken, &Session{
 		username: (*user).Username,
 		expiry:   expiresAt,
-	}
+	})
 
 	type UserWithRedirect struct {
 		User          models.User `json:"user"`
@@ -188,17 +225,14 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	var creds Credentials
 
-	err := json.NewDecoder(r.Body).Decode(&creds)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if !DecodeJSON(w, r, &creds) {
 		return
 	}
 
 	user, err := h.userRepository.GetUserByUsernameAndPassword(creds.Username, creds.Password)
 
 	if err != nil {
-		// set status to 401
-		w.WriteHeader(http.StatusUnauthorized)
+		WriteError(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
@@ -214,7 +248,8 @@ func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if sessionToken != "" {
-		delete(*h.sessions, sessionToken)
+		h.sessions.Delete(sessionToken)
+		ClearSessionCookie(w)
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 	}
 
@@ -226,22 +261,28 @@ func (h *UserHandler) ValidateSession(next http.HandlerFunc) http.HandlerFunc {
 		sessionToken, session := GetSession(r)
 		if sessionToken == "" || *session == (Session{}) || Expired(session) {
 			if sessionToken != "" && *session != (Session{}) {
-				delete(Sessions, sessionToken)
+				h.sessions.Delete(sessionToken)
 			}
 
-			http.Redirect(w, r, "/expired.html", http.StatusTemporaryRedirect)
+			// browsers are sent to the expired page, API clients get JSON
+			if wantsHTML(r) {
+				http.Redirect(w, r, "/expired.html", http.StatusTemporaryRedirect)
+			} else {
+				WriteError(w, "Session expired", http.StatusUnauthorized)
+			}
 			return
 		}
 
 		expiresAt := time.Now().Add(360 * time.Second)
 
-		session.SetTime(expiresAt)
-		http.SetCookie(w, &http.Cookie{
-			Name:    "session_token",
-			Path:    "/",
-			Value:   sessionToken,
-			Expires: expiresAt,
-		})
+		h.sessions.Extend(sessionToken, expiresAt)
+		SetSessionCookie(w, sessionToken, expiresAt)
+
+		// keep the impersonating admin's own session alive, and tell the client
+		if session.Impersonated() {
+			h.sessions.Extend(session.originalToken, expiresAt)
+			w.Header().Set("X-Impersonated-By", session.impersonator)
+		}
 
 		next.ServeHTTP(w, r)
 
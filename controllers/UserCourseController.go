@@ -4,45 +4,103 @@ import (
 	"encoding/json"
 	"net/http"
 	"restAPI/models"
-	"strconv"
-
-	"github.com/gorilla/mux"
 )
 
 // UserCourseHandler ..
 type UserCourseHandler struct {
 	userCourseRepository models.UserCourseRepository
+	auditor              *Auditor
 }
 
 // NewUserCourseHandler ..
-func NewUserCourseHandler(userCourseRepository models.UserCourseRepository) *UserCourseHandler {
+func NewUserCourseHandler(userCourseRepository models.UserCourseRepository, auditor *Auditor) *UserCourseHandler {
 	return &UserCourseHandler{
 		userCourseRepository: userCourseRepository,
+		auditor:              auditor,
 	}
 }
 
+// CreateUserCourse enrols the current user in a course as a learner; other
+// users and roles are enrolled by the course's instructors, see EnrolUser
 func (h *UserCourseHandler) CreateUserCourse(w http.ResponseWriter, r *http.Request) {
 	userCourse := models.UserCourse{}
-	err := json.NewDecoder(r.Body).Decode(&userCourse)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !DecodeJSON(w, r, &userCourse) {
 		return
 	}
+	user := CurrentUser(r)
+	if user == nil {
+		WriteError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if userCourse.UserID != user.KeyID || (userCourse.Role != "" && userCourse.Role != CourseRoleLearner) {
+		WriteError(w, "You can only enrol yourself, as a learner", http.StatusForbidden)
+		return
+	}
+
+	h.enrol(w, r, &models.UserCourse{
+		UserID:    user.KeyID,
+		CourseID:  userCourse.CourseID,
+		StartedOn: userCourse.StartedOn,
+		Role:      CourseRoleLearner,
+	})
+}
 
-	_, err = h.userCourseRepository.CreateUserCourse(&userCourse)
+// EnrolUser enrols a user in the course with any role but owner, which
+// belongs to the course's owner_id
+func (h *UserCourseHandler) EnrolUser(w http.ResponseWriter, r *http.Request) {
+	courseID, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	userCourse := models.UserCourse{}
+	if !DecodeJSON(w, r, &userCourse) {
+		return
+	}
+	if !enrolmentRole(w, userCourse.Role) {
+		return
+	}
+
+	userCourse.KeyID = 0
+	userCourse.CourseID = courseID
+	if userCourse.Role == "" {
+		userCourse.Role = CourseRoleLearner
+	}
+	h.enrol(w, r, &userCourse)
+}
+
+func (h *UserCourseHandler) enrol(w http.ResponseWriter, r *http.Request, userCourse *models.UserCourse) {
+	if _, err := h.userCourseRepository.GetUserCourseByUserIDAndCourseID(userCourse.UserID, userCourse.CourseID); err == nil {
+		WriteError(w, "The user is already enrolled in the course", http.StatusConflict)
+		return
+	}
+
+	key, err := h.userCourseRepository.CreateUserCourse(userCourse)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
+	userCourse.KeyID = key.ID
+
+	h.auditor.RecordChange(r, "usercourse.create", "UserCourse", key.ID, nil, userCourse)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(userCourse)
 }
 
+// enrolmentRole writes a 422 unless role can be given through an enrolment
+func enrolmentRole(w http.ResponseWriter, role string) bool {
+	switch role {
+	case "", CourseRoleLearner, CourseRoleTA, CourseRoleInstructor:
+		return true
+	}
+	WriteError(w, "role must be learner, ta or instructor", http.StatusUnprocessableEntity)
+	return false
+}
+
 func (h *UserCourseHandler) GetAllUserCourses(w http.ResponseWriter, r *http.Request) {
 	userCourses, err := h.userCourseRepository.GetAllUserCourses()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -51,46 +109,101 @@ func (h *UserCourseHandler) GetAllUserCourses(w http.ResponseWriter, r *http.Req
 }
 
 func (h *UserCourseHandler) DeleteUserCourse(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	err := h.userCourseRepository.DeleteUserCourse(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	h.auditor.Record(r, "usercourse.delete", "UserCourse", idInt)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "UserCourse deleted"})
 }
 
+// UpdateUserCourse replaces an enrolment's grade and dates; the user, course
+// and role stay, see SetUserCourseRole
 func (h *UserCourseHandler) UpdateUserCourse(w http.ResponseWriter, r *http.Request) {
-	var id = mux.Vars(r)["id"]
-	idInt, _ := strconv.ParseInt(id, 10, 64)
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	userCourse := models.UserCourse{}
-	err := json.NewDecoder(r.Body).Decode(&userCourse)
+	if !DecodeJSON(w, r, &userCourse) {
+		return
+	}
+	existing, err := h.userCourseRepository.GetUserCourseByID(idInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		StorageError(w, err)
 		return
 	}
+	userCourse.KeyID = idInt
+	userCourse.UserID = existing.UserID
+	userCourse.CourseID = existing.CourseID
+	userCourse.Role = existing.Role
 
 	_, err = h.userCourseRepository.UpdateUserCourse(idInt, &userCourse)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
+	h.auditor.RecordChange(r, "usercourse.update", "UserCourse", idInt, existing, &userCourse)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(userCourse)
+}
+
+// EnrolmentRole is the body of PUT /usercourse/{id}/role
+type EnrolmentRole struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// SetUserCourseRole changes the role of an enrolment
+func (h *UserCourseHandler) SetUserCourseRole(w http.ResponseWriter, r *http.Request) {
+	idInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
+	request := EnrolmentRole{}
+	if !DecodeJSON(w, r, &request) || !enrolmentRole(w, request.Role) {
+		return
+	}
+	before, err := h.userCourseRepository.GetUserCourseByID(idInt)
+	if err != nil {
+		StorageError(w, err)
+		return
+	}
+	userCourse := *before
+	userCourse.Role = request.Role
+
+	if _, err := h.userCourseRepository.UpdateUserCourse(idInt, &userCourse); err != nil {
+		StorageError(w, err)
+		return
+	}
+
+	h.auditor.RecordChange(r, "usercourse.role", "UserCourse", idInt, before, &userCourse)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(userCourse)
 }
 
 func (h *UserCourseHandler) GetUserCourseByUserIDAndCourseID(w http.ResponseWriter, r *http.Request) {
-	var userID = mux.Vars(r)["userID"]
-	var courseID = mux.Vars(r)["courseID"]
-	userIDInt, _ := strconv.ParseInt(userID, 10, 64)
-	courseIDInt, _ := strconv.ParseInt(courseID, 10, 64)
+	userIDInt, ok := ParseID(w, r, "userID")
+	if !ok {
+		return
+	}
+	courseIDInt, ok := ParseID(w, r, "courseID")
+	if !ok {
+		return
+	}
 	userCourse, err := h.userCourseRepository.GetUserCourseByUserIDAndCourseID(userIDInt, courseIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -99,11 +212,13 @@ func (h *UserCourseHandler) GetUserCourseByUserIDAndCourseID(w http.ResponseWrit
 }
 
 func (h *UserCourseHandler) GetUserCoursesByCourseID(w http.ResponseWriter, r *http.Request) {
-	var courseID = mux.Vars(r)["id"]
-	courseIDInt, _ := strconv.ParseInt(courseID, 10, 64)
+	courseIDInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	userCourses, err := h.userCourseRepository.GetUserCoursesByCourseID(courseIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -112,11 +227,13 @@ func (h *UserCourseHandler) GetUserCoursesByCourseID(w http.ResponseWriter, r *h
 }
 
 func (h *UserCourseHandler) GetUserCoursesByUserID(w http.ResponseWriter, r *http.Request) {
-	var userID = mux.Vars(r)["id"]
-	userIDInt, _ := strconv.ParseInt(userID, 10, 64)
+	userIDInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	userCourses, err := h.userCourseRepository.GetUserCoursesByUserID(userIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -125,11 +242,13 @@ func (h *UserCourseHandler) GetUserCoursesByUserID(w http.ResponseWriter, r *htt
 }
 
 func (h *UserCourseHandler) GetCoursesByUserID(w http.ResponseWriter, r *http.Request) {
-	var userID = mux.Vars(r)["id"]
-	userIDInt, _ := strconv.ParseInt(userID, 10, 64)
+	userIDInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	courses, err := h.userCourseRepository.GetCoursesByUserID(userIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -138,11 +257,13 @@ func (h *UserCourseHandler) GetCoursesByUserID(w http.ResponseWriter, r *http.Re
 }
 
 func (h *UserCourseHandler) GetUsersByCourseID(w http.ResponseWriter, r *http.Request) {
-	var courseID = mux.Vars(r)["id"]
-	courseIDInt, _ := strconv.ParseInt(courseID, 10, 64)
+	courseIDInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	users, err := h.userCourseRepository.GetUsersByCourseID(courseIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
@@ -151,11 +272,13 @@ func (h *UserCourseHandler) GetUsersByCourseID(w http.ResponseWriter, r *http.Re
 }
 
 func (h *UserCourseHandler) GetInstructorsByCourseID(w http.ResponseWriter, r *http.Request) {
-	var courseID = mux.Vars(r)["id"]
-	courseIDInt, _ := strconv.ParseInt(courseID, 10, 64)
+	courseIDInt, ok := ParseID(w, r, "id")
+	if !ok {
+		return
+	}
 	users, err := h.userCourseRepository.GetInstructorsByCourseID(courseIDInt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		StorageError(w, err)
 		return
 	}
 
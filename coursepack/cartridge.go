@@ -0,0 +1,181 @@
+package coursepack
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"strings"
+	"unicode/utf8"
+)
+
+// The archive is an IMS Common Cartridge 1.3 (imsmanifest.xml): each module
+// is a folder of web content pages, one per element, and ManifestFile is a
+// learning application resource other LMSes ignore
+
+const (
+	cartridgeNamespace = "http://www.imsglobal.org/xsd/imsccv1p3/imscp_v1p1"
+	lomNamespace       = "http://ltsc.ieee.org/xsd/imsccv1p3/LOM/manifest"
+	applicationType    = "associatedcontent/imscc_xmlv1p3/learning-application-resource"
+)
+
+type ccManifest struct {
+	XMLName       xml.Name        `xml:"manifest"`
+	Namespace     string          `xml:"xmlns,attr"`
+	LOM           string          `xml:"xmlns:lomimscc,attr"`
+	Identifier    string          `xml:"identifier,attr"`
+	Metadata      ccMetadata      `xml:"metadata"`
+	Organizations ccOrganizations `xml:"organizations"`
+	Resources     []ccResource    `xml:"resources>resource"`
+}
+
+type ccMetadata struct {
+	Schema        string `xml:"schema"`
+	SchemaVersion string `xml:"schemaversion"`
+	Title         string `xml:"lomimscc:lom>lomimscc:general>lomimscc:title>lomimscc:string"`
+}
+
+type ccOrganizations struct {
+	Organization ccOrganization `xml:"organization"`
+}
+
+type ccOrganization struct {
+	Identifier string `xml:"identifier,attr"`
+	Structure  string `xml:"structure,attr"`
+	Root       ccItem `xml:"item"`
+}
+
+type ccItem struct {
+	Identifier    string   `xml:"identifier,attr"`
+	IdentifierRef string   `xml:"identifierref,attr,omitempty"`
+	Title         string   `xml:"title,omitempty"`
+	Items         []ccItem `xml:"item"`
+}
+
+type ccResource struct {
+	Identifier string   `xml:"identifier,attr"`
+	Type       string   `xml:"type,attr"`
+	Href       string   `xml:"href,attr,omitempty"`
+	Files      []ccFile `xml:"file"`
+}
+
+type ccFile struct {
+	Href string `xml:"href,attr"`
+}
+
+var elementPage = template.Must(template.New("element").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+{{range .Paragraphs}}<p>{{.}}</p>
+{{end}}{{with .Image}}<figure><img src="{{.}}" alt="{{$.Element.ImageCaption}}"><figcaption>{{$.Element.ImageCaption}} {{$.Element.ImageCredit}}</figcaption></figure>
+{{end}}{{with .Video}}<figure><video controls src="{{.}}"></video><figcaption>{{$.Element.VideoCaption}} {{$.Element.VideoCredit}}</figcaption></figure>
+{{end}}{{with .Element.Choices}}<ol type="a">{{range .}}<li>{{.Text}}</li>{{end}}</ol>
+{{end}}</body></html>
+`))
+
+var coursePage = template.Must(template.New("course").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><title>{{.Name}}</title></head>
+<body><h1>{{.Name}}</h1>
+<p>{{.Description}}</p>
+<div>{{.HomeContent}}</div>
+</body></html>
+`))
+
+// writeCartridge adds imsmanifest.xml and a page for the course and each element
+func writeCartridge(archive *zip.Writer, pkg *Package) error {
+	mediaFiles := map[string]string{}
+	for _, m := range pkg.Media {
+		mediaFiles[m.Location] = m.File
+	}
+
+	manifest := ccManifest{
+		Namespace:  cartridgeNamespace,
+		LOM:        lomNamespace,
+		Identifier: fmt.Sprintf("course_%d", pkg.Course.KeyID),
+		Metadata:   ccMetadata{Schema: "IMS Common Cartridge", SchemaVersion: "1.3.0", Title: pkg.Course.Name},
+		Resources: []ccResource{
+			{Identifier: "application", Type: applicationType, Files: []ccFile{{Href: ManifestFile}}},
+			{Identifier: "course", Type: "webcontent", Href: "index.html", Files: []ccFile{{Href: "index.html"}}},
+		},
+	}
+	root := ccItem{Identifier: "root", Items: []ccItem{{Identifier: "course_home", IdentifierRef: "course", Title: pkg.Course.Name}}}
+
+	var page bytes.Buffer
+	if err := coursePage.Execute(&page, pkg.Course); err != nil {
+		return err
+	}
+	if err := writeFile(archive, "index.html", page.Bytes()); err != nil {
+		return err
+	}
+
+	elements := map[int64]int{}
+	for i, element := range pkg.Elements {
+		elements[element.KeyID] = i
+	}
+	written := map[int64]bool{}
+	for _, module := range pkg.Modules {
+		folder := ccItem{Identifier: fmt.Sprintf("module_%d", module.KeyID), Title: module.Name}
+		for _, placement := range module.Elements {
+			element := pkg.Elements[elements[placement.ElementID]]
+			resource := fmt.Sprintf("element_%d", element.KeyID)
+			folder.Items = append(folder.Items, ccItem{
+				Identifier:    fmt.Sprintf("module_%d_element_%d", module.KeyID, element.KeyID),
+				IdentifierRef: resource,
+				Title:         title(element.Text, element.Type),
+			})
+			if written[element.KeyID] {
+				continue
+			}
+			written[element.KeyID] = true
+
+			href := fmt.Sprintf("elements/%d.html", element.KeyID)
+			files := []ccFile{{Href: href}}
+			data := struct {
+				Title        string
+				Paragraphs   []string
+				Image, Video string
+				Element      interface{}
+			}{Title: title(element.Text, element.Type), Paragraphs: strings.Split(element.Text, "\n\n"), Element: element}
+			data.Image, data.Video = element.ImageLocation, element.VideoLocation
+			if file, ok := mediaFiles[element.ImageLocation]; ok {
+				data.Image = "../" + file
+				files = append(files, ccFile{Href: file})
+			}
+			if file, ok := mediaFiles[element.VideoLocation]; ok {
+				data.Video = "../" + file
+				files = append(files, ccFile{Href: file})
+			}
+
+			page.Reset()
+			if err := elementPage.Execute(&page, data); err != nil {
+				return err
+			}
+			if err := writeFile(archive, href, page.Bytes()); err != nil {
+				return err
+			}
+			manifest.Resources = append(manifest.Resources, ccResource{Identifier: resource, Type: "webcontent", Href: href, Files: files})
+		}
+		root.Items = append(root.Items, folder)
+	}
+	manifest.Organizations.Organization = ccOrganization{Identifier: "organization", Structure: "rooted-hierarchy", Root: root}
+
+	content, err := xml.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(archive, "imsmanifest.xml", append([]byte(xml.Header), content...))
+}
+
+// title shortens an element's text to a title for the cartridge's table of contents
+func title(text string, kind string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return kind
+	}
+	if utf8.RuneCountInString(text) > 80 {
+		runes := []rune(text)
+		text = string(runes[:79]) + "…"
+	}
+	return text
+}
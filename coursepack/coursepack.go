@@ -0,0 +1,231 @@
+// Package coursepack reads and writes a course, its modules and elements
+// and their media as one zip archive, which is also an IMS Common
+// Cartridge 1.3 of web content for other LMSes
+package coursepack
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"restAPI/models"
+	"strings"
+	"time"
+)
+
+// Format and Version identify ManifestFile
+const (
+	Format       = "restapi-course"
+	Version      = 1
+	ManifestFile = "course.json"
+)
+
+// Limits on what Read accepts
+const (
+	MaxManifest  = 10 << 20
+	MaxMediaFile = 50 << 20
+	MaxMedia     = 500 << 20
+)
+
+// Package is the content of ManifestFile. IDs are those of the exporting
+// environment; they only link the parts together and are remapped on import.
+type Package struct {
+	Format     string           `json:"format"`
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Course     models.Course    `json:"course"`
+	Modules    []Module         `json:"modules"`
+	Elements   []models.Element `json:"elements"`
+	Media      []Media          `json:"media"`
+}
+
+// Module is a module with its elements, in order
+type Module struct {
+	models.Module
+	Elements []Placement `json:"elements"`
+}
+
+// Placement puts an element of the package in a module (a ModuleElement)
+type Placement struct {
+	ElementID int64 `json:"element_id"`
+	SortKey   int   `json:"sort_key"`
+}
+
+// Media is a file an element refers to by Location, stored as File in the archive
+type Media struct {
+	Location string `json:"location"`
+	File     string `json:"file"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+}
+
+// IsLocal reports whether a media location is a file served by this
+// application (a path such as /uploads/x.png) rather than a URL
+func IsLocal(location string) bool {
+	return strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//")
+}
+
+// Opener opens the file of a local media location
+type Opener func(location string) (io.ReadCloser, error)
+
+// Write writes pkg as an archive, adding the local media of its elements
+// which open can find. pkg.Media is filled in.
+func Write(w io.Writer, pkg *Package, open Opener) error {
+	pkg.Format, pkg.Version = Format, Version
+	if pkg.ExportedAt.IsZero() {
+		pkg.ExportedAt = time.Now().UTC()
+	}
+
+	media := map[string][]byte{}
+	pkg.Media = nil
+	seen := map[string]bool{}
+	for _, element := range pkg.Elements {
+		for _, location := range []string{element.ImageLocation, element.VideoLocation} {
+			if !IsLocal(location) || seen[location] {
+				continue
+			}
+			seen[location] = true
+
+			content, err := readMedia(open, location)
+			if err != nil {
+				return fmt.Errorf("media %s: %w", location, err)
+			}
+			if content == nil {
+				continue // missing; the element keeps its location
+			}
+			sum := sha256.Sum256(content)
+			file := "media/" + hex.EncodeToString(sum[:]) + strings.ToLower(path.Ext(location))
+			media[file] = content
+			pkg.Media = append(pkg.Media, Media{Location: location, File: file, SHA256: hex.EncodeToString(sum[:]), Size: int64(len(content))})
+		}
+	}
+
+	archive := zip.NewWriter(w)
+	manifest, err := json.MarshalIndent(pkg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(archive, ManifestFile, manifest); err != nil {
+		return err
+	}
+	if err := writeCartridge(archive, pkg); err != nil {
+		return err
+	}
+	for _, m := range pkg.Media {
+		if err := writeFile(archive, m.File, media[m.File]); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// readMedia returns nil content, without an error, for a missing file
+func readMedia(open Opener, location string) ([]byte, error) {
+	r, err := open(location)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(io.LimitReader(r, MaxMediaFile+1))
+	if err == nil && len(content) > MaxMediaFile {
+		err = fmt.Errorf("larger than %d MB", MaxMediaFile>>20)
+	}
+	return content, err
+}
+
+func writeFile(archive *zip.Writer, name string, content []byte) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	return err
+}
+
+// Read parses an archive written by Write, checking that its parts are
+// consistent. The media content is returned by Media.File.
+func Read(data []byte) (*Package, map[string][]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid zip: %w", err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	f, ok := files[ManifestFile]
+	if !ok {
+		return nil, nil, fmt.Errorf("no %s: not a course package", ManifestFile)
+	}
+	content, err := readZipFile(f, MaxManifest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", ManifestFile, err)
+	}
+	pkg := &Package{}
+	if err := json.Unmarshal(content, pkg); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", ManifestFile, err)
+	}
+	if pkg.Format != Format || pkg.Version < 1 || pkg.Version > Version {
+		return nil, nil, fmt.Errorf("unsupported package format %q version %d", pkg.Format, pkg.Version)
+	}
+
+	elements := map[int64]bool{}
+	for _, element := range pkg.Elements {
+		elements[element.KeyID] = true
+	}
+	for _, module := range pkg.Modules {
+		for _, placement := range module.Elements {
+			if !elements[placement.ElementID] {
+				return nil, nil, fmt.Errorf("module %q places element %d, which is not in the package", module.Name, placement.ElementID)
+			}
+		}
+	}
+
+	media := map[string][]byte{}
+	var total int64
+	for _, m := range pkg.Media {
+		f, ok := files[m.File]
+		if !ok {
+			return nil, nil, fmt.Errorf("media file %s is missing", m.File)
+		}
+		if total += int64(f.UncompressedSize64); total > MaxMedia {
+			return nil, nil, fmt.Errorf("media larger than %d MB", MaxMedia>>20)
+		}
+		content, err := readZipFile(f, MaxMediaFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("media file %s: %w", m.File, err)
+		}
+		if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != m.SHA256 {
+			return nil, nil, fmt.Errorf("media file %s does not match its checksum", m.File)
+		}
+		media[m.File] = content
+	}
+	return pkg, media, nil
+}
+
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("larger than %d MB", limit>>20)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	content, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err == nil && int64(len(content)) > limit {
+		err = fmt.Errorf("larger than %d MB", limit>>20)
+	}
+	return content, err
+}
@@ -0,0 +1,145 @@
+package lti
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"restAPI/models"
+	"strings"
+	"time"
+)
+
+// ScoreScope is the Assignment and Grade Services scope for posting scores
+const ScoreScope = "https://purl.imsglobal.org/spec/lti-ags/scope/score"
+
+// Score is a learner's result on a line item
+type Score struct {
+	UserID           string  `json:"userId"`
+	ScoreGiven       float64 `json:"scoreGiven"`
+	ScoreMaximum     float64 `json:"scoreMaximum"`
+	Comment          string  `json:"comment,omitempty"`
+	ActivityProgress string  `json:"activityProgress"`
+	GradingProgress  string  `json:"gradingProgress"`
+	Timestamp        string  `json:"timestamp"`
+}
+
+type accessToken struct {
+	token   string
+	expires time.Time
+}
+
+// clientAssertion authenticates the tool to the platform's token endpoint
+type clientAssertion struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience Audience `json:"aud"`
+	Exp      int64    `json:"exp"`
+	Iat      int64    `json:"iat"`
+	ID       string   `json:"jti"`
+}
+
+// PostScore publishes a score to a line item of the platform
+func (t *Tool) PostScore(p *models.LTIPlatform, lineItem string, score Score) error {
+	token, err := t.accessToken(p, ScoreScope)
+	if err != nil {
+		return err
+	}
+
+	// the scores endpoint is the line item URL with /scores added to its path
+	scores, err := url.Parse(lineItem)
+	if err != nil {
+		return fmt.Errorf("invalid line item %q", lineItem)
+	}
+	scores.Path = strings.TrimSuffix(scores.Path, "/") + "/scores"
+
+	body, err := json.Marshal(score)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, scores.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.ims.lis.v1.score+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		if resp.StatusCode == http.StatusUnauthorized {
+			t.forgetToken(p, ScoreScope)
+		}
+		return fmt.Errorf("posting the score: %s", resp.Status)
+	}
+	return nil
+}
+
+// accessToken gets a token with a client credentials grant, authenticated
+// by a JWT signed with the tool's key, and keeps it until it expires
+func (t *Tool) accessToken(p *models.LTIPlatform, scope string) (string, error) {
+	if p.AuthTokenURL == "" {
+		return "", errors.New("the platform has no token URL")
+	}
+	cacheKey := p.AuthTokenURL + " " + p.ClientID + " " + scope
+	t.mu.Lock()
+	cached, ok := t.tokens[cacheKey]
+	t.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.token, nil
+	}
+
+	now := time.Now()
+	assertion, err := Sign(clientAssertion{
+		Issuer:   p.ClientID,
+		Subject:  p.ClientID,
+		Audience: Audience{p.AuthTokenURL},
+		Exp:      now.Add(5 * time.Minute).Unix(),
+		Iat:      now.Unix(),
+		ID:       randomString(16),
+	}, t.key, t.keyID)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := t.client.PostForm(p.AuthTokenURL, url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {assertion},
+		"scope":                 {scope},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("getting an access token: %s", resp.Status)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil || body.AccessToken == "" {
+		return "", errors.New("getting an access token: invalid response")
+	}
+
+	// renew a little early
+	lifetime := time.Duration(body.ExpiresIn)*time.Second - time.Minute
+	t.mu.Lock()
+	t.tokens[cacheKey] = accessToken{token: body.AccessToken, expires: now.Add(lifetime)}
+	t.mu.Unlock()
+	return body.AccessToken, nil
+}
+
+func (t *Tool) forgetToken(p *models.LTIPlatform, scope string) {
+	t.mu.Lock()
+	delete(t.tokens, p.AuthTokenURL+" "+p.ClientID+" "+scope)
+	t.mu.Unlock()
+}
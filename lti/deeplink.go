@@ -0,0 +1,100 @@
+package lti
+
+import (
+	"errors"
+	"restAPI/models"
+	"time"
+)
+
+// LinkItem is an ltiResourceLink content item returned by deep linking
+type LinkItem struct {
+	Type   string            `json:"type"`
+	Title  string            `json:"title,omitempty"`
+	Text   string            `json:"text,omitempty"`
+	URL    string            `json:"url,omitempty"`
+	Custom map[string]string `json:"custom,omitempty"`
+	// LineItem asks the platform to create a gradebook column for the link
+	LineItem *LineItem `json:"lineItem,omitempty"`
+}
+
+// LineItem is a gradebook column
+type LineItem struct {
+	ScoreMaximum float64 `json:"scoreMaximum"`
+	Label        string  `json:"label,omitempty"`
+	ResourceID   string  `json:"resourceId,omitempty"`
+}
+
+// Selection is what a deep linking launch needs to answer the platform once
+// the user has chosen what to link; it is signed by the tool and carried by
+// the page the user chooses on
+type Selection struct {
+	PlatformID   int64  `json:"platform_id"`
+	DeploymentID string `json:"deployment_id"`
+	ReturnURL    string `json:"return_url"`
+	Data         string `json:"data,omitempty"`
+	Multiple     bool   `json:"multiple,omitempty"`
+	UserID       int64  `json:"user_id"`
+	Exp          int64  `json:"exp"`
+}
+
+// NewSelection starts the deep linking of a launch
+func NewSelection(claims *Claims, p *models.LTIPlatform, userID int64) Selection {
+	return Selection{
+		PlatformID:   p.KeyID,
+		DeploymentID: claims.DeploymentID,
+		ReturnURL:    claims.DeepLinking.ReturnURL,
+		Data:         claims.DeepLinking.Data,
+		Multiple:     claims.DeepLinking.AcceptMultiple,
+		UserID:       userID,
+		Exp:          time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// Seal signs a selection
+func (t *Tool) Seal(s Selection) (string, error) {
+	return Sign(s, t.key, t.keyID)
+}
+
+// Open verifies a sealed selection
+func (t *Tool) Open(token string) (Selection, error) {
+	var s Selection
+	if err := Verify(token, t.ownKey, &s); err != nil {
+		return s, errors.New("invalid or expired selection")
+	}
+	return s, nil
+}
+
+// deepLinkingResponse is the JWT a tool posts back to the platform
+type deepLinkingResponse struct {
+	Issuer       string     `json:"iss"`
+	Audience     Audience   `json:"aud"`
+	Exp          int64      `json:"exp"`
+	Iat          int64      `json:"iat"`
+	Nonce        string     `json:"nonce"`
+	MessageType  string     `json:"https://purl.imsglobal.org/spec/lti/claim/message_type"`
+	Version      string     `json:"https://purl.imsglobal.org/spec/lti/claim/version"`
+	DeploymentID string     `json:"https://purl.imsglobal.org/spec/lti/claim/deployment_id"`
+	ContentItems []LinkItem `json:"https://purl.imsglobal.org/spec/lti-dl/claim/content_items"`
+	Data         string     `json:"https://purl.imsglobal.org/spec/lti-dl/claim/data,omitempty"`
+}
+
+// DeepLinkingResponse returns the signed JWT which returns items to the
+// platform; it is posted by the browser to the selection's ReturnURL as "JWT"
+func (t *Tool) DeepLinkingResponse(p *models.LTIPlatform, s Selection, items []LinkItem) (string, error) {
+	now := time.Now()
+	for i := range items {
+		items[i].Type = "ltiResourceLink"
+	}
+	return Sign(deepLinkingResponse{
+		Issuer:       p.ClientID,
+		Audience:     Audience{p.Issuer},
+		Exp:          now.Add(5 * time.Minute).Unix(),
+		Iat:          now.Unix(),
+		Nonce:        randomString(16),
+		MessageType:  DeepLinkingReply,
+		Version:      LTIVersion,
+		DeploymentID: s.DeploymentID,
+		ContentItems: items,
+		Data:         s.Data,
+	}, t.key, t.keyID)
+}
@@ -0,0 +1,24 @@
+package lti
+
+import (
+	"html/template"
+	"net/http"
+)
+
+var autoPostPage = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.Action}}">
+{{range $name, $value := .Fields}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body></html>
+`))
+
+// AutoPost answers with a page which posts fields to action as soon as it
+// loads, the way LTI messages travel through the browser
+func AutoPost(w http.ResponseWriter, title, action string, fields map[string]string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	autoPostPage.Execute(w, map[string]interface{}{"Title": title, "Action": action, "Fields": fields})
+}
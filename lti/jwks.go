@@ -0,0 +1,165 @@
+package lti
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// JWK is an RSA public key as a JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWK describes pub as a signing key with ID kid
+func PublicJWK(pub *rsa.PublicKey, kid string) JWK {
+	return JWK{
+		Kty: "RSA",
+		Alg: "RS256",
+		Use: "sig",
+		Kid: kid,
+		N:   encode(pub.N.Bytes()),
+		E:   encode(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+// PublicKey converts an RSA JWK back into a key
+func (k JWK) PublicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, fmt.Errorf("key %s is not an RSA key", k.Kid)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: invalid modulus", k.Kid)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil || len(e) > 4 {
+		return nil, fmt.Errorf("key %s: invalid exponent", k.Kid)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// KeyID derives a key ID from the key itself (its RFC 7638 thumbprint)
+func KeyID(pub *rsa.PublicKey) string {
+	jwk := PublicJWK(pub, "")
+	sum := sha256.Sum256([]byte(`{"e":"` + jwk.E + `","kty":"RSA","n":"` + jwk.N + `"}`))
+	return encode(sum[:])
+}
+
+// LoadKey reads a PEM RSA private key (PKCS #1 or #8)
+func LoadKey(file string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// KeySet fetches a platform's JWKS and keeps it, fetching it again when a
+// token names a key it does not have (but at most once a minute)
+type KeySet struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// NewKeySet ..
+func NewKeySet(url string, client *http.Client) *KeySet {
+	return &KeySet{url: url, client: client}
+}
+
+// Key returns the key with ID kid; with kid "", the set must have only one
+func (s *KeySet) Key(kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.find(kid); ok {
+		return key, nil
+	}
+	if time.Since(s.fetched) < time.Minute {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if err := s.fetch(); err != nil {
+		return nil, err
+	}
+	if key, ok := s.find(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func (s *KeySet) find(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+func (s *KeySet) fetch() error {
+	s.fetched = time.Now()
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return fmt.Errorf("fetching the platform's keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching the platform's keys: %s", resp.Status)
+	}
+	var set JWKS
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return fmt.Errorf("invalid key set: %w", err)
+	}
+
+	s.keys = map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.PublicKey(); err == nil {
+			s.keys[jwk.Kid] = key
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package lti
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// unsigned builds a token with any header and an empty signature
+func unsigned(t *testing.T, h header, claims interface{}) string {
+	t.Helper()
+	hb, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encode(hb) + "." + encode(cb) + "."
+}
+
+func TestVerify(t *testing.T) {
+	key, other := generateKey(t), generateKey(t)
+	keys := func(kid string) (*rsa.PublicKey, error) {
+		if kid != "k1" {
+			return nil, errors.New("unknown key")
+		}
+		return &key.PublicKey, nil
+	}
+	now := time.Now()
+	sign := func(claims map[string]interface{}, key *rsa.PrivateKey, kid string) string {
+		token, err := Sign(claims, key, kid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{"sub": "42", "exp": now.Add(time.Hour).Unix(), "iat": now.Unix()}
+	}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := valid()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "valid", token: sign(valid(), key, "k1")},
+		{name: "expired within the leeway", token: sign(with("exp", now.Add(-30*time.Second).Unix()), key, "k1")},
+		{name: "not before within the leeway", token: sign(with("nbf", now.Add(30*time.Second).Unix()), key, "k1")},
+		{name: "expired", token: sign(with("exp", now.Add(-2*time.Minute).Unix()), key, "k1"), wantErr: "token expired"},
+		{name: "no expiry", token: sign(with("exp", nil), key, "k1"), wantErr: "token expired"},
+		{name: "not yet valid", token: sign(with("nbf", now.Add(time.Hour).Unix()), key, "k1"), wantErr: "token not yet valid"},
+		{name: "issued in the future", token: sign(with("iat", now.Add(time.Hour).Unix()), key, "k1"), wantErr: "token issued in the future"},
+		{name: "signed with another key", token: sign(valid(), other, "k1"), wantErr: "invalid signature"},
+		{name: "unknown key", token: sign(valid(), key, "k2"), wantErr: "unknown key"},
+		{name: "no algorithm", token: unsigned(t, header{Alg: "none", Kid: "k1"}, valid()), wantErr: "unsupported algorithm"},
+		{name: "symmetric algorithm", token: unsigned(t, header{Alg: "HS256", Kid: "k1"}, valid()), wantErr: "unsupported algorithm"},
+		{name: "two parts", token: "a.b", wantErr: "malformed token"},
+		{name: "header not base64", token: "!!!.e30.", wantErr: "malformed token"},
+		{
+			name: "claims swapped after signing",
+			token: func() string {
+				parts := strings.Split(sign(valid(), key, "k1"), ".")
+				forged := strings.Split(sign(with("sub", "1"), key, "k1"), ".")
+				return parts[0] + "." + forged[1] + "." + parts[2]
+			}(),
+			wantErr: "invalid signature",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var claims struct {
+				Subject string `json:"sub"`
+			}
+			err := Verify(test.token, keys, &claims)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				if claims.Subject != "42" {
+					t.Errorf("got sub %q, want 42", claims.Subject)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestAudience(t *testing.T) {
+	tests := []struct {
+		json string
+		want Audience
+	}{
+		{json: `"client-1"`, want: Audience{"client-1"}},
+		{json: `["client-1","client-2"]`, want: Audience{"client-1", "client-2"}},
+	}
+	for _, test := range tests {
+		var aud Audience
+		if err := json.Unmarshal([]byte(test.json), &aud); err != nil {
+			t.Fatalf("unmarshal %s: %v", test.json, err)
+		}
+		if !aud.Contains("client-1") || aud.Contains("client-3") || len(aud) != len(test.want) {
+			t.Errorf("%s: got %q, want %q", test.json, aud, test.want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package lti
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"restAPI/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockPlatform is an in-memory LTI 1.3 platform for development and testing.
+// Its home page launches the tool as a learner or an instructor, deep links
+// modules into it and shows the scores the tool posts back.
+type MockPlatform struct {
+	// BaseURL is the platform's issuer, e.g. http://localhost:8002
+	BaseURL      string
+	ClientID     string
+	DeploymentID string
+
+	tool    *Tool
+	key     *rsa.PrivateKey
+	keyID   string
+	toolKey *KeySet
+
+	mu     sync.Mutex
+	links  []mockLink
+	scores []Score
+	tokens map[string]time.Time
+}
+
+type mockLink struct {
+	ID       string            `json:"id"`
+	Title    string            `json:"title"`
+	Custom   map[string]string `json:"custom,omitempty"`
+	LineItem string            `json:"lineitem,omitempty"`
+}
+
+type mockUser struct {
+	Subject, Given, Family string
+	Roles                  []string
+}
+
+var mockUsers = map[string]mockUser{
+	"learner":    {"mock-learner", "Lee", "Learner", []string{"http://purl.imsglobal.org/vocab/lis/v2/membership#Learner"}},
+	"instructor": {"mock-instructor", "Ida", "Instructor", []string{"http://purl.imsglobal.org/vocab/lis/v2/membership#Instructor"}},
+}
+
+// NewMockPlatform creates a platform at baseURL which launches tool
+func NewMockPlatform(baseURL string, tool *Tool) (*MockPlatform, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &MockPlatform{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		ClientID:     "mock-tool",
+		DeploymentID: "mock-deployment",
+		tool:         tool,
+		key:          key,
+		keyID:        KeyID(&key.PublicKey),
+		toolKey:      NewKeySet(tool.KeySetURL(), tool.client),
+		tokens:       map[string]time.Time{},
+	}, nil
+}
+
+// Registration is how the tool must register the platform
+func (m *MockPlatform) Registration() models.LTIPlatform {
+	return models.LTIPlatform{
+		Name:          "Mock platform",
+		Issuer:        m.BaseURL,
+		ClientID:      m.ClientID,
+		DeploymentIDs: []string{m.DeploymentID},
+		AuthLoginURL:  m.BaseURL + "/auth",
+		AuthTokenURL:  m.BaseURL + "/token",
+		KeySetURL:     m.BaseURL + "/jwks",
+	}
+}
+
+func (m *MockPlatform) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/":
+		m.home(w, r)
+	case r.URL.Path == "/launch":
+		m.launch(w, r)
+	case r.URL.Path == "/auth":
+		m.authorize(w, r)
+	case r.URL.Path == "/jwks":
+		writeJSON(w, http.StatusOK, JWKS{Keys: []JWK{PublicJWK(&m.key.PublicKey, m.keyID)}})
+	case r.URL.Path == "/token" && r.Method == http.MethodPost:
+		m.token(w, r)
+	case r.URL.Path == "/deep_link_return" && r.Method == http.MethodPost:
+		m.deepLinkReturn(w, r)
+	case strings.HasPrefix(r.URL.Path, "/lineitems/") && strings.HasSuffix(r.URL.Path, "/scores") && r.Method == http.MethodPost:
+		m.score(w, r)
+	case r.URL.Path == "/scores":
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		writeJSON(w, http.StatusOK, append([]Score{}, m.scores...))
+	case r.URL.Path == "/links":
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		writeJSON(w, http.StatusOK, append([]mockLink{}, m.links...))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+var mockHome = template.Must(template.New("home").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Mock LTI platform</title></head>
+<body>
+<h1>Mock LTI platform</h1>
+<p><a href="/launch?user=instructor&amp;deep_link=1">Add modules (deep linking, as instructor)</a></p>
+<h2>Links</h2>
+<ul>{{range .Links}}
+<li>{{.Title}}: <a href="/launch?user=learner&amp;link={{.ID}}">launch as learner</a>,
+<a href="/launch?user=instructor&amp;link={{.ID}}">launch as instructor</a>{{if .LineItem}} (graded){{end}}</li>
+{{else}}<li>None yet</li>{{end}}</ul>
+<h2>Scores</h2>
+<ul>{{range .Scores}}<li>{{.UserID}}: {{.ScoreGiven}}/{{.ScoreMaximum}} {{.ActivityProgress}} {{.GradingProgress}} {{.Timestamp}}</li>
+{{else}}<li>None yet</li>{{end}}</ul>
+</body></html>
+`))
+
+func (m *MockPlatform) home(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	data := map[string]interface{}{"Links": append([]mockLink{}, m.links...), "Scores": append([]Score{}, m.scores...)}
+	m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	mockHome.Execute(w, data)
+}
+
+// launch starts a third-party initiated login at the tool
+func (m *MockPlatform) launch(w http.ResponseWriter, r *http.Request) {
+	user := r.FormValue("user")
+	if _, ok := mockUsers[user]; !ok {
+		http.Error(w, "user must be learner or instructor", http.StatusBadRequest)
+		return
+	}
+	hint := url.Values{"link": {r.FormValue("link")}, "deep_link": {r.FormValue("deep_link")}}
+	login := url.Values{
+		"iss":               {m.BaseURL},
+		"login_hint":        {user},
+		"target_link_uri":   {m.tool.LaunchURL()},
+		"lti_message_hint":  {hint.Encode()},
+		"client_id":         {m.ClientID},
+		"lti_deployment_id": {m.DeploymentID},
+	}
+	http.Redirect(w, r, m.tool.LoginURL()+"?"+login.Encode(), http.StatusFound)
+}
+
+// authorize is the OIDC authorization endpoint: it answers the tool's
+// authentication request with an id_token for the launch
+func (m *MockPlatform) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	q := r.Form
+	switch {
+	case q.Get("scope") != "openid" || q.Get("response_type") != "id_token" || q.Get("response_mode") != "form_post":
+		http.Error(w, "invalid authentication request", http.StatusBadRequest)
+		return
+	case q.Get("client_id") != m.ClientID:
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	case q.Get("redirect_uri") != m.tool.LaunchURL():
+		http.Error(w, "unregistered redirect_uri", http.StatusBadRequest)
+		return
+	case q.Get("nonce") == "":
+		http.Error(w, "nonce is required", http.StatusBadRequest)
+		return
+	}
+	user, ok := mockUsers[q.Get("login_hint")]
+	if !ok {
+		http.Error(w, "unknown login_hint", http.StatusBadRequest)
+		return
+	}
+	hint, _ := url.ParseQuery(q.Get("lti_message_hint"))
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":                           m.BaseURL,
+		"sub":                           user.Subject,
+		"aud":                           m.ClientID,
+		"exp":                           now.Add(5 * time.Minute).Unix(),
+		"iat":                           now.Unix(),
+		"nonce":                         q.Get("nonce"),
+		"name":                          user.Given + " " + user.Family,
+		"given_name":                    user.Given,
+		"family_name":                   user.Family,
+		"email":                         user.Subject + "@mock.example",
+		ClaimPrefix + "version":         LTIVersion,
+		ClaimPrefix + "deployment_id":   m.DeploymentID,
+		ClaimPrefix + "target_link_uri": m.tool.LaunchURL(),
+		ClaimPrefix + "roles":           user.Roles,
+		ClaimPrefix + "context":         Context{ID: "mock-course", Label: "MOCK101", Title: "Mock course"},
+	}
+
+	if hint.Get("deep_link") != "" {
+		claims[ClaimPrefix+"message_type"] = DeepLinkingLaunch
+		claims["https://purl.imsglobal.org/spec/lti-dl/claim/deep_linking_settings"] = DeepLinkingSettings{
+			ReturnURL:      m.BaseURL + "/deep_link_return",
+			AcceptTypes:    []string{"ltiResourceLink"},
+			AcceptMultiple: true,
+			Data:           "mock-" + randomString(6),
+		}
+	} else {
+		link, ok := m.link(hint.Get("link"))
+		if !ok {
+			http.Error(w, "unknown link", http.StatusBadRequest)
+			return
+		}
+		claims[ClaimPrefix+"message_type"] = ResourceLinkLaunch
+		claims[ClaimPrefix+"resource_link"] = ResourceLink{ID: link.ID, Title: link.Title}
+		claims[ClaimPrefix+"custom"] = link.Custom
+		if link.LineItem != "" {
+			claims["https://purl.imsglobal.org/spec/lti-ags/claim/endpoint"] = Endpoint{
+				Scope:     []string{ScoreScope},
+				LineItems: m.BaseURL + "/lineitems",
+				LineItem:  link.LineItem,
+			}
+		}
+	}
+
+	idToken, err := Sign(claims, m.key, m.keyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	AutoPost(w, "Launching", q.Get("redirect_uri"), map[string]string{"id_token": idToken, "state": q.Get("state")})
+}
+
+func (m *MockPlatform) link(id string) (mockLink, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, link := range m.links {
+		if link.ID == id {
+			return link, true
+		}
+	}
+	return mockLink{}, false
+}
+
+// token grants access tokens to the tool, authenticated by its signed assertion
+func (m *MockPlatform) token(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("grant_type") != "client_credentials" ||
+		r.FormValue("client_assertion_type") != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	var assertion clientAssertion
+	if err := Verify(r.FormValue("client_assertion"), m.toolKey.Key, &assertion); err != nil ||
+		assertion.Issuer != m.ClientID || !assertion.Audience.Contains(m.BaseURL+"/token") {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	token := randomString(24)
+	m.mu.Lock()
+	m.tokens[token] = time.Now().Add(time.Hour)
+	m.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"scope":        r.FormValue("scope"),
+	})
+}
+
+// score records a score posted to a line item
+func (m *MockPlatform) score(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	m.mu.Lock()
+	expires, ok := m.tokens[token]
+	m.mu.Unlock()
+	if !ok || time.Now().After(expires) {
+		http.Error(w, "invalid access token", http.StatusUnauthorized)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/vnd.ims.lis.v1.score+json") {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	var score Score
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&score); err != nil || score.UserID == "" {
+		http.Error(w, "invalid score", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	m.scores = append(m.scores, score)
+	m.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deepLinkReturn receives the links the tool returns and places them
+func (m *MockPlatform) deepLinkReturn(w http.ResponseWriter, r *http.Request) {
+	var response deepLinkingResponse
+	if err := Verify(r.FormValue("JWT"), m.toolKey.Key, &response); err != nil {
+		http.Error(w, "invalid deep linking response: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if response.Issuer != m.ClientID || !response.Audience.Contains(m.BaseURL) || response.MessageType != DeepLinkingReply {
+		http.Error(w, "invalid deep linking response", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	for _, item := range response.ContentItems {
+		link := mockLink{ID: fmt.Sprintf("link-%d", len(m.links)+1), Title: item.Title, Custom: item.Custom}
+		if item.LineItem != nil {
+			link.LineItem = m.BaseURL + "/lineitems/" + link.ID
+		}
+		m.links = append(m.links, link)
+	}
+	m.mu.Unlock()
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
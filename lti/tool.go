@@ -0,0 +1,322 @@
+package lti
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"restAPI/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LTI claims and values
+const (
+	ClaimPrefix        = "https://purl.imsglobal.org/spec/lti/claim/"
+	LTIVersion         = "1.3.0"
+	ResourceLinkLaunch = "LtiResourceLinkRequest"
+	DeepLinkingLaunch  = "LtiDeepLinkingRequest"
+	DeepLinkingReply   = "LtiDeepLinkingResponse"
+)
+
+// how long a login may take, from initiation to launch
+const loginTimeout = 10 * time.Minute
+
+// Tool is this application as an LTI tool: the key it signs with and the
+// URLs platforms are configured with
+type Tool struct {
+	key   *rsa.PrivateKey
+	keyID string
+	// BaseURL is where platforms reach this application, e.g. https://courses.example.com
+	BaseURL string
+
+	client *http.Client
+
+	mu      sync.Mutex
+	keySets map[string]*KeySet
+	nonces  map[string]time.Time
+	tokens  map[string]accessToken
+}
+
+// NewTool ..
+func NewTool(key *rsa.PrivateKey, baseURL string) *Tool {
+	return &Tool{
+		key:     key,
+		keyID:   KeyID(&key.PublicKey),
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		keySets: map[string]*KeySet{},
+		nonces:  map[string]time.Time{},
+		tokens:  map[string]accessToken{},
+	}
+}
+
+// URLs of the tool, for registering it with a platform
+func (t *Tool) LoginURL() string  { return t.BaseURL + "/lti/login" }
+func (t *Tool) LaunchURL() string { return t.BaseURL + "/lti/launch" }
+func (t *Tool) KeySetURL() string { return t.BaseURL + "/lti/jwks" }
+
+// JWKS is the tool's public key set, which platforms verify its messages with
+func (t *Tool) JWKS() JWKS {
+	return JWKS{Keys: []JWK{PublicJWK(&t.key.PublicKey, t.keyID)}}
+}
+
+// Login is a third-party initiated login, the first step of a launch
+type Login struct {
+	Issuer         string
+	LoginHint      string
+	TargetLinkURI  string
+	LTIMessageHint string
+	ClientID       string
+	DeploymentID   string
+}
+
+// ParseLogin reads the login initiation parameters a platform sends (as a
+// query or a form)
+func ParseLogin(r *http.Request) (Login, error) {
+	login := Login{
+		Issuer:         r.FormValue("iss"),
+		LoginHint:      r.FormValue("login_hint"),
+		TargetLinkURI:  r.FormValue("target_link_uri"),
+		LTIMessageHint: r.FormValue("lti_message_hint"),
+		ClientID:       r.FormValue("client_id"),
+		DeploymentID:   r.FormValue("lti_deployment_id"),
+	}
+	if login.Issuer == "" || login.LoginHint == "" {
+		return login, errors.New("iss and login_hint are required")
+	}
+	return login, nil
+}
+
+// state is signed by the tool and passed through the platform's
+// authorization request, so that no server-side session is needed
+type state struct {
+	Issuer   string `json:"iss"`
+	Platform string `json:"platform"`
+	ClientID string `json:"client_id"`
+	Nonce    string `json:"nonce"`
+	Exp      int64  `json:"exp"`
+	Iat      int64  `json:"iat"`
+}
+
+// AuthRedirect returns the platform's authorization URL which the browser is
+// sent to; the platform answers by posting an id_token to the launch URL
+func (t *Tool) AuthRedirect(p *models.LTIPlatform, login Login) (string, error) {
+	now := time.Now()
+	st := state{
+		Issuer:   t.BaseURL,
+		Platform: p.Issuer,
+		ClientID: p.ClientID,
+		Nonce:    randomString(24),
+		Exp:      now.Add(loginTimeout).Unix(),
+		Iat:      now.Unix(),
+	}
+	signed, err := Sign(st, t.key, t.keyID)
+	if err != nil {
+		return "", err
+	}
+
+	auth, err := url.Parse(p.AuthLoginURL)
+	if err != nil {
+		return "", err
+	}
+	query := auth.Query()
+	query.Set("scope", "openid")
+	query.Set("response_type", "id_token")
+	query.Set("response_mode", "form_post")
+	query.Set("prompt", "none")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", t.LaunchURL())
+	query.Set("login_hint", login.LoginHint)
+	query.Set("state", signed)
+	query.Set("nonce", st.Nonce)
+	if login.LTIMessageHint != "" {
+		query.Set("lti_message_hint", login.LTIMessageHint)
+	}
+	auth.RawQuery = query.Encode()
+	return auth.String(), nil
+}
+
+// Claims are the claims of a launch's id_token which the application uses
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        Audience `json:"aud"`
+	AuthorizedParty string   `json:"azp,omitempty"`
+	Nonce           string   `json:"nonce"`
+
+	Name       string `json:"name,omitempty"`
+	GivenName  string `json:"given_name,omitempty"`
+	FamilyName string `json:"family_name,omitempty"`
+	Email      string `json:"email,omitempty"`
+
+	MessageType   string            `json:"https://purl.imsglobal.org/spec/lti/claim/message_type"`
+	Version       string            `json:"https://purl.imsglobal.org/spec/lti/claim/version"`
+	DeploymentID  string            `json:"https://purl.imsglobal.org/spec/lti/claim/deployment_id"`
+	TargetLinkURI string            `json:"https://purl.imsglobal.org/spec/lti/claim/target_link_uri"`
+	ResourceLink  *ResourceLink     `json:"https://purl.imsglobal.org/spec/lti/claim/resource_link,omitempty"`
+	Roles         []string          `json:"https://purl.imsglobal.org/spec/lti/claim/roles"`
+	Context       *Context          `json:"https://purl.imsglobal.org/spec/lti/claim/context,omitempty"`
+	Custom        map[string]string `json:"https://purl.imsglobal.org/spec/lti/claim/custom,omitempty"`
+
+	Endpoint    *Endpoint            `json:"https://purl.imsglobal.org/spec/lti-ags/claim/endpoint,omitempty"`
+	DeepLinking *DeepLinkingSettings `json:"https://purl.imsglobal.org/spec/lti-dl/claim/deep_linking_settings,omitempty"`
+}
+
+// ResourceLink is the link on the platform which was launched
+type ResourceLink struct {
+	ID    string `json:"id"`
+	Title string `json:"title,omitempty"`
+}
+
+// Context is the platform's course the launch came from
+type Context struct {
+	ID    string `json:"id"`
+	Label string `json:"label,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// Endpoint is the Assignment and Grade Services claim
+type Endpoint struct {
+	Scope     []string `json:"scope"`
+	LineItems string   `json:"lineitems,omitempty"`
+	LineItem  string   `json:"lineitem,omitempty"`
+}
+
+// DeepLinkingSettings say where to return deep linking content items
+type DeepLinkingSettings struct {
+	ReturnURL      string   `json:"deep_link_return_url"`
+	AcceptTypes    []string `json:"accept_types"`
+	AcceptMultiple bool     `json:"accept_multiple,omitempty"`
+	Data           string   `json:"data,omitempty"`
+}
+
+// role URIs which make a launch an instructor's; anything else is a learner's
+var instructorRoles = []string{
+	"http://purl.imsglobal.org/vocab/lis/v2/membership#Instructor",
+	"http://purl.imsglobal.org/vocab/lis/v2/membership#ContentDeveloper",
+	"http://purl.imsglobal.org/vocab/lis/v2/membership#Administrator",
+	"http://purl.imsglobal.org/vocab/lis/v2/institution/person#Administrator",
+	"http://purl.imsglobal.org/vocab/lis/v2/system/person#Administrator",
+}
+
+// Instructor reports whether the user launched in an instructor role
+func (c *Claims) Instructor() bool {
+	for _, role := range c.Roles {
+		for _, instructor := range instructorRoles {
+			// membership roles may have a sub-role, e.g. Instructor#TeachingAssistant
+			if role == instructor || strings.HasPrefix(role, instructor+"#") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Launch validates the id_token and state a platform posted to the launch
+// URL. platform finds the registration of an issuer and client ID.
+func (t *Tool) Launch(r *http.Request, platform func(issuer, clientID string) (*models.LTIPlatform, error)) (*Claims, *models.LTIPlatform, error) {
+	if errMessage := r.FormValue("error"); errMessage != "" {
+		return nil, nil, fmt.Errorf("the platform refused the launch: %s %s", errMessage, r.FormValue("error_description"))
+	}
+	idToken, signedState := r.FormValue("id_token"), r.FormValue("state")
+	if idToken == "" || signedState == "" {
+		return nil, nil, errors.New("id_token and state are required")
+	}
+
+	var st state
+	if err := Verify(signedState, t.ownKey, &st); err != nil {
+		return nil, nil, fmt.Errorf("invalid state: %w", err)
+	}
+	if st.Issuer != t.BaseURL {
+		return nil, nil, errors.New("invalid state")
+	}
+
+	var unverified Claims
+	if err := Unverified(idToken, &unverified); err != nil {
+		return nil, nil, err
+	}
+	if unverified.Issuer != st.Platform {
+		return nil, nil, errors.New("the id_token is not from the platform the login started with")
+	}
+	p, err := platform(st.Platform, st.ClientID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unknown platform %s", st.Platform)
+	}
+
+	var claims Claims
+	if err := Verify(idToken, t.keySet(p.KeySetURL).Key, &claims); err != nil {
+		return nil, nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	switch {
+	case claims.Issuer != p.Issuer:
+		return nil, nil, errors.New("invalid issuer")
+	case !claims.Audience.Contains(p.ClientID):
+		return nil, nil, errors.New("the id_token is for another client")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID:
+		return nil, nil, errors.New("invalid authorized party")
+	case claims.Nonce != st.Nonce:
+		return nil, nil, errors.New("invalid nonce")
+	case claims.Version != LTIVersion:
+		return nil, nil, fmt.Errorf("unsupported LTI version %q", claims.Version)
+	case !p.AllowsDeployment(claims.DeploymentID):
+		return nil, nil, fmt.Errorf("unknown deployment %q", claims.DeploymentID)
+	case claims.Subject == "":
+		return nil, nil, errors.New("anonymous launches are not supported")
+	}
+	switch claims.MessageType {
+	case ResourceLinkLaunch:
+		if claims.ResourceLink == nil || claims.ResourceLink.ID == "" {
+			return nil, nil, errors.New("the launch has no resource link")
+		}
+	case DeepLinkingLaunch:
+		if claims.DeepLinking == nil || claims.DeepLinking.ReturnURL == "" {
+			return nil, nil, errors.New("the launch has no deep linking settings")
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported message type %q", claims.MessageType)
+	}
+
+	// each login launches once
+	if !t.useNonce(st.Nonce, time.Unix(st.Exp, 0)) {
+		return nil, nil, errors.New("the launch was already used")
+	}
+	return &claims, p, nil
+}
+
+func (t *Tool) ownKey(kid string) (*rsa.PublicKey, error) {
+	if kid != t.keyID {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return &t.key.PublicKey, nil
+}
+
+func (t *Tool) keySet(url string) *KeySet {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	set, ok := t.keySets[url]
+	if !ok {
+		set = NewKeySet(url, t.client)
+		t.keySets[url] = set
+	}
+	return set
+}
+
+// useNonce records a nonce until it expires, reporting false if it was already used
+func (t *Tool) useNonce(nonce string, expires time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for n, exp := range t.nonces {
+		if exp.Before(now) {
+			delete(t.nonces, n)
+		}
+	}
+	if _, used := t.nonces[nonce]; used {
+		return false
+	}
+	t.nonces[nonce] = expires.Add(leeway)
+	return true
+}
@@ -0,0 +1,238 @@
+package lti
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"restAPI/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// launchFixture is a tool and a platform registered with it, whose keys
+// are served by a test server
+type launchFixture struct {
+	tool     *Tool
+	platform *models.LTIPlatform
+	sign     func(claims map[string]interface{}) string
+}
+
+func newLaunchFixture(t *testing.T) *launchFixture {
+	platformKey := generateKey(t)
+	keys := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JWKS{Keys: []JWK{PublicJWK(&platformKey.PublicKey, "p1")}})
+	}))
+	t.Cleanup(keys.Close)
+
+	return &launchFixture{
+		tool: NewTool(generateKey(t), "https://tool.example"),
+		platform: &models.LTIPlatform{
+			Issuer:        "https://platform.example",
+			ClientID:      "client-1",
+			DeploymentIDs: []string{"deployment-1"},
+			AuthLoginURL:  "https://platform.example/auth",
+			KeySetURL:     keys.URL,
+		},
+		sign: func(claims map[string]interface{}) string {
+			token, err := Sign(claims, platformKey, "p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return token
+		},
+	}
+}
+
+// login starts a login, returning the state and nonce the platform is sent
+func (f *launchFixture) login(t *testing.T) (string, string) {
+	t.Helper()
+	redirect, err := f.tool.AuthRedirect(f.platform, Login{Issuer: f.platform.Issuer, LoginHint: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("state"), u.Query().Get("nonce")
+}
+
+// claims are those of a valid resource link launch
+func (f *launchFixture) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                         f.platform.Issuer,
+		"sub":                         "user-1",
+		"aud":                         f.platform.ClientID,
+		"nonce":                       nonce,
+		"exp":                         now.Add(5 * time.Minute).Unix(),
+		"iat":                         now.Unix(),
+		ClaimPrefix + "message_type":  ResourceLinkLaunch,
+		ClaimPrefix + "version":       LTIVersion,
+		ClaimPrefix + "deployment_id": "deployment-1",
+		ClaimPrefix + "resource_link": map[string]string{"id": "link-1"},
+	}
+}
+
+func (f *launchFixture) launch(form url.Values) (*Claims, error) {
+	r := httptest.NewRequest("POST", "/lti/launch", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	claims, _, err := f.tool.Launch(r, func(issuer, clientID string) (*models.LTIPlatform, error) {
+		if issuer != f.platform.Issuer || clientID != f.platform.ClientID {
+			return nil, errors.New("not registered")
+		}
+		return f.platform, nil
+	})
+	return claims, err
+}
+
+func TestLaunch(t *testing.T) {
+	f := newLaunchFixture(t)
+	otherTool := NewTool(generateKey(t), "https://tool.example")
+
+	tests := []struct {
+		name string
+		// change the id_token's claims, or the whole form
+		claims  func(claims map[string]interface{})
+		form    func(form url.Values)
+		wantErr string
+	}{
+		{name: "valid"},
+		{
+			name:   "several audiences with this client as the authorized party",
+			claims: func(c map[string]interface{}) { c["aud"] = []string{"client-1", "client-2"}; c["azp"] = "client-1" },
+		},
+		{
+			name:    "issuer other than the login's",
+			claims:  func(c map[string]interface{}) { c["iss"] = "https://other.example" },
+			wantErr: "not from the platform the login started with",
+		},
+		{
+			name:    "audience of another client",
+			claims:  func(c map[string]interface{}) { c["aud"] = "client-2" },
+			wantErr: "for another client",
+		},
+		{
+			name:    "several audiences without an authorized party",
+			claims:  func(c map[string]interface{}) { c["aud"] = []string{"client-1", "client-2"} },
+			wantErr: "invalid authorized party",
+		},
+		{
+			name:    "nonce of another login",
+			claims:  func(c map[string]interface{}) { c["nonce"] = "another" },
+			wantErr: "invalid nonce",
+		},
+		{
+			name:    "expired id_token",
+			claims:  func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			wantErr: "token expired",
+		},
+		{
+			name:    "other LTI version",
+			claims:  func(c map[string]interface{}) { c[ClaimPrefix+"version"] = "1.1" },
+			wantErr: "unsupported LTI version",
+		},
+		{
+			name:    "unknown deployment",
+			claims:  func(c map[string]interface{}) { c[ClaimPrefix+"deployment_id"] = "deployment-2" },
+			wantErr: "unknown deployment",
+		},
+		{
+			name:    "anonymous",
+			claims:  func(c map[string]interface{}) { delete(c, "sub") },
+			wantErr: "anonymous launches",
+		},
+		{
+			name:    "no resource link",
+			claims:  func(c map[string]interface{}) { delete(c, ClaimPrefix+"resource_link") },
+			wantErr: "no resource link",
+		},
+		{
+			name:    "deep linking without settings",
+			claims:  func(c map[string]interface{}) { c[ClaimPrefix+"message_type"] = DeepLinkingLaunch },
+			wantErr: "no deep linking settings",
+		},
+		{
+			name:    "unknown message type",
+			claims:  func(c map[string]interface{}) { c[ClaimPrefix+"message_type"] = "LtiSubmissionReviewRequest" },
+			wantErr: "unsupported message type",
+		},
+		{
+			name: "id_token not signed by the platform",
+			form: func(form url.Values) {
+				token, _ := Sign(f.claims(""), otherTool.key, "p1")
+				form.Set("id_token", token)
+			},
+			wantErr: "invalid signature",
+		},
+		{
+			name: "state of another tool",
+			form: func(form url.Values) {
+				redirect, _ := otherTool.AuthRedirect(f.platform, Login{Issuer: f.platform.Issuer, LoginHint: "user-1"})
+				u, _ := url.Parse(redirect)
+				form.Set("state", u.Query().Get("state"))
+			},
+			wantErr: "invalid state",
+		},
+		{
+			name:    "no state",
+			form:    func(form url.Values) { form.Del("state") },
+			wantErr: "id_token and state are required",
+		},
+		{
+			name:    "refused by the platform",
+			form:    func(form url.Values) { form.Set("error", "login_required") },
+			wantErr: "refused the launch",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, nonce := f.login(t)
+			claims := f.claims(nonce)
+			if test.claims != nil {
+				test.claims(claims)
+			}
+			form := url.Values{"id_token": {f.sign(claims)}, "state": {state}}
+			if test.form != nil {
+				test.form(form)
+			}
+
+			got, err := f.launch(form)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Launch: %v", err)
+				}
+				if got.Subject != "user-1" || got.ResourceLink.ID != "link-1" {
+					t.Errorf("got %+v", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestLaunchReplay(t *testing.T) {
+	f := newLaunchFixture(t)
+	state, nonce := f.login(t)
+	form := url.Values{"id_token": {f.sign(f.claims(nonce))}, "state": {state}}
+
+	if _, err := f.launch(form); err != nil {
+		t.Fatalf("first launch: %v", err)
+	}
+	if _, err := f.launch(form); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("replayed launch: got error %v, want it refused", err)
+	}
+
+	// a new login launches again
+	state, nonce = f.login(t)
+	if _, err := f.launch(url.Values{"id_token": {f.sign(f.claims(nonce))}, "state": {state}}); err != nil {
+		t.Errorf("launch after a new login: %v", err)
+	}
+}
@@ -9,56 +9,97 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"restAPI/config"
+	"restAPI/controllers"
 	"restAPI/routes"
+	"restAPI/xapi"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/datastore"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
 	"google.golang.org/api/option"
 )
 
 // main function
 func main() {
 
-	err := godotenv.Load()
+	cfg, err := config.Load()
 	if err != nil {
-		log.Fatal("Error loading .env file: " + err.Error())
+		log.Fatal(err)
 	}
 
-	projID := os.Getenv("DATASTORE_PROJECT_ID")
-	if projID == "" {
-		log.Fatal(`You need to set the environment variable "DATASTORE_PROJECT_ID"`)
-	}
-
-	jsonPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-	if jsonPath == "" {
-		log.Fatal(`You need to set the environment variable "GOOGLE_APPLICATION_CREDENTIALS"`)
-	}
+	// command-line flag will override environment variable
+	flag.StringVar(&cfg.StaticDir, "dir", cfg.StaticDir, "the directory to serve files from")
+	flag.Parse()
 
 	ctx := context.Background()
-	client, err := datastore.NewClient(ctx, projID, option.WithCredentialsFile(jsonPath))
+	client, err := datastore.NewClient(ctx, cfg.DatastoreProjectID, option.WithCredentialsFile(cfg.CredentialsFile))
 	if err != nil {
 		log.Fatalf("Could not create datastore client: %v", err)
 	}
 	defer client.Close()
 
+	if cfg.SSO.Enabled() {
+		controllers.ConfigureSSO(cfg.SSO.RedirectURL, cfg.SSO.ClientID, cfg.SSO.ClientSecret)
+	}
+
+	if cfg.XAPI.StubAddr != "" {
+		log.Printf("Starting the stub LRS on %s", cfg.XAPI.StubAddr)
+		go func() {
+			stub := &http.Server{Addr: cfg.XAPI.StubAddr, Handler: xapi.NewStubLRS(), ReadHeaderTimeout: cfg.ReadHeaderTimeout}
+			log.Fatal(stub.ListenAndServe())
+		}()
+	}
+
 	// create a new router
+	done := make(chan struct{})
 	router := mux.NewRouter()
-	routes.SetupRoutes(router, client, ctx)
+	health := routes.SetupRoutes(router, client, ctx, cfg, done)
+
+	server := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		// uploads and downloads move the deadlines for their own requests
+		ConnContext: controllers.WithConn,
+	}
 
 	// start the server
-	ssl_enabled := os.Getenv("SSL_ENABLED")
-	fmt.Println("Starting the application " + projID + " @ " + time.Now().UTC().Format(time.RFC3339) + " on port " + os.Getenv("PORT") + " with SSL: " + ssl_enabled)
-	if ssl_enabled == "true" {
-		cert := os.Getenv("CERT_FILE")
-		key := os.Getenv("KEY_FILE")
-		log.Fatal(http.ListenAndServeTLS(":"+os.Getenv("PORT"), cert, key, router))
-	} else {
-		log.Fatal(http.ListenAndServe(":"+os.Getenv("PORT"), router))
+	log.Printf("Starting the application %s @ %s on port %s with SSL: %t",
+		cfg.DatastoreProjectID, time.Now().UTC().Format(time.RFC3339), cfg.Port, cfg.SSLEnabled)
+	go func() {
+		var err error
+		if cfg.SSLEnabled {
+			err = server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// on SIGTERM (or Ctrl-C), fail readiness and let in-flight requests finish
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	sig := <-signals
+
+	log.Printf("Received %s, shutting down (waiting up to %s)", sig, cfg.ShutdownTimeout)
+	health.Drain()
+	close(done)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down: %v", err)
 	}
 }
@@ -0,0 +1,226 @@
+// Package metrics keeps counters and histograms in memory and serves them
+// in the Prometheus text exposition format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are latency buckets in seconds, from 5ms to 10s
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector is anything the registry can write out
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry holds the metrics exposed by Handler
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default is the registry used by NewCounterVec, NewHistogramVec and Handler
+var Default = NewRegistry()
+
+func (reg *Registry) register(c collector) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.collectors = append(reg.collectors, c)
+}
+
+// Write writes every metric in the text exposition format
+func (reg *Registry) Write(w io.Writer) {
+	reg.mu.Lock()
+	collectors := append([]collector(nil), reg.collectors...)
+	reg.mu.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves the registry for Prometheus to scrape
+func (reg *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.Write(w)
+	})
+}
+
+// Handler serves the Default registry
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounterVec creates a counter in the Default registry
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: map[string]*counterValue{}}
+	Default.register(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter with the given label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.values[key]
+	if !ok {
+		value = &counterValue{labelValues: labelValues}
+		c.values[key] = value
+	}
+	value.value += v
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		value := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelString(c.labels, value.labelValues, ""), formatFloat(value.value))
+	}
+}
+
+// HistogramVec is a histogram partitioned by label values
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+// NewHistogramVec creates a histogram in the Default registry; buckets are
+// upper bounds in increasing order, and +Inf is implied
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: map[string]*histogramValue{}}
+	Default.register(h)
+	return h
+}
+
+// Observe records v in the histogram with the given label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			value.counts[i]++
+			break
+		}
+	}
+	value.count++
+	value.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.values) {
+		value := h.values[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += value.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, value.labelValues, formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, value.labelValues, "+Inf"), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelString(h.labels, value.labelValues, ""), formatFloat(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelString(h.labels, value.labelValues, ""), value.count)
+	}
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelString formats {name="value",...}, adding le when it is not empty
+func labelString(names []string, values []string, le string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, name+`="`+escapeLabel(value)+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
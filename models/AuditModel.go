@@ -0,0 +1,80 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// AuditEntry records who did what to which entity, and from where.
+// Actor is always the real user; OnBehalfOf is the impersonated user, if any.
+// Before and After hold the JSON of the fields that changed.
+//
+// Entries are append-only and hash-chained: Seq numbers them from 1, and Hash
+// covers the entry's content and the Hash of the previous entry (PrevHash),
+// so that editing or removing an entry breaks the chain from there on.
+type AuditEntry struct {
+	KeyID      int64     `json:"id"` //gorm:"primary_key,autoIncrement"
+	Seq        int64     `json:"seq"`
+	Actor      string    `json:"actor"`
+	OnBehalfOf string    `json:"on_behalf_of,omitempty"`
+	Action     string    `json:"action"`
+	TargetKind string    `json:"target_kind,omitempty"`
+	TargetID   int64     `json:"target_id,omitempty"`
+	Before     string    `json:"before,omitempty" datastore:",noindex"`
+	After      string    `json:"after,omitempty" datastore:",noindex"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	PrevHash   string    `json:"prev_hash" datastore:",noindex"`
+	Hash       string    `json:"hash" datastore:",noindex"`
+}
+
+// ComputeHash returns the hex SHA-256 of the entry's content and PrevHash.
+// KeyID and Hash itself are not covered.
+func (e *AuditEntry) ComputeHash() string {
+	content, _ := json.Marshal(struct {
+		Seq        int64
+		PrevHash   string
+		Actor      string
+		OnBehalfOf string
+		Action     string
+		TargetKind string
+		TargetID   int64
+		Before     string
+		After      string
+		IPAddress  string
+		Timestamp  string
+	}{
+		e.Seq, e.PrevHash, e.Actor, e.OnBehalfOf, e.Action, e.TargetKind, e.TargetID,
+		e.Before, e.After, e.IPAddress, e.Timestamp.UTC().Format(time.RFC3339Nano),
+	})
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditHead is the single entity pointing at the last entry of the chain
+type AuditHead struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash" datastore:",noindex"`
+}
+
+// AuditFilter selects audit entries; zero values match everything
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	TargetKind string
+	TargetID   int64
+	Since      time.Time
+	Until      time.Time
+}
+
+type AuditRepository interface {
+	AppendAuditEntry(entry *AuditEntry) (*datastore.Key, error)
+	GetAllAuditEntries() ([]*AuditEntry, error)
+	GetAuditEntries(filter AuditFilter) ([]*AuditEntry, error)
+	GetAuditHead() (*AuditHead, error)
+}
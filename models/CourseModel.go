@@ -1,21 +1,36 @@
 package models
 
-import "cloud.google.com/go/datastore"
+import (
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
 
 type Course struct {
 	KeyID       int64   `json:"id"` //gorm:"primary_key,autoIncrement"
-	Name        string  `json:"name,omitempty"`
+	Name        string  `json:"name,omitempty" validate:"required,max=200"`
 	HomeContent string  `json:"home_content,omitempty" datastore:",noindex"`
 	Description string  `json:"description,omitempty" datastore:",noindex"`
 	Modules     []int64 `json:"modules,omitempty" datastore:",noindex"`
 	OwnerID     int64   `json:"owner_id,omitempty"`
 	Approved    bool    `json:"approved"`
-	Department  string  `json:"department,omitempty"`
+	Department  string  `json:"department,omitempty" validate:"max=100"`
+}
+
+// Announcement is pushed to everyone following a course; it is not stored
+type Announcement struct {
+	CourseID int64     `json:"course_id"`
+	Title    string    `json:"title" validate:"required,max=200"`
+	Body     string    `json:"body" validate:"required,max=5000"`
+	Author   string    `json:"author"`
+	PostedOn time.Time `json:"posted_on"`
 }
 
 type CourseRepository interface {
 	CreateCourse(Course *Course) (*datastore.Key, error)
 	GetAllCourses() ([]*Course, error)
+	ListCourses(opts QueryOptions) ([]*Course, string, error)
+	CountCourses(opts QueryOptions) (int, error)
 	DeleteCourse(id int64) error
 	GetCourseByID(id int64) (*Course, error)
 	UpdateCourse(id int64, Course *Course) (*datastore.Key, error)
@@ -13,23 +13,28 @@ type Choice struct {
 type Element struct {
 	KeyID         int64    `json:"id"` //gorm:"primary_key,autoIncrement"
 	Text          string   `json:"text,omitempty"`
-	Type          string   `json:"type,omitempty"` //default 'single'
-	ImageLocation string   `json:"image_location,omitempty"`
+	Type          string   `json:"type,omitempty" validate:"oneof=content single multiple text essay project file scorm"` //default 'single'
+	ImageLocation string   `json:"image_location,omitempty" validate:"max=2048"`
 	ImageCaption  string   `json:"image_caption,omitempty"`
 	ImageCredit   string   `json:"image_credit,omitempty"`
-	VideoLocation string   `json:"video_location,omitempty"`
+	VideoLocation string   `json:"video_location,omitempty" validate:"max=2048"`
 	VideoCaption  string   `json:"video_caption,omitempty"`
 	VideoCredit   string   `json:"video_credit,omitempty"`
 	Choices       []Choice `json:"choices,omitempty"`
 	TextRegex     string   `json:"text_regex,omitempty"`
 	EssayRegex    string   `json:"essay_regex,omitempty"`
 	ProjectID     int64    `json:"project_id,omitempty"`
-	OwnerID       int64    `json:"owner_id,omitempty"`
+	// a scorm element launches PackageLocation, within its unpacked package
+	PackageLocation string `json:"package_location,omitempty" validate:"max=2048"`
+	ScormVersion    string `json:"scorm_version,omitempty" validate:"oneof=1.2 2004"`
+	OwnerID         int64  `json:"owner_id,omitempty"`
 }
 
 type ElementRepository interface {
 	CreateElement(Element *Element) (*datastore.Key, error)
 	GetAllElements() ([]*Element, error)
+	ListElements(opts QueryOptions) ([]*Element, string, error)
+	CountElements(opts QueryOptions) (int, error)
 	DeleteElement(id int64) error
 	GetElementByID(id int64) (*Element, error)
 	UpdateElement(id int64, Element *Element) (*datastore.Key, error)
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// LTIPlatform is an LMS registered to launch modules with LTI 1.3; its
+// values come from the platform's tool registration
+type LTIPlatform struct {
+	KeyID    int64  `json:"id"`
+	Name     string `json:"name,omitempty" validate:"max=200"`
+	Issuer   string `json:"issuer" validate:"required,url,max=2048"`
+	ClientID string `json:"client_id" validate:"required,max=255"`
+	// launches from other deployments are refused; empty allows any
+	DeploymentIDs []string  `json:"deployment_ids,omitempty" datastore:",noindex"`
+	AuthLoginURL  string    `json:"auth_login_url" validate:"required,url,max=2048" datastore:",noindex"`
+	AuthTokenURL  string    `json:"auth_token_url,omitempty" validate:"url,max=2048" datastore:",noindex"`
+	KeySetURL     string    `json:"key_set_url" validate:"required,url,max=2048" datastore:",noindex"`
+	CreatedOn     time.Time `json:"created_on,omitempty"`
+}
+
+// AllowsDeployment reports whether launches from deploymentID are accepted
+func (p *LTIPlatform) AllowsDeployment(deploymentID string) bool {
+	if len(p.DeploymentIDs) == 0 {
+		return true
+	}
+	for _, id := range p.DeploymentIDs {
+		if id == deploymentID {
+			return true
+		}
+	}
+	return false
+}
+
+// LTIIdentity links a platform's user (issuer and subject) to the user
+// provisioned for them
+type LTIIdentity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+	UserID  int64  `json:"user_id"`
+}
+
+// LTILink is a resource link placed on a platform, which launches a module;
+// scores are posted to its line item if the platform gave one
+type LTILink struct {
+	PlatformID     int64     `json:"platform_id"`
+	DeploymentID   string    `json:"deployment_id"`
+	ResourceLinkID string    `json:"resource_link_id"`
+	ModuleID       int64     `json:"module_id"`
+	LineItem       string    `json:"line_item,omitempty" datastore:",noindex"`
+	UpdatedOn      time.Time `json:"updated_on"`
+}
+
+// LTIRepository ..
+type LTIRepository interface {
+	CreateLTIPlatform(platform *LTIPlatform) (*datastore.Key, error)
+	GetAllLTIPlatforms() ([]*LTIPlatform, error)
+	GetLTIPlatformByID(id int64) (*LTIPlatform, error)
+	GetLTIPlatformByIssuer(issuer string, clientID string) (*LTIPlatform, error)
+	UpdateLTIPlatform(id int64, platform *LTIPlatform) (*datastore.Key, error)
+	DeleteLTIPlatform(id int64) error
+	GetLTIIdentity(issuer string, subject string) (*LTIIdentity, error)
+	GetLTIIdentitiesByUserID(userID int64) ([]*LTIIdentity, error)
+	HasLTIIdentities(issuer string) (bool, error)
+	SaveLTIIdentity(identity *LTIIdentity) error
+	GetLTILink(platformID int64, deploymentID string, resourceLinkID string) (*LTILink, error)
+	SaveLTILink(link *LTILink) error
+	GetLTILinksByModuleID(moduleID int64) ([]*LTILink, error)
+}
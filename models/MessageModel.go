@@ -0,0 +1,92 @@
+package models
+
+import (
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// MaxAttachments is how many files one message may carry
+const MaxAttachments = 5
+
+// Conversation is a private exchange between two users of a course, or an
+// instructor's broadcast to the course's roster
+type Conversation struct {
+	KeyID          int64     `json:"id"`
+	CourseID       int64     `json:"course_id" validate:"required"`
+	Subject        string    `json:"subject,omitempty" validate:"max=200" datastore:",noindex"`
+	ParticipantIDs []int64   `json:"participant_ids,omitempty" datastore:",noindex"`
+	CreatedBy      int64     `json:"created_by,omitempty"`
+	CreatedOn      time.Time `json:"created_on,omitempty"`
+	// only the sender writes in a broadcast
+	Broadcast bool `json:"broadcast"`
+}
+
+// HasParticipant reports whether the user takes part in the conversation
+func (conversation *Conversation) HasParticipant(userID int64) bool {
+	for _, id := range conversation.ParticipantIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Participant is a conversation as it appears in one participant's inbox
+type Participant struct {
+	ConversationID int64  `json:"conversation_id"`
+	UserID         int64  `json:"user_id"`
+	CourseID       int64  `json:"course_id"`
+	Subject        string `json:"subject,omitempty" datastore:",noindex"`
+	// With is who the conversation is with: the other user, or the sender of a broadcast
+	With          string    `json:"with,omitempty" datastore:",noindex"`
+	Broadcast     bool      `json:"broadcast"`
+	LastMessage   string    `json:"last_message,omitempty" datastore:",noindex"`
+	LastMessageOn time.Time `json:"last_message_on,omitempty"`
+	Unread        int       `json:"unread"`
+	LastReadOn    time.Time `json:"last_read_on,omitempty"`
+}
+
+// Attachment is a file sent with a message; only participants may download it
+type Attachment struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	Path        string `json:"-"`
+}
+
+// Message is one message of a conversation
+type Message struct {
+	KeyID          int64        `json:"id"`
+	ConversationID int64        `json:"conversation_id,omitempty"`
+	SenderID       int64        `json:"sender_id,omitempty"`
+	Sender         string       `json:"sender,omitempty"`
+	Body           string       `json:"body,omitempty" validate:"required,max=5000" datastore:",noindex"`
+	Attachments    []Attachment `json:"attachments,omitempty" datastore:",noindex"`
+	SentOn         time.Time    `json:"sent_on,omitempty"`
+	Reported       bool         `json:"reported,omitempty"`
+}
+
+// Block stops a user from messaging the user who blocked them
+type Block struct {
+	BlockerID int64     `json:"blocker_id"`
+	BlockedID int64     `json:"blocked_id"`
+	CreatedOn time.Time `json:"created_on"`
+}
+
+type MessageRepository interface {
+	CreateConversation(conversation *Conversation, usernames map[int64]string) (*datastore.Key, error)
+	GetConversationByID(id int64) (*Conversation, error)
+	SendMessage(message *Message) (*datastore.Key, error)
+	GetMessageByID(id int64) (*Message, error)
+	ListMessages(opts QueryOptions) ([]*Message, string, error)
+	CountMessages(opts QueryOptions) (int, error)
+	ListParticipants(opts QueryOptions) ([]*Participant, string, error)
+	CountParticipants(opts QueryOptions) (int, error)
+	CountUnreadMessages(userID int64) (int, error)
+	MarkConversationRead(conversationID int64, userID int64) error
+	BlockUser(block *Block) error
+	UnblockUser(blockerID int64, blockedID int64) error
+	IsBlocked(blockerID int64, blockedID int64) (bool, error)
+	GetBlocksByBlockerID(blockerID int64) ([]*Block, error)
+}
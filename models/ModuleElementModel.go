@@ -17,6 +17,7 @@ type ModuleElement struct {
 type ModuleElementRepository interface {
 	CreateModuleElement(userCourse *ModuleElement) (*datastore.Key, error)
 	GetAllModuleElements() ([]*ModuleElement, error)
+	GetModuleElementByID(id int64) (*ModuleElement, error)
 	DeleteModuleElement(id int64) error
 	GetModuleElementsByModuleID(moduleID int64) ([]*ModuleElement, error)
 	GetModuleElementsByElementID(elementID int64) ([]*ModuleElement, error)
@@ -1,20 +1,27 @@
 package models
 
-import "cloud.google.com/go/datastore"
+import (
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
 
 // create Module model
 type Module struct {
 	// auto increment id
 	KeyID       int64   `json:"id"` //gorm:"primary_key,autoIncrement"
-	Name        string  `json:"name,omitempty"`
+	Name        string  `json:"name,omitempty" validate:"required,max=200"`
 	Description string  `json:"description,omitempty" datastore:",noindex"`
-	TimeLimit   int     `json:"time_limit,omitempty"`
-	MaxAttempts int     `json:"max_attempts,omitempty"`
-	MinPassing  int     `json:"min_passing,omitempty"`
+	TimeLimit   int     `json:"time_limit,omitempty" validate:"min=0"`
+	MaxAttempts int     `json:"max_attempts,omitempty" validate:"min=0"`
+	MinPassing  int     `json:"min_passing,omitempty" validate:"min=0,max=100"`
 	SortKey     int     `json:"sort_key,omitempty"`
 	CourseID    int64   `json:"course_id,omitempty"`
 	ThreadIDs   []int64 `json:"thread_ids,omitempty" datastore:",noindex"`
 	OwnerID     int64   `json:"owner_id,omitempty"`
+	// Deadline, if set, is when learners should have passed the module; they
+	// are reminded shortly before
+	Deadline time.Time `json:"deadline,omitempty"`
 }
 
 // ModuleRepository ..
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Events users are notified of
+const (
+	EventReply    = "reply"
+	EventGrade    = "grade"
+	EventApproval = "approval"
+	EventDeadline = "deadline"
+	EventMessage  = "message"
+)
+
+// NotificationEvents are every event, in the order preferences list them
+var NotificationEvents = []string{EventReply, EventGrade, EventApproval, EventDeadline, EventMessage}
+
+// Notification tells a user that something happened to them; it is kept in
+// their inbox until they delete their account
+type Notification struct {
+	KeyID     int64     `json:"id"`
+	UserID    int64     `json:"user_id,omitempty"`
+	Event     string    `json:"event,omitempty"`
+	Title     string    `json:"title,omitempty" datastore:",noindex"`
+	Body      string    `json:"body,omitempty" datastore:",noindex"`
+	Link      string    `json:"link,omitempty" datastore:",noindex"`
+	CreatedOn time.Time `json:"created_on,omitempty"`
+	Read      bool      `json:"read"`
+	ReadOn    time.Time `json:"read_on,omitempty"`
+}
+
+// EventPreference says where notifications of an event are sent
+type EventPreference struct {
+	Event string `json:"event" validate:"required,oneof=reply grade approval deadline message"`
+	Inbox bool   `json:"inbox"`
+	Email bool   `json:"email"`
+	Slack bool   `json:"slack"`
+	Teams bool   `json:"teams"`
+}
+
+// NotificationPreferences are a user's choice of channels per event, and the
+// incoming webhooks of their Slack and Teams channels
+type NotificationPreferences struct {
+	UserID       int64             `json:"user_id,omitempty"`
+	Events       []EventPreference `json:"events"`
+	SlackWebhook string            `json:"slack_webhook,omitempty" validate:"url,max=2048" datastore:",noindex"`
+	TeamsWebhook string            `json:"teams_webhook,omitempty" validate:"url,max=2048" datastore:",noindex"`
+}
+
+// For returns the preference for event; events without one go to the inbox
+// and by email
+func (p *NotificationPreferences) For(event string) EventPreference {
+	for _, preference := range p.Events {
+		if preference.Event == event {
+			return preference
+		}
+	}
+	return EventPreference{Event: event, Inbox: true, Email: true}
+}
+
+// Complete lists every event, filling in the defaults of those left out
+func (p *NotificationPreferences) Complete() {
+	events := make([]EventPreference, 0, len(NotificationEvents))
+	for _, event := range NotificationEvents {
+		events = append(events, p.For(event))
+	}
+	p.Events = events
+}
+
+type NotificationRepository interface {
+	CreateNotification(notification *Notification) (*datastore.Key, error)
+	ListNotifications(opts QueryOptions) ([]*Notification, string, error)
+	CountNotifications(opts QueryOptions) (int, error)
+	MarkNotificationsRead(userID int64, ids []int64) (int, error)
+	GetNotificationPreferences(userID int64) (*NotificationPreferences, error)
+	SaveNotificationPreferences(preferences *NotificationPreferences) error
+	ClaimReminder(name string) (bool, error)
+}
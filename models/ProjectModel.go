@@ -8,9 +8,11 @@ import (
 
 type Project struct {
 	KeyID       int64     `json:"id"` //gorm:"primary_key,autoIncrement"
-	Name        string    `json:"name,omitempty"`
+	Name        string    `json:"name,omitempty" validate:"required,max=200"`
 	Description string    `json:"description,omitempty"`
-	File        string    `json:"file,omitempty"`
+	File        string    `json:"file,omitempty"`      // the name of the file in the blob store
+	FileName    string    `json:"file_name,omitempty"` // what the file was called when it was uploaded
+	Size        int64     `json:"size,omitempty"`      // the file's size, counted against the course's storage quota
 	Date        time.Time `json:"date,omitempty"`
 	UserID      int64     `json:"user_id,omitempty"`
 	CourseID    int64     `json:"course_id,omitempty"`
@@ -20,6 +22,8 @@ type Project struct {
 type ProjectRepository interface {
 	CreateProject(Project *Project) (*datastore.Key, error)
 	GetAllProjects() ([]*Project, error)
+	ListProjects(opts QueryOptions) ([]*Project, string, error)
+	CountProjects(opts QueryOptions) (int, error)
 	DeleteProject(id int64) error
 	GetProjectByID(id int64) (*Project, error)
 	UpdateProject(id int64, Project *Project) (*datastore.Key, error)
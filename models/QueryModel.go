@@ -0,0 +1,30 @@
+package models
+
+import "errors"
+
+// ErrInvalidCursor is returned by List methods given a cursor they did not issue
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Filter restricts a list to entities whose Field (the Datastore property
+// name, e.g. "Department") compares to Value with Op ("=", "<", ">=", ...)
+type Filter struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+// QueryOptions select one page of a list. Order is a Datastore property
+// name, descending if prefixed with "-". A zero Limit means no limit, and
+// Cursor continues from where a previous page ended.
+type QueryOptions struct {
+	Filters []Filter
+	Order   string
+	Limit   int
+	Cursor  string
+}
+
+// Where adds an equality filter
+func (o QueryOptions) Where(field string, value interface{}) QueryOptions {
+	o.Filters = append(append([]Filter{}, o.Filters...), Filter{Field: field, Op: "=", Value: value})
+	return o
+}
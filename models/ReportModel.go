@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// How reports are resolved
+const (
+	ReportHidden    = "hidden"
+	ReportDismissed = "dismissed"
+)
+
+// Report is a user's complaint about a thread, a reply or a message, waiting
+// in its course's moderation queue until a moderator hides the post or
+// dismisses it. Moderators cannot read private messages, so a report of one
+// carries what it said.
+type Report struct {
+	Kind       string    `json:"kind,omitempty"`
+	PostID     int64     `json:"post_id,omitempty"`
+	ThreadID   int64     `json:"thread_id,omitempty"`
+	CourseID   int64     `json:"course_id,omitempty"`
+	ReporterID int64     `json:"reporter_id,omitempty"`
+	Reporter   string    `json:"reporter,omitempty"`
+	Reason     string    `json:"reason,omitempty" validate:"required,max=1000" datastore:",noindex"`
+	Excerpt    string    `json:"excerpt,omitempty" datastore:",noindex"`
+	CreatedOn  time.Time `json:"created_on,omitempty"`
+	Resolved   bool      `json:"resolved"`
+	Resolution string    `json:"resolution,omitempty"`
+	ResolvedBy int64     `json:"resolved_by,omitempty"`
+	ResolvedOn time.Time `json:"resolved_on,omitempty"`
+}
+
+type ReportRepository interface {
+	CreateReport(report *Report) (*datastore.Key, error)
+	ListReports(opts QueryOptions) ([]*Report, string, error)
+	CountReports(opts QueryOptions) (int, error)
+	ResolveReports(kind string, postID int64, resolution string, moderatorID int64) (int, error)
+	DeleteReportsByThreadID(threadID int64) error
+}
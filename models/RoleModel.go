@@ -2,12 +2,36 @@ package models
 
 import "cloud.google.com/go/datastore"
 
+// Named permissions which roles grant and routes require.
+// PermissionAll grants every route, including ones not yet configured.
+const (
+	PermissionAll           = "*"
+	PermissionUserRead      = "user:read"
+	PermissionUserWrite     = "user:write"
+	PermissionCourseWrite   = "course:write"
+	PermissionCourseApprove = "course:approve"
+	PermissionModuleWrite   = "module:write"
+	PermissionElementWrite  = "element:write"
+	PermissionThreadWrite   = "thread:write"
+	PermissionProjectWrite  = "project:write"
+	PermissionAttemptSubmit = "attempt:submit"
+	PermissionGrade         = "grade"
+	PermissionRoleManage    = "role:manage"
+	PermissionRouteManage   = "route:manage"
+	PermissionAdminRead     = "admin:read"
+	PermissionSignedIn      = "signed-in"
+)
+
+// RoleUser is held by every signed-in user, whether or not it is in their roles
+const RoleUser = "user"
+
 // create Role model
 type Role struct {
 	// auto increment id
-	KeyID        int64  `json:"id"` //gorm:"primary_key,autoIncrement"
-	Name         string `json:"name,omitempty"`
-	NumericValue int    `json:"numeric_value,omitempty"`
+	KeyID        int64    `json:"id"` //gorm:"primary_key,autoIncrement"
+	Name         string   `json:"name,omitempty" validate:"required,max=100"`
+	NumericValue int      `json:"numeric_value,omitempty"` // legacy bitmask value
+	Permissions  []string `json:"permissions,omitempty" datastore:",noindex"`
 }
 
 // RoleRepository ..
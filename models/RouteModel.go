@@ -2,10 +2,19 @@ package models
 
 import "cloud.google.com/go/datastore"
 
+// Route is named path_METHOD, e.g. "/course/{id}_PUT".
+// A route is open to everyone if Public, otherwise to users holding any of
+// its Permissions (or a role matching the legacy PermissionLevel bitmask).
+// Routes with neither are only open to PermissionAll (deny by default).
+// Routes stored by earlier versions, where PermissionLevel 0 meant public,
+// are not Managed until UpdateRoutes has migrated them.
 type Route struct {
-	KeyID           int64  `json:"id"` //gorm:"primary_key,autoIncrement"
-	Name            string `json:"name,omitempty"`
-	PermissionLevel int    `json:"numeric_value,omitempty"`
+	KeyID           int64    `json:"id"` //gorm:"primary_key,autoIncrement"
+	Name            string   `json:"name,omitempty" validate:"required"`
+	PermissionLevel int      `json:"numeric_value,omitempty"`
+	Public          bool     `json:"public"`
+	Permissions     []string `json:"permissions,omitempty" datastore:",noindex"`
+	Managed         bool     `json:"managed"`
 }
 
 type RouteRepository interface {
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+)
+
+// CMIValue is one element of SCORM runtime data, e.g. cmi.core.lesson_status
+type CMIValue struct {
+	Element string `json:"element"`
+	Value   string `json:"value" datastore:",noindex"`
+}
+
+// ScormAttempt holds the runtime data a SCORM element reports while a user
+// works through a module; submitting the module moves it into the attempt's
+// Answer for the element
+type ScormAttempt struct {
+	UserID    int64      `json:"user_id"`
+	ModuleID  int64      `json:"module_id"`
+	ElementID int64      `json:"element_id"`
+	CMI       []CMIValue `json:"cmi" datastore:",noindex"`
+	Finished  bool       `json:"finished"`
+	UpdatedOn time.Time  `json:"updated_on"`
+}
+
+// Data returns the CMI values by element
+func (a *ScormAttempt) Data() map[string]string {
+	data := make(map[string]string, len(a.CMI))
+	for _, v := range a.CMI {
+		data[v.Element] = v.Value
+	}
+	return data
+}
+
+// Merge sets the given CMI values, keeping the others
+func (a *ScormAttempt) Merge(data map[string]string) {
+	for i, v := range a.CMI {
+		if value, ok := data[v.Element]; ok {
+			a.CMI[i].Value = value
+			delete(data, v.Element)
+		}
+	}
+	for element, value := range data {
+		a.CMI = append(a.CMI, CMIValue{Element: element, Value: value})
+	}
+}
+
+// ScormAttemptRepository ..
+type ScormAttemptRepository interface {
+	GetScormAttempt(userID int64, moduleID int64, elementID int64) (*ScormAttempt, error)
+	SaveScormAttempt(attempt *ScormAttempt) error
+	DeleteScormAttempts(userID int64, moduleID int64) error
+}
@@ -1,41 +1,149 @@
 package models
 
 import (
+	"errors"
+	"math"
 	"time"
 
 	"cloud.google.com/go/datastore"
 )
 
+// Replies nest at most MaxReplyDepth deep, and keep the previous versions of
+// their body from at most MaxReplyEdits edits
+const (
+	MaxReplyDepth = 8
+	MaxReplyEdits = 20
+)
+
+// Kinds of post, which votes and reports refer to; messages can only be reported
+const (
+	PostThread  = "Thread"
+	PostReply   = "Reply"
+	PostMessage = "Message"
+)
+
+// ErrReplyDeleted is returned when editing a reply which was deleted
+var ErrReplyDeleted = errors.New("reply deleted")
+
+// ReplyEdit is a previous version of a reply's body
+type ReplyEdit struct {
+	Body     string    `json:"body"`
+	EditedOn time.Time `json:"edited_on"`
+	EditedBy int64     `json:"edited_by,omitempty"`
+}
+
+// Reply is a post in a thread, answering the thread itself or, when ParentID
+// is set, another reply
 type Reply struct {
-	Body      string    `json:"body,omitempty"`
-	Author    string    `json:"author,omitempty"`
-	CreatedOn time.Time `json:"created_on,omitempty"`
-	BumpedOn  time.Time `json:"bumped_on,omitempty"`
-	Reported  bool      `json:"reported,omitempty"`
-	Upvotes   int       `json:"upvotes,omitempty"`
-	Downvotes int       `json:"downvotes,omitempty"`
+	KeyID     int64       `json:"id"`
+	ThreadID  int64       `json:"thread_id,omitempty"`
+	ParentID  int64       `json:"parent_id,omitempty"`
+	Depth     int         `json:"depth,omitempty"`
+	Body      string      `json:"body,omitempty" validate:"required,max=10000" datastore:",noindex"`
+	Author    string      `json:"author,omitempty"`
+	AuthorID  int64       `json:"author_id,omitempty"`
+	CreatedOn time.Time   `json:"created_on,omitempty"`
+	BumpedOn  time.Time   `json:"bumped_on,omitempty"`
+	EditedOn  time.Time   `json:"edited_on,omitempty"`
+	History   []ReplyEdit `json:"history,omitempty" datastore:",noindex"`
+	Deleted   bool        `json:"deleted,omitempty"`
+	Hidden    bool        `json:"hidden,omitempty"`
+	Reported  bool        `json:"reported,omitempty"`
+	Upvotes   int         `json:"upvotes,omitempty"`
+	Downvotes int         `json:"downvotes,omitempty"`
+	Score     int         `json:"score"`
+}
+
+// Edit replaces the body, keeping the previous one in the history
+func (reply *Reply) Edit(body string, editorID int64, now time.Time) {
+	reply.History = append(reply.History, ReplyEdit{Body: reply.Body, EditedOn: now, EditedBy: editorID})
+	if len(reply.History) > MaxReplyEdits {
+		reply.History = reply.History[len(reply.History)-MaxReplyEdits:]
+	}
+	reply.Body = body
+	reply.EditedOn = now
+}
+
+// Redact removes what a hidden reply says, for those who may not see it
+func (reply *Reply) Redact() {
+	reply.Body = ""
+	reply.History = nil
+}
+
+// hotEpoch and hotPeriod scale hot rankings: a thread posted hotPeriod later
+// ranks as high as one with ten times the score
+var hotEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const hotPeriod = 12.5 * float64(time.Hour/time.Second)
+
+// HotRank ranks a post by its score and age. Newer posts rank higher, so the
+// rank only changes when the score does, and can be stored and sorted on.
+func HotRank(score int, createdOn time.Time) float64 {
+	order := math.Log10(math.Max(math.Abs(float64(score)), 1))
+	if score < 0 {
+		order = -order
+	}
+	return order + createdOn.Sub(hotEpoch).Seconds()/hotPeriod
 }
 
 type Thread struct {
-	KeyID     int64     `json:"id"` //gorm:"primary_key,autoIncrement"
-	Title     string    `json:"title,omitempty"`
-	Body      string    `json:"body,omitempty"`
-	Author    string    `json:"author,omitempty"`
-	CreatedOn time.Time `json:"created_on,omitempty"`
-	BumpedOn  time.Time `json:"bumped_on,omitempty"`
-	Closed    bool      `json:"closed,omitempty"`
-	Reported  bool      `json:"reported,omitempty"`
-	Replies   []Reply   `json:"replies,omitempty" datastore:",noindex"`
-	Upvotes   int       `json:"upvotes,omitempty"`
-	Downvotes int       `json:"downvotes,omitempty"`
-	ModuleID  int64     `json:"module_id,omitempty"`
+	KeyID      int64     `json:"id"` //gorm:"primary_key,autoIncrement"
+	Title      string    `json:"title,omitempty" validate:"required,max=300"`
+	Body       string    `json:"body,omitempty"`
+	Author     string    `json:"author,omitempty"`
+	AuthorID   int64     `json:"author_id,omitempty"`
+	CreatedOn  time.Time `json:"created_on,omitempty"`
+	BumpedOn   time.Time `json:"bumped_on,omitempty"`
+	Closed     bool      `json:"closed,omitempty"`
+	Pinned     bool      `json:"pinned,omitempty"`
+	Hidden     bool      `json:"hidden,omitempty"`
+	Reported   bool      `json:"reported,omitempty"`
+	ReplyCount int       `json:"reply_count"`
+	// Replies were embedded in the thread before they were entities of their
+	// own; MigrateThreads moves them out
+	Replies   []Reply `json:"-" datastore:",noindex"`
+	Upvotes   int     `json:"upvotes,omitempty"`
+	Downvotes int     `json:"downvotes,omitempty"`
+	Score     int     `json:"score"`
+	Hot       float64 `json:"hot"`
+	ModuleID  int64   `json:"module_id,omitempty"`
+}
+
+// Redact removes what a hidden thread says, for those who may not see it
+func (thread *Thread) Redact() {
+	thread.Title = ""
+	thread.Body = ""
+}
+
+// Rank sets the score and hot rank from the votes
+func (thread *Thread) Rank() {
+	thread.Score = thread.Upvotes - thread.Downvotes
+	thread.Hot = HotRank(thread.Score, thread.CreatedOn)
 }
 
 type ThreadRepository interface {
 	CreateThread(Thread *Thread) (*datastore.Key, error)
 	GetAllThreads() ([]*Thread, error)
+	ListThreads(opts QueryOptions) ([]*Thread, string, error)
+	CountThreads(opts QueryOptions) (int, error)
 	DeleteThread(id int64) error
 	GetThreadByID(id int64) (*Thread, error)
 	UpdateThread(id int64, Thread *Thread) (*datastore.Key, error)
 	GetAllThreadsByModuleID(moduleID int64) ([]*Thread, error)
+	RankThread(id int64) error
+	ModerateThread(id int64, moderate func(thread *Thread)) (*Thread, error)
+}
+
+type ReplyRepository interface {
+	CreateReply(reply *Reply) (*datastore.Key, error)
+	GetReplyByID(id int64) (*Reply, error)
+	EditReply(id int64, body string, editorID int64) (*Reply, error)
+	DeleteReply(id int64) error
+	ListReplies(opts QueryOptions) ([]*Reply, string, error)
+	CountReplies(opts QueryOptions) (int, error)
+	GetRepliesByThreadID(threadID int64) ([]*Reply, error)
+	GetAllReplies() ([]*Reply, error)
+	DeleteRepliesByThreadID(threadID int64) error
+	MigrateReplies(threadID int64) (int, error)
+	ModerateReply(id int64, moderate func(reply *Reply)) (*Reply, error)
 }
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Upload statuses
+const (
+	UploadOpen       = "open"
+	UploadAssembling = "assembling"
+	UploadComplete   = "complete"
+	UploadFailed     = "failed"
+)
+
+var (
+	// ErrOffsetMismatch is returned when a chunk does not start where the upload stands
+	ErrOffsetMismatch = errors.New("offset mismatch")
+	// ErrUploadAssembling is returned when an upload being assembled is removed
+	ErrUploadAssembling = errors.New("upload is being assembled")
+	// ErrQuotaExceeded is returned when a course has no room for more files
+	ErrQuotaExceeded = errors.New("course storage quota exceeded")
+)
+
+// Upload is a project file sent in chunks, which can be resumed from
+// Offset after a failure. Once all Size bytes arrived, its chunks are
+// assembled into the blob store and a Project is created for it.
+type Upload struct {
+	KeyID       int64  `json:"id"`
+	UserID      int64  `json:"user_id,omitempty"`
+	CourseID    int64  `json:"course_id,omitempty"`
+	ModuleID    int64  `json:"module_id,omitempty" validate:"required"`
+	ElementID   int64  `json:"element_id,omitempty"`
+	Name        string `json:"name,omitempty" validate:"required,max=200"`
+	Description string `json:"description,omitempty" datastore:",noindex"`
+	FileName    string `json:"file_name,omitempty" validate:"required,max=255"`
+	Size        int64  `json:"size,omitempty" validate:"required"`
+	Offset      int64  `json:"offset"`
+	// Chunks are the blob names of the chunks received, in order
+	Chunks    []string  `json:"-" datastore:",noindex"`
+	Status    string    `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty" datastore:",noindex"`
+	ProjectID int64     `json:"project_id,omitempty"`
+	CreatedOn time.Time `json:"created_on,omitempty"`
+	ExpiresOn time.Time `json:"expires_on,omitempty"`
+}
+
+// CourseStorage is how many bytes of files a course holds, counting
+// uploads in progress, against its quota
+type CourseStorage struct {
+	CourseID int64 `json:"course_id"`
+	Used     int64 `json:"used"`
+	// Limit replaces the default quota when it is set
+	Limit int64 `json:"limit,omitempty"`
+}
+
+type UploadRepository interface {
+	CreateUpload(upload *Upload) (*datastore.Key, error)
+	GetUploadByID(id int64) (*Upload, error)
+	UpdateUpload(upload *Upload) error
+	// RemoveUpload deletes an upload and returns it, so that of two callers
+	// only one gets it; an upload being assembled is only removed once it
+	// expired, otherwise ErrUploadAssembling is returned
+	RemoveUpload(id int64) (*Upload, error)
+	// AdvanceUpload records a chunk of length bytes received at offset and
+	// stored as the blob chunk, returning ErrOffsetMismatch if the upload is
+	// not open at offset or the chunk goes past its end
+	AdvanceUpload(id int64, offset int64, length int64, chunk string, expiresOn time.Time) (*Upload, error)
+	GetExpiredUploads(before time.Time) ([]*Upload, error)
+	// GetCourseStorage returns datastore.ErrNoSuchEntity for a course which
+	// was never counted
+	GetCourseStorage(courseID int64) (*CourseStorage, error)
+	// ReserveCourseStorage adds bytes to a course's usage, starting from
+	// initial if it was never counted, and returns ErrQuotaExceeded if that
+	// would take it over its limit, or quota if it has none
+	ReserveCourseStorage(courseID int64, bytes int64, quota int64, initial int64) (*CourseStorage, error)
+	ReleaseCourseStorage(courseID int64, bytes int64) error
+	SetCourseStorageLimit(courseID int64, limit int64, initial int64) (*CourseStorage, error)
+}
@@ -11,17 +11,18 @@ import (
 
 type UserCourse struct {
 	KeyID       int64     `json:"id"` //gorm:"primary_key,autoIncrement"
-	UserID      int64     `json:"user_id,omitempty"`
-	CourseID    int64     `json:"course_id,omitempty"`
-	Grade       int       `json:"grade,omitempty"`
+	UserID      int64     `json:"user_id,omitempty" validate:"required"`
+	CourseID    int64     `json:"course_id,omitempty" validate:"required"`
+	Grade       int       `json:"grade,omitempty" validate:"min=0,max=100"`
 	StartedOn   time.Time `json:"started_on,omitempty"`
 	CompletedOn time.Time `json:"completed_on,omitempty"`
-	Role        string    `json:"role,omitempty"`
+	Role        string    `json:"role,omitempty" validate:"oneof=learner ta instructor owner"`
 }
 
 type UserCourseRepository interface {
 	CreateUserCourse(userCourse *UserCourse) (*datastore.Key, error)
 	GetAllUserCourses() ([]*UserCourse, error)
+	GetUserCourseByID(id int64) (*UserCourse, error)
 	DeleteUserCourse(id int64) error
 	GetUserCoursesByUserID(userID int64) ([]*UserCourse, error)
 	GetUserCoursesByCourseID(courseID int64) ([]*UserCourse, error)
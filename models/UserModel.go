@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"cloud.google.com/go/datastore"
@@ -13,6 +14,8 @@ type Answer struct {
 	AnswerEssay string `json:"answer_essay,omitempty" datastore:",noindex"`
 	ProjectID   int64  `json:"project_id,omitempty"`
 	Correct     bool   `json:"correct,omitempty"`
+	// runtime data of a SCORM element
+	CMI []CMIValue `json:"cmi,omitempty" datastore:",noindex"`
 }
 
 // the string key for Answers is the element ID
@@ -29,8 +32,8 @@ type UserModule struct {
 type User struct {
 	// auto increment id
 	KeyID     int64        `json:"id"` //gorm:"primary_key,autoIncrement"
-	Username  string       `json:"username,omitempty"`
-	Email     string       `json:"email,omitempty"`
+	Username  string       `json:"username,omitempty" validate:"required,max=100"`
+	Email     string       `json:"email,omitempty" validate:"email"`
 	Password  string       `json:"password,omitempty"`
 	Firstname string       `json:"firstname,omitempty"`
 	Lastname  string       `json:"lastname,omitempty"`
@@ -41,6 +44,15 @@ type User struct {
 	CreatedOn time.Time    `json:"created_on,omitempty"`
 }
 
+// MarshalJSON leaves the password out of every response and audit record;
+// it is still read from request bodies
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User
+	out := user(u)
+	out.Password = ""
+	return json.Marshal(out)
+}
+
 // TODO: why is this in the model?
 func (u User) GetRoles() []string {
 	if u.Roles == nil {
@@ -53,6 +65,8 @@ func (u User) GetRoles() []string {
 type UserRepository interface {
 	CreateUser(user *User) (*datastore.Key, error)
 	GetAllUsers() ([]*User, error)
+	ListUsers(opts QueryOptions) ([]*User, string, error)
+	CountUsers(opts QueryOptions) (int, error)
 	GetUserByID(id int64) (*User, error)
 	GetUserByUsername(username string) (*User, error)
 	UpdateUser(id int64, user *User) (*datastore.Key, error)
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// Vote is a user's up (1) or down (-1) vote on a thread or a reply; each user
+// has at most one vote per post
+type Vote struct {
+	UserID    int64     `json:"user_id,omitempty"`
+	Kind      string    `json:"kind,omitempty"`
+	PostID    int64     `json:"post_id,omitempty"`
+	ThreadID  int64     `json:"thread_id,omitempty"`
+	Value     int       `json:"value" validate:"required,oneof=-1 1"`
+	CreatedOn time.Time `json:"created_on,omitempty"`
+}
+
+// VoteTally is a post's counters after a vote, and the voter's current vote
+type VoteTally struct {
+	Vote      int `json:"vote"`
+	Upvotes   int `json:"upvotes"`
+	Downvotes int `json:"downvotes"`
+	Score     int `json:"score"`
+}
+
+// UserVotes are a user's votes in a thread: on the thread itself, and on its
+// replies by reply ID
+type UserVotes struct {
+	Thread  int           `json:"thread"`
+	Replies map[int64]int `json:"replies"`
+}
+
+type VoteRepository interface {
+	CastVote(vote *Vote) (*VoteTally, error)
+	GetVotesByUserAndThread(userID int64, threadID int64) ([]*Vote, error)
+	DeleteVotesByThreadID(threadID int64) error
+}
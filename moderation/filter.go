@@ -0,0 +1,52 @@
+// Package moderation screens discussion posts as they are written: posts
+// containing a blocked word, or more links than allowed, are rejected
+package moderation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// links counts URLs, with or without a scheme
+var links = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// Filter checks posts against a list of blocked words and a link limit
+type Filter struct {
+	words    map[string]bool
+	maxLinks int
+}
+
+// NewFilter creates a Filter. Words match whole words, ignoring case; a
+// negative maxLinks allows any number of links.
+func NewFilter(words []string, maxLinks int) *Filter {
+	f := &Filter{words: map[string]bool{}, maxLinks: maxLinks}
+	for _, word := range words {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			f.words[word] = true
+		}
+	}
+	return f
+}
+
+// Check returns why text may not be posted, or "" if it may
+func (f *Filter) Check(text string) string {
+	if f.maxLinks >= 0 {
+		if n := len(links.FindAllStringIndex(text, -1)); n > f.maxLinks {
+			return fmt.Sprintf("must not contain more than %d links", f.maxLinks)
+		}
+	}
+
+	if len(f.words) > 0 {
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, word := range words {
+			if f.words[word] {
+				return "contains a blocked word"
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+	"restAPI/models"
+	"time"
+)
+
+// RemindDeadlinesEvery checks every interval, until done is closed, for
+// modules due within window, and reminds the enrolled learners who have not
+// passed them yet. Each deadline is reminded of once, however many
+// instances are running.
+func (n *Notifier) RemindDeadlinesEvery(interval time.Duration, window time.Duration, done <-chan struct{},
+	modules models.ModuleRepository, userCourses models.UserCourseRepository, users models.UserRepository) {
+	if !n.Enabled() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			n.remindDeadlines(window, modules, userCourses, users)
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func (n *Notifier) remindDeadlines(window time.Duration, modules models.ModuleRepository,
+	userCourses models.UserCourseRepository, users models.UserRepository) {
+	all, err := modules.GetAllModules()
+	if err != nil {
+		log.Printf("Error loading modules for deadline reminders: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, module := range all {
+		if module.Deadline.IsZero() || !module.Deadline.After(now) || module.Deadline.After(now.Add(window)) {
+			continue
+		}
+		// moving the deadline calls for a new reminder
+		claimed, err := n.notifications.ClaimReminder(fmt.Sprintf("deadline-%d-%d", module.KeyID, module.Deadline.Unix()))
+		if err != nil {
+			log.Printf("Error claiming the deadline reminder of module %d: %v", module.KeyID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		enrolments, err := userCourses.GetUserCoursesByCourseID(module.CourseID)
+		if err != nil {
+			log.Printf("Error loading the learners of course %d: %v", module.CourseID, err)
+			continue
+		}
+		var learners []int64
+		for _, enrolment := range enrolments {
+			if enrolment.Role != "" && enrolment.Role != "learner" {
+				continue
+			}
+			user, err := users.GetUserByID(enrolment.UserID)
+			if err != nil || passed(user, module) {
+				continue
+			}
+			learners = append(learners, user.KeyID)
+		}
+		n.NotifyAll(learners, Message{
+			Event: models.EventDeadline,
+			Title: "Deadline approaching: " + module.Name,
+			Body:  fmt.Sprintf("%s is due %s.", module.Name, module.Deadline.UTC().Format("Mon 2 Jan 2006 15:04 MST")),
+			Link:  fmt.Sprintf("/module/%d", module.KeyID),
+		})
+	}
+}
+
+// passed reports whether user scored at least the passing mark of module
+func passed(user *models.User, module *models.Module) bool {
+	for _, userModule := range user.Modules {
+		if userModule.ModuleID == module.KeyID && userModule.Score >= module.MinPassing {
+			return true
+		}
+	}
+	return false
+}
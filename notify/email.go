@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"fmt"
+	"net/smtp"
+	"restAPI/models"
+	"strings"
+	"time"
+)
+
+// Email sends notifications through an SMTP relay
+type Email struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewEmail creates an email channel relaying through addr (host:port),
+// authenticating if username is set
+func NewEmail(addr, username, password, from string) *Email {
+	email := &Email{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		email.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return email
+}
+
+// Name ..
+func (e *Email) Name() string {
+	return "email"
+}
+
+// Deliver mails message to the user's address
+func (e *Email) Deliver(user *models.User, preferences *models.NotificationPreferences, message Message) error {
+	if user.Email == "" {
+		return nil
+	}
+
+	body := message.Body
+	if message.Link != "" {
+		body += "\r\n\r\n" + message.Link
+	}
+	content := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		e.from, headerValue(user.Email), headerValue(message.Title), time.Now().Format(time.RFC1123Z), body)
+	return smtp.SendMail(e.addr, e.auth, e.from, []string{user.Email}, []byte(content))
+}
+
+// headerValue keeps a value on its header line
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,193 @@
+// Package notify tells users about replies, grades, approvals and deadlines:
+// in their inbox, by email, and in Slack or Teams, as each user prefers
+package notify
+
+import (
+	"log"
+	"restAPI/models"
+	"strings"
+	"time"
+)
+
+// how many notifications, or broadcasts, wait to be delivered before new
+// ones are dropped
+const queueSize = 1000
+
+// how many notifications are delivered at once, so that a large broadcast or
+// a slow channel does not hold up the rest
+const workers = 4
+
+// Message is one notification on its way to a user; Link is a path in the
+// application, made absolute for channels outside it
+type Message struct {
+	UserID int64
+	Event  string
+	Title  string
+	Body   string
+	Link   string
+}
+
+// Channel delivers notifications outside the application
+type Channel interface {
+	// Name is the preference which enables the channel: email, slack or teams
+	Name() string
+	// Deliver sends message to user; it does nothing if the user has no
+	// address on the channel
+	Deliver(user *models.User, preferences *models.NotificationPreferences, message Message) error
+}
+
+// Notifier stores and delivers notifications in the background, retrying
+// failed channels. A nil Notifier notifies nobody.
+type Notifier struct {
+	notifications models.NotificationRepository
+	users         models.UserRepository
+	channels      []Channel
+	// BaseURL is where links in notifications point
+	BaseURL string
+
+	queue chan job
+}
+
+// job is a notification for one or more users
+type job struct {
+	userIDs []int64
+	message Message
+}
+
+// NewNotifier creates a notifier which stores notifications in the inbox
+// and delivers them to channels
+func NewNotifier(notifications models.NotificationRepository, users models.UserRepository, baseURL string,
+	channels ...Channel) *Notifier {
+	return &Notifier{
+		notifications: notifications,
+		users:         users,
+		channels:      channels,
+		BaseURL:       strings.TrimSuffix(baseURL, "/"),
+		queue:         make(chan job, queueSize),
+	}
+}
+
+// Enabled reports whether notifications are sent
+func (n *Notifier) Enabled() bool {
+	return n != nil
+}
+
+// Start delivers queued notifications until done is closed
+func (n *Notifier) Start(done <-chan struct{}) {
+	if !n.Enabled() {
+		return
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case job := <-n.queue:
+					for _, userID := range job.userIDs {
+						message := job.message
+						message.UserID = userID
+						n.deliver(message)
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Notify queues a notification for a user
+func (n *Notifier) Notify(message Message) {
+	if message.UserID == 0 {
+		return
+	}
+	n.enqueue(job{userIDs: []int64{message.UserID}, message: message})
+}
+
+// NotifyAll queues the same notification for every user in userIDs as one
+// job, so that a broadcast takes a single place in the queue
+func (n *Notifier) NotifyAll(userIDs []int64, message Message) {
+	if len(userIDs) == 0 {
+		return
+	}
+	n.enqueue(job{userIDs: userIDs, message: message})
+}
+
+func (n *Notifier) enqueue(job job) {
+	if !n.Enabled() {
+		return
+	}
+	select {
+	case n.queue <- job:
+	default:
+		log.Printf("Notification queue full: dropping %s notification for %d users", job.message.Event, len(job.userIDs))
+	}
+}
+
+// deliver stores message in the inbox and sends it to the channels the user
+// chose for its event
+func (n *Notifier) deliver(message Message) {
+	user, err := n.users.GetUserByID(message.UserID)
+	if err != nil {
+		log.Printf("Error loading user %d to notify: %v", message.UserID, err)
+		return
+	}
+	preferences, err := n.notifications.GetNotificationPreferences(message.UserID)
+	if err != nil {
+		log.Printf("Error loading notification preferences of user %d: %v", message.UserID, err)
+		return
+	}
+	preference := preferences.For(message.Event)
+
+	if preference.Inbox {
+		notification := &models.Notification{
+			UserID:    message.UserID,
+			Event:     message.Event,
+			Title:     message.Title,
+			Body:      message.Body,
+			Link:      message.Link,
+			CreatedOn: time.Now(),
+		}
+		if _, err := n.notifications.CreateNotification(notification); err != nil {
+			log.Printf("Error storing notification for user %d: %v", message.UserID, err)
+		}
+	}
+
+	if message.Link != "" && strings.HasPrefix(message.Link, "/") {
+		message.Link = n.BaseURL + message.Link
+	}
+	for _, channel := range n.channels {
+		if wants(preference, channel.Name()) {
+			n.send(channel, user, preferences, message)
+		}
+	}
+}
+
+// send delivers through one channel, retrying with backoff
+func (n *Notifier) send(channel Channel, user *models.User, preferences *models.NotificationPreferences, message Message) {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := channel.Deliver(user, preferences, message)
+		if err == nil {
+			return
+		}
+		if attempt == 4 {
+			log.Printf("Error sending %s notification to user %d by %s: %v", message.Event, message.UserID, channel.Name(), err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// wants reports whether preference enables the named channel
+func wants(preference models.EventPreference, channel string) bool {
+	switch channel {
+	case "email":
+		return preference.Email
+	case "slack":
+		return preference.Slack
+	case "teams":
+		return preference.Teams
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"restAPI/models"
+	"strings"
+	"time"
+)
+
+// Webhook posts notifications to the Slack or Teams incoming webhook a user
+// configured
+type Webhook struct {
+	name   string
+	hosts  []string
+	client *http.Client
+}
+
+// NewSlack creates the Slack channel, posting only to hosts
+func NewSlack(hosts []string) *Webhook {
+	return &Webhook{name: "slack", hosts: hosts, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// NewTeams creates the Microsoft Teams channel, posting only to hosts
+func NewTeams(hosts []string) *Webhook {
+	return &Webhook{name: "teams", hosts: hosts, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Name ..
+func (h *Webhook) Name() string {
+	return h.name
+}
+
+// Deliver posts message to the user's webhook
+func (h *Webhook) Deliver(user *models.User, preferences *models.NotificationPreferences, message Message) error {
+	endpoint := preferences.SlackWebhook
+	if h.name == "teams" {
+		endpoint = preferences.TeamsWebhook
+	}
+	if endpoint == "" {
+		return nil
+	}
+	if !AllowedWebhook(endpoint, h.hosts) {
+		return fmt.Errorf("webhook host is not allowed")
+	}
+
+	body, err := json.Marshal(h.payload(message))
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// payload formats message as Slack text or a Teams message card
+func (h *Webhook) payload(message Message) interface{} {
+	if h.name == "teams" {
+		card := map[string]interface{}{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  message.Title,
+			"title":    message.Title,
+			"text":     message.Body,
+		}
+		if message.Link != "" {
+			card["potentialAction"] = []map[string]interface{}{{
+				"@type":   "OpenUri",
+				"name":    "Open",
+				"targets": []map[string]string{{"os": "default", "uri": message.Link}},
+			}}
+		}
+		return card
+	}
+
+	text := "*" + message.Title + "*\n" + message.Body
+	if message.Link != "" {
+		text += "\n<" + message.Link + ">"
+	}
+	return map[string]string{"text": text}
+}
+
+// AllowedWebhook reports whether rawURL is an https URL on one of hosts or
+// their subdomains, so that users cannot make the server post elsewhere
+func AllowedWebhook(rawURL string, hosts []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.User != nil || u.Port() != "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range hosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+// Package openapi holds the OpenAPI 3 document types, and derives JSON
+// schemas from Go structs by their json and validate tags
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the OpenAPI specification the documents follow
+const Version = "3.0.3"
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Components holds the schemas referred to by name, and security schemes
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes how requests authenticate
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Operation is one method on a path. Security lists the schemes that may
+// authenticate it, and must be empty (not nil) for public operations.
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+}
+
+// Parameter is a path or query parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is the content an operation accepts
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// MediaType gives the schema of a content type
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Response is one possible response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// Header is a response header
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Schema is a JSON schema, as far as OpenAPI 3.0 uses it
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+// String, Integer and Boolean are schemas of scalar parameters
+var (
+	String  = &Schema{Type: "string"}
+	Integer = &Schema{Type: "integer", Format: "int64"}
+	Boolean = &Schema{Type: "boolean"}
+)
+
+// NewDocument creates an empty document
+func NewDocument(info Info) *Document {
+	return &Document{
+		OpenAPI:    Version,
+		Info:       info,
+		Paths:      map[string]map[string]Operation{},
+		Components: Components{Schemas: map[string]*Schema{}},
+	}
+}
+
+// AddOperation adds op for the method (e.g. "GET") on path
+func (d *Document) AddOperation(path string, method string, op Operation) {
+	if d.Paths[path] == nil {
+		d.Paths[path] = map[string]Operation{}
+	}
+	d.Paths[path][strings.ToLower(method)] = op
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaOf returns the schema of v's type. Named structs are added to the
+// components and referred to by name.
+func (d *Document) SchemaOf(v interface{}) *Schema {
+	return d.schema(reflect.TypeOf(v))
+}
+
+func (d *Document) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schema(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		if t == timeType {
+			return &Schema{Type: "string", Format: "date-time"}
+		}
+		if t.Name() == "" {
+			return d.object(t)
+		}
+		if _, ok := d.Components.Schemas[t.Name()]; !ok {
+			d.Components.Schemas[t.Name()] = &Schema{} // placeholder, for recursive types
+			d.Components.Schemas[t.Name()] = d.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &Schema{}
+}
+
+func (d *Document) object(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for n, property := range d.object(embedded).Properties {
+					schema.Properties[n] = property
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := d.schema(field.Type)
+		if applyRules(property, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+// applyRules copies validate rules (see package validation) onto a schema,
+// and reports whether the field is required
+func applyRules(schema *Schema, tag string) bool {
+	required := false
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "required":
+			required = true
+		case "oneof":
+			schema.Enum = strings.Fields(arg)
+		case "email":
+			schema.Format = "email"
+		case "url":
+			schema.Format = "uri"
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil || schema.Ref != "" {
+				continue
+			}
+			n := int(limit)
+			switch schema.Type {
+			case "string":
+				if name == "min" {
+					schema.MinLength = &n
+				} else {
+					schema.MaxLength = &n
+				}
+			case "array":
+				if name == "min" {
+					schema.MinItems = &n
+				} else {
+					schema.MaxItems = &n
+				}
+			case "integer", "number":
+				if name == "min" {
+					schema.Minimum = &limit
+				} else {
+					schema.Maximum = &limit
+				}
+			}
+		}
+	}
+	return required
+}
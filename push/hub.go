@@ -0,0 +1,195 @@
+// Package push delivers events to connected clients as they happen: new
+// posts in a module's discussions, a learner's grades and course
+// announcements
+package push
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// how many recent events are kept for clients which reconnect
+	historySize = 1000
+	// how many events may wait for a subscriber before it is dropped
+	bufferSize = 64
+)
+
+// Event is one message on a topic; IDs increase, so that a client which
+// reconnects can ask for what it missed
+type Event struct {
+	ID    uint64
+	Topic string
+	Type  string
+	Data  json.RawMessage
+}
+
+// Reset is sent first to a client which missed more events than are kept;
+// it should reload what it shows
+const Reset = "reset"
+
+// Broker passes events from publishers to subscribers. Hub is the
+// in-process broker; one shared between instances can replace it.
+type Broker interface {
+	// Publish sends data, as JSON, to the subscribers of topic
+	Publish(topic string, kind string, data interface{})
+	// Subscribe returns the events on topics after lastID (0 for new ones only)
+	Subscribe(topics []string, lastID uint64) *Subscription
+}
+
+// Subscription receives events until it is closed, or until the broker
+// drops it; then Events is closed
+type Subscription struct {
+	Events <-chan Event
+
+	events chan Event
+	topics []string
+	hub    *Hub
+	once   sync.Once
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.hub.remove(s)
+}
+
+// Hub is a Broker within this process
+type Hub struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []Event
+	subscribers map[string]map[*Subscription]bool
+	closed      bool
+}
+
+// NewHub creates a hub whose event IDs start from the clock, so that clients
+// of a previous run are told to reset rather than given the wrong events
+func NewHub() *Hub {
+	return &Hub{
+		lastID:      uint64(time.Now().UnixNano()/int64(time.Millisecond)) * 1000,
+		subscribers: map[string]map[*Subscription]bool{},
+	}
+}
+
+// Start closes every subscription once done is closed, so that open streams
+// end and the server can shut down
+func (h *Hub) Start(done <-chan struct{}) {
+	go func() {
+		<-done
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.closed = true
+		for _, subscriptions := range h.subscribers {
+			for s := range subscriptions {
+				s.once.Do(func() { close(s.events) })
+			}
+		}
+		h.subscribers = map[string]map[*Subscription]bool{}
+	}()
+}
+
+// Publish ..
+func (h *Hub) Publish(topic string, kind string, data interface{}) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", kind, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastID++
+	event := Event{ID: h.lastID, Topic: topic, Type: kind, Data: content}
+	if len(h.history) == historySize {
+		h.history = append(h.history[:0], h.history[1:]...)
+	}
+	h.history = append(h.history, event)
+
+	for s := range h.subscribers[topic] {
+		select {
+		case s.events <- event:
+		default:
+			// too slow: it reconnects and catches up from the history
+			h.drop(s)
+		}
+	}
+}
+
+// Subscribe ..
+func (h *Hub) Subscribe(topics []string, lastID uint64) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []Event
+	if lastID > 0 {
+		oldest := h.lastID + 1
+		if len(h.history) > 0 {
+			oldest = h.history[0].ID
+		}
+		if lastID+1 < oldest || lastID > h.lastID {
+			missed = append(missed, Event{ID: h.lastID, Type: Reset, Data: json.RawMessage("{}")})
+		} else {
+			wanted := map[string]bool{}
+			for _, topic := range topics {
+				wanted[topic] = true
+			}
+			for _, event := range h.history {
+				if event.ID > lastID && wanted[event.Topic] {
+					missed = append(missed, event)
+				}
+			}
+		}
+	}
+
+	events := make(chan Event, bufferSize+len(missed))
+	s := &Subscription{Events: events, events: events, topics: topics, hub: h}
+	for _, event := range missed {
+		events <- event
+	}
+	if h.closed {
+		close(events)
+		return s
+	}
+	for _, topic := range topics {
+		if h.subscribers[topic] == nil {
+			h.subscribers[topic] = map[*Subscription]bool{}
+		}
+		h.subscribers[topic][s] = true
+	}
+	return s
+}
+
+func (h *Hub) remove(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(s)
+}
+
+// drop unsubscribes s and closes its events; h.mu must be held
+func (h *Hub) drop(s *Subscription) {
+	for _, topic := range s.topics {
+		delete(h.subscribers[topic], s)
+		if len(h.subscribers[topic]) == 0 {
+			delete(h.subscribers, topic)
+		}
+	}
+	s.once.Do(func() { close(s.events) })
+}
+
+// ModuleTopic carries the threads and replies of a module
+func ModuleTopic(moduleID int64) string {
+	return "module:" + strconv.FormatInt(moduleID, 10)
+}
+
+// CourseTopic carries the announcements of a course
+func CourseTopic(courseID int64) string {
+	return "course:" + strconv.FormatInt(courseID, 10)
+}
+
+// UserTopic carries a user's grades
+func UserTopic(userID int64) string {
+	return "user:" + strconv.FormatInt(userID, 10)
+}
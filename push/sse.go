@@ -0,0 +1,13 @@
+package push
+
+import (
+	"fmt"
+	"io"
+)
+
+// WriteSSE writes event in the Server-Sent Events format; its data is JSON,
+// which never spans lines
+func WriteSSE(w io.Writer, event Event) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+	return err
+}
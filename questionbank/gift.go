@@ -0,0 +1,262 @@
+package questionbank
+
+import (
+	"fmt"
+	"restAPI/models"
+	"strconv"
+	"strings"
+)
+
+// ParseGIFT parses questions in Moodle's GIFT format
+// (https://docs.moodle.org/en/GIFT_format)
+func ParseGIFT(data []byte) (*Bank, error) {
+	text := strings.TrimPrefix(string(data), "\xef\xbb\xbf")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	bank := newBank()
+	var question []string
+	start := 0
+	flush := func() {
+		if len(question) > 0 {
+			parseGIFTQuestion(bank, fmt.Sprintf("line %d", start), strings.Join(question, "\n"))
+		}
+		question = nil
+	}
+
+	for i, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "//"):
+			continue
+		case strings.HasPrefix(trimmed, "$CATEGORY:"):
+			flush()
+			continue
+		case trimmed == "":
+			// a blank line ends a question, unless inside its answers
+			if !unclosed(strings.Join(question, "\n")) {
+				flush()
+				continue
+			}
+		}
+		if len(question) == 0 {
+			start = i + 1
+		}
+		question = append(question, line)
+	}
+	flush()
+	return bank, nil
+}
+
+// unclosed reports whether s has an unescaped { without its }
+func unclosed(s string) bool {
+	open, _ := unescapedIndex(s, '{')
+	if open < 0 {
+		return false
+	}
+	end, _ := unescapedIndex(s[open:], '}')
+	return end < 0
+}
+
+// unescapedIndex finds the first c in s not preceded by a backslash
+func unescapedIndex(s string, c byte) (int, bool) {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == c {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+var giftEscapes = strings.NewReplacer(`\:`, ":", `\~`, "~", `\=`, "=", `\#`, "#", `\{`, "{", `\}`, "}", `\n`, "\n", `\\`, `\`)
+
+func unescape(s string) string {
+	return strings.TrimSpace(giftEscapes.Replace(s))
+}
+
+// stripFormat removes a leading [html], [moodle], [plain] or [markdown]
+func stripFormat(s string) string {
+	s = strings.TrimSpace(s)
+	for _, format := range []string{"[html]", "[moodle]", "[plain]", "[markdown]"} {
+		if strings.HasPrefix(strings.ToLower(s), format) {
+			return strings.TrimSpace(s[len(format):])
+		}
+	}
+	return s
+}
+
+// giftAnswer is one =right or ~wrong answer, with an optional %weight%
+type giftAnswer struct {
+	right  bool
+	weight float64
+	text   string
+	match  bool
+}
+
+func parseGIFTQuestion(bank *Bank, source string, question string) {
+	question = strings.TrimSpace(question)
+
+	var title string
+	if strings.HasPrefix(question, "::") {
+		if end := strings.Index(question[2:], "::"); end >= 0 {
+			title = unescape(question[2 : end+2])
+			question = question[end+4:]
+		}
+	}
+	question = stripFormat(question)
+
+	open, _ := unescapedIndex(question, '{')
+	if open < 0 {
+		bank.add(source, models.Element{Type: "content", Text: collapse(unescape(question))}, nil)
+		return
+	}
+	length, _ := unescapedIndex(question[open:], '}')
+	if length < 0 {
+		bank.unsupported(source, title, "answers are not closed with }")
+		return
+	}
+	answers := strings.TrimSpace(stripFormat(question[open+1 : open+length]))
+	before, after := unescape(question[:open]), unescape(question[open+length+1:])
+
+	text := before
+	if after != "" {
+		text = before + " _____ " + after // a missing word question
+	}
+	element := models.Element{Text: collapse(text)}
+	if element.Text == "" {
+		element.Text = title
+	}
+
+	switch upper := strings.ToUpper(strings.SplitN(answers, "#", 2)[0]); {
+	case answers == "":
+		element.Type = "essay"
+		bank.add(source, element, nil)
+		return
+	case strings.HasPrefix(answers, "#"):
+		bank.unsupported(source, title, "numerical questions are not supported")
+		return
+	case upper == "T" || upper == "TRUE" || upper == "F" || upper == "FALSE":
+		right := strings.HasPrefix(upper, "T")
+		element.Type = "single"
+		element.Choices = []models.Choice{{Text: "True", Correct: right}, {Text: "False", Correct: !right}}
+		bank.add(source, element, nil)
+		return
+	}
+
+	parsed, err := parseGIFTAnswers(answers)
+	if err != nil {
+		bank.unsupported(source, title, err.Error())
+		return
+	}
+
+	wrong, correct, weighted := 0, 0, false
+	for _, answer := range parsed {
+		if answer.match {
+			bank.unsupported(source, title, "matching questions are not supported")
+			return
+		}
+		if !answer.right {
+			wrong++
+		}
+		if answer.weight != 0 {
+			weighted = true
+		}
+		if answer.right || answer.weight > 0 {
+			correct++
+		}
+	}
+
+	var warnings []string
+	if wrong == 0 {
+		// only right answers: a short answer question
+		accepted := make([]string, 0, len(parsed))
+		for _, answer := range parsed {
+			accepted = append(accepted, answer.text)
+			if answer.weight != 0 && answer.weight != 100 {
+				warnings = append(warnings, fmt.Sprintf("partial credit for %q is scored as correct", answer.text))
+			}
+		}
+		element.Type = "text"
+		element.TextRegex = answerRegex(accepted)
+		bank.add(source, element, warnings)
+		return
+	}
+
+	if correct == 0 {
+		bank.unsupported(source, title, "no answer is marked correct")
+		return
+	}
+	element.Type = "single"
+	if weighted || correct > 1 {
+		element.Type = "multiple"
+		if weighted {
+			warnings = append(warnings, "answer weights are not kept; answers with a positive weight are correct")
+		}
+	}
+	for _, answer := range parsed {
+		element.Choices = append(element.Choices, models.Choice{Text: answer.text, Correct: answer.right || answer.weight > 0})
+	}
+	bank.add(source, element, warnings)
+}
+
+// parseGIFTAnswers splits answers at each unescaped = or ~
+func parseGIFTAnswers(answers string) ([]giftAnswer, error) {
+	var parsed []giftAnswer
+	var current *giftAnswer
+	var b strings.Builder
+	finish := func() error {
+		if current == nil {
+			if strings.TrimSpace(b.String()) != "" {
+				return fmt.Errorf("answers must start with = or ~")
+			}
+			return nil
+		}
+		text := b.String()
+		// feedback follows an unescaped #
+		if i, ok := unescapedIndex(text, '#'); ok {
+			text = text[:i]
+		}
+		if strings.HasPrefix(text, "%") {
+			end := strings.Index(text[1:], "%")
+			if end < 0 {
+				return fmt.Errorf("unclosed answer weight in %q", text)
+			}
+			weight, err := strconv.ParseFloat(text[1:end+1], 64)
+			if err != nil {
+				return fmt.Errorf("invalid answer weight in %q", text)
+			}
+			current.weight = weight
+			text = text[end+2:]
+		}
+		current.match = strings.Contains(text, "->")
+		current.text = unescape(text)
+		parsed = append(parsed, *current)
+		return nil
+	}
+
+	for i := 0; i < len(answers); i++ {
+		c := answers[i]
+		if c == '\\' && i+1 < len(answers) {
+			b.WriteByte(c)
+			b.WriteByte(answers[i+1])
+			i++
+			continue
+		}
+		if c == '=' || c == '~' {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			current = &giftAnswer{right: c == '='}
+			b.Reset()
+			continue
+		}
+		b.WriteByte(c)
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
@@ -0,0 +1,176 @@
+package questionbank
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"restAPI/models"
+)
+
+func TestParseGIFT(t *testing.T) {
+	tests := []struct {
+		name        string
+		gift        string
+		element     *models.Element // the only item, if any
+		accepts     []string        // answers the text question's regex must match
+		warnings    int
+		unsupported string // the only unsupported question's reason, if any
+	}{
+		{
+			name:    "true or false",
+			gift:    "::Sky:: The sky is blue. {T}",
+			element: &models.Element{Type: "single", Text: "The sky is blue.", Choices: []models.Choice{{Text: "True", Correct: true}, {Text: "False"}}},
+		},
+		{
+			name:    "false spelled out",
+			gift:    "The earth is flat. {FALSE#It is not.}",
+			element: &models.Element{Type: "single", Text: "The earth is flat.", Choices: []models.Choice{{Text: "True"}, {Text: "False", Correct: true}}},
+		},
+		{
+			name: "multiple choice with feedback",
+			gift: "Who is buried in Grant's tomb? {=Grant#Right ~Sherman ~Lincoln}",
+			element: &models.Element{Type: "single", Text: "Who is buried in Grant's tomb?",
+				Choices: []models.Choice{{Text: "Grant", Correct: true}, {Text: "Sherman"}, {Text: "Lincoln"}}},
+		},
+		{
+			name: "several right answers by weight",
+			gift: "Pick the primes. {~%50%2 ~%50%3 ~%-100%4}",
+			element: &models.Element{Type: "multiple", Text: "Pick the primes.",
+				Choices: []models.Choice{{Text: "2", Correct: true}, {Text: "3", Correct: true}, {Text: "4"}}},
+			warnings: 1,
+		},
+		{
+			name:    "missing word",
+			gift:    "Grant is {=buried ~born} in Grant's tomb.",
+			element: &models.Element{Type: "single", Text: "Grant is _____ in Grant's tomb.", Choices: []models.Choice{{Text: "buried", Correct: true}, {Text: "born"}}},
+		},
+		{
+			name:    "short answer",
+			gift:    "Two plus two is {=four =4}.",
+			element: &models.Element{Type: "text", Text: "Two plus two is _____ ."},
+			accepts: []string{"four", " FOUR ", "4"},
+		},
+		{
+			name:     "short answer with partial credit",
+			gift:     "Name a colour. {=red =%50%pink}",
+			element:  &models.Element{Type: "text", Text: "Name a colour."},
+			accepts:  []string{"red", "pink"},
+			warnings: 1,
+		},
+		{
+			name:    "essay",
+			gift:    "Write about your summer. {}",
+			element: &models.Element{Type: "essay", Text: "Write about your summer."},
+		},
+		{
+			name:    "description",
+			gift:    "[html]Read the next questions <b>carefully</b>.",
+			element: &models.Element{Type: "content", Text: "Read the next questions <b>carefully</b>."},
+		},
+		{
+			name:    "escaped characters",
+			gift:    `Is 1\=1 \{always\}? {T}`,
+			element: &models.Element{Type: "single", Text: "Is 1=1 {always}?", Choices: []models.Choice{{Text: "True", Correct: true}, {Text: "False"}}},
+		},
+		{
+			name:    "title without text",
+			gift:    "::Capital of France:: {=Paris ~Lyon}",
+			element: &models.Element{Type: "single", Text: "Capital of France", Choices: []models.Choice{{Text: "Paris", Correct: true}, {Text: "Lyon"}}},
+		},
+		{
+			name:        "numerical",
+			gift:        "How many legs has a spider? {#8}",
+			unsupported: "numerical questions are not supported",
+		},
+		{
+			name:        "matching",
+			gift:        "Match them. {=cat -> meow =dog -> woof}",
+			unsupported: "matching questions are not supported",
+		},
+		{
+			name:        "no right answer",
+			gift:        "Pick one. {~a ~b}",
+			unsupported: "no answer is marked correct",
+		},
+		{
+			name:        "unclosed answers",
+			gift:        "Pick one. {=a ~b",
+			unsupported: "answers are not closed with }",
+		},
+		{
+			name:        "text before the first answer",
+			gift:        "Pick one. {a =b ~c}",
+			unsupported: "answers must start with = or ~",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bank, err := ParseGIFT([]byte(test.gift))
+			if err != nil {
+				t.Fatalf("ParseGIFT: %v", err)
+			}
+
+			if test.unsupported != "" {
+				if len(bank.Items) != 0 || len(bank.Unsupported) != 1 || bank.Unsupported[0].Reason != test.unsupported {
+					t.Fatalf("got items %+v and unsupported %+v, want only %q", bank.Items, bank.Unsupported, test.unsupported)
+				}
+				return
+			}
+			if len(bank.Unsupported) != 0 || len(bank.Items) != 1 {
+				t.Fatalf("got items %+v and unsupported %+v, want one item", bank.Items, bank.Unsupported)
+			}
+
+			item := bank.Items[0]
+			got := item.Element
+			regex := got.TextRegex
+			got.TextRegex = ""
+			if !reflect.DeepEqual(&got, test.element) {
+				t.Errorf("got %+v, want %+v", got, *test.element)
+			}
+			for _, answer := range test.accepts {
+				if !regexp.MustCompile(regex).MatchString(answer) {
+					t.Errorf("regex %q does not accept %q", regex, answer)
+				}
+			}
+			if len(item.Warnings) != test.warnings {
+				t.Errorf("got warnings %q, want %d", item.Warnings, test.warnings)
+			}
+		})
+	}
+}
+
+func TestParseGIFTFile(t *testing.T) {
+	gift := strings.Join([]string{
+		"\xef\xbb\xbf// exported from Moodle",
+		"$CATEGORY: $course$/Week 1",
+		"",
+		"::Q1:: First? {T}",
+		"",
+		"Second {",
+		"",
+		"=yes",
+		"~no",
+		"}",
+		"",
+		"Third {#1}",
+	}, "\r\n")
+
+	bank, err := ParseGIFT([]byte(gift))
+	if err != nil {
+		t.Fatalf("ParseGIFT: %v", err)
+	}
+
+	var sources []string
+	for _, item := range bank.Items {
+		sources = append(sources, item.Source)
+	}
+	if want := []string{"line 4", "line 6"}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("got items from %q, want %q", sources, want)
+	}
+	if len(bank.Unsupported) != 1 || bank.Unsupported[0].Source != "line 12" {
+		t.Errorf("got unsupported %+v, want one from line 12", bank.Unsupported)
+	}
+}
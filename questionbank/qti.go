@@ -0,0 +1,369 @@
+package questionbank
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"restAPI/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// largest XML file read from a content package
+const maxQTIFile = 10 << 20
+
+// node is an XML element, or a text node when Name is ""
+type node struct {
+	Name     string
+	Attr     map[string]string
+	Children []*node
+	Text     string
+}
+
+func parseXML(data []byte) (*node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+
+	root := &node{}
+	stack := []*node{root}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &node{Name: t.Name.Local, Attr: map[string]string{}}
+			for _, attr := range t.Attr {
+				n.Attr[attr.Name.Local] = attr.Value
+			}
+			parent.Children = append(parent.Children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.Children = append(parent.Children, &node{Text: string(t)})
+		}
+	}
+
+	for _, n := range root.Children {
+		if n.Name != "" {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("no root element")
+}
+
+// find returns the descendants of n named name, in document order
+func (n *node) find(name string) []*node {
+	var found []*node
+	for _, child := range n.Children {
+		if child.Name == name {
+			found = append(found, child)
+		}
+		found = append(found, child.find(name)...)
+	}
+	return found
+}
+
+func (n *node) first(name string) *node {
+	if found := n.find(name); len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+// QTI block elements, which separate paragraphs in text
+var qtiBlocks = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "blockquote": true, "pre": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "table": true, "tr": true,
+}
+
+// text renders the readable text of n: the text of inline interactions
+// becomes a blank, and only the prompt of block interactions is kept
+func (n *node) text() string {
+	var b strings.Builder
+	n.writeText(&b)
+	return collapse(b.String())
+}
+
+func (n *node) writeText(b *strings.Builder) {
+	if n.Name == "" {
+		b.WriteString(n.Text)
+		return
+	}
+	switch {
+	case n.Name == "textEntryInteraction" || n.Name == "inlineChoiceInteraction":
+		b.WriteString(" _____ ")
+		return
+	case strings.HasSuffix(n.Name, "Interaction"):
+		if prompt := n.first("prompt"); prompt != nil {
+			b.WriteString("\n\n")
+			prompt.writeText(b)
+			b.WriteString("\n\n")
+		}
+		return
+	case n.Name == "img" || n.Name == "object" || n.Name == "rubricBlock" || n.Name == "modalFeedback":
+		return
+	}
+
+	if qtiBlocks[n.Name] {
+		b.WriteString("\n\n")
+	}
+	for _, child := range n.Children {
+		child.writeText(b)
+	}
+	if qtiBlocks[n.Name] {
+		b.WriteString("\n\n")
+	}
+}
+
+// ParseQTI parses a QTI 2.1 assessmentItem, or a content package (zip) of
+// them, in the order of its manifest
+func ParseQTI(data []byte) (*Bank, error) {
+	bank := newBank()
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		root, err := parseXML(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid XML: %w", err)
+		}
+		if root.Name != "assessmentItem" {
+			return nil, fmt.Errorf("expected a QTI assessmentItem, found <%s>", root.Name)
+		}
+		parseItem(bank, "item", root)
+		return bank, nil
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip: %w", err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[path.Clean(f.Name)] = f
+	}
+
+	for _, name := range packageItems(files) {
+		content, err := readZipFile(files[name])
+		if err != nil {
+			bank.unsupported(name, "", err.Error())
+			continue
+		}
+		root, err := parseXML(content)
+		if err != nil {
+			bank.unsupported(name, "", "invalid XML: "+err.Error())
+			continue
+		}
+		if root.Name != "assessmentItem" {
+			continue // tests, sections and metadata
+		}
+		parseItem(bank, name, root)
+	}
+	return bank, nil
+}
+
+// packageItems lists the item files of a content package, as ordered by its
+// manifest, falling back to every XML file by name
+func packageItems(files map[string]*zip.File) []string {
+	var names []string
+	if manifest, ok := files["imsmanifest.xml"]; ok {
+		if content, err := readZipFile(manifest); err == nil {
+			if root, err := parseXML(content); err == nil {
+				for _, resource := range root.find("resource") {
+					href := path.Clean(resource.Attr["href"])
+					if strings.HasPrefix(resource.Attr["type"], "imsqti_item") && files[href] != nil {
+						names = append(names, href)
+					}
+				}
+			}
+		}
+	}
+	if len(names) > 0 {
+		return names
+	}
+
+	for name := range files {
+		if strings.EqualFold(path.Ext(name), ".xml") && name != "imsmanifest.xml" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > maxQTIFile {
+		return nil, fmt.Errorf("file larger than %d MB", maxQTIFile>>20)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	content, err := io.ReadAll(io.LimitReader(r, maxQTIFile+1))
+	if err == nil && len(content) > maxQTIFile {
+		err = fmt.Errorf("file larger than %d MB", maxQTIFile>>20)
+	}
+	return content, err
+}
+
+// qtiResponse is a responseDeclaration: the accepted values of a response
+type qtiResponse struct {
+	cardinality string
+	correct     []string
+}
+
+func parseItem(bank *Bank, source string, item *node) {
+	title := item.Attr["title"]
+	if id := item.Attr["identifier"]; id != "" {
+		source += "#" + id
+	}
+
+	responses := map[string]qtiResponse{}
+	for _, declaration := range item.find("responseDeclaration") {
+		response := qtiResponse{cardinality: declaration.Attr["cardinality"]}
+		if correct := declaration.first("correctResponse"); correct != nil {
+			for _, value := range correct.find("value") {
+				response.correct = append(response.correct, strings.TrimSpace(value.text()))
+			}
+		}
+		// text entries often list further accepted answers in a mapping
+		for _, entry := range declaration.find("mapEntry") {
+			if score, err := strconv.ParseFloat(entry.Attr["mappedValue"], 64); err == nil && score > 0 {
+				response.correct = appendNew(response.correct, entry.Attr["mapKey"])
+			}
+		}
+		responses[declaration.Attr["identifier"]] = response
+	}
+
+	body := item.first("itemBody")
+	if body == nil {
+		bank.unsupported(source, title, "no itemBody")
+		return
+	}
+
+	var interactions []*node
+	for _, n := range allNodes(body) {
+		if strings.HasSuffix(n.Name, "Interaction") {
+			interactions = append(interactions, n)
+		}
+	}
+
+	element := models.Element{Text: body.text()}
+	if element.Text == "" {
+		element.Text = title
+	}
+	warnings := media(body, &element)
+
+	if len(interactions) == 0 {
+		element.Type = "content"
+		bank.add(source, element, warnings)
+		return
+	}
+	if len(interactions) > 1 {
+		bank.unsupported(source, title, fmt.Sprintf("%d interactions in one item; only one is supported", len(interactions)))
+		return
+	}
+
+	interaction := interactions[0]
+	response := responses[interaction.Attr["responseIdentifier"]]
+	switch interaction.Name {
+	case "choiceInteraction":
+		correct := map[string]bool{}
+		for _, value := range response.correct {
+			correct[value] = true
+		}
+		for _, choice := range interaction.find("simpleChoice") {
+			element.Choices = append(element.Choices, models.Choice{
+				Text:    choice.text(),
+				Correct: correct[choice.Attr["identifier"]],
+			})
+		}
+		if len(response.correct) == 0 {
+			bank.unsupported(source, title, "choiceInteraction without a correct response")
+			return
+		}
+		element.Type = "single"
+		if interaction.Attr["maxChoices"] != "1" || response.cardinality == "multiple" || len(response.correct) > 1 {
+			element.Type = "multiple"
+		}
+	case "textEntryInteraction":
+		if len(response.correct) == 0 {
+			bank.unsupported(source, title, "textEntryInteraction without a correct response")
+			return
+		}
+		element.Type = "text"
+		element.TextRegex = answerRegex(response.correct)
+	case "extendedTextInteraction":
+		element.Type = "essay"
+	case "uploadInteraction":
+		element.Type = "file"
+	default:
+		bank.unsupported(source, title, interaction.Name+" is not supported")
+		return
+	}
+	bank.add(source, element, warnings)
+}
+
+func allNodes(n *node) []*node {
+	var nodes []*node
+	for _, child := range n.Children {
+		if child.Name != "" {
+			nodes = append(nodes, child)
+			nodes = append(nodes, allNodes(child)...)
+		}
+	}
+	return nodes
+}
+
+// media sets the element's image and video from the first of each in the
+// body; files inside the package are not imported
+func media(body *node, element *models.Element) []string {
+	var warnings []string
+	for _, n := range allNodes(body) {
+		var src, kind, caption string
+		switch n.Name {
+		case "img":
+			src, kind, caption = n.Attr["src"], "image", n.Attr["alt"]
+		case "object":
+			src, kind = n.Attr["data"], strings.SplitN(n.Attr["type"], "/", 2)[0]
+		default:
+			continue
+		}
+
+		if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+			warnings = append(warnings, "media file "+src+" was not imported")
+			continue
+		}
+		switch {
+		case kind == "image" && element.ImageLocation == "":
+			element.ImageLocation, element.ImageCaption = src, caption
+		case kind == "video" && element.VideoLocation == "":
+			element.VideoLocation = src
+		default:
+			warnings = append(warnings, "only one image and one video are kept; "+src+" was dropped")
+		}
+	}
+	return warnings
+}
+
+func appendNew(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,218 @@
+package questionbank
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"restAPI/models"
+)
+
+// qtiItem wraps response declarations and an item body in an assessmentItem
+func qtiItem(identifier string, declarations string, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<assessmentItem xmlns="http://www.imsglobal.org/xsd/imsqti_v2p1" identifier="` + identifier + `" title="Title of ` + identifier + `">
+` + declarations + `
+<itemBody>` + body + `</itemBody>
+</assessmentItem>`
+}
+
+const singleChoice = `<responseDeclaration identifier="RESPONSE" cardinality="single" baseType="identifier">
+  <correctResponse><value>B</value></correctResponse>
+</responseDeclaration>`
+
+func TestParseQTIItem(t *testing.T) {
+	tests := []struct {
+		name         string
+		declarations string
+		body         string
+		element      *models.Element // the only item, if any
+		accepts      []string        // answers the text question's regex must match
+		warnings     int
+		unsupported  string // the only unsupported question's reason, if any
+	}{
+		{
+			name:         "single choice",
+			declarations: singleChoice,
+			body: `<p>Which is blue?</p><choiceInteraction responseIdentifier="RESPONSE" maxChoices="1">
+				<prompt>Pick one</prompt>
+				<simpleChoice identifier="A">Grass</simpleChoice><simpleChoice identifier="B">The sky</simpleChoice>
+			</choiceInteraction>`,
+			element: &models.Element{Type: "single", Text: "Which is blue?\n\nPick one",
+				Choices: []models.Choice{{Text: "Grass"}, {Text: "The sky", Correct: true}}},
+		},
+		{
+			name: "multiple choice",
+			declarations: `<responseDeclaration identifier="RESPONSE" cardinality="multiple" baseType="identifier">
+				<correctResponse><value>A</value><value>C</value></correctResponse>
+			</responseDeclaration>`,
+			body: `<choiceInteraction responseIdentifier="RESPONSE" maxChoices="0">
+				<prompt>Pick the primes</prompt>
+				<simpleChoice identifier="A">2</simpleChoice><simpleChoice identifier="B">4</simpleChoice><simpleChoice identifier="C">5</simpleChoice>
+			</choiceInteraction>`,
+			element: &models.Element{Type: "multiple", Text: "Pick the primes",
+				Choices: []models.Choice{{Text: "2", Correct: true}, {Text: "4"}, {Text: "5", Correct: true}}},
+		},
+		{
+			name: "text entry with mapped answers",
+			declarations: `<responseDeclaration identifier="RESPONSE" cardinality="single" baseType="string">
+				<correctResponse><value>York</value></correctResponse>
+				<mapping defaultValue="0"><mapEntry mapKey="York" mappedValue="1"/><mapEntry mapKey="Jorvik" mappedValue="0.5"/><mapEntry mapKey="Leeds" mappedValue="0"/></mapping>
+			</responseDeclaration>`,
+			body:    `<p>The Vikings took <textEntryInteraction responseIdentifier="RESPONSE"/> in 866.</p>`,
+			element: &models.Element{Type: "text", Text: "The Vikings took _____ in 866."},
+			accepts: []string{"York", "jorvik"},
+		},
+		{
+			name:         "extended text",
+			declarations: `<responseDeclaration identifier="RESPONSE" cardinality="single" baseType="string"/>`,
+			body:         `<extendedTextInteraction responseIdentifier="RESPONSE"><prompt>Describe your day.</prompt></extendedTextInteraction>`,
+			element:      &models.Element{Type: "essay", Text: "Describe your day."},
+		},
+		{
+			name:         "upload",
+			declarations: `<responseDeclaration identifier="RESPONSE" cardinality="single" baseType="file"/>`,
+			body:         `<p>Upload your essay.</p><uploadInteraction responseIdentifier="RESPONSE"/>`,
+			element:      &models.Element{Type: "file", Text: "Upload your essay."},
+		},
+		{
+			name:     "content with media",
+			body:     `<p>Look at this &amp; that.</p><img src="https://example.com/a.png" alt="A"/><img src="local.png"/>`,
+			element:  &models.Element{Type: "content", Text: "Look at this & that.", ImageLocation: "https://example.com/a.png", ImageCaption: "A"},
+			warnings: 1,
+		},
+		{
+			name:    "title without text",
+			body:    ``,
+			element: &models.Element{Type: "content", Text: "Title of q1"},
+		},
+		{
+			name:         "choice without a correct response",
+			declarations: `<responseDeclaration identifier="RESPONSE" cardinality="single" baseType="identifier"/>`,
+			body:         `<choiceInteraction responseIdentifier="RESPONSE" maxChoices="1"><simpleChoice identifier="A">A</simpleChoice></choiceInteraction>`,
+			unsupported:  "choiceInteraction without a correct response",
+		},
+		{
+			name:         "text entry without a correct response",
+			declarations: `<responseDeclaration identifier="RESPONSE" cardinality="single" baseType="string"/>`,
+			body:         `<p><textEntryInteraction responseIdentifier="RESPONSE"/></p>`,
+			unsupported:  "textEntryInteraction without a correct response",
+		},
+		{
+			name:        "unsupported interaction",
+			body:        `<orderInteraction responseIdentifier="RESPONSE"/>`,
+			unsupported: "orderInteraction is not supported",
+		},
+		{
+			name:         "several interactions",
+			declarations: singleChoice,
+			body:         `<extendedTextInteraction responseIdentifier="A"/><extendedTextInteraction responseIdentifier="B"/>`,
+			unsupported:  "2 interactions in one item; only one is supported",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bank, err := ParseQTI([]byte(qtiItem("q1", test.declarations, test.body)))
+			if err != nil {
+				t.Fatalf("ParseQTI: %v", err)
+			}
+
+			if test.unsupported != "" {
+				if len(bank.Items) != 0 || len(bank.Unsupported) != 1 || bank.Unsupported[0].Reason != test.unsupported {
+					t.Fatalf("got items %+v and unsupported %+v, want only %q", bank.Items, bank.Unsupported, test.unsupported)
+				}
+				if bank.Unsupported[0].Source != "item#q1" || bank.Unsupported[0].Title != "Title of q1" {
+					t.Errorf("got source %q and title %q", bank.Unsupported[0].Source, bank.Unsupported[0].Title)
+				}
+				return
+			}
+			if len(bank.Unsupported) != 0 || len(bank.Items) != 1 {
+				t.Fatalf("got items %+v and unsupported %+v, want one item", bank.Items, bank.Unsupported)
+			}
+
+			item := bank.Items[0]
+			if item.Source != "item#q1" {
+				t.Errorf("got source %q, want item#q1", item.Source)
+			}
+			got := item.Element
+			regex := got.TextRegex
+			got.TextRegex = ""
+			if !reflect.DeepEqual(&got, test.element) {
+				t.Errorf("got %+v, want %+v", got, *test.element)
+			}
+			for _, answer := range test.accepts {
+				if !regexp.MustCompile(regex).MatchString(answer) {
+					t.Errorf("regex %q does not accept %q", regex, answer)
+				}
+			}
+			if regex != "" && regexp.MustCompile(regex).MatchString("Leeds") {
+				t.Errorf("regex %q accepts an answer mapped to no score", regex)
+			}
+			if len(item.Warnings) != test.warnings {
+				t.Errorf("got warnings %q, want %d", item.Warnings, test.warnings)
+			}
+		})
+	}
+}
+
+func TestParseQTIRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "not XML", data: "<assessmentItem><itemBody>"},
+		{name: "no root element", data: "just text"},
+		{name: "another root element", data: `<assessmentTest identifier="t"/>`},
+		{name: "broken zip", data: "PK\x03\x04 not really"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if bank, err := ParseQTI([]byte(test.data)); err == nil {
+				t.Errorf("got %+v, want an error", bank)
+			}
+		})
+	}
+}
+
+func TestParseQTIPackage(t *testing.T) {
+	files := map[string]string{
+		"imsmanifest.xml": `<manifest><resources>
+			<resource identifier="r2" type="imsqti_item_xmlv2p1" href="items/second.xml"/>
+			<resource identifier="r1" type="imsqti_item_xmlv2p1" href="items/first.xml"/>
+			<resource identifier="t" type="imsqti_test_xmlv2p1" href="test.xml"/>
+			<resource identifier="gone" type="imsqti_item_xmlv2p1" href="items/missing.xml"/>
+		</resources></manifest>`,
+		"items/first.xml":  qtiItem("first", "", "<p>First</p>"),
+		"items/second.xml": qtiItem("second", "", "<p>Second</p>"),
+		"items/other.xml":  qtiItem("other", "", "<p>Not in the manifest</p>"),
+		"test.xml":         `<assessmentTest identifier="t"/>`,
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bank, err := ParseQTI(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseQTI: %v", err)
+	}
+	var sources []string
+	for _, item := range bank.Items {
+		sources = append(sources, item.Source)
+	}
+	if want := []string{"items/second.xml#second", "items/first.xml#first"}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("got items %q, want %q in manifest order", sources, want)
+	}
+}
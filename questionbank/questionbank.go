@@ -0,0 +1,105 @@
+// Package questionbank parses question banks exported by other tools (IMS
+// QTI 2.1 and Moodle GIFT) into Elements
+package questionbank
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"restAPI/models"
+	"strings"
+)
+
+// Formats which can be imported
+const (
+	FormatQTI  = "qti"
+	FormatGIFT = "gift"
+)
+
+// Item is a question converted to an Element. Source identifies it in the
+// imported file; Warnings list what was lost in the conversion.
+type Item struct {
+	Source   string         `json:"source"`
+	Element  models.Element `json:"element"`
+	Warnings []string       `json:"warnings,omitempty"`
+}
+
+// Unsupported is a question which could not be converted, and why
+type Unsupported struct {
+	Source string `json:"source"`
+	Title  string `json:"title,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Bank is the result of parsing a file, in the file's order
+type Bank struct {
+	Items       []Item        `json:"items"`
+	Unsupported []Unsupported `json:"unsupported"`
+}
+
+func newBank() *Bank {
+	return &Bank{Items: []Item{}, Unsupported: []Unsupported{}}
+}
+
+func (b *Bank) add(source string, element models.Element, warnings []string) {
+	b.Items = append(b.Items, Item{Source: source, Element: element, Warnings: warnings})
+}
+
+func (b *Bank) unsupported(source string, title string, reason string) {
+	b.Unsupported = append(b.Unsupported, Unsupported{Source: source, Title: title, Reason: reason})
+}
+
+// Detect guesses the format of a file from its name and content, returning
+// "" if it is neither
+func Detect(name string, data []byte) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".gift":
+		return FormatGIFT
+	case ".xml", ".zip":
+		return FormatQTI
+	}
+
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("PK\x03\x04")), bytes.HasPrefix(trimmed, []byte("<")):
+		return FormatQTI
+	case len(trimmed) > 0 && bytes.ContainsAny(trimmed, "{}"):
+		return FormatGIFT
+	}
+	return ""
+}
+
+// Parse parses data in the given format
+func Parse(format string, data []byte) (*Bank, error) {
+	switch format {
+	case FormatQTI:
+		return ParseQTI(data)
+	case FormatGIFT:
+		return ParseGIFT(data)
+	}
+	return nil, fmt.Errorf("unknown format %q: must be %s or %s", format, FormatQTI, FormatGIFT)
+}
+
+// answerRegex matches any of the accepted answers of a text question,
+// ignoring case and surrounding space
+func answerRegex(answers []string) string {
+	quoted := make([]string, len(answers))
+	for i, answer := range answers {
+		quoted[i] = regexp.QuoteMeta(strings.TrimSpace(answer))
+	}
+	return `(?i)^\s*(` + strings.Join(quoted, "|") + `)\s*$`
+}
+
+var spaces = regexp.MustCompile(`[ \t\r\n\x{00a0}]+`)
+
+// collapse joins runs of white space as HTML would, keeping paragraph breaks
+func collapse(s string) string {
+	var paragraphs []string
+	for _, paragraph := range strings.Split(s, "\n\n") {
+		if paragraph = strings.TrimSpace(spaces.ReplaceAllString(paragraph, " ")); paragraph != "" {
+			paragraphs = append(paragraphs, paragraph)
+		}
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
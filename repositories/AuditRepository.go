@@ -0,0 +1,121 @@
+package repositories
+
+import (
+	"context"
+	"restAPI/models"
+	"sort"
+
+	"cloud.google.com/go/datastore"
+)
+
+// the single AuditHead entity
+var auditHeadKey = datastore.NameKey("AuditHead", "head", nil)
+
+// newAuditRepository
+func NewAuditRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
+
+	return &BaseRepository{
+		client: instrument(client),
+		ctx:    ctx,
+	}
+}
+
+// AppendAuditEntry chains the entry onto the AuditHead and stores both in one
+// transaction, so that concurrent appends cannot fork the chain
+func (r *BaseRepository) AppendAuditEntry(entry *models.AuditEntry) (*datastore.Key, error) {
+	var pending *datastore.PendingKey
+
+	commit, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		head := models.AuditHead{}
+		if err := tx.Get(auditHeadKey, &head); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		entry.Seq = head.Seq + 1
+		entry.PrevHash = head.Hash
+		entry.Hash = entry.ComputeHash()
+
+		var err error
+		pending, err = tx.Put(datastore.IncompleteKey("AuditEntry", nil), entry)
+		if err != nil {
+			return err
+		}
+
+		head.Seq = entry.Seq
+		head.Hash = entry.Hash
+		_, err = tx.Put(auditHeadKey, &head)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key := commit.Key(pending)
+	entry.KeyID = key.ID
+	return key, nil
+}
+
+// GetAllAuditEntries returns the whole chain, in order
+func (r *BaseRepository) GetAllAuditEntries() ([]*models.AuditEntry, error) {
+	var entries []*models.AuditEntry
+	query := datastore.NewQuery("AuditEntry").Order("Seq")
+	keys, err := r.client.GetAll(r.ctx, query, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		entries[i].KeyID = key.ID
+	}
+
+	return entries, nil
+}
+
+// GetAuditEntries returns the entries matching the filter, in order.
+// Only equality filters go to Datastore (they need no composite index);
+// the time range and ordering are applied here.
+func (r *BaseRepository) GetAuditEntries(filter models.AuditFilter) ([]*models.AuditEntry, error) {
+	query := datastore.NewQuery("AuditEntry")
+	if filter.Actor != "" {
+		query = query.Filter("Actor =", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Filter("Action =", filter.Action)
+	}
+	if filter.TargetKind != "" {
+		query = query.Filter("TargetKind =", filter.TargetKind)
+	}
+	if filter.TargetID != 0 {
+		query = query.Filter("TargetID =", filter.TargetID)
+	}
+
+	var entries []*models.AuditEntry
+	keys, err := r.client.GetAll(r.ctx, query, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	matching := make([]*models.AuditEntry, 0, len(entries))
+	for i, entry := range entries {
+		entry.KeyID = keys[i].ID
+		if !filter.Since.IsZero() && entry.Timestamp.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !entry.Timestamp.Before(filter.Until) {
+			continue
+		}
+		matching = append(matching, entry)
+	}
+
+	sort.Slice(matching, func(i, j int) bool { return matching[i].Seq < matching[j].Seq })
+	return matching, nil
+}
+
+// GetAuditHead returns the head of the chain, or an empty head if nothing was audited yet
+func (r *BaseRepository) GetAuditHead() (*models.AuditHead, error) {
+	head := models.AuditHead{}
+	if err := r.client.Get(r.ctx, auditHeadKey, &head); err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+	return &head, nil
+}
@@ -11,7 +11,7 @@ import (
 func NewCourseRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
 
 	return &BaseRepository{
-		client: client,
+		client: instrument(client),
 		ctx:    ctx,
 	}
 }
@@ -33,6 +33,25 @@ func (r *BaseRepository) CreateCourse(Course *models.Course) (*datastore.Key, er
 	return k1, err
 }
 
+// ListCourses returns one page of courses and the cursor of the next
+func (r *BaseRepository) ListCourses(opts models.QueryOptions) ([]*models.Course, string, error) {
+	courses, keys, next, err := list[models.Course](r, "Course", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i, key := range keys {
+		courses[i].KeyID = key.ID
+	}
+
+	return courses, next, nil
+}
+
+// CountCourses returns how many courses match the filters of opts
+func (r *BaseRepository) CountCourses(opts models.QueryOptions) (int, error) {
+	return count(r, "Course", opts)
+}
+
 // GetAllCourses returns all Courses
 func (r *BaseRepository) GetAllCourses() ([]*models.Course, error) {
 	var Courses []*models.Course
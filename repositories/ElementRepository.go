@@ -11,7 +11,7 @@ import (
 func NewElementRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
 
 	return &BaseRepository{
-		client: client,
+		client: instrument(client),
 		ctx:    ctx,
 	}
 }
@@ -33,6 +33,25 @@ func (r *BaseRepository) CreateElement(Element *models.Element) (*datastore.Key,
 	return k1, err
 }
 
+// ListElements returns one page of elements and the cursor of the next
+func (r *BaseRepository) ListElements(opts models.QueryOptions) ([]*models.Element, string, error) {
+	elements, keys, next, err := list[models.Element](r, "Element", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i, key := range keys {
+		elements[i].KeyID = key.ID
+	}
+
+	return elements, next, nil
+}
+
+// CountElements returns how many elements match the filters of opts
+func (r *BaseRepository) CountElements(opts models.QueryOptions) (int, error) {
+	return count(r, "Element", opts)
+}
+
 // GetAllElements returns all Elements
 func (r *BaseRepository) GetAllElements() ([]*models.Element, error) {
 	var Elements []*models.Element
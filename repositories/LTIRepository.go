@@ -0,0 +1,157 @@
+package repositories
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"restAPI/models"
+	"strconv"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// NewLTIRepository
+func NewLTIRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
+
+	return &BaseRepository{
+		client: instrument(client),
+		ctx:    ctx,
+	}
+}
+
+// CreateLTIPlatform registers a platform
+func (r *BaseRepository) CreateLTIPlatform(platform *models.LTIPlatform) (*datastore.Key, error) {
+	return r.client.Put(r.ctx, datastore.IncompleteKey("LTIPlatform", nil), platform)
+}
+
+// GetAllLTIPlatforms returns every registered platform
+func (r *BaseRepository) GetAllLTIPlatforms() ([]*models.LTIPlatform, error) {
+	var platforms []*models.LTIPlatform
+	keys, err := r.client.GetAll(r.ctx, datastore.NewQuery("LTIPlatform"), &platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		platforms[i].KeyID = key.ID
+	}
+	return platforms, nil
+}
+
+// GetLTIPlatformByID returns a platform by id
+func (r *BaseRepository) GetLTIPlatformByID(id int64) (*models.LTIPlatform, error) {
+	platform := new(models.LTIPlatform)
+	if err := r.client.Get(r.ctx, datastore.IDKey("LTIPlatform", id, nil), platform); err != nil {
+		return nil, err
+	}
+
+	platform.KeyID = id
+	return platform, nil
+}
+
+// GetLTIPlatformByIssuer returns the platform registered with issuer and
+// client ID; with clientID "", the first registered with issuer
+func (r *BaseRepository) GetLTIPlatformByIssuer(issuer string, clientID string) (*models.LTIPlatform, error) {
+	platform := new(models.LTIPlatform)
+	query := datastore.NewQuery("LTIPlatform").FilterField("Issuer", "=", issuer)
+	if clientID != "" {
+		query = query.FilterField("ClientID", "=", clientID)
+	}
+
+	key, err := r.client.Run(r.ctx, query).Next(platform)
+	if err == iterator.Done {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	platform.KeyID = key.ID
+	return platform, nil
+}
+
+// UpdateLTIPlatform replaces a platform
+func (r *BaseRepository) UpdateLTIPlatform(id int64, platform *models.LTIPlatform) (*datastore.Key, error) {
+	return r.client.Put(r.ctx, datastore.IDKey("LTIPlatform", id, nil), platform)
+}
+
+// DeleteLTIPlatform deletes a platform
+func (r *BaseRepository) DeleteLTIPlatform(id int64) error {
+	return r.client.Delete(r.ctx, datastore.IDKey("LTIPlatform", id, nil))
+}
+
+// identities and links are keyed by what identifies them on the platform,
+// hashed because issuers and IDs may be long
+func ltiKey(kind string, parts ...string) *datastore.Key {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return datastore.NameKey(kind, hex.EncodeToString(h.Sum(nil)), nil)
+}
+
+// GetLTIIdentity returns the user a platform's user was provisioned as
+func (r *BaseRepository) GetLTIIdentity(issuer string, subject string) (*models.LTIIdentity, error) {
+	identity := new(models.LTIIdentity)
+	if err := r.client.Get(r.ctx, ltiKey("LTIIdentity", issuer, subject), identity); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// GetLTIIdentitiesByUserID returns a user's identities on every platform
+func (r *BaseRepository) GetLTIIdentitiesByUserID(userID int64) ([]*models.LTIIdentity, error) {
+	var identities []*models.LTIIdentity
+	query := datastore.NewQuery("LTIIdentity").FilterField("UserID", "=", userID)
+	if _, err := r.client.GetAll(r.ctx, query, &identities); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// HasLTIIdentities reports whether any user was provisioned from an issuer
+func (r *BaseRepository) HasLTIIdentities(issuer string) (bool, error) {
+	query := datastore.NewQuery("LTIIdentity").FilterField("Issuer", "=", issuer).Limit(1).KeysOnly()
+	keys, err := r.client.GetAll(r.ctx, query, nil)
+	if err != nil {
+		return false, err
+	}
+	return len(keys) > 0, nil
+}
+
+// SaveLTIIdentity creates or replaces an identity
+func (r *BaseRepository) SaveLTIIdentity(identity *models.LTIIdentity) error {
+	_, err := r.client.Put(r.ctx, ltiKey("LTIIdentity", identity.Issuer, identity.Subject), identity)
+	return err
+}
+
+func ltiLinkKey(platformID int64, deploymentID string, resourceLinkID string) *datastore.Key {
+	return ltiKey("LTILink", strconv.FormatInt(platformID, 10), deploymentID, resourceLinkID)
+}
+
+// GetLTILink returns a resource link placed on a platform
+func (r *BaseRepository) GetLTILink(platformID int64, deploymentID string, resourceLinkID string) (*models.LTILink, error) {
+	link := new(models.LTILink)
+	if err := r.client.Get(r.ctx, ltiLinkKey(platformID, deploymentID, resourceLinkID), link); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// SaveLTILink creates or replaces a resource link
+func (r *BaseRepository) SaveLTILink(link *models.LTILink) error {
+	_, err := r.client.Put(r.ctx, ltiLinkKey(link.PlatformID, link.DeploymentID, link.ResourceLinkID), link)
+	return err
+}
+
+// GetLTILinksByModuleID returns the resource links which launch a module
+func (r *BaseRepository) GetLTILinksByModuleID(moduleID int64) ([]*models.LTILink, error) {
+	var links []*models.LTILink
+	query := datastore.NewQuery("LTILink").FilterField("ModuleID", "=", moduleID)
+	if _, err := r.client.GetAll(r.ctx, query, &links); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
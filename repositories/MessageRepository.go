@@ -0,0 +1,268 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"restAPI/models"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// how many participants are written in one transaction
+const participantBatch = 250
+
+// how much of the last message an inbox shows
+const previewLength = 140
+
+// NewMessageRepository
+func NewMessageRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
+
+	return &BaseRepository{
+		client: instrument(client),
+		ctx:    ctx,
+	}
+}
+
+// there is one Participant per conversation and user
+func participantKey(conversationID int64, userID int64) *datastore.Key {
+	return datastore.NameKey("Participant", fmt.Sprintf("%d-%d", conversationID, userID), nil)
+}
+
+// and one Block per blocker and blocked user
+func blockKey(blockerID int64, blockedID int64) *datastore.Key {
+	return datastore.NameKey("Block", fmt.Sprintf("%d-%d", blockerID, blockedID), nil)
+}
+
+// CreateConversation stores a conversation and puts it in the inbox of each
+// participant; usernames name the participants, for the others' inboxes
+func (r *BaseRepository) CreateConversation(conversation *models.Conversation, usernames map[int64]string) (*datastore.Key, error) {
+	key, err := r.client.Put(r.ctx, datastore.IncompleteKey("Conversation", nil), conversation)
+	if err != nil {
+		return nil, err
+	}
+	conversation.KeyID = key.ID
+
+	ids := conversation.ParticipantIDs
+	for start := 0; start < len(ids); start += participantBatch {
+		batch := ids[start:]
+		if len(batch) > participantBatch {
+			batch = batch[:participantBatch]
+		}
+
+		keys := make([]*datastore.Key, 0, len(batch))
+		participants := make([]*models.Participant, 0, len(batch))
+		for _, userID := range batch {
+			// the sender of a broadcast has it with the whole course
+			with := usernames[conversation.CreatedBy]
+			if userID == conversation.CreatedBy {
+				with = ""
+				for _, other := range ids {
+					if other != userID && !conversation.Broadcast {
+						with = usernames[other]
+					}
+				}
+			}
+			keys = append(keys, participantKey(key.ID, userID))
+			participants = append(participants, &models.Participant{
+				ConversationID: key.ID,
+				UserID:         userID,
+				CourseID:       conversation.CourseID,
+				Subject:        conversation.Subject,
+				With:           with,
+				Broadcast:      conversation.Broadcast,
+				LastMessageOn:  conversation.CreatedOn,
+				LastReadOn:     conversation.CreatedOn,
+			})
+		}
+		_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+			_, err := tx.PutMulti(keys, participants)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// GetConversationByID ..
+func (r *BaseRepository) GetConversationByID(id int64) (*models.Conversation, error) {
+	conversation := new(models.Conversation)
+	if err := r.client.Get(r.ctx, datastore.IDKey("Conversation", id, nil), conversation); err != nil {
+		return nil, err
+	}
+	conversation.KeyID = id
+	return conversation, nil
+}
+
+// SendMessage stores a message and updates the inbox of every participant:
+// the sender's is read up to the message, the others' count it as unread
+func (r *BaseRepository) SendMessage(message *models.Message) (*datastore.Key, error) {
+	conversation, err := r.GetConversationByID(message.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := r.client.Put(r.ctx, datastore.IncompleteKey("Message", nil), message)
+	if err != nil {
+		return nil, err
+	}
+	message.KeyID = key.ID
+
+	preview := []rune(message.Body)
+	if len(preview) > previewLength {
+		preview = append(preview[:previewLength-1], '…')
+	}
+
+	ids := conversation.ParticipantIDs
+	for start := 0; start < len(ids); start += participantBatch {
+		batch := ids[start:]
+		if len(batch) > participantBatch {
+			batch = batch[:participantBatch]
+		}
+		_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+			keys := make([]*datastore.Key, 0, len(batch))
+			participants := make([]*models.Participant, 0, len(batch))
+			for _, userID := range batch {
+				participantKey := participantKey(conversation.KeyID, userID)
+				participant := new(models.Participant)
+				if err := tx.Get(participantKey, participant); err == datastore.ErrNoSuchEntity {
+					continue
+				} else if err != nil {
+					return err
+				}
+				participant.LastMessage = string(preview)
+				participant.LastMessageOn = message.SentOn
+				if userID == message.SenderID {
+					participant.Unread = 0
+					participant.LastReadOn = message.SentOn
+				} else {
+					participant.Unread++
+				}
+				keys = append(keys, participantKey)
+				participants = append(participants, participant)
+			}
+			_, err := tx.PutMulti(keys, participants)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// GetMessageByID ..
+func (r *BaseRepository) GetMessageByID(id int64) (*models.Message, error) {
+	message := new(models.Message)
+	if err := r.client.Get(r.ctx, datastore.IDKey("Message", id, nil), message); err != nil {
+		return nil, err
+	}
+	message.KeyID = id
+	return message, nil
+}
+
+// ListMessages returns one page of messages and the cursor of the next
+func (r *BaseRepository) ListMessages(opts models.QueryOptions) ([]*models.Message, string, error) {
+	messages, keys, next, err := list[models.Message](r, "Message", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i, key := range keys {
+		messages[i].KeyID = key.ID
+	}
+
+	return messages, next, nil
+}
+
+// CountMessages returns how many messages match the filters of opts
+func (r *BaseRepository) CountMessages(opts models.QueryOptions) (int, error) {
+	return count(r, "Message", opts)
+}
+
+// ListParticipants returns one page of inbox entries and the cursor of the next
+func (r *BaseRepository) ListParticipants(opts models.QueryOptions) ([]*models.Participant, string, error) {
+	participants, _, next, err := list[models.Participant](r, "Participant", opts)
+	return participants, next, err
+}
+
+// CountParticipants returns how many inbox entries match the filters of opts
+func (r *BaseRepository) CountParticipants(opts models.QueryOptions) (int, error) {
+	return count(r, "Participant", opts)
+}
+
+// CountUnreadMessages adds up the unread messages in the user's conversations
+func (r *BaseRepository) CountUnreadMessages(userID int64) (int, error) {
+	query := datastore.NewQuery("Participant").FilterField("UserID", "=", userID).FilterField("Unread", ">", 0)
+	unread := 0
+	it := r.client.Run(r.ctx, query)
+	for {
+		participant := new(models.Participant)
+		_, err := it.Next(participant)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		unread += participant.Unread
+	}
+	return unread, nil
+}
+
+// MarkConversationRead clears the user's unread count of a conversation
+func (r *BaseRepository) MarkConversationRead(conversationID int64, userID int64) error {
+	key := participantKey(conversationID, userID)
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		participant := new(models.Participant)
+		if err := tx.Get(key, participant); err != nil {
+			return err
+		}
+		participant.Unread = 0
+		participant.LastReadOn = time.Now()
+		_, err := tx.Put(key, participant)
+		return err
+	})
+	return err
+}
+
+// BlockUser stores a block; blocking again changes nothing
+func (r *BaseRepository) BlockUser(block *models.Block) error {
+	key := blockKey(block.BlockerID, block.BlockedID)
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		if err := tx.Get(key, new(models.Block)); err == nil {
+			return nil
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		_, err := tx.Put(key, block)
+		return err
+	})
+	return err
+}
+
+// UnblockUser removes a block, if there is one
+func (r *BaseRepository) UnblockUser(blockerID int64, blockedID int64) error {
+	return r.client.Delete(r.ctx, blockKey(blockerID, blockedID))
+}
+
+// IsBlocked reports whether blocker blocked blocked
+func (r *BaseRepository) IsBlocked(blockerID int64, blockedID int64) (bool, error) {
+	err := r.client.Get(r.ctx, blockKey(blockerID, blockedID), new(models.Block))
+	if err == datastore.ErrNoSuchEntity {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetBlocksByBlockerID returns the users a user blocked
+func (r *BaseRepository) GetBlocksByBlockerID(blockerID int64) ([]*models.Block, error) {
+	blocks := []*models.Block{}
+	query := datastore.NewQuery("Block").FilterField("BlockerID", "=", blockerID)
+	if _, err := r.client.GetAll(r.ctx, query, &blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
@@ -11,7 +11,7 @@ import (
 func NewModuleElementRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
 
 	return &BaseRepository{
-		client: client,
+		client: instrument(client),
 		ctx:    ctx,
 	}
 }
@@ -37,6 +37,16 @@ func (r *BaseRepository) GetAllModuleElements() ([]*models.ModuleElement, error)
 	return ModuleElements, nil
 }
 
+// GetModuleElementByID returns a ModuleElement by its ID
+func (r *BaseRepository) GetModuleElementByID(id int64) (*models.ModuleElement, error) {
+	moduleElement := new(models.ModuleElement)
+	if err := r.client.Get(r.ctx, datastore.IDKey("ModuleElement", id, nil), moduleElement); err != nil {
+		return nil, err
+	}
+	moduleElement.KeyID = id
+	return moduleElement, nil
+}
+
 // DeleteModuleElement deletes a ModuleElement
 func (r *BaseRepository) DeleteModuleElement(id int64) error {
 	return r.client.Delete(r.ctx, datastore.IDKey("ModuleElement", id, nil))
@@ -87,6 +97,10 @@ func (r *BaseRepository) GetModuleElementByModuleIDAndElementID(moduleID int64,
 		return nil, err
 	}
 
+	if len(ModuleElements) == 0 {
+		return nil, datastore.ErrNoSuchEntity
+	}
+
 	// add key values to ModuleElements
 	for i, key := range keys {
 		ModuleElements[i].KeyID = key.ID
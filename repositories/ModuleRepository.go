@@ -11,7 +11,7 @@ import (
 func NewModuleRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
 
 	return &BaseRepository{
-		client: client,
+		client: instrument(client),
 		ctx:    ctx,
 	}
 }
@@ -0,0 +1,151 @@
+package repositories
+
+import (
+	"context"
+	"restAPI/models"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// how many notifications are marked read in one transaction
+const markReadBatch = 250
+
+// NewNotificationRepository
+func NewNotificationRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
+
+	return &BaseRepository{
+		client: instrument(client),
+		ctx:    ctx,
+	}
+}
+
+// CreateNotification stores a notification in its user's inbox
+func (r *BaseRepository) CreateNotification(notification *models.Notification) (*datastore.Key, error) {
+	key, err := r.client.Put(r.ctx, datastore.IncompleteKey("Notification", nil), notification)
+	if err != nil {
+		return nil, err
+	}
+	notification.KeyID = key.ID
+	return key, nil
+}
+
+// ListNotifications returns one page of notifications and the cursor of the next
+func (r *BaseRepository) ListNotifications(opts models.QueryOptions) ([]*models.Notification, string, error) {
+	notifications, keys, next, err := list[models.Notification](r, "Notification", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i, key := range keys {
+		notifications[i].KeyID = key.ID
+	}
+
+	return notifications, next, nil
+}
+
+// CountNotifications returns how many notifications match the filters of opts
+func (r *BaseRepository) CountNotifications(opts models.QueryOptions) (int, error) {
+	return count(r, "Notification", opts)
+}
+
+// MarkNotificationsRead marks the user's notifications with the given IDs as
+// read, or all of them if there are none, and returns how many changed.
+// Notifications of other users are left alone.
+func (r *BaseRepository) MarkNotificationsRead(userID int64, ids []int64) (int, error) {
+	var keys []*datastore.Key
+	if len(ids) == 0 {
+		query := datastore.NewQuery("Notification").FilterField("UserID", "=", userID).
+			FilterField("Read", "=", false).KeysOnly()
+		var err error
+		if keys, err = r.client.GetAll(r.ctx, query, nil); err != nil {
+			return 0, err
+		}
+	} else {
+		for _, id := range ids {
+			keys = append(keys, datastore.IDKey("Notification", id, nil))
+		}
+	}
+
+	marked := 0
+	now := time.Now()
+	for start := 0; start < len(keys); start += markReadBatch {
+		batch := keys[start:]
+		if len(batch) > markReadBatch {
+			batch = batch[:markReadBatch]
+		}
+
+		changed := 0
+		_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+			changed = 0
+			for _, key := range batch {
+				notification := new(models.Notification)
+				if err := tx.Get(key, notification); err == datastore.ErrNoSuchEntity {
+					continue
+				} else if err != nil {
+					return err
+				}
+				if notification.UserID != userID || notification.Read {
+					continue
+				}
+				notification.Read = true
+				notification.ReadOn = now
+				if _, err := tx.Put(key, notification); err != nil {
+					return err
+				}
+				changed++
+			}
+			return nil
+		})
+		if err != nil {
+			return marked, err
+		}
+		marked += changed
+	}
+	return marked, nil
+}
+
+// GetNotificationPreferences returns the user's preferences, or the defaults
+// if they never saved any
+func (r *BaseRepository) GetNotificationPreferences(userID int64) (*models.NotificationPreferences, error) {
+	preferences := &models.NotificationPreferences{}
+	err := r.client.Get(r.ctx, datastore.IDKey("NotificationPreferences", userID, nil), preferences)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+	preferences.UserID = userID
+	preferences.Complete()
+	return preferences, nil
+}
+
+// SaveNotificationPreferences replaces the user's preferences
+func (r *BaseRepository) SaveNotificationPreferences(preferences *models.NotificationPreferences) error {
+	_, err := r.client.Put(r.ctx, datastore.IDKey("NotificationPreferences", preferences.UserID, nil), preferences)
+	return err
+}
+
+// reminder records that a reminder went out, so that it goes out only once
+type reminder struct {
+	ClaimedOn time.Time
+}
+
+// ClaimReminder reports whether the named reminder is yours to send; it is
+// true only the first time, even across instances
+func (r *BaseRepository) ClaimReminder(name string) (bool, error) {
+	key := datastore.NameKey("Reminder", name, nil)
+	claimed := false
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		claimed = false
+		if err := tx.Get(key, new(reminder)); err == nil {
+			return nil
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if _, err := tx.Put(key, &reminder{ClaimedOn: time.Now()}); err != nil {
+			return err
+		}
+		claimed = true
+		return nil
+	})
+	return claimed, err
+}
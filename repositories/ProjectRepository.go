@@ -11,7 +11,7 @@ import (
 func NewProjectRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
 
 	return &BaseRepository{
-		client: client,
+		client: instrument(client),
 		ctx:    ctx,
 	}
 }
@@ -21,6 +21,25 @@ func (r *BaseRepository) CreateProject(Project *models.Project) (*datastore.Key,
 	return r.client.Put(r.ctx, datastore.IncompleteKey("Project", nil), Project)
 }
 
+// ListProjects returns one page of projects and the cursor of the next
+func (r *BaseRepository) ListProjects(opts models.QueryOptions) ([]*models.Project, string, error) {
+	projects, keys, next, err := list[models.Project](r, "Project", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i, key := range keys {
+		projects[i].KeyID = key.ID
+	}
+
+	return projects, next, nil
+}
+
+// CountProjects returns how many projects match the filters of opts
+func (r *BaseRepository) CountProjects(opts models.QueryOptions) (int, error) {
+	return count(r, "Project", opts)
+}
+
 // GetAllProjects returns all Projects
 func (r *BaseRepository) GetAllProjects() ([]*models.Project, error) {
 	var Projects []*models.Project
@@ -0,0 +1,265 @@
+package repositories
+
+import (
+	"context"
+	"restAPI/models"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// NewReplyRepository
+func NewReplyRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
+
+	return &BaseRepository{
+		client: instrument(client),
+		ctx:    ctx,
+	}
+}
+
+// CreateReply stores a reply, counting it on its thread and bumping the
+// thread and the reply it answers in the same transaction
+func (r *BaseRepository) CreateReply(reply *models.Reply) (*datastore.Key, error) {
+	var pk *datastore.PendingKey
+
+	commit, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		threadKey := datastore.IDKey("Thread", reply.ThreadID, nil)
+		thread := new(models.Thread)
+		if err := tx.Get(threadKey, thread); err != nil {
+			return err
+		}
+
+		// answering a reply bumps it too
+		if reply.ParentID != 0 {
+			parentKey := datastore.IDKey("Reply", reply.ParentID, nil)
+			parent := new(models.Reply)
+			if err := tx.Get(parentKey, parent); err != nil {
+				return err
+			}
+			parent.BumpedOn = reply.CreatedOn
+			if _, err := tx.Put(parentKey, parent); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		pk, err = tx.Put(datastore.IncompleteKey("Reply", nil), reply)
+		if err != nil {
+			return err
+		}
+
+		thread.ReplyCount++
+		thread.BumpedOn = reply.CreatedOn
+		_, err = tx.Put(threadKey, thread)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return commit.Key(pk), nil
+}
+
+// GetReplyByID returns a reply by id
+func (r *BaseRepository) GetReplyByID(id int64) (*models.Reply, error) {
+	reply := new(models.Reply)
+	if err := r.client.Get(r.ctx, datastore.IDKey("Reply", id, nil), reply); err != nil {
+		return nil, err
+	}
+
+	reply.KeyID = id
+	return reply, nil
+}
+
+// EditReply replaces the body of a reply, keeping the previous one in its
+// history, and returns the edited reply
+func (r *BaseRepository) EditReply(id int64, body string, editorID int64) (*models.Reply, error) {
+	key := datastore.IDKey("Reply", id, nil)
+	reply := new(models.Reply)
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		*reply = models.Reply{}
+		if err := tx.Get(key, reply); err != nil {
+			return err
+		}
+		if reply.Deleted {
+			return models.ErrReplyDeleted
+		}
+		if reply.Body == body {
+			return nil
+		}
+		reply.Edit(body, editorID, time.Now())
+		_, err := tx.Put(key, reply)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reply.KeyID = id
+	return reply, nil
+}
+
+// DeleteReply replaces a reply with a tombstone, so that the replies
+// answering it keep their place, and takes it off its thread's reply count
+func (r *BaseRepository) DeleteReply(id int64) error {
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		key := datastore.IDKey("Reply", id, nil)
+		reply := new(models.Reply)
+		if err := tx.Get(key, reply); err != nil {
+			return err
+		}
+		if reply.Deleted {
+			return nil
+		}
+		reply.Deleted = true
+		reply.Body = ""
+		reply.History = nil
+		if _, err := tx.Put(key, reply); err != nil {
+			return err
+		}
+
+		threadKey := datastore.IDKey("Thread", reply.ThreadID, nil)
+		thread := new(models.Thread)
+		if err := tx.Get(threadKey, thread); err == datastore.ErrNoSuchEntity {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if thread.ReplyCount > 0 {
+			thread.ReplyCount--
+		}
+		_, err := tx.Put(threadKey, thread)
+		return err
+	})
+	return err
+}
+
+// ListReplies returns one page of replies and the cursor of the next
+func (r *BaseRepository) ListReplies(opts models.QueryOptions) ([]*models.Reply, string, error) {
+	replies, keys, next, err := list[models.Reply](r, "Reply", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i, key := range keys {
+		replies[i].KeyID = key.ID
+	}
+
+	return replies, next, nil
+}
+
+// CountReplies returns how many replies match the filters of opts
+func (r *BaseRepository) CountReplies(opts models.QueryOptions) (int, error) {
+	return count(r, "Reply", opts)
+}
+
+// GetRepliesByThreadID returns every reply of a thread, oldest first
+func (r *BaseRepository) GetRepliesByThreadID(threadID int64) ([]*models.Reply, error) {
+	var replies []*models.Reply
+	query := datastore.NewQuery("Reply").FilterField("ThreadID", "=", threadID).Order("CreatedOn")
+	keys, err := r.client.GetAll(r.ctx, query, &replies)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		replies[i].KeyID = key.ID
+	}
+
+	return replies, nil
+}
+
+// GetAllReplies returns all replies
+func (r *BaseRepository) GetAllReplies() ([]*models.Reply, error) {
+	var replies []*models.Reply
+	keys, err := r.client.GetAll(r.ctx, datastore.NewQuery("Reply"), &replies)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		replies[i].KeyID = key.ID
+	}
+
+	return replies, nil
+}
+
+// DeleteRepliesByThreadID removes every reply of a thread
+func (r *BaseRepository) DeleteRepliesByThreadID(threadID int64) error {
+	query := datastore.NewQuery("Reply").FilterField("ThreadID", "=", threadID).KeysOnly()
+	keys, err := r.client.GetAll(r.ctx, query, nil)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := r.client.Delete(r.ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateReplies moves the replies embedded in a thread into Reply entities,
+// in one transaction so that they are moved exactly once, and returns how
+// many were moved
+func (r *BaseRepository) MigrateReplies(threadID int64) (int, error) {
+	moved := 0
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		threadKey := datastore.IDKey("Thread", threadID, nil)
+		thread := new(models.Thread)
+		if err := tx.Get(threadKey, thread); err != nil {
+			return err
+		}
+		moved = len(thread.Replies)
+		if moved == 0 {
+			return nil
+		}
+
+		keys := make([]*datastore.Key, moved)
+		replies := make([]*models.Reply, moved)
+		for i, embedded := range thread.Replies {
+			reply := embedded
+			reply.ThreadID = threadID
+			reply.Score = reply.Upvotes - reply.Downvotes
+			// keep the order of replies which were saved without a date
+			if reply.CreatedOn.IsZero() {
+				reply.CreatedOn = thread.CreatedOn.Add(time.Duration(i) * time.Millisecond)
+			}
+			keys[i] = datastore.IncompleteKey("Reply", nil)
+			replies[i] = &reply
+		}
+		if _, err := tx.PutMulti(keys, replies); err != nil {
+			return err
+		}
+
+		thread.Replies = nil
+		thread.ReplyCount += moved
+		_, err := tx.Put(threadKey, thread)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return moved, nil
+}
+
+// ModerateReply applies a moderator's change to a reply and returns it
+func (r *BaseRepository) ModerateReply(id int64, moderate func(reply *models.Reply)) (*models.Reply, error) {
+	key := datastore.IDKey("Reply", id, nil)
+	reply := new(models.Reply)
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		*reply = models.Reply{}
+		if err := tx.Get(key, reply); err != nil {
+			return err
+		}
+		moderate(reply)
+		_, err := tx.Put(key, reply)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reply.KeyID = id
+	return reply, nil
+}
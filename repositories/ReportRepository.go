@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"restAPI/models"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// NewReportRepository
+func NewReportRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
+
+	return &BaseRepository{
+		client: instrument(client),
+		ctx:    ctx,
+	}
+}
+
+// there is one Report per reporter and post; reporting again replaces it
+func reportKey(kind string, postID int64, reporterID int64) *datastore.Key {
+	return datastore.NameKey("Report", fmt.Sprintf("%s-%d-%d", kind, postID, reporterID), nil)
+}
+
+// setReported flags or unflags a thread, reply or message in a transaction
+func setReported(tx *datastore.Transaction, kind string, postID int64, reported bool) error {
+	key := datastore.IDKey(kind, postID, nil)
+	var post interface{}
+	var flag *bool
+	switch kind {
+	case models.PostThread:
+		thread := new(models.Thread)
+		post, flag = thread, &thread.Reported
+	case models.PostReply:
+		reply := new(models.Reply)
+		post, flag = reply, &reply.Reported
+	case models.PostMessage:
+		message := new(models.Message)
+		post, flag = message, &message.Reported
+	default:
+		return fmt.Errorf("cannot report %q", kind)
+	}
+	if err := tx.Get(key, post); err != nil {
+		return err
+	}
+	if *flag == reported {
+		return nil
+	}
+	*flag = reported
+	_, err := tx.Put(key, post)
+	return err
+}
+
+// CreateReport stores a report and flags the post as reported in the same transaction
+func (r *BaseRepository) CreateReport(report *models.Report) (*datastore.Key, error) {
+	key := reportKey(report.Kind, report.PostID, report.ReporterID)
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		if err := setReported(tx, report.Kind, report.PostID, true); err != nil {
+			return err
+		}
+		_, err := tx.Put(key, report)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ListReports returns one page of reports and the cursor of the next
+func (r *BaseRepository) ListReports(opts models.QueryOptions) ([]*models.Report, string, error) {
+	reports, _, next, err := list[models.Report](r, "Report", opts)
+	return reports, next, err
+}
+
+// CountReports returns how many reports match the filters of opts
+func (r *BaseRepository) CountReports(opts models.QueryOptions) (int, error) {
+	return count(r, "Report", opts)
+}
+
+// ResolveReports closes the open reports on a post and clears its flag,
+// returning how many were closed
+func (r *BaseRepository) ResolveReports(kind string, postID int64, resolution string, moderatorID int64) (int, error) {
+	query := datastore.NewQuery("Report").FilterField("Kind", "=", kind).FilterField("PostID", "=", postID).
+		FilterField("Resolved", "=", false).KeysOnly()
+	keys, err := r.client.GetAll(r.ctx, query, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resolved := 0
+	_, err = r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		resolved = 0
+		now := time.Now()
+		for _, key := range keys {
+			report := new(models.Report)
+			if err := tx.Get(key, report); err == datastore.ErrNoSuchEntity {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if report.Resolved {
+				continue
+			}
+			report.Resolved = true
+			report.Resolution = resolution
+			report.ResolvedBy = moderatorID
+			report.ResolvedOn = now
+			if _, err := tx.Put(key, report); err != nil {
+				return err
+			}
+			resolved++
+		}
+		return setReported(tx, kind, postID, false)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return resolved, nil
+}
+
+// DeleteReportsByThreadID removes the reports on a thread and its replies
+func (r *BaseRepository) DeleteReportsByThreadID(threadID int64) error {
+	query := datastore.NewQuery("Report").FilterField("ThreadID", "=", threadID).KeysOnly()
+	keys, err := r.client.GetAll(r.ctx, query, nil)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := r.client.Delete(r.ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -11,7 +11,7 @@ import (
 func NewRoleRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
 
 	return &BaseRepository{
-		client: client,
+		client: instrument(client),
 		ctx:    ctx,
 	}
 }
@@ -46,6 +46,7 @@ func (r *BaseRepository) GetRoleByID(id int64) (*models.Role, error) {
 		return nil, err
 	}
 
+	Role.KeyID = k.ID
 	return Role, nil
 }
 
@@ -64,6 +65,7 @@ func (r *BaseRepository) GetRoleByName(Rolename string) (*models.Role, error) {
 		return nil, err
 	}
 
+	Role.KeyID = key.ID
 	return Role, nil
 }
 
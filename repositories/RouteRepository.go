@@ -11,7 +11,7 @@ import (
 func NewRouteRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
 
 	return &BaseRepository{
-		client: client,
+		client: instrument(client),
 		ctx:    ctx,
 	}
 }
@@ -46,6 +46,7 @@ func (r *BaseRepository) GetRouteByID(id int64) (*models.Route, error) {
 		return nil, err
 	}
 
+	Route.KeyID = k.ID
 	return Route, nil
 }
 
@@ -64,6 +65,7 @@ func (r *BaseRepository) GetRouteByName(Routename string) (*models.Route, error)
 		return nil, err
 	}
 
+	Route.KeyID = key.ID
 	return Route, nil
 }
 
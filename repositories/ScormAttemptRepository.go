@@ -0,0 +1,53 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"restAPI/models"
+
+	"cloud.google.com/go/datastore"
+)
+
+// NewScormAttemptRepository
+func NewScormAttemptRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
+
+	return &BaseRepository{
+		client: instrument(client),
+		ctx:    ctx,
+	}
+}
+
+// there is one ScormAttempt per user, module and element
+func scormAttemptKey(userID int64, moduleID int64, elementID int64) *datastore.Key {
+	return datastore.NameKey("ScormAttempt", fmt.Sprintf("%d-%d-%d", userID, moduleID, elementID), nil)
+}
+
+// GetScormAttempt returns the runtime data of an element in a user's current attempt
+func (r *BaseRepository) GetScormAttempt(userID int64, moduleID int64, elementID int64) (*models.ScormAttempt, error) {
+	attempt := &models.ScormAttempt{}
+	if err := r.client.Get(r.ctx, scormAttemptKey(userID, moduleID, elementID), attempt); err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
+
+// SaveScormAttempt creates or replaces the attempt
+func (r *BaseRepository) SaveScormAttempt(attempt *models.ScormAttempt) error {
+	_, err := r.client.Put(r.ctx, scormAttemptKey(attempt.UserID, attempt.ModuleID, attempt.ElementID), attempt)
+	return err
+}
+
+// DeleteScormAttempts removes the runtime data of every element of a user's attempt at a module
+func (r *BaseRepository) DeleteScormAttempts(userID int64, moduleID int64) error {
+	query := datastore.NewQuery("ScormAttempt").FilterField("UserID", "=", userID).FilterField("ModuleID", "=", moduleID).KeysOnly()
+	keys, err := r.client.GetAll(r.ctx, query, nil)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := r.client.Delete(r.ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
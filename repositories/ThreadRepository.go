@@ -11,7 +11,7 @@ import (
 func NewThreadRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
 
 	return &BaseRepository{
-		client: client,
+		client: instrument(client),
 		ctx:    ctx,
 	}
 }
@@ -33,6 +33,25 @@ func (r *BaseRepository) CreateThread(Thread *models.Thread) (*datastore.Key, er
 	return k1, err
 }
 
+// ListThreads returns one page of threads and the cursor of the next
+func (r *BaseRepository) ListThreads(opts models.QueryOptions) ([]*models.Thread, string, error) {
+	threads, keys, next, err := list[models.Thread](r, "Thread", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i, key := range keys {
+		threads[i].KeyID = key.ID
+	}
+
+	return threads, next, nil
+}
+
+// CountThreads returns how many threads match the filters of opts
+func (r *BaseRepository) CountThreads(opts models.QueryOptions) (int, error) {
+	return count(r, "Thread", opts)
+}
+
 // GetAllThreads returns all Threads
 func (r *BaseRepository) GetAllThreads() ([]*models.Thread, error) {
 	var Threads []*models.Thread
@@ -61,9 +80,39 @@ func (r *BaseRepository) GetThreadByID(id int64) (*models.Thread, error) {
 	return Thread, nil
 }
 
-// UpdateThread updates a Thread
+// UpdateThread updates a Thread, keeping what only its author, replies, votes
+// and moderators change, and the module it was posted in: the author, module,
+// dates, reply count, any replies not yet migrated, the vote counters and the
+// moderation flags
 func (r *BaseRepository) UpdateThread(id int64, Thread *models.Thread) (*datastore.Key, error) {
-	return r.client.Put(r.ctx, datastore.IDKey("Thread", id, nil), Thread)
+	key := datastore.IDKey("Thread", id, nil)
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		existing := new(models.Thread)
+		if err := tx.Get(key, existing); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		Thread.Author = existing.Author
+		Thread.AuthorID = existing.AuthorID
+		Thread.ModuleID = existing.ModuleID
+		Thread.CreatedOn = existing.CreatedOn
+		Thread.BumpedOn = existing.BumpedOn
+		Thread.ReplyCount = existing.ReplyCount
+		Thread.Replies = existing.Replies
+		Thread.Upvotes = existing.Upvotes
+		Thread.Downvotes = existing.Downvotes
+		Thread.Closed = existing.Closed
+		Thread.Pinned = existing.Pinned
+		Thread.Hidden = existing.Hidden
+		Thread.Reported = existing.Reported
+		Thread.Rank()
+
+		_, err := tx.Put(key, Thread)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
 }
 
 // DeleteThread deletes a Thread
@@ -86,3 +135,40 @@ func (r *BaseRepository) GetAllThreadsByModuleID(moduleID int64) ([]*models.Thre
 
 	return Threads, nil
 }
+
+// RankThread recomputes the score and hot rank of a thread, for threads
+// saved before they were ranked
+func (r *BaseRepository) RankThread(id int64) error {
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		key := datastore.IDKey("Thread", id, nil)
+		thread := new(models.Thread)
+		if err := tx.Get(key, thread); err != nil {
+			return err
+		}
+		thread.Rank()
+		_, err := tx.Put(key, thread)
+		return err
+	})
+	return err
+}
+
+// ModerateThread applies a moderator's change to a thread and returns it
+func (r *BaseRepository) ModerateThread(id int64, moderate func(thread *models.Thread)) (*models.Thread, error) {
+	key := datastore.IDKey("Thread", id, nil)
+	thread := new(models.Thread)
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		*thread = models.Thread{}
+		if err := tx.Get(key, thread); err != nil {
+			return err
+		}
+		moderate(thread)
+		_, err := tx.Put(key, thread)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	thread.KeyID = id
+	return thread, nil
+}
@@ -0,0 +1,176 @@
+package repositories
+
+import (
+	"context"
+	"restAPI/models"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// NewUploadRepository
+func NewUploadRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
+
+	return &BaseRepository{
+		client: instrument(client),
+		ctx:    ctx,
+	}
+}
+
+// CreateUpload stores a new upload and sets its KeyID
+func (r *BaseRepository) CreateUpload(upload *models.Upload) (*datastore.Key, error) {
+	key, err := r.client.Put(r.ctx, datastore.IncompleteKey("Upload", nil), upload)
+	if err != nil {
+		return nil, err
+	}
+	upload.KeyID = key.ID
+	return key, nil
+}
+
+// GetUploadByID returns an upload by id
+func (r *BaseRepository) GetUploadByID(id int64) (*models.Upload, error) {
+	upload := new(models.Upload)
+	if err := r.client.Get(r.ctx, datastore.IDKey("Upload", id, nil), upload); err != nil {
+		return nil, err
+	}
+	upload.KeyID = id
+	return upload, nil
+}
+
+// UpdateUpload replaces an upload
+func (r *BaseRepository) UpdateUpload(upload *models.Upload) error {
+	_, err := r.client.Put(r.ctx, datastore.IDKey("Upload", upload.KeyID, nil), upload)
+	return err
+}
+
+// RemoveUpload deletes an upload in a transaction and returns it
+func (r *BaseRepository) RemoveUpload(id int64) (*models.Upload, error) {
+	key := datastore.IDKey("Upload", id, nil)
+	upload := new(models.Upload)
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		*upload = models.Upload{}
+		if err := tx.Get(key, upload); err != nil {
+			return err
+		}
+		if upload.Status == models.UploadAssembling && upload.ExpiresOn.After(time.Now()) {
+			return models.ErrUploadAssembling
+		}
+		return tx.Delete(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	upload.KeyID = id
+	return upload, nil
+}
+
+// AdvanceUpload moves an open upload past a chunk in a transaction, so that
+// of two clients sending the same chunk only one succeeds
+func (r *BaseRepository) AdvanceUpload(id int64, offset int64, length int64, chunk string, expiresOn time.Time) (*models.Upload, error) {
+	key := datastore.IDKey("Upload", id, nil)
+	upload := new(models.Upload)
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		*upload = models.Upload{}
+		if err := tx.Get(key, upload); err != nil {
+			return err
+		}
+		if upload.Status != models.UploadOpen || upload.Offset != offset || offset+length > upload.Size {
+			return models.ErrOffsetMismatch
+		}
+		upload.Chunks = append(upload.Chunks, chunk)
+		upload.Offset += length
+		upload.ExpiresOn = expiresOn
+		if upload.Offset == upload.Size {
+			upload.Status = models.UploadAssembling
+		}
+		_, err := tx.Put(key, upload)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	upload.KeyID = id
+	return upload, nil
+}
+
+// GetExpiredUploads returns the uploads which expired before the given time
+func (r *BaseRepository) GetExpiredUploads(before time.Time) ([]*models.Upload, error) {
+	var uploads []*models.Upload
+	query := datastore.NewQuery("Upload").FilterField("ExpiresOn", "<", before)
+	keys, err := r.client.GetAll(r.ctx, query, &uploads)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		uploads[i].KeyID = key.ID
+	}
+	return uploads, nil
+}
+
+// GetCourseStorage returns how much a course stores
+func (r *BaseRepository) GetCourseStorage(courseID int64) (*models.CourseStorage, error) {
+	storage := new(models.CourseStorage)
+	if err := r.client.Get(r.ctx, datastore.IDKey("CourseStorage", courseID, nil), storage); err != nil {
+		return nil, err
+	}
+	storage.CourseID = courseID
+	return storage, nil
+}
+
+// ReserveCourseStorage counts bytes against a course's quota in a
+// transaction, so that concurrent uploads cannot overrun it together
+func (r *BaseRepository) ReserveCourseStorage(courseID int64, bytes int64, quota int64, initial int64) (*models.CourseStorage, error) {
+	return r.changeCourseStorage(courseID, initial, func(storage *models.CourseStorage) error {
+		limit := quota
+		if storage.Limit > 0 {
+			limit = storage.Limit
+		}
+		if storage.Used+bytes > limit {
+			return models.ErrQuotaExceeded
+		}
+		storage.Used += bytes
+		return nil
+	})
+}
+
+// ReleaseCourseStorage stops counting bytes against a course's quota
+func (r *BaseRepository) ReleaseCourseStorage(courseID int64, bytes int64) error {
+	_, err := r.changeCourseStorage(courseID, 0, func(storage *models.CourseStorage) error {
+		if storage.Used -= bytes; storage.Used < 0 {
+			storage.Used = 0
+		}
+		return nil
+	})
+	return err
+}
+
+// SetCourseStorageLimit replaces a course's quota; 0 restores the default
+func (r *BaseRepository) SetCourseStorageLimit(courseID int64, limit int64, initial int64) (*models.CourseStorage, error) {
+	return r.changeCourseStorage(courseID, initial, func(storage *models.CourseStorage) error {
+		storage.Limit = limit
+		return nil
+	})
+}
+
+func (r *BaseRepository) changeCourseStorage(courseID int64, initial int64, change func(*models.CourseStorage) error) (*models.CourseStorage, error) {
+	key := datastore.IDKey("CourseStorage", courseID, nil)
+	storage := new(models.CourseStorage)
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		*storage = models.CourseStorage{}
+		if err := tx.Get(key, storage); err == datastore.ErrNoSuchEntity {
+			storage.Used = initial
+		} else if err != nil {
+			return err
+		}
+		if err := change(storage); err != nil {
+			return err
+		}
+		_, err := tx.Put(key, storage)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	storage.CourseID = courseID
+	return storage, nil
+}
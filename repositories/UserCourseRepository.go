@@ -11,7 +11,7 @@ import (
 func NewUserCourseRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
 
 	return &BaseRepository{
-		client: client,
+		client: instrument(client),
 		ctx:    ctx,
 	}
 }
@@ -37,6 +37,16 @@ func (r *BaseRepository) GetAllUserCourses() ([]*models.UserCourse, error) {
 	return UserCourses, nil
 }
 
+// GetUserCourseByID returns a UserCourse by id
+func (r *BaseRepository) GetUserCourseByID(id int64) (*models.UserCourse, error) {
+	userCourse := new(models.UserCourse)
+	if err := r.client.Get(r.ctx, datastore.IDKey("UserCourse", id, nil), userCourse); err != nil {
+		return nil, err
+	}
+	userCourse.KeyID = id
+	return userCourse, nil
+}
+
 // DeleteUserCourse deletes a UserCourse
 func (r *BaseRepository) DeleteUserCourse(id int64) error {
 	return r.client.Delete(r.ctx, datastore.IDKey("UserCourse", id, nil))
@@ -78,6 +88,10 @@ func (r *BaseRepository) GetUserCourseByUserIDAndCourseID(userID int64, courseID
 		return nil, err
 	}
 
+	if len(UserCourses) == 0 {
+		return nil, datastore.ErrNoSuchEntity
+	}
+
 	// add key values to UserCourses
 	for i, key := range keys {
 		UserCourses[i].KeyID = key.ID
@@ -8,7 +8,7 @@ import (
 )
 
 type BaseRepository struct {
-	client *datastore.Client
+	client instrumentedClient
 	ctx    context.Context
 }
 
@@ -16,7 +16,7 @@ type BaseRepository struct {
 func NewUserRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
 
 	return &BaseRepository{
-		client: client,
+		client: instrument(client),
 		ctx:    ctx,
 	}
 }
@@ -26,6 +26,25 @@ func (r *BaseRepository) CreateUser(user *models.User) (*datastore.Key, error) {
 	return r.client.Put(r.ctx, datastore.IncompleteKey("User", nil), user)
 }
 
+// ListUsers returns one page of users and the cursor of the next
+func (r *BaseRepository) ListUsers(opts models.QueryOptions) ([]*models.User, string, error) {
+	users, keys, next, err := list[models.User](r, "User", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i, key := range keys {
+		users[i].KeyID = key.ID
+	}
+
+	return users, next, nil
+}
+
+// CountUsers returns how many users match the filters of opts
+func (r *BaseRepository) CountUsers(opts models.QueryOptions) (int, error) {
+	return count(r, "User", opts)
+}
+
 // GetAllUsers returns all users
 func (r *BaseRepository) GetAllUsers() ([]*models.User, error) {
 	var users []*models.User
@@ -51,6 +70,7 @@ func (r *BaseRepository) GetUserByID(id int64) (*models.User, error) {
 		return nil, err
 	}
 
+	user.KeyID = k.ID
 	return user, nil
 }
 
@@ -69,6 +89,7 @@ func (r *BaseRepository) GetUserByUsername(username string) (*models.User, error
 		return nil, err
 	}
 
+	user.KeyID = key.ID
 	return user, nil
 }
 
@@ -99,5 +120,15 @@ func (r *BaseRepository) GetUserByUsernameAndPassword(username string, password
 		return nil, err
 	}
 
+	user.KeyID = key.ID
 	return user, nil
 }
+
+// Ping checks that Datastore answers, by looking up a key that never exists
+func (r *BaseRepository) Ping(ctx context.Context) error {
+	err := r.client.Get(ctx, datastore.NameKey("HealthCheck", "ping", nil), &struct{}{})
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	return err
+}
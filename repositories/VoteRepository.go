@@ -0,0 +1,125 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"restAPI/models"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// NewVoteRepository
+func NewVoteRepository(client *datastore.Client, ctx context.Context) *BaseRepository {
+
+	return &BaseRepository{
+		client: instrument(client),
+		ctx:    ctx,
+	}
+}
+
+// there is one Vote per user and post
+func voteKey(kind string, postID int64, userID int64) *datastore.Key {
+	return datastore.NameKey("Vote", fmt.Sprintf("%s-%d-%d", kind, postID, userID), nil)
+}
+
+// CastVote records a user's vote on a thread or a reply, replacing their
+// previous vote on it; a Value of 0 retracts the vote. The post's counters
+// change in the same transaction, so that every vote counts exactly once.
+func (r *BaseRepository) CastVote(vote *models.Vote) (*models.VoteTally, error) {
+	tally := &models.VoteTally{}
+	_, err := r.client.RunInTransaction(r.ctx, func(tx *datastore.Transaction) error {
+		postKey := datastore.IDKey(vote.Kind, vote.PostID, nil)
+		var post interface{}
+		var upvotes, downvotes, score *int
+		var thread *models.Thread
+		switch vote.Kind {
+		case models.PostThread:
+			thread = new(models.Thread)
+			post, upvotes, downvotes, score = thread, &thread.Upvotes, &thread.Downvotes, &thread.Score
+		case models.PostReply:
+			reply := new(models.Reply)
+			post, upvotes, downvotes, score = reply, &reply.Upvotes, &reply.Downvotes, &reply.Score
+		default:
+			return fmt.Errorf("cannot vote on %q", vote.Kind)
+		}
+		if err := tx.Get(postKey, post); err != nil {
+			return err
+		}
+
+		key := voteKey(vote.Kind, vote.PostID, vote.UserID)
+		previous := new(models.Vote)
+		if err := tx.Get(key, previous); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if previous.Value == vote.Value {
+			*tally = models.VoteTally{Vote: vote.Value, Upvotes: *upvotes, Downvotes: *downvotes, Score: *score}
+			return nil
+		}
+
+		count := func(value int, delta int) {
+			switch value {
+			case 1:
+				*upvotes += delta
+			case -1:
+				*downvotes += delta
+			}
+		}
+		count(previous.Value, -1)
+		count(vote.Value, 1)
+		*score = *upvotes - *downvotes
+		if thread != nil {
+			thread.Rank()
+		}
+
+		if vote.Value == 0 {
+			if err := tx.Delete(key); err != nil {
+				return err
+			}
+		} else {
+			if previous.CreatedOn.IsZero() {
+				vote.CreatedOn = time.Now()
+			} else {
+				vote.CreatedOn = previous.CreatedOn
+			}
+			if _, err := tx.Put(key, vote); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Put(postKey, post); err != nil {
+			return err
+		}
+
+		*tally = models.VoteTally{Vote: vote.Value, Upvotes: *upvotes, Downvotes: *downvotes, Score: *score}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tally, nil
+}
+
+// GetVotesByUserAndThread returns a user's votes on a thread and its replies
+func (r *BaseRepository) GetVotesByUserAndThread(userID int64, threadID int64) ([]*models.Vote, error) {
+	var votes []*models.Vote
+	query := datastore.NewQuery("Vote").FilterField("UserID", "=", userID).FilterField("ThreadID", "=", threadID)
+	if _, err := r.client.GetAll(r.ctx, query, &votes); err != nil {
+		return nil, err
+	}
+	return votes, nil
+}
+
+// DeleteVotesByThreadID removes the votes on a thread and its replies
+func (r *BaseRepository) DeleteVotesByThreadID(threadID int64) error {
+	query := datastore.NewQuery("Vote").FilterField("ThreadID", "=", threadID).KeysOnly()
+	keys, err := r.client.GetAll(r.ctx, query, nil)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := r.client.Delete(r.ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package repositories
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"unicode"
+
+	"restAPI/metrics"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	datastoreCalls = metrics.NewCounterVec("datastore_calls_total",
+		"Datastore calls, by repository method and Datastore operation.", "method", "operation")
+	datastoreErrors = metrics.NewCounterVec("datastore_errors_total",
+		"Failed Datastore calls, by repository method and Datastore operation.", "method", "operation")
+)
+
+// instrumentedClient counts every Datastore call, and its failures, against
+// the repository method that made it
+type instrumentedClient struct {
+	*datastore.Client
+}
+
+func instrument(client *datastore.Client) instrumentedClient {
+	return instrumentedClient{Client: client}
+}
+
+// repositoryMethod returns the name of the BaseRepository method calling
+// into the client, e.g. "GetUserByID", skipping unexported helpers such as list
+func repositoryMethod() string {
+	pcs := make([]uintptr, 8)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(4, pcs)])
+	for {
+		frame, more := frames.Next()
+		name := frame.Function
+
+		// closures (e.g. transaction bodies) are named Method.func1, and
+		// generic functions name[...]
+		name = strings.TrimSuffix(name, ".func1")
+		name = strings.TrimSuffix(name, "[...]")
+		name = name[strings.LastIndex(name, ".")+1:]
+		if name != "" && unicode.IsUpper(rune(name[0])) {
+			return name
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// observe counts a call; not found and end of iteration are not errors
+func observe(operation string, err error) {
+	method := repositoryMethod()
+	datastoreCalls.Inc(method, operation)
+	if err != nil && err != datastore.ErrNoSuchEntity && err != iterator.Done {
+		datastoreErrors.Inc(method, operation)
+	}
+}
+
+func (c instrumentedClient) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	err := c.Client.Get(ctx, key, dst)
+	observe("Get", err)
+	return err
+}
+
+func (c instrumentedClient) GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	keys, err := c.Client.GetAll(ctx, q, dst)
+	observe("GetAll", err)
+	return keys, err
+}
+
+func (c instrumentedClient) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	key, err := c.Client.Put(ctx, key, src)
+	observe("Put", err)
+	return key, err
+}
+
+func (c instrumentedClient) Delete(ctx context.Context, key *datastore.Key) error {
+	err := c.Client.Delete(ctx, key)
+	observe("Delete", err)
+	return err
+}
+
+func (c instrumentedClient) RunInTransaction(ctx context.Context, f func(tx *datastore.Transaction) error, opts ...datastore.TransactionOption) (*datastore.Commit, error) {
+	commit, err := c.Client.RunInTransaction(ctx, f, opts...)
+	observe("RunInTransaction", err)
+	return commit, err
+}
+
+func (c instrumentedClient) Count(ctx context.Context, q *datastore.Query) (int, error) {
+	n, err := c.Client.Count(ctx, q)
+	observe("Count", err)
+	return n, err
+}
+
+// Run is counted once the caller reads the first result
+func (c instrumentedClient) Run(ctx context.Context, q *datastore.Query) *instrumentedIterator {
+	return &instrumentedIterator{Iterator: c.Client.Run(ctx, q)}
+}
+
+type instrumentedIterator struct {
+	*datastore.Iterator
+	observed bool
+}
+
+func (it *instrumentedIterator) Next(dst interface{}) (*datastore.Key, error) {
+	key, err := it.Iterator.Next(dst)
+	if !it.observed {
+		it.observed = true
+		observe("Run", err)
+	}
+	return key, err
+}
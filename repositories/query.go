@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"restAPI/models"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// newQuery builds the Datastore query for opts, without the cursor and limit
+func newQuery(kind string, opts models.QueryOptions) *datastore.Query {
+	query := datastore.NewQuery(kind)
+	for _, filter := range opts.Filters {
+		query = query.FilterField(filter.Field, filter.Op, filter.Value)
+	}
+	if opts.Order != "" {
+		query = query.Order(opts.Order)
+	}
+	return query
+}
+
+// list reads one page of kind into a slice, returning the keys alongside and
+// the cursor of the next page, which is empty after the last page
+func list[T any](r *BaseRepository, kind string, opts models.QueryOptions) ([]*T, []*datastore.Key, string, error) {
+	query := newQuery(kind, opts)
+	if opts.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, nil, "", models.ErrInvalidCursor
+		}
+		query = query.Start(cursor)
+	}
+	if opts.Limit > 0 {
+		// one more than asked for tells whether there is a next page
+		query = query.Limit(opts.Limit + 1)
+	}
+
+	items := []*T{}
+	keys := []*datastore.Key{}
+	next := ""
+	it := r.client.Run(r.ctx, query)
+	for {
+		if opts.Limit > 0 && len(items) == opts.Limit {
+			cursor, err := it.Cursor()
+			if err != nil {
+				return nil, nil, "", err
+			}
+			if _, err := it.Next(new(T)); err == iterator.Done {
+				break
+			} else if err != nil {
+				return nil, nil, "", err
+			}
+			next = cursor.String()
+			break
+		}
+
+		item := new(T)
+		key, err := it.Next(item)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, nil, "", err
+		}
+		items = append(items, item)
+		keys = append(keys, key)
+	}
+	return items, keys, next, nil
+}
+
+// count returns how many entities of kind match the filters of opts, with a
+// keys-only query
+func count(r *BaseRepository, kind string, opts models.QueryOptions) (int, error) {
+	return r.client.Count(r.ctx, newQuery(kind, opts).KeysOnly())
+}
@@ -0,0 +1,278 @@
+package routes
+
+import (
+	"net/http"
+	"restAPI/controllers"
+	"restAPI/lti"
+	"restAPI/models"
+	"restAPI/openapi"
+
+	"cloud.google.com/go/datastore"
+)
+
+// response bodies without a named type
+type (
+	message = map[string]string
+	stats   = map[string]interface{}
+)
+
+var (
+	key = &datastore.Key{}
+	id  = int64(0)
+)
+
+// apiSpecs documents every route by its path_METHOD name; OpenAPI adds the
+// path parameters, error responses and security from the route itself.
+// TestEveryRouteIsDocumented fails if a route is missing.
+var apiSpecs = map[string]Spec{
+	// users
+	"/user_POST":        {Summary: "Register a user (409 if the username is taken)", Request: models.User{}, Response: models.User{}},
+	"/user_GET":         {Summary: "List users", Response: []models.User{}, List: &controllers.UserListSpec},
+	"/user/{id}_GET":    {Summary: "Get a user", Response: models.User{}},
+	"/user/{id}_PUT":    {Summary: "Replace a user", Request: models.User{}, Response: models.User{}},
+	"/user/{id}_DELETE": {Summary: "Delete a user", Response: message{}},
+
+	// courses
+	"/course_POST":                        {Summary: "Create a course", Request: models.Course{}, Response: id},
+	"/course_GET":                         {Summary: "List courses", Response: []models.Course{}, List: &controllers.CourseListSpec},
+	"/course/{id}_GET":                    {Summary: "Get a course", Response: models.Course{}},
+	"/course/{id}_PUT":                    {Summary: "Replace a course", Request: models.Course{}, Response: key},
+	"/course/{id}_DELETE":                 {Summary: "Delete a course", Response: message{}},
+	"/course/{id}/instructor_GET":         {Summary: "List a course's instructors", Response: []models.User{}},
+	"/course/approved_GET":                {Summary: "List approved courses", Response: []models.Course{}},
+	"/course/unapproved_GET":              {Summary: "List courses awaiting approval", Response: []models.Course{}},
+	"/course/department/{department}_GET": {Summary: "List a department's courses", Response: []models.Course{}},
+	"/course/{id}/approve_PUT":            {Summary: "Approve a course", Response: key},
+	"/course/{id}/unapprove_PUT":          {Summary: "Withdraw a course's approval", Response: key},
+	"/course/{id}/user_GET":               {Summary: "List a course's users", Response: []models.User{}},
+	"/course/{id}/export_GET":             {Summary: "Download a course as a package (zip, also an IMS Common Cartridge)", ContentType: "application/zip"},
+	"/course/import_POST":                 {Summary: "Create a course from a package", Form: []string{"file"}, Response: controllers.CourseImport{}, Status: http.StatusCreated},
+	"/media/{name}_GET":                   {Summary: "An image or video imported with a course", ContentType: "application/octet-stream"},
+	"/course/{id}/usercourse_GET":         {Summary: "List a course's enrolments", Response: []models.UserCourse{}},
+	"/course/{id}/usercourse_POST":        {Summary: "Enrol a user in a course as a learner, TA or instructor", Request: models.UserCourse{}, Response: models.UserCourse{}},
+
+	// enrolments
+	"/usercourse_POST":                    {Summary: "Enrol yourself in a course as a learner", Request: models.UserCourse{}, Response: models.UserCourse{}},
+	"/usercourse_GET":                     {Summary: "List enrolments", Response: []models.UserCourse{}},
+	"/usercourse/{id}_PUT":                {Summary: "Replace an enrolment's grade and dates", Request: models.UserCourse{}, Response: models.UserCourse{}},
+	"/usercourse/{id}/role_PUT":           {Summary: "Change an enrolment's role", Request: controllers.EnrolmentRole{}, Response: models.UserCourse{}},
+	"/usercourse/{id}_DELETE":             {Summary: "Leave a course, or remove someone from it", Response: message{}},
+	"/usercourse/{userID}/{courseID}_GET": {Summary: "Get a user's enrolment in a course", Response: models.UserCourse{}},
+	"/user/{id}/course_GET":               {Summary: "List a user's courses", Response: []models.Course{}},
+	"/user/{id}/usercourse_GET":           {Summary: "List a user's enrolments", Response: []models.UserCourse{}},
+
+	// roles and routes
+	"/role_POST":         {Summary: "Create a role", Request: models.Role{}, Response: key},
+	"/role_GET":          {Summary: "List roles", Response: []models.Role{}},
+	"/role/{id}_GET":     {Summary: "Get a role", Response: models.Role{}},
+	"/role/{id}_PUT":     {Summary: "Replace a role", Request: models.Role{}, Response: key},
+	"/role/{id}_DELETE":  {Summary: "Delete a role", Response: message{}},
+	"/route_POST":        {Summary: "Create a route", Request: models.Route{}, Response: key},
+	"/route_GET":         {Summary: "List routes and the permissions they need", Response: []models.Route{}},
+	"/route/{id}_PUT":    {Summary: "Replace a route", Request: models.Route{}, Response: key},
+	"/route/{id}_DELETE": {Summary: "Delete a route", Response: message{}},
+
+	// modules
+	"/module_GET":                           {Summary: "List modules", Response: []models.Module{}},
+	"/module/{id}_GET":                      {Summary: "Get a module", Response: models.Module{}},
+	"/module/{id}_PUT":                      {Summary: "Replace a module", Request: models.Module{}, Response: key},
+	"/course/{courseId}/module_GET":         {Summary: "List a course's modules", Response: []models.Module{}},
+	"/course/{courseId}/module_POST":        {Summary: "Add a module to a course", Request: models.Module{}, Response: id},
+	"/course/{courseId}/module/{id}_GET":    {Summary: "Get a module of a course", Response: models.Module{}},
+	"/course/{courseId}/module/{id}_PUT":    {Summary: "Replace a module of a course", Request: models.Module{}, Response: key},
+	"/course/{courseId}/module/{id}_DELETE": {Summary: "Delete a module of a course", Response: message{}},
+
+	// discussion threads
+	"/thread_GET":                           {Summary: "List threads", Response: []models.Thread{}, List: &controllers.ThreadListSpec},
+	"/thread/{id}_GET":                      {Summary: "Get a thread", Response: models.Thread{}},
+	"/thread/{id}_PUT":                      {Summary: "Replace a thread", Request: models.Thread{}, Response: key},
+	"/module/{moduleId}/thread_GET":         {Summary: "List a module's threads", Response: []models.Thread{}},
+	"/module/{moduleId}/thread_POST":        {Summary: "Start a thread in a module", Request: models.Thread{}, Response: id},
+	"/module/{moduleId}/thread/{id}_GET":    {Summary: "Get a thread of a module", Response: models.Thread{}},
+	"/module/{moduleId}/thread/{id}_PUT":    {Summary: "Replace a thread of a module", Request: models.Thread{}, Response: key},
+	"/module/{moduleId}/thread/{id}_DELETE": {Summary: "Delete a thread of a module", Response: message{}},
+
+	// thread replies
+	"/thread/{threadId}/reply_GET":         {Summary: "List a thread's replies", Response: []models.Reply{}, List: &controllers.ReplyListSpec},
+	"/thread/{threadId}/reply_POST":        {Summary: "Reply to a thread or to a reply", Request: models.Reply{}, Response: id, Status: http.StatusCreated},
+	"/thread/{threadId}/reply/{id}_GET":    {Summary: "Get a reply with its edit history", Response: models.Reply{}},
+	"/thread/{threadId}/reply/{id}_PUT":    {Summary: "Edit a reply", Request: models.Reply{}, Response: models.Reply{}},
+	"/thread/{threadId}/reply/{id}_DELETE": {Summary: "Delete a reply, leaving a placeholder", Response: message{}},
+
+	// votes
+	"/thread/{id}/vote_GET":                     {Summary: "Get the current user's votes in a thread", Response: models.UserVotes{}},
+	"/thread/{id}/vote_PUT":                     {Summary: "Vote on a thread", Request: models.Vote{}, Response: models.VoteTally{}},
+	"/thread/{id}/vote_DELETE":                  {Summary: "Retract a vote on a thread", Response: models.VoteTally{}},
+	"/thread/{threadId}/reply/{id}/vote_PUT":    {Summary: "Vote on a reply", Request: models.Vote{}, Response: models.VoteTally{}},
+	"/thread/{threadId}/reply/{id}/vote_DELETE": {Summary: "Retract a vote on a reply", Response: models.VoteTally{}},
+
+	// moderation
+	"/thread/{id}/report_POST":                  {Summary: "Report a thread to the course's moderators", Request: models.Report{}, Response: message{}, Status: http.StatusCreated},
+	"/thread/{threadId}/reply/{id}/report_POST": {Summary: "Report a reply to the course's moderators", Request: models.Report{}, Response: message{}, Status: http.StatusCreated},
+	"/course/{courseId}/report_GET":             {Summary: "List a course's moderation queue", Response: []models.Report{}, List: &controllers.ReportListSpec},
+	"/thread/{id}/hide_PUT":                     {Summary: "Hide a thread and resolve its reports", Response: models.Thread{}},
+	"/thread/{id}/restore_PUT":                  {Summary: "Show a hidden thread again", Response: models.Thread{}},
+	"/thread/{id}/lock_PUT":                     {Summary: "Lock a thread against replies, edits and votes", Response: models.Thread{}},
+	"/thread/{id}/unlock_PUT":                   {Summary: "Unlock a thread", Response: models.Thread{}},
+	"/thread/{id}/pin_PUT":                      {Summary: "Pin a thread to the top of its module", Response: models.Thread{}},
+	"/thread/{id}/unpin_PUT":                    {Summary: "Unpin a thread", Response: models.Thread{}},
+	"/thread/{id}/dismiss_PUT":                  {Summary: "Dismiss the reports on a thread", Response: models.Thread{}},
+	"/thread/{threadId}/reply/{id}/hide_PUT":    {Summary: "Hide a reply and resolve its reports", Response: models.Reply{}},
+	"/thread/{threadId}/reply/{id}/restore_PUT": {Summary: "Show a hidden reply again", Response: models.Reply{}},
+	"/thread/{threadId}/reply/{id}/dismiss_PUT": {Summary: "Dismiss the reports on a reply", Response: models.Reply{}},
+
+	// elements
+	"/element_POST":        {Summary: "Create an element", Request: models.Element{}, Response: id},
+	"/element_GET":         {Summary: "List elements", Response: []models.Element{}, List: &controllers.ElementListSpec},
+	"/element/{id}_GET":    {Summary: "Get an element, with its answers", Response: models.Element{}},
+	"/element/{id}_PUT":    {Summary: "Replace an element", Request: models.Element{}, Response: key},
+	"/element/{id}_DELETE": {Summary: "Delete an element", Response: message{}},
+
+	// elements in modules
+	"/moduleelement_POST":                       {Summary: "Add an element to a module", Request: models.ModuleElement{}, Response: models.ModuleElement{}},
+	"/moduleelement_GET":                        {Summary: "List elements in modules", Response: []models.ModuleElement{}},
+	"/moduleelement/{id}_PUT":                   {Summary: "Move an element within a module", Request: models.ModuleElement{}, Response: models.ModuleElement{}},
+	"/moduleelement/{id}_DELETE":                {Summary: "Remove an element from a module", Response: message{}},
+	"/moduleelement/{moduleID}/{elementID}_GET": {Summary: "Get an element's place in a module", Response: models.ModuleElement{}},
+	"/module/{id}/element_GET":                  {Summary: "List a module's elements", Response: []models.Element{}},
+	"/module/{id}/moduleelement_GET":            {Summary: "List a module's element placements", Response: []models.ModuleElement{}},
+	"/element/{id}/module_GET":                  {Summary: "List the modules using an element", Response: []models.Module{}},
+	"/module/{id}/import_POST":                  {Summary: "Import a QTI 2.1 or GIFT question bank into a module", Form: []string{"file", "format", "dry_run"}, Response: controllers.ImportReport{}},
+	"/element/{id}/moduleelement_GET":           {Summary: "List an element's placements", Response: []models.ModuleElement{}},
+
+	// projects
+	"/project_POST":          {Summary: "Create a project", Request: models.Project{}, Response: key},
+	"/project_GET":           {Summary: "List projects", Response: []models.Project{}, List: &controllers.ProjectListSpec},
+	"/project/{id}_GET":      {Summary: "Get a project", Response: models.Project{}},
+	"/project/{id}_PUT":      {Summary: "Replace a project", Request: models.Project{}, Response: key},
+	"/project/{id}_DELETE":   {Summary: "Delete a project", Response: message{}},
+	"/user/{id}/project_GET": {Summary: "List a user's projects", Response: []models.Project{}},
+
+	// project files
+	"/upload/project_POST":            {Summary: "Upload a project file", Form: []string{"userId", "moduleId", "courseId", "elementId", "name", "description", "projectFile"}, Response: stats{}},
+	"/project/{id}/file_GET":          {Summary: "View a project file", ContentType: "application/octet-stream"},
+	"/project/{id}/download_GET":      {Summary: "Download a project file, or redirect to a signed URL for it", ContentType: "application/octet-stream"},
+	"/user/{userId}/projects_GET":     {Summary: "List a user's projects with their files", Response: []stats{}},
+	"/module/{moduleId}/projects_GET": {Summary: "List a module's projects with their files", Response: stats{}},
+
+	// resumable uploads
+	"/course/{courseId}/upload_POST": {Summary: "Start a resumable upload of a project file, holding room for it in the course's quota", Request: controllers.NewUpload{}, Response: models.Upload{}, Status: http.StatusCreated},
+	"/upload/{id}_GET":               {Summary: "Get one of your uploads, with the offset to resume it from", Response: models.Upload{}},
+	"/upload/{id}_PUT":               {Summary: "Send the next chunk of an upload, Upload-Chunk-Size bytes unless it is the last, at Upload-Offset with its SHA-256 in Upload-Checksum", Response: models.Upload{}},
+	"/upload/{id}_DELETE":            {Summary: "Cancel an upload", Response: message{}},
+	"/course/{courseId}/storage_GET": {Summary: "Get how much of its storage quota a course uses", Response: models.CourseStorage{}},
+	"/admin/course/{id}/storage_PUT": {Summary: "Set a course's storage quota; 0 restores the default", Request: controllers.StorageLimit{}, Response: models.CourseStorage{}},
+
+	// authentication
+	"/login_POST":   {Summary: "Log in, setting the session cookie", Request: controllers.Credentials{}, Response: stats{}},
+	"/logout_GET":   {Summary: "Log out", Status: http.StatusTemporaryRedirect},
+	"/sso_GET":      {Summary: "Log in with Google", Status: http.StatusTemporaryRedirect},
+	"/callback_GET": {Summary: "Google login callback", Status: http.StatusTemporaryRedirect},
+	"/session_GET":  {Summary: "Describe the current session", Response: controllers.SessionInfo{}},
+
+	// search
+	"/search_GET": {Summary: "Search content the user may see", Response: []controllers.SearchResult{}, Query: []openapi.Parameter{
+		{Name: "q", In: "query", Required: true, Description: "Words to search for", Schema: openapi.String},
+		{Name: "kind", In: "query", Description: "Comma-separated kinds: course, module, element, thread", Schema: openapi.String},
+		{Name: "limit", In: "query", Description: "At most 100, default 20", Schema: openapi.Integer},
+	}},
+
+	// notifications
+	"/notification_GET":             {Summary: "List the current user's notifications, newest first", Response: []models.Notification{}, List: &controllers.NotificationListSpec},
+	"/notification/read_PUT":        {Summary: "Mark notifications read, or all of them without ids", Request: controllers.MarkRead{}, Response: stats{}},
+	"/notification/preferences_GET": {Summary: "Get the current user's notification preferences", Response: models.NotificationPreferences{}},
+	"/notification/preferences_PUT": {Summary: "Replace the current user's notification preferences", Request: models.NotificationPreferences{}, Response: models.NotificationPreferences{}},
+
+	// private messages; requests with attachments are multipart, with the JSON body in "message"
+	"/conversation_GET":                                             {Summary: "List the current user's conversations, latest first", Response: []models.Participant{}, List: &controllers.ConversationListSpec},
+	"/conversation_POST":                                            {Summary: "Start a conversation with someone in one of your courses", Request: controllers.NewConversation{}, Response: models.Conversation{}, Status: http.StatusCreated},
+	"/conversation/unread_GET":                                      {Summary: "Count the current user's unread messages", Response: stats{}},
+	"/conversation/{id}_GET":                                        {Summary: "Get a conversation you take part in", Response: models.Conversation{}},
+	"/conversation/{id}/message_GET":                                {Summary: "List a conversation's messages, latest first", Response: []models.Message{}, List: &controllers.MessageListSpec},
+	"/conversation/{id}/message_POST":                               {Summary: "Send a message, with up to 5 attachments", Request: models.Message{}, Response: models.Message{}, Status: http.StatusCreated},
+	"/conversation/{id}/read_PUT":                                   {Summary: "Mark a conversation read", Response: message{}},
+	"/conversation/{id}/message/{messageId}/attachment/{index}_GET": {Summary: "Download a message attachment, or redirect to a signed URL for it", ContentType: "application/octet-stream"},
+	"/conversation/{id}/message/{messageId}/report_POST":            {Summary: "Report a message to the course's moderators, optionally blocking its sender", Request: controllers.MessageReport{}, Response: message{}, Status: http.StatusCreated},
+	"/course/{courseId}/broadcast_POST":                             {Summary: "Write to everyone in a course", Request: controllers.NewBroadcast{}, Response: models.Conversation{}, Status: http.StatusCreated},
+	"/message/{id}/dismiss_PUT":                                     {Summary: "Dismiss the reports on a message", Response: stats{}},
+	"/block_GET":                                                    {Summary: "List the users you blocked", Response: []models.Block{}},
+	"/block/{userId}_PUT":                                           {Summary: "Block a user from writing to you", Response: models.Block{}},
+	"/block/{userId}_DELETE":                                        {Summary: "Unblock a user", Response: message{}},
+
+	// event streams
+	"/events_GET": {Summary: "Stream discussion, grade and announcement events as Server-Sent Events", ContentType: "text/event-stream", Query: []openapi.Parameter{
+		{Name: "module", In: "query", Description: "Comma-separated modules whose discussions to follow", Schema: openapi.String},
+		{Name: "course", In: "query", Description: "Comma-separated courses whose announcements to follow", Schema: openapi.String},
+		{Name: "last_event_id", In: "query", Description: "Resume after this event, like the Last-Event-ID header", Schema: openapi.String},
+	}},
+	"/course/{courseId}/announcement_POST": {Summary: "Push an announcement to a course's followers", Request: models.Announcement{}, Response: models.Announcement{}},
+
+	// genetic algorithm
+	"/genetic_POST": {Summary: "Run the genetic algorithm", Request: models.GeneticModel{}, Response: controllers.GeneticHandlerResponse{}},
+
+	// operations
+	"/metrics_GET":      {Summary: "Prometheus metrics", ContentType: "text/plain"},
+	"/healthz_GET":      {Summary: "Liveness probe", Response: message{}},
+	"/readyz_GET":       {Summary: "Readiness probe", Response: stats{}},
+	"/openapi.json_GET": {Summary: "This document", Response: stats{}},
+	"/docs_GET":         {Summary: "API explorer", ContentType: "text/html"},
+
+	// administration
+	"/admin/stats_GET":             {Summary: "System statistics", Response: stats{}},
+	"/admin/user/{id}/stats_GET":   {Summary: "A user's statistics", Response: stats{}},
+	"/admin/course/{id}/stats_GET": {Summary: "A course's statistics", Response: stats{}},
+	"/admin/permissions_GET":       {Summary: "Which roles may use each route", Response: []controllers.RoutePermissions{}},
+	"/admin/audit_GET":             {Summary: "Query the audit log", Response: []models.AuditEntry{}, Query: auditQuery},
+	"/admin/audit/export_GET":      {Summary: "Download the audit log", Response: []models.AuditEntry{}, Query: append(auditQuery, openapi.Parameter{Name: "format", In: "query", Description: "json (default) or csv", Schema: openapi.String})},
+	"/admin/audit/verify_GET":      {Summary: "Verify the audit log's hash chain", Response: controllers.AuditVerification{}},
+	"/admin/impersonate/{id}_POST": {Summary: "Start seeing the application as a user", Response: controllers.SessionInfo{}},
+	"/admin/impersonate_DELETE":    {Summary: "Return to the admin's own session", Response: message{}},
+
+	// progress
+	"/user/{userId}/progress_GET":                   {Summary: "A user's progress in every course", Response: controllers.UserProgressSummary{}},
+	"/user/{userId}/course/{courseId}/progress_GET": {Summary: "A user's progress in a course", Response: controllers.CourseProgressSummary{}},
+	"/user/{userId}/course/{courseId}/progress_PUT": {Summary: "Recompute a user's grade in a course", Response: stats{}},
+
+	// module attempts
+	"/module/{id}/start_GET":                 {Summary: "Start a module attempt", Response: stats{}},
+	"/user/{userId}/module/{id}/submit_POST": {Summary: "Submit and grade a module attempt", Request: controllers.ModuleSubmission{}, Response: controllers.ModuleResult{}},
+	"/user/{userId}/module/{id}/results_GET": {Summary: "A user's results in a module", Response: stats{}},
+	"/module/{id}/analytics_GET":             {Summary: "Aggregate results of a module", Response: stats{}},
+	"/user/{userId}/module/{id}/reset_POST":  {Summary: "Reset a user's module attempt", Response: message{}},
+
+	// SCORM
+	"/element/{id}/scorm_POST":                               {Summary: "Host a SCORM 1.2 or 2004 package in an element", Form: []string{"file"}, Response: models.Element{}},
+	"/user/{userId}/module/{moduleId}/scorm/{elementId}_GET": {Summary: "CMI data a SCORM element stored in the current attempt", Response: controllers.RuntimeData{}},
+	"/user/{userId}/module/{moduleId}/scorm/{elementId}_PUT": {Summary: "Store CMI data from the SCORM runtime API", Request: controllers.RuntimeData{}, Response: controllers.RuntimeData{}},
+	"/scorm/runtime.js_GET":                                  {Summary: "The SCORM runtime API adapter", ContentType: "application/javascript"},
+
+	// LTI 1.3
+	"/lti/tool_GET":             {Summary: "The URLs to register the LTI tool with a platform", Response: controllers.LTITool{}},
+	"/lti/jwks_GET":             {Summary: "The LTI tool's public keys", Response: lti.JWKS{}},
+	"/lti/login_GET":            {Summary: "LTI login initiation; redirects to the platform", Query: ltiLogin, Status: http.StatusFound},
+	"/lti/login_POST":           {Summary: "LTI login initiation; redirects to the platform", Form: []string{"iss", "login_hint", "target_link_uri", "lti_message_hint", "client_id", "lti_deployment_id"}, Status: http.StatusFound},
+	"/lti/launch_POST":          {Summary: "LTI launch; redirects to the module, or shows deep linking", Form: []string{"id_token", "state"}, Status: http.StatusSeeOther},
+	"/lti/deeplink_POST":        {Summary: "Return the chosen modules to the platform", Form: []string{"selection", "module"}, ContentType: "text/html"},
+	"/lti/platform_GET":         {Summary: "List the registered LTI platforms", Response: []models.LTIPlatform{}},
+	"/lti/platform_POST":        {Summary: "Register an LTI platform", Request: models.LTIPlatform{}, Response: models.LTIPlatform{}, Status: http.StatusCreated},
+	"/lti/platform/{id}_PUT":    {Summary: "Replace an LTI platform's registration", Request: models.LTIPlatform{}, Response: models.LTIPlatform{}},
+	"/lti/platform/{id}_DELETE": {Summary: "Remove an LTI platform", Response: message{}},
+}
+
+var ltiLogin = []openapi.Parameter{
+	{Name: "iss", In: "query", Required: true, Schema: openapi.String},
+	{Name: "login_hint", In: "query", Required: true, Schema: openapi.String},
+	{Name: "target_link_uri", In: "query", Schema: openapi.String},
+	{Name: "lti_message_hint", In: "query", Schema: openapi.String},
+	{Name: "client_id", In: "query", Schema: openapi.String},
+	{Name: "lti_deployment_id", In: "query", Schema: openapi.String},
+}
+
+var auditQuery = []openapi.Parameter{
+	{Name: "actor", In: "query", Schema: openapi.String},
+	{Name: "action", In: "query", Schema: openapi.String},
+	{Name: "target_kind", In: "query", Schema: openapi.String},
+	{Name: "target_id", In: "query", Schema: openapi.Integer},
+	{Name: "since", In: "query", Description: "RFC 3339", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+	{Name: "until", In: "query", Description: "RFC 3339", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+}
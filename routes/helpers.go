@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"log"
 	"net/http"
 	"restAPI/controllers"
 	"restAPI/models"
@@ -19,49 +20,134 @@ func Contains(s []string, e string) bool {
 }
 
 // "Receiver" model which allows main function to pass
-// routeRepository, userHandler, and roleHandler to the context
+// routeRepository, userHandler, and the permission cache to the context
 type HelperContext struct {
 	routeRepository *repositories.BaseRepository
 	userHandler     *controllers.UserHandler
-	roleHandler     *controllers.RoleHandler
+	permissions     *PermissionCache
 }
 
 // Function to return a new context
-func NewHelperContext(routeRepository *repositories.BaseRepository, userHandler *controllers.UserHandler, roleHandler *controllers.RoleHandler) *HelperContext {
+func NewHelperContext(routeRepository *repositories.BaseRepository, userHandler *controllers.UserHandler, permissions *PermissionCache) *HelperContext {
 	return &HelperContext{
 		routeRepository: routeRepository,
 		userHandler:     userHandler,
-		roleHandler:     roleHandler,
+		permissions:     permissions,
 	}
 }
 
+// RouteName returns the path_METHOD name of the route matched for the request,
+// e.g. "/course/{id}_PUT", or false for routes without a method (static files)
+func RouteName(r *http.Request) (string, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", false
+	}
+	return routeName(route)
+}
+
+func routeName(route *mux.Route) (string, bool) {
+	name, _ := route.GetPathTemplate()
+	method, err := route.GetMethods()
+	if err != nil {
+		return "", false
+	}
+	return name + "_" + method[0], true
+}
+
+// sessionUsername returns the username of an active session, or ""
+func sessionUsername(sessionToken string) string {
+	session, ok := controllers.Sessions.Get(sessionToken)
+	if !ok || controllers.Expired(session) {
+		return ""
+	}
+	return session.GetUsername()
+}
+
+// Routes which are created Public when UpdateRoutes first discovers them;
+// every other new route is denied until a role is granted access to it
+var defaultPublicRoutes = map[string]bool{
+	"/login_POST":           true,
+	"/logout_GET":           true,
+	"/sso_GET":              true,
+	"/callback_GET":         true,
+	"/user_POST":            true,
+	"/course/approved_GET":  true,
+	"/genetic_POST":         true,
+	"/session_GET":          true,
+	"/healthz_GET":          true,
+	"/readyz_GET":           true,
+	"/search_GET":           true,
+	"/openapi.json_GET":     true,
+	"/docs_GET":             true,
+	"/scorm/runtime.js_GET": true,
+	"/media/{name}_GET":     true,
+
+	// LTI messages come from platforms, through the user's browser
+	"/lti/tool_GET":      true,
+	"/lti/jwks_GET":      true,
+	"/lti/login_GET":     true,
+	"/lti/login_POST":    true,
+	"/lti/launch_POST":   true,
+	"/lti/deeplink_POST": true,
+
+	// ending an impersonation must not depend on the impersonated user's permissions
+	"/admin/impersonate_DELETE": true,
+}
+
+// Methods an impersonated (read-only) session may use
+var readOnlyMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
 func (ctx *HelperContext) UpdateRoutes(router *mux.Router) {
 	// Add the name of each route to an array
 	var routeNames []string
 	routesInRepository, _ := ctx.routeRepository.GetAllRoutes()
 	for _, r := range routesInRepository {
 		routeNames = append(routeNames, r.Name)
+
+		// Routes stored before deny-by-default were open to everyone with
+		// PermissionLevel 0; grant them to their named permission so they
+		// stay reachable, except for administration
+		if !r.Managed {
+			if r.PermissionLevel == 0 && !r.Public && len(r.Permissions) == 0 {
+				r.Public = defaultPublicRoutes[r.Name]
+				if permission := controllers.RoutePermission(r.Name); !r.Public && permission != "" {
+					r.Permissions = []string{permission}
+				}
+			}
+			r.Managed = true
+			ctx.routeRepository.UpdateRoute(r.KeyID, r)
+		}
 	}
 
 	var routesConfigured []string
 	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
-		name, _ := route.GetPathTemplate()
-		method, err2 := route.GetMethods()
-		if err2 == nil {
-			name += "_" + method[0]
-			// fmt.Println(name)
+		if name, ok := routeName(route); ok {
 			routesConfigured = append(routesConfigured, name)
 		}
 		return nil
 	})
 
 	// Check if any routesConfigured are missing from routeNames
-	// If so, add them to the database
+	// If so, add them to the database, granted to their named permission
+	// (denied to everyone else unless public by default)
 	for _, r := range routesConfigured {
 		if !Contains(routeNames, r) {
-			ctx.routeRepository.CreateRoute(&models.Route{Name: r, PermissionLevel: 0})
+			route := &models.Route{Name: r, Public: defaultPublicRoutes[r], Managed: true}
+			if permission := controllers.RoutePermission(r); !route.Public && permission != "" {
+				route.Permissions = []string{permission}
+			}
+			ctx.routeRepository.CreateRoute(route)
 		}
 	}
+
+	if err := ctx.permissions.Refresh(); err != nil {
+		log.Println("Error loading permissions: " + err.Error())
+	}
 }
 
 // Middleware to check user roles
@@ -69,42 +155,56 @@ func (ctx *HelperContext) CheckPermissions(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		// Does the resource require authorization?
-		route := mux.CurrentRoute(r)
-		name, _ := route.GetPathTemplate()
-		method, err2 := route.GetMethods()
-		if err2 == nil {
-			name += "_" + method[0]
-
-			// Get the route from the database
-			router, _ := ctx.routeRepository.GetRouteByName(name)
-			if router != nil && router.PermissionLevel > 0 {
-				// Get the user from the session
-				_, session := ctx.userHandler.GetSession(r)
-
-				if *session == (controllers.Session{}) {
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
-				}
-
-				user, _ := ctx.userHandler.GetUserByUsername(session.GetUsername())
-				roleKey := ctx.roleHandler.GetRoleKey(user.GetRoles())
+		// (static files are served by a route without a method and are public)
+		name, ok := RouteName(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-				if user == nil {
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
+		// Get the route from the permission cache; unknown routes are denied
+		route := ctx.permissions.GetRouteByName(name)
+
+		// Get the user from the session, if any.  While an admin impersonates
+		// a user, permissions are those of the impersonated (effective) user
+		var user, realUser *models.User
+		_, session := ctx.userHandler.GetSession(r)
+		if *session != (controllers.Session{}) && !controllers.Expired(session) {
+			user, _ = ctx.userHandler.GetUserByUsername(session.GetUsername())
+			if session.Impersonated() {
+				realUser, _ = ctx.userHandler.GetUserByUsername(session.GetImpersonator())
+				if realUser == nil {
+					user = nil
 				}
+			}
+		}
 
-				// Compare bitwise AND of the roleKey and the required permission level
-				if (roleKey & router.PermissionLevel) == 0 {
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
-				}
+		// impersonated sessions may look but not touch
+		if session.Impersonated() && !readOnlyMethods[r.Method] && name != "/admin/impersonate_DELETE" {
+			controllers.WriteError(w, "Impersonated sessions are read-only", http.StatusForbidden)
+			return
+		}
+
+		if route == nil || !route.Public {
+			if user == nil {
+				controllers.WriteError(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
 
+			roles := append([]string{models.RoleUser}, user.GetRoles()...)
+			if !controllers.RoutePermits(route, ctx.permissions.GetRoleKey(roles), ctx.permissions.GetPermissions(roles)) {
+				controllers.WriteError(w, "Forbidden", http.StatusForbidden)
+				return
 			}
 		}
 
-		next.ServeHTTP(w, r)
+		if user != nil {
+			r = controllers.WithUser(r, user)
+		}
+		if realUser != nil {
+			r = controllers.WithRealUser(r, realUser)
+		}
 
-		// do something after the request
+		next.ServeHTTP(w, r)
 	})
 }
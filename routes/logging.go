@@ -0,0 +1,99 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"restAPI/controllers"
+	"restAPI/metrics"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("http_requests_total",
+		"HTTP requests, by route template, method and status code.", "route", "method", "status")
+	httpDuration = metrics.NewHistogramVec("http_request_duration_seconds",
+		"HTTP request latency in seconds, by route template and method.", metrics.DefaultBuckets, "route", "method")
+)
+
+type requestIDKey struct{}
+
+// incoming request IDs are only trusted if they look like one
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestID returns the ID assigned to the request by RequestLogger, or ""
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// structured JSON lines, one per request
+var requestLog = log.New(os.Stdout, "", 0)
+
+// RequestLogger assigns every request an ID (reusing a valid X-Request-ID
+// header), echoes it in the response, writes one JSON log line per request
+// and records request metrics per route template
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		// the user making the request, before login or logout changes it
+		_, session := controllers.GetSession(r)
+		username, impersonator := session.GetUsername(), session.GetImpersonator()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		elapsed := time.Since(start)
+
+		// static files share one template, so they do not explode the metrics
+		template := "static"
+		if route := mux.CurrentRoute(r); route != nil {
+			if t, err := route.GetPathTemplate(); err == nil {
+				if _, ok := routeName(route); ok {
+					template = t
+				}
+			}
+		}
+		name, _ := RouteName(r)
+
+		httpRequests.Inc(template, r.Method, strconv.Itoa(recorder.status))
+		httpDuration.Observe(elapsed.Seconds(), template, r.Method)
+
+		entry := map[string]interface{}{
+			"time":       start.UTC().Format(time.RFC3339Nano),
+			"request_id": id,
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"route":      name,
+			"status":     recorder.status,
+			"latency_ms": float64(elapsed.Microseconds()) / 1000,
+			"remote_ip":  controllers.ClientIP(r),
+		}
+		if username != "" {
+			entry["user"] = username
+		}
+		if impersonator != "" {
+			entry["impersonator"] = impersonator
+		}
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		requestLog.Println(string(line))
+	})
+}
@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"log"
+	"net/http"
+	"restAPI/config"
+	"restAPI/controllers"
+	"restAPI/lti"
+	"strings"
+)
+
+// ltiTool creates the LTI tool with the key in LTI_PRIVATE_KEY_FILE, or one
+// generated for this run
+func ltiTool(cfg *config.Config) *lti.Tool {
+	var key *rsa.PrivateKey
+	var err error
+	if cfg.LTI.PrivateKeyFile != "" {
+		key, err = lti.LoadKey(cfg.LTI.PrivateKeyFile)
+	} else {
+		log.Println("LTI_PRIVATE_KEY_FILE is not set: generating an LTI key which lasts until the server stops")
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		log.Fatalf("Could not load the LTI key: %v", err)
+	}
+	return lti.NewTool(key, cfg.LTI.ToolURL)
+}
+
+// startMockPlatform serves a mock LTI platform on LTI_MOCK_ADDR and registers it
+func startMockPlatform(cfg *config.Config, tool *lti.Tool, ltiHandler *controllers.LTIHandler) {
+	host, port, _ := strings.Cut(cfg.LTI.MockAddr, ":")
+	if host == "" {
+		host = "localhost"
+	}
+	mock, err := lti.NewMockPlatform("http://"+host+":"+port, tool)
+	if err != nil {
+		log.Printf("Error creating the mock LTI platform: %v", err)
+		return
+	}
+	if err := ltiHandler.EnsurePlatform(mock.Registration()); err != nil {
+		log.Printf("Error registering the mock LTI platform: %v", err)
+		return
+	}
+
+	log.Printf("Starting the mock LTI platform on %s", mock.BaseURL)
+	go func() {
+		server := &http.Server{Addr: cfg.LTI.MockAddr, Handler: mock, ReadHeaderTimeout: cfg.ReadHeaderTimeout}
+		log.Fatal(server.ListenAndServe())
+	}()
+}
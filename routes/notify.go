@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"restAPI/config"
+	"restAPI/notify"
+)
+
+// notificationChannels are the channels notifications may be delivered
+// through: email when there is an SMTP relay, and always the webhooks, which
+// users configure for themselves
+func notificationChannels(cfg *config.Config) []notify.Channel {
+	channels := []notify.Channel{
+		notify.NewSlack(cfg.Notifications.WebhookHosts),
+		notify.NewTeams(cfg.Notifications.WebhookHosts),
+	}
+	if cfg.Notifications.SMTPAddr != "" {
+		channels = append(channels, notify.NewEmail(cfg.Notifications.SMTPAddr, cfg.Notifications.SMTPUsername,
+			cfg.Notifications.SMTPPassword, cfg.Notifications.From))
+	}
+	return channels
+}
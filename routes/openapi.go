@@ -0,0 +1,217 @@
+package routes
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"restAPI/controllers"
+	"restAPI/openapi"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Spec documents one route for the OpenAPI document. Request and Response are
+// values whose types give the body schemas; a nil Response means the route
+// answers with a message or no body.
+type Spec struct {
+	Summary  string
+	Request  interface{}
+	Response interface{}
+
+	// Status of a successful response, if not 200
+	Status int
+	// ContentType of a successful response, if not application/json
+	ContentType string
+	// Form is set when the request is multipart/form-data, naming its fields;
+	// "file" and names ending in "File" are uploads
+	Form []string
+	// List documents the pagination, filter and sort parameters of ServeList
+	List *controllers.ListSpec
+	// Query documents other query string parameters
+	Query []openapi.Parameter
+}
+
+// integer path variables are IDs: id, userId, courseID, ...
+var idVariable = regexp.MustCompile(`(?i)id$`)
+
+var pathVariable = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// OpenAPI describes every route of the router, returning the names (as in
+// path_METHOD) of the routes without an entry in apiSpecs too
+func OpenAPI(router *mux.Router) (*openapi.Document, []string) {
+	doc := openapi.NewDocument(openapi.Info{
+		Title:       "restAPI",
+		Version:     "1.0",
+		Description: "Courses, modules, elements, learner progress and discussions. Errors are returned as APIError.",
+	})
+	doc.Components.SecuritySchemes = map[string]openapi.SecurityScheme{
+		"session": {Type: "apiKey", In: "cookie", Name: "session_token", Description: "Set by POST /login"},
+	}
+	errorSchema := doc.SchemaOf(controllers.APIError{})
+
+	var undocumented []string
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil // the static file server
+		}
+
+		for _, method := range methods {
+			name := path + "_" + method
+			spec, ok := apiSpecs[name]
+			if !ok {
+				undocumented = append(undocumented, name)
+			}
+			doc.AddOperation(pathVariable.ReplaceAllString(path, "{$1}"), method, operation(doc, name, path, method, spec, errorSchema))
+		}
+		return nil
+	})
+
+	sort.Strings(undocumented)
+	return doc, undocumented
+}
+
+func operation(doc *openapi.Document, name string, path string, method string, spec Spec, errorSchema *openapi.Schema) openapi.Operation {
+	op := openapi.Operation{
+		OperationID: name,
+		Summary:     spec.Summary,
+		Tags:        []string{strings.Split(strings.TrimPrefix(path, "/"), "/")[0]},
+		Responses:   map[string]openapi.Response{},
+		Security:    []map[string][]string{{"session": {}}},
+	}
+	if defaultPublicRoutes[name] {
+		op.Security = []map[string][]string{}
+	}
+
+	for _, match := range pathVariable.FindAllStringSubmatch(path, -1) {
+		schema := openapi.String
+		if idVariable.MatchString(match[1]) {
+			schema = openapi.Integer
+		}
+		op.Parameters = append(op.Parameters, openapi.Parameter{Name: match[1], In: "path", Required: true, Schema: schema})
+	}
+	if spec.List != nil {
+		op.Parameters = append(op.Parameters, listParameters(*spec.List)...)
+	}
+	op.Parameters = append(op.Parameters, spec.Query...)
+
+	if spec.Request != nil {
+		op.RequestBody = &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+			"application/json": {Schema: doc.SchemaOf(spec.Request)},
+		}}
+	}
+	if spec.Form != nil {
+		form := &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{}}
+		for _, field := range spec.Form {
+			form.Properties[field] = openapi.String
+			if field == "file" || strings.HasSuffix(field, "File") {
+				form.Properties[field] = &openapi.Schema{Type: "string", Format: "binary"}
+			}
+		}
+		op.RequestBody = &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+			"multipart/form-data": {Schema: form},
+		}}
+	}
+
+	status, contentType := spec.Status, spec.ContentType
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	success := openapi.Response{Description: http.StatusText(status)}
+	if spec.Response != nil {
+		success.Content = map[string]openapi.MediaType{contentType: {Schema: doc.SchemaOf(spec.Response)}}
+	} else if spec.ContentType != "" {
+		success.Content = map[string]openapi.MediaType{contentType: {}}
+	}
+	if spec.List != nil {
+		success.Headers = map[string]openapi.Header{
+			"X-Next-Cursor": {Description: "Cursor of the next page, if there is one", Schema: openapi.String},
+			"X-Total-Count": {Description: "Number of matching entities, on the first page", Schema: openapi.Integer},
+		}
+	}
+	op.Responses[strconv.Itoa(status)] = success
+
+	failure := func(status int) openapi.Response {
+		return openapi.Response{
+			Description: http.StatusText(status),
+			Content:     map[string]openapi.MediaType{"application/json": {Schema: errorSchema}},
+		}
+	}
+	if len(op.Parameters) > 0 || op.RequestBody != nil {
+		op.Responses["400"] = failure(http.StatusBadRequest)
+	}
+	if spec.Request != nil {
+		op.Responses["422"] = failure(http.StatusUnprocessableEntity)
+	}
+	if !defaultPublicRoutes[name] {
+		op.Responses["401"] = failure(http.StatusUnauthorized)
+		op.Responses["403"] = failure(http.StatusForbidden)
+	}
+	if strings.Contains(path, "{") {
+		op.Responses["404"] = failure(http.StatusNotFound)
+	}
+	return op
+}
+
+func listParameters(spec controllers.ListSpec) []openapi.Parameter {
+	parameters := []openapi.Parameter{
+		{Name: "limit", In: "query", Description: "Page size, at most " + strconv.Itoa(controllers.MaxPageSize), Schema: openapi.Integer},
+		{Name: "cursor", In: "query", Description: "X-Next-Cursor of the previous page", Schema: openapi.String},
+	}
+
+	var sorts []string
+	for name := range spec.Sorts {
+		sorts = append(sorts, name, "-"+name)
+	}
+	sort.Strings(sorts)
+	parameters = append(parameters, openapi.Parameter{Name: "sort", In: "query", Schema: &openapi.Schema{Type: "string", Enum: sorts}})
+
+	var filters []string
+	for name := range spec.Filters {
+		filters = append(filters, name)
+	}
+	sort.Strings(filters)
+	for _, name := range filters {
+		parameters = append(parameters, openapi.Parameter{
+			Name: name, In: "query", Description: "Filter on " + spec.Filters[name].Field,
+			Schema: &openapi.Schema{Type: spec.Filters[name].Type},
+		})
+	}
+	return parameters
+}
+
+//go:embed explorer.html
+var explorerPage []byte
+
+// OpenAPIHandler serves the OpenAPI document of the router, generated on
+// first use once every route is registered
+func OpenAPIHandler(router *mux.Router) http.HandlerFunc {
+	var once sync.Once
+	var content []byte
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			doc, _ := OpenAPI(router)
+			content, _ = json.MarshalIndent(doc, "", "  ")
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	}
+}
+
+// APIExplorer serves a page to browse the OpenAPI document and try requests
+func APIExplorer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(explorerPage)
+}
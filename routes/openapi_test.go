@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	router := mux.NewRouter()
+	RegisterRoutes(router, &Handlers{}, ".")
+
+	doc, undocumented := OpenAPI(router)
+	for _, name := range undocumented {
+		t.Errorf("route %s has no entry in apiSpecs", name)
+	}
+
+	documented := map[string]bool{}
+	for _, operations := range doc.Paths {
+		for _, op := range operations {
+			documented[op.OperationID] = true
+		}
+	}
+	for name := range apiSpecs {
+		if !documented[name] {
+			t.Errorf("apiSpecs entry %s has no route", name)
+		}
+	}
+}
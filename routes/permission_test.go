@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"restAPI/controllers"
+	"restAPI/models"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAdminRoutePermissions(t *testing.T) {
+	router := mux.NewRouter()
+	RegisterRoutes(router, &Handlers{}, ".")
+
+	// "" is admin-only
+	want := map[string]string{
+		"/metrics_GET":                   models.PermissionAdminRead,
+		"/admin/stats_GET":               models.PermissionAdminRead,
+		"/admin/user/{id}/stats_GET":     models.PermissionAdminRead,
+		"/admin/course/{id}/stats_GET":   models.PermissionAdminRead,
+		"/admin/permissions_GET":         models.PermissionAdminRead,
+		"/admin/audit_GET":               models.PermissionAdminRead,
+		"/admin/audit/export_GET":        models.PermissionAdminRead,
+		"/admin/audit/verify_GET":        models.PermissionAdminRead,
+		"/admin/impersonate/{id}_POST":   "",
+		"/admin/course/{id}/storage_PUT": "",
+		"/role_GET":                      models.PermissionRoleManage,
+		"/role_POST":                     models.PermissionRoleManage,
+		"/role/{id}_GET":                 models.PermissionRoleManage,
+		"/role/{id}_PUT":                 models.PermissionRoleManage,
+		"/role/{id}_DELETE":              models.PermissionRoleManage,
+		"/route_GET":                     models.PermissionRouteManage,
+		"/route_POST":                    models.PermissionRouteManage,
+		"/route/{id}_PUT":                models.PermissionRouteManage,
+		"/route/{id}_DELETE":             models.PermissionRouteManage,
+		"/lti/platform_GET":              "",
+		"/lti/platform_POST":             "",
+		"/lti/platform/{id}_PUT":         "",
+		"/lti/platform/{id}_DELETE":      "",
+	}
+
+	registered := map[string]bool{}
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if name, ok := routeName(route); ok {
+			registered[name] = true
+		}
+		return nil
+	})
+
+	everyone := map[string]bool{}
+	for _, permission := range controllers.UserPermissions {
+		everyone[permission] = true
+	}
+	for name, permission := range want {
+		if !registered[name] {
+			t.Errorf("route %s is not registered", name)
+			continue
+		}
+		got := controllers.RoutePermission(name)
+		if got != permission {
+			t.Errorf("RoutePermission(%s) = %q, want %q", name, got, permission)
+		}
+		if everyone[got] {
+			t.Errorf("route %s is granted to every signed-in user by %q", name, got)
+		}
+	}
+}
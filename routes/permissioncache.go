@@ -0,0 +1,130 @@
+package routes
+
+import (
+	"log"
+	"net/http"
+	"restAPI/models"
+	"sync"
+	"time"
+)
+
+// PermissionCache keeps every Route and Role in memory, so that checking
+// permissions does not cost a Datastore query per request. It is loaded at
+// startup, reloaded whenever /route or /role is modified through this
+// instance, and periodically so that several instances converge.
+type PermissionCache struct {
+	routeRepository models.RouteRepository
+	roleRepository  models.RoleRepository
+
+	mu     sync.RWMutex
+	routes map[string]*models.Route
+	roles  map[string]*models.Role
+}
+
+// NewPermissionCache returns an empty cache; call Refresh to load it
+func NewPermissionCache(routeRepository models.RouteRepository, roleRepository models.RoleRepository) *PermissionCache {
+	return &PermissionCache{
+		routeRepository: routeRepository,
+		roleRepository:  roleRepository,
+		routes:          map[string]*models.Route{},
+		roles:           map[string]*models.Role{},
+	}
+}
+
+// Refresh reloads all routes and roles from the repositories
+func (c *PermissionCache) Refresh() error {
+	routes, err := c.routeRepository.GetAllRoutes()
+	if err != nil {
+		return err
+	}
+
+	roles, err := c.roleRepository.GetAllRoles()
+	if err != nil {
+		return err
+	}
+
+	routesByName := make(map[string]*models.Route, len(routes))
+	for _, route := range routes {
+		routesByName[route.Name] = route
+	}
+
+	rolesByName := make(map[string]*models.Role, len(roles))
+	for _, role := range roles {
+		rolesByName[role.Name] = role
+	}
+
+	c.mu.Lock()
+	c.routes = routesByName
+	c.roles = rolesByName
+	c.mu.Unlock()
+	return nil
+}
+
+// RefreshEvery reloads the cache on an interval until stop is closed
+func (c *PermissionCache) RefreshEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.Refresh(); err != nil {
+					log.Println("Error refreshing permissions: " + err.Error())
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// RefreshAfter reloads the cache once next has successfully modified a route or role
+func (c *PermissionCache) RefreshAfter(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status < http.StatusBadRequest {
+			if err := c.Refresh(); err != nil {
+				log.Println("Error refreshing permissions: " + err.Error())
+			}
+		}
+	})
+}
+
+// GetRouteByName returns the cached route, or nil if it is unknown
+func (c *PermissionCache) GetRouteByName(name string) *models.Route {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.routes[name]
+}
+
+// GetRoleKey returns the legacy bitmask of the named roles
+func (c *PermissionCache) GetRoleKey(roles []string) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var roleKey int
+	for _, name := range roles {
+		if role, ok := c.roles[name]; ok {
+			roleKey |= role.NumericValue
+		}
+	}
+	return roleKey
+}
+
+// GetPermissions returns the union of the named permissions granted by roles
+func (c *PermissionCache) GetPermissions(roles []string) map[string]bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	permissions := map[string]bool{}
+	for _, name := range roles {
+		if role, ok := c.roles[name]; ok {
+			for _, permission := range role.Permissions {
+				permissions[permission] = true
+			}
+		}
+	}
+	return permissions
+}
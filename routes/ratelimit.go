@@ -0,0 +1,288 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"restAPI/config"
+	"restAPI/controllers"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The route name of the login endpoint, using the same path_METHOD
+// naming that UpdateRoutes stores in the Route repository
+const loginRouteName = "/login_POST"
+
+// RateLimit allows Requests per Window for a single client (IP or username)
+type RateLimit = config.RateLimit
+
+// DefaultRateLimits covers the public endpoints that accept anonymous traffic
+var DefaultRateLimits = map[string]RateLimit{
+	"/login_POST":   {Requests: 10, Window: time.Minute},
+	"/user_POST":    {Requests: 5, Window: time.Minute},
+	"/genetic_POST": {Requests: 5, Window: time.Minute},
+}
+
+// fixed window counter for one client on one route
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// failed login bookkeeping for one IP or username
+type loginFailures struct {
+	count        int
+	lockouts     int
+	blockedUntil time.Time
+	lastFailure  time.Time
+}
+
+// RateLimiter throttles requests per route by IP and by username, and backs
+// off (then temporarily locks out) clients that keep failing to log in
+type RateLimiter struct {
+	// MaxFailures is the number of consecutive failed logins before a lockout
+	MaxFailures int
+	// BaseDelay is the backoff after the first failed login; it doubles with every failure
+	BaseDelay time.Duration
+	// BaseLockout is the first lockout period; it doubles with every further lockout
+	BaseLockout time.Duration
+	// MaxLockout caps both the backoff and the lockout period
+	MaxLockout time.Duration
+
+	mu        sync.Mutex
+	limits    map[string]RateLimit
+	windows   map[string]*rateWindow
+	failures  map[string]*loginFailures
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewRateLimiter returns a RateLimiter for the given per-route limits
+func NewRateLimiter(limits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{
+		MaxFailures: 5,
+		BaseDelay:   time.Second,
+		BaseLockout: 5 * time.Minute,
+		MaxLockout:  time.Hour,
+		limits:      limits,
+		windows:     map[string]*rateWindow{},
+		failures:    map[string]*loginFailures{},
+		lastSweep:   time.Now(),
+		now:         time.Now,
+	}
+}
+
+// Middleware to throttle requests on rate limited routes and the login route
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := RouteName(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		l.mu.Lock()
+		limit, limited := l.limits[name]
+		l.mu.Unlock()
+
+		isLogin := name == loginRouteName
+		if !limited && !isLogin {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		clients := []string{"ip:" + controllers.ClientIP(r)}
+		if username := requestUsername(r); username != "" {
+			clients = append(clients, "user:"+strings.ToLower(username))
+		}
+
+		if wait := l.reserve(name, limit, limited, clients); wait > 0 {
+			tooManyRequests(w, wait)
+			return
+		}
+
+		if !isLogin {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		switch {
+		case recorder.status == http.StatusUnauthorized:
+			l.LoginFailed(clients...)
+		case recorder.status < http.StatusMultipleChoices:
+			l.LoginSucceeded(clients...)
+		}
+	})
+}
+
+// reserve counts the request against every client key and returns how long
+// the caller has to wait if any of them is over its limit or locked out
+func (l *RateLimiter) reserve(name string, limit RateLimit, limited bool, clients []string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	var wait time.Duration
+	for _, client := range clients {
+		if f, ok := l.failures[client]; ok && f.blockedUntil.After(now) {
+			wait = maxDuration(wait, f.blockedUntil.Sub(now))
+		}
+
+		if !limited {
+			continue
+		}
+
+		key := name + "|" + client
+		win, ok := l.windows[key]
+		if !ok || now.Sub(win.start) >= limit.Window {
+			win = &rateWindow{start: now}
+			l.windows[key] = win
+		}
+
+		win.count++
+		if win.count > limit.Requests {
+			wait = maxDuration(wait, win.start.Add(limit.Window).Sub(now))
+		}
+	}
+	return wait
+}
+
+// LoginFailed records a failed login for each client key, applying
+// exponential backoff and locking the client out after MaxFailures
+func (l *RateLimiter) LoginFailed(clients ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for _, client := range clients {
+		f, ok := l.failures[client]
+		if !ok {
+			f = &loginFailures{}
+			l.failures[client] = f
+		}
+
+		f.count++
+		f.lastFailure = now
+
+		if f.count >= l.MaxFailures {
+			f.blockedUntil = now.Add(l.backoff(l.BaseLockout, f.lockouts))
+			f.lockouts++
+			f.count = 0
+			continue
+		}
+
+		f.blockedUntil = now.Add(l.backoff(l.BaseDelay, f.count-1))
+	}
+}
+
+// LoginSucceeded clears the failure history of each client key
+func (l *RateLimiter) LoginSucceeded(clients ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, client := range clients {
+		delete(l.failures, client)
+	}
+}
+
+// backoff returns base * 2^n, capped at MaxLockout
+func (l *RateLimiter) backoff(base time.Duration, n int) time.Duration {
+	d := time.Duration(float64(base) * math.Pow(2, float64(n)))
+	if d <= 0 || d > l.MaxLockout {
+		return l.MaxLockout
+	}
+	return d
+}
+
+// sweep drops expired windows and forgotten failures, at most once a minute
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, win := range l.windows {
+		name, _, _ := strings.Cut(key, "|")
+		if now.Sub(win.start) >= l.limits[name].Window {
+			delete(l.windows, key)
+		}
+	}
+
+	for client, f := range l.failures {
+		if f.blockedUntil.Before(now) && now.Sub(f.lastFailure) > l.MaxLockout {
+			delete(l.failures, client)
+		}
+	}
+}
+
+// requestUsername returns the username of the session, or the "username"
+// field of a JSON body (e.g. /login), restoring the body for the handler
+func requestUsername(r *http.Request) string {
+	if c, err := r.Cookie("session_token"); err == nil && c.Value != "" {
+		if username := sessionUsername(c.Value); username != "" {
+			return username
+		}
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if r.Body == nil || (contentType != "" && !strings.HasPrefix(contentType, "application/json")) {
+		return ""
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var creds struct {
+		Username string `json:"username"`
+	}
+	json.Unmarshal(body, &creds)
+	return creds.Username
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	controllers.WriteError(w, "Too many requests", http.StatusTooManyRequests)
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// statusRecorder remembers the status code written by the next handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes through, so that event streams are not buffered
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -2,69 +2,300 @@ package routes
 
 import (
 	"context"
-	"flag"
+	"log"
 	"net/http"
-	"os"
 
+	"restAPI/config"
 	"restAPI/controllers"
+	"restAPI/metrics"
+	"restAPI/models"
+	"restAPI/moderation"
+	"restAPI/notify"
+	"restAPI/push"
 	"restAPI/repositories"
+	"restAPI/scan"
+	"restAPI/search"
+	"restAPI/xapi"
 
 	"cloud.google.com/go/datastore"
 	"github.com/gorilla/mux"
 )
 
-func SetupRoutes(router *mux.Router, client *datastore.Client, ctx context.Context) {
+// SetupRoutes registers every route and middleware on the router. Background
+// work (refreshing the permission cache) stops when done is closed. The
+// returned HealthHandler is drained by main when the server shuts down.
+func SetupRoutes(router *mux.Router, client *datastore.Client, ctx context.Context, cfg *config.Config, done <-chan struct{}) *controllers.HealthHandler {
 
 	// Create repositories with the database connection
 	userRepository := repositories.NewUserRepository(client, ctx)
+	auditRepository := repositories.NewAuditRepository(client, ctx)
 	roleRepository := repositories.NewRoleRepository(client, ctx)
 	routeRepository := repositories.NewRouteRepository(client, ctx)
 	courseRepository := repositories.NewCourseRepository(client, ctx)
 	threadRepository := repositories.NewThreadRepository(client, ctx)
+	replyRepository := repositories.NewReplyRepository(client, ctx)
+	messageRepository := repositories.NewMessageRepository(client, ctx)
+	voteRepository := repositories.NewVoteRepository(client, ctx)
+	reportRepository := repositories.NewReportRepository(client, ctx)
 	moduleRepository := repositories.NewModuleRepository(client, ctx)
 	projectRepository := repositories.NewProjectRepository(client, ctx)
 	userCourseRepository := repositories.NewUserCourseRepository(client, ctx)
 	elementRepository := repositories.NewElementRepository(client, ctx)
 	moduleElementRepository := repositories.NewModuleElementRepository(client, ctx)
+	scormAttemptRepository := repositories.NewScormAttemptRepository(client, ctx)
+	ltiRepository := repositories.NewLTIRepository(client, ctx)
+	notificationRepository := repositories.NewNotificationRepository(client, ctx)
+	uploadRepository := repositories.NewUploadRepository(client, ctx)
+
+	// courses, modules, elements and threads with their replies are indexed for search as they are written
+	searchIndex := search.NewIndex()
+	indexedCourses := search.IndexCourses(courseRepository, searchIndex)
+	indexedModules := search.IndexModules(moduleRepository, searchIndex)
+	indexedElements := search.IndexElements(elementRepository, moduleElementRepository, searchIndex)
+	indexedModuleElements := search.IndexModuleElements(moduleElementRepository, elementRepository, searchIndex)
+	indexedThreads, indexedReplies := search.IndexThreads(threadRepository, replyRepository, moduleRepository, searchIndex)
+
+	// mutating admin, instructor and grading actions are written to the audit log
+	auditor := controllers.NewAuditor(auditRepository)
+
+	// module attempts and course completions are reported to the LRS, if there is one
+	emitter := xapi.NewEmitter(cfg.XAPI.Endpoint, cfg.XAPI.Username, cfg.XAPI.Password, cfg.XAPI.HomePage)
+	emitter.Start(done)
+
+	// users are told about replies, grades, approvals and deadlines in their
+	// inbox, and by email, Slack or Teams if they choose
+	notifier := notify.NewNotifier(notificationRepository, userRepository, cfg.Notifications.BaseURL, notificationChannels(cfg)...)
+	notifier.Start(done)
+
+	// discussion posts, grades and announcements are pushed to open event streams
+	hub := push.NewHub()
+	hub.Start(done)
+
+	// uploads are kept out of the static directory, and handed out by the handlers that check who asks
+	blobs := blobStore(ctx, cfg)
+	// and wait in quarantine until they are scanned
+	scanner := malwareScanner(cfg)
+	quarantine := scan.NewQuarantine(blobs, scanner)
+	// and count against their course's storage quota
+	quota := controllers.NewCourseQuota(uploadRepository, projectRepository, blobs, cfg.Storage.CourseQuota)
+
+	// modules can be launched from LMSes as an LTI 1.3 tool
+	tool := ltiTool(cfg)
 
 	// Create handlers (controllers) with the repositories
-	userHandler := controllers.NewUserHandler(userRepository, &controllers.Sessions)
-	roleHandler := controllers.NewRoleHandler(roleRepository)
-	routeHandler := controllers.NewRouteHandler(routeRepository)
-	courseHandler := controllers.NewCourseHandler(courseRepository)
-	threadHandler := controllers.NewThreadHandler(threadRepository, moduleRepository)
-	moduleHandler := controllers.NewModuleHandler(moduleRepository, courseRepository)
-	projectHandler := controllers.NewProjectHandler(projectRepository)
-	userCourseHandler := controllers.NewUserCourseHandler(userCourseRepository)
-	elementHandler := controllers.NewElementHandler(elementRepository)
-	moduleElementHandler := controllers.NewModuleElementHandler(moduleElementRepository)
-	progressHandler := controllers.NewProgressHandler(userRepository, courseRepository, moduleRepository, userCourseRepository)
-	moduleAttemptHandler := controllers.NewModuleAttemptHandler(moduleRepository, elementRepository, moduleElementRepository, userRepository)
-	fileUploadHandler := controllers.NewFileUploadHandler(projectRepository, moduleRepository, userRepository, moduleElementRepository)
+	// routes and roles are cached in memory for the permission checks
+	permissions := NewPermissionCache(routeRepository, roleRepository)
+
+	// course-level authorization (ownership, instructors, TAs and learners)
+	az := controllers.NewAuthorizer(courseRepository, moduleRepository, elementRepository, moduleElementRepository,
+		userCourseRepository, threadRepository, replyRepository, messageRepository, projectRepository, permissions)
+
+	userHandler := controllers.NewUserHandler(userRepository, controllers.Sessions, permissions, auditor)
+	roleHandler := controllers.NewRoleHandler(roleRepository, auditor)
+	routeHandler := controllers.NewRouteHandler(routeRepository, auditor)
+	courseHandler := controllers.NewCourseHandler(indexedCourses, auditor, notifier, hub, az)
+	moduleHandler := controllers.NewModuleHandler(indexedModules, indexedCourses, auditor)
+	projectHandler := controllers.NewProjectHandler(projectRepository, userRepository, blobs, quota, auditor)
+	userCourseHandler := controllers.NewUserCourseHandler(userCourseRepository, auditor)
+	elementHandler := controllers.NewElementHandler(indexedElements, auditor)
+	importHandler := controllers.NewImportHandler(indexedElements, indexedModuleElements, auditor)
+	coursePackageHandler := controllers.NewCoursePackageHandler(indexedCourses, indexedModules, indexedElements, indexedModuleElements, cfg.StaticDir,
+		blobs, scanner, quarantine, auditor)
+	ltiHandler := controllers.NewLTIHandler(ltiRepository, userRepository, userCourseRepository, courseRepository, moduleRepository,
+		userHandler, tool, cfg.LTI.ModulePage, auditor, az)
+	progressHandler := controllers.NewProgressHandler(userRepository, courseRepository, moduleRepository, userCourseRepository, auditor, emitter, notifier, hub)
+	moduleAttemptHandler := controllers.NewModuleAttemptHandler(moduleRepository, elementRepository, moduleElementRepository, userRepository,
+		scormAttemptRepository, auditor, emitter, ltiHandler, hub)
+	scormHandler := controllers.NewScormHandler(indexedElements, indexedModuleElements, scormAttemptRepository, cfg.StaticDir, scanner, auditor)
+	fileUploadHandler := controllers.NewFileUploadHandler(projectRepository, moduleRepository, userRepository, moduleElementRepository,
+		blobs, quarantine, quota, cfg.Storage.SignedURLExpiry)
+	uploadHandler := controllers.NewUploadHandler(uploadRepository, moduleRepository, fileUploadHandler, blobs, quarantine, quota, auditor,
+		cfg.Storage.MaxUploadSize, cfg.Storage.ChunkSize, cfg.Storage.UploadExpiry)
 	adminHandler := controllers.NewAdminHandler(userRepository, courseRepository, moduleRepository, elementRepository, projectRepository)
+	permissionHandler := controllers.NewPermissionHandler(routeRepository, roleRepository)
+	auditHandler := controllers.NewAuditHandler(auditRepository)
+	impersonationHandler := controllers.NewImpersonationHandler(userRepository, auditor, controllers.Sessions)
+	notificationHandler := controllers.NewNotificationHandler(notificationRepository, cfg.Notifications.WebhookHosts)
+	healthHandler := controllers.NewHealthHandler(map[string]controllers.HealthCheck{
+		"datastore": userRepository.Ping,
+	})
+
+	searchHandler := controllers.NewSearchHandler(searchIndex, az)
+	moduleElementHandler := controllers.NewModuleElementHandler(indexedModuleElements, az)
+	eventHandler := controllers.NewEventHandler(hub, az, cfg.Events.StreamTimeout, cfg.Events.HeartbeatInterval)
+
+	// posts pass the content filter, and hidden ones are only shown to moderators
+	discussionFilter := moderation.NewFilter(cfg.Discussion.BlockedWords, cfg.Discussion.MaxLinks)
+	threadHandler := controllers.NewThreadHandler(indexedThreads, indexedReplies, voteRepository, reportRepository, moduleRepository,
+		discussionFilter, az, notifier, hub)
+	moderationHandler := controllers.NewModerationHandler(indexedThreads, indexedReplies, reportRepository, moduleRepository, auditor, hub)
+
+	messageHandler := controllers.NewMessageHandler(messageRepository, userRepository, userCourseRepository, courseRepository,
+		reportRepository, blobs, quarantine, cfg.Storage.SignedURLExpiry, auditor, notifier, hub)
 
 	// AI/Machine Learning routes
 	geneticHandler := controllers.NewGeneticHandler()
 
 	// call NewCheckPermissionsContext to create a CheckPermissionsContext
 	// which will be passed to the CheckPermissions middleware
-	c := NewHelperContext(routeRepository, userHandler, roleHandler)
+	c := NewHelperContext(routeRepository, userHandler, permissions)
 
+	// client addresses, for rate limits, audit entries and request logs,
+	// come from X-Forwarded-For only as far as the trusted proxies go
+	controllers.TrustedProxyHops = cfg.TrustedProxyHops
+	controllers.TransferTimeout = cfg.TransferTimeout
+
+	// throttle the public endpoints and failed logins before anything else
+	rateLimits := map[string]RateLimit{}
+	for name, limit := range DefaultRateLimits {
+		rateLimits[name] = limit
+	}
+	for name, limit := range cfg.RateLimits {
+		rateLimits[name] = limit
+	}
+	limiter := NewRateLimiter(rateLimits)
+
+	router.Use(RequestLogger)
+	router.Use(limiter.Middleware)
 	router.Use(c.CheckPermissions)
+	router.Use(az.Middleware)
+	RegisterRoutes(router, &Handlers{
+		User:          userHandler,
+		Role:          roleHandler,
+		Route:         routeHandler,
+		Course:        courseHandler,
+		Thread:        threadHandler,
+		Moderation:    moderationHandler,
+		Message:       messageHandler,
+		Module:        moduleHandler,
+		Project:       projectHandler,
+		UserCourse:    userCourseHandler,
+		Element:       elementHandler,
+		ModuleElement: moduleElementHandler,
+		Import:        importHandler,
+		CoursePackage: coursePackageHandler,
+		Progress:      progressHandler,
+		ModuleAttempt: moduleAttemptHandler,
+		Scorm:         scormHandler,
+		LTI:           ltiHandler,
+		FileUpload:    fileUploadHandler,
+		Upload:        uploadHandler,
+		Admin:         adminHandler,
+		Permission:    permissionHandler,
+		Audit:         auditHandler,
+		Impersonation: impersonationHandler,
+		Health:        healthHandler,
+		Search:        searchHandler,
+		Notification:  notificationHandler,
+		Event:         eventHandler,
+		Genetic:       geneticHandler,
+		Authorizer:    az,
+		Permissions:   permissions,
+	}, cfg.StaticDir)
+
+	// the admin role can use every route, including newly discovered ones,
+	// and the user role, held by everyone signed in, the ordinary ones
+	if err := roleHandler.EnsureRole(models.Role{Name: "admin", Permissions: []string{models.PermissionAll}}); err != nil {
+		log.Println("Error creating the admin role: " + err.Error())
+	}
+	if err := roleHandler.EnsureRole(models.Role{Name: models.RoleUser, Permissions: controllers.UserPermissions}); err != nil {
+		log.Println("Error creating the user role: " + err.Error())
+	}
+
+	// store newly discovered routes and load the permission cache,
+	// then keep it converging with changes made by other instances
+	c.UpdateRoutes(router)
+	permissions.RefreshEvery(cfg.PermissionRefreshInterval, done)
+
+	if cfg.LTI.MockAddr != "" {
+		startMockPlatform(cfg, tool, ltiHandler)
+	}
+
+	// replies used to be embedded in their threads, and threads were not ranked
+	go controllers.MigrateThreads(threadRepository, indexedReplies)
+
+	// project files used to be uploaded into the static directory
+	go controllers.MigrateProjectFiles(projectRepository, blobs, cfg.StaticDir)
+
+	// abandoned uploads give their room back to their course
+	uploadHandler.ExpireUploadsEvery(cfg.Storage.UploadExpiryCheckInterval, done)
+
+	// learners are reminded of module deadlines
+	notifier.RemindDeadlinesEvery(cfg.Notifications.DeadlineCheckInterval, cfg.Notifications.DeadlineWindow, done,
+		moduleRepository, userCourseRepository, userRepository)
+
+	// build the search index, and rebuild it to pick up other instances' writes
+	search.RebuildEvery(cfg.SearchRebuildInterval, done, searchIndex, courseRepository, moduleRepository, elementRepository,
+		moduleElementRepository, threadRepository, replyRepository)
+
+	return healthHandler
+}
+
+// Handlers are what RegisterRoutes routes requests to
+type Handlers struct {
+	User          *controllers.UserHandler
+	Role          *controllers.RoleHandler
+	Route         *controllers.RouteHandler
+	Course        *controllers.CourseHandler
+	Thread        *controllers.ThreadHandler
+	Moderation    *controllers.ModerationHandler
+	Message       *controllers.MessageHandler
+	Module        *controllers.ModuleHandler
+	Project       *controllers.ProjectHandler
+	UserCourse    *controllers.UserCourseHandler
+	Element       *controllers.ElementHandler
+	ModuleElement *controllers.ModuleElementHandler
+	Import        *controllers.ImportHandler
+	CoursePackage *controllers.CoursePackageHandler
+	Progress      *controllers.ProgressHandler
+	ModuleAttempt *controllers.ModuleAttemptHandler
+	Scorm         *controllers.ScormHandler
+	LTI           *controllers.LTIHandler
+	FileUpload    *controllers.FileUploadHandler
+	Upload        *controllers.UploadHandler
+	Admin         *controllers.AdminHandler
+	Permission    *controllers.PermissionHandler
+	Audit         *controllers.AuditHandler
+	Impersonation *controllers.ImpersonationHandler
+	Health        *controllers.HealthHandler
+	Search        *controllers.SearchHandler
+	Notification  *controllers.NotificationHandler
+	Event         *controllers.EventHandler
+	Genetic       *controllers.GeneticHandler
+	Authorizer    *controllers.Authorizer
+	Permissions   *PermissionCache
+}
+
+// RegisterRoutes registers every route on the router, serving anything else
+// from staticDir. It only wires handlers up, so it can be called with zero
+// Handlers to list the routes (see the OpenAPI test).
+func RegisterRoutes(router *mux.Router, h *Handlers, staticDir string) {
+	userHandler, roleHandler, routeHandler, courseHandler := h.User, h.Role, h.Route, h.Course
+	threadHandler, moduleHandler, projectHandler, userCourseHandler := h.Thread, h.Module, h.Project, h.UserCourse
+	elementHandler, moduleElementHandler, importHandler, progressHandler := h.Element, h.ModuleElement, h.Import, h.Progress
+	moduleAttemptHandler, fileUploadHandler, adminHandler := h.ModuleAttempt, h.FileUpload, h.Admin
+	uploadHandler := h.Upload
+	permissionHandler, auditHandler, impersonationHandler := h.Permission, h.Audit, h.Impersonation
+	healthHandler, searchHandler, geneticHandler := h.Health, h.Search, h.Genetic
+	coursePackageHandler, scormHandler, ltiHandler, moderationHandler := h.CoursePackage, h.Scorm, h.LTI, h.Moderation
+	notificationHandler, eventHandler, messageHandler := h.Notification, h.Event, h.Message
+	az, permissions := h.Authorizer, h.Permissions
+	learner, ta, instructor, owner := controllers.CourseRoleLearner, controllers.CourseRoleTA, controllers.CourseRoleInstructor, controllers.CourseRoleOwner
+
 	// TODO: universalize the ValidateSession middleware
 
 	// user routes - tested OK
 	router.HandleFunc("/user", userHandler.CreateUser).Methods("POST")
 	router.HandleFunc("/user", userHandler.ValidateSession(userHandler.GetAllUsers)).Methods("GET")
-	router.HandleFunc("/user/{id}", userHandler.ValidateSession(userHandler.GetUser)).Methods("GET")
-	router.HandleFunc("/user/{id}", userHandler.ValidateSession(userHandler.UpdateUser)).Methods("PUT")
-	router.HandleFunc("/user/{id}", userHandler.ValidateSession(userHandler.DeleteUser)).Methods("DELETE")
+	router.HandleFunc("/user/{id}", userHandler.ValidateSession(az.Require(owner, controllers.LearnerParam("id"), userHandler.GetUser))).Methods("GET")
+	router.HandleFunc("/user/{id}", userHandler.ValidateSession(az.Require(owner, controllers.LearnerParam("id"), userHandler.UpdateUser))).Methods("PUT")
+	router.HandleFunc("/user/{id}", userHandler.ValidateSession(az.Require(owner, controllers.LearnerParam("id"), userHandler.DeleteUser))).Methods("DELETE")
 
 	// course routes - tested OK
 	router.HandleFunc("/course", courseHandler.CreateCourse).Methods("POST")
 	router.HandleFunc("/course", courseHandler.GetAllCourses).Methods("GET")
-	router.HandleFunc("/course/{id}", courseHandler.DeleteCourse).Methods("DELETE")
-	router.HandleFunc("/course/{id}", courseHandler.UpdateCourse).Methods("PUT")
+	router.HandleFunc("/course/{id}", az.Require(owner, controllers.CourseParam("id"), courseHandler.DeleteCourse)).Methods("DELETE")
+	router.HandleFunc("/course/{id}", az.Require(instructor, controllers.CourseParam("id"), courseHandler.UpdateCourse)).Methods("PUT")
 	router.HandleFunc("/course/{id}", courseHandler.GetCourseByID).Methods("GET")
 	router.HandleFunc("/course/{id}/instructor", userCourseHandler.GetInstructorsByCourseID).Methods("GET")
 
@@ -75,135 +306,246 @@ func SetupRoutes(router *mux.Router, client *datastore.Client, ctx context.Conte
 	router.HandleFunc("/course/{id}/approve", userHandler.ValidateSession(courseHandler.ApproveCourse)).Methods("PUT")
 	router.HandleFunc("/course/{id}/unapprove", userHandler.ValidateSession(courseHandler.UnapproveeCourse)).Methods("PUT")
 
+	// course packages, to copy a course between environments
+	router.HandleFunc("/course/{id}/export", userHandler.ValidateSession(az.Require(instructor, controllers.CourseParam("id"), coursePackageHandler.ExportCourse))).Methods("GET")
+	router.HandleFunc("/course/import", userHandler.ValidateSession(coursePackageHandler.ImportCourse)).Methods("POST")
+	router.HandleFunc("/media/{name}", coursePackageHandler.GetMedia).Methods("GET")
+
 	// userCourse routes - tested OK
-	router.HandleFunc("/usercourse", userCourseHandler.CreateUserCourse).Methods("POST")
-	router.HandleFunc("/usercourse", userCourseHandler.GetAllUserCourses).Methods("GET")
-	router.HandleFunc("/usercourse/{id}", userCourseHandler.DeleteUserCourse).Methods("DELETE")
-	router.HandleFunc("/usercourse/{id}", userCourseHandler.UpdateUserCourse).Methods("PUT")
-	router.HandleFunc("/usercourse/{userID}/{courseID}", userCourseHandler.GetUserCourseByUserIDAndCourseID).Methods("GET")
+	// learners enrol themselves; instructors enrol others and give roles
+	router.HandleFunc("/usercourse", userHandler.ValidateSession(userCourseHandler.CreateUserCourse)).Methods("POST")
+	router.HandleFunc("/usercourse", az.RequireAdmin(userCourseHandler.GetAllUserCourses)).Methods("GET")
+	router.HandleFunc("/usercourse/{id}", userHandler.ValidateSession(az.Require(instructor, controllers.UserCourseLearnerParam("id"), userCourseHandler.DeleteUserCourse))).Methods("DELETE")
+	router.HandleFunc("/usercourse/{id}", userHandler.ValidateSession(az.Require(instructor, controllers.UserCourseParam("id"), userCourseHandler.UpdateUserCourse))).Methods("PUT")
+	router.HandleFunc("/usercourse/{id}/role", userHandler.ValidateSession(az.Require(instructor, controllers.UserCourseParam("id"), userCourseHandler.SetUserCourseRole))).Methods("PUT")
+	router.HandleFunc("/course/{id}/usercourse", userHandler.ValidateSession(az.Require(instructor, controllers.CourseParam("id"), userCourseHandler.EnrolUser))).Methods("POST")
+	router.HandleFunc("/usercourse/{userID}/{courseID}", az.Require(ta, controllers.LearnerInCourse("userID", "courseID"), userCourseHandler.GetUserCourseByUserIDAndCourseID)).Methods("GET")
 
 	// gets by UserID - tested OK
-	router.HandleFunc("/user/{id}/course", userCourseHandler.GetCoursesByUserID).Methods("GET")
-	router.HandleFunc("/user/{id}/usercourse", userCourseHandler.GetUserCoursesByUserID).Methods("GET")
+	router.HandleFunc("/user/{id}/course", az.Require(owner, controllers.LearnerParam("id"), userCourseHandler.GetCoursesByUserID)).Methods("GET")
+	router.HandleFunc("/user/{id}/usercourse", az.Require(owner, controllers.LearnerParam("id"), userCourseHandler.GetUserCoursesByUserID)).Methods("GET")
 
 	// gets by CourseID - tested OK
-	router.HandleFunc("/course/{id}/user", userCourseHandler.GetUsersByCourseID).Methods("GET")
-	router.HandleFunc("/course/{id}/usercourse", userCourseHandler.GetUserCoursesByCourseID).Methods("GET")
+	router.HandleFunc("/course/{id}/user", az.Require(ta, controllers.CourseParam("id"), userCourseHandler.GetUsersByCourseID)).Methods("GET")
+	router.HandleFunc("/course/{id}/usercourse", az.Require(ta, controllers.CourseParam("id"), userCourseHandler.GetUserCoursesByCourseID)).Methods("GET")
 
 	// role routes - tested OK
-	router.HandleFunc("/role", roleHandler.CreateRole).Methods("POST")
+	router.HandleFunc("/role", permissions.RefreshAfter(roleHandler.CreateRole)).Methods("POST")
 	router.HandleFunc("/role", roleHandler.GetAllRoles).Methods("GET")
-	router.HandleFunc("/role/{id}", roleHandler.DeleteRole).Methods("DELETE")
-	router.HandleFunc("/role/{id}", roleHandler.UpdateRole).Methods("PUT")
+	router.HandleFunc("/role/{id}", permissions.RefreshAfter(roleHandler.DeleteRole)).Methods("DELETE")
+	router.HandleFunc("/role/{id}", permissions.RefreshAfter(roleHandler.UpdateRole)).Methods("PUT")
 	router.HandleFunc("/role/{id}", roleHandler.GetRoleByID).Methods("GET")
 
 	// route routes - tested OK
-	router.HandleFunc("/route", routeHandler.CreateRoute).Methods("POST")
+	router.HandleFunc("/route", permissions.RefreshAfter(routeHandler.CreateRoute)).Methods("POST")
 	router.HandleFunc("/route", routeHandler.GetAllRoutes).Methods("GET")
-	router.HandleFunc("/route/{id}", routeHandler.DeleteRoute).Methods("DELETE")
-	router.HandleFunc("/route/{id}", routeHandler.UpdateRoute).Methods("PUT")
+	router.HandleFunc("/route/{id}", permissions.RefreshAfter(routeHandler.DeleteRoute)).Methods("DELETE")
+	router.HandleFunc("/route/{id}", permissions.RefreshAfter(routeHandler.UpdateRoute)).Methods("PUT")
 
 	// module routes - tested OK
-	router.HandleFunc("/module", moduleHandler.GetAllModules).Methods("GET")
-	router.HandleFunc("/module/{id}", moduleHandler.UpdateModule).Methods("PUT")
-	router.HandleFunc("/module/{id}", moduleHandler.GetModuleByID).Methods("GET")
+	router.HandleFunc("/module", az.RequireAdmin(moduleHandler.GetAllModules)).Methods("GET")
+	router.HandleFunc("/module/{id}", az.Require(instructor, controllers.ModuleParam("id"), moduleHandler.UpdateModule)).Methods("PUT")
+	router.HandleFunc("/module/{id}", az.Require(learner, controllers.ModuleParam("id"), moduleHandler.GetModuleByID)).Methods("GET")
 
 	// course-module routes - tested OK
-	router.HandleFunc("/course/{courseId}/module", moduleHandler.GetAllModulesByCourseID).Methods("GET")
-	router.HandleFunc("/course/{courseId}/module", moduleHandler.CreateModule).Methods("POST")
-	router.HandleFunc("/course/{courseId}/module/{id}", moduleHandler.DeleteModule).Methods("DELETE")
-	router.HandleFunc("/course/{courseId}/module/{id}", moduleHandler.UpdateModule).Methods("PUT")
-	router.HandleFunc("/course/{courseId}/module/{id}", moduleHandler.GetModuleByID).Methods("GET")
+	router.HandleFunc("/course/{courseId}/module", az.Require(learner, controllers.CourseParam("courseId"), moduleHandler.GetAllModulesByCourseID)).Methods("GET")
+	router.HandleFunc("/course/{courseId}/module", az.Require(instructor, controllers.CourseParam("courseId"), moduleHandler.CreateModule)).Methods("POST")
+	router.HandleFunc("/course/{courseId}/module/{id}", az.Require(instructor, controllers.ModuleParam("id"), moduleHandler.DeleteModule)).Methods("DELETE")
+	router.HandleFunc("/course/{courseId}/module/{id}", az.Require(instructor, controllers.ModuleParam("id"), moduleHandler.UpdateModule)).Methods("PUT")
+	router.HandleFunc("/course/{courseId}/module/{id}", az.Require(learner, controllers.ModuleParam("id"), moduleHandler.GetModuleByID)).Methods("GET")
 
 	// thread routes - tested OK
-	router.HandleFunc("/thread", threadHandler.GetAllThreads).Methods("GET")
-	router.HandleFunc("/thread/{id}", threadHandler.UpdateThread).Methods("PUT")
-	router.HandleFunc("/thread/{id}", threadHandler.GetThreadByID).Methods("GET")
+	router.HandleFunc("/thread", az.RequireAdmin(threadHandler.GetAllThreads)).Methods("GET")
+	router.HandleFunc("/thread/{id}", az.Require(instructor, controllers.ThreadAuthorParam("id"), threadHandler.UpdateThread)).Methods("PUT")
+	router.HandleFunc("/thread/{id}", az.Require(learner, controllers.ThreadParam("id"), threadHandler.GetThreadByID)).Methods("GET")
 
 	// module-thread routes - tested OK
-	router.HandleFunc("/module/{moduleId}/thread", threadHandler.CreateThread).Methods("POST")
-	router.HandleFunc("/module/{moduleId}/thread", threadHandler.GetAllThreadsByModuleID).Methods("GET")
-	router.HandleFunc("/module/{moduleId}/thread/{id}", threadHandler.DeleteThread).Methods("DELETE")
-	router.HandleFunc("/module/{moduleId}/thread/{id}", threadHandler.UpdateThread).Methods("PUT")
-	router.HandleFunc("/module/{moduleId}/thread/{id}", threadHandler.GetThreadByID).Methods("GET")
+	router.HandleFunc("/module/{moduleId}/thread", az.Require(learner, controllers.ModuleParam("moduleId"), threadHandler.CreateThread)).Methods("POST")
+	router.HandleFunc("/module/{moduleId}/thread", az.Require(learner, controllers.ModuleParam("moduleId"), threadHandler.GetAllThreadsByModuleID)).Methods("GET")
+	router.HandleFunc("/module/{moduleId}/thread/{id}", az.Require(ta, controllers.ThreadAuthorParam("id"), threadHandler.DeleteThread)).Methods("DELETE")
+	router.HandleFunc("/module/{moduleId}/thread/{id}", az.Require(instructor, controllers.ThreadAuthorParam("id"), threadHandler.UpdateThread)).Methods("PUT")
+	router.HandleFunc("/module/{moduleId}/thread/{id}", az.Require(learner, controllers.ModuleParam("moduleId"), threadHandler.GetThreadByID)).Methods("GET")
+
+	// thread reply routes: authors edit their own replies, TAs may delete anyone's
+	router.HandleFunc("/thread/{threadId}/reply", az.Require(learner, controllers.ThreadParam("threadId"), threadHandler.GetReplies)).Methods("GET")
+	router.HandleFunc("/thread/{threadId}/reply", az.Require(learner, controllers.ThreadParam("threadId"), threadHandler.CreateReply)).Methods("POST")
+	router.HandleFunc("/thread/{threadId}/reply/{id}", az.Require(learner, controllers.ThreadParam("threadId"), threadHandler.GetReply)).Methods("GET")
+	router.HandleFunc("/thread/{threadId}/reply/{id}", az.Require(owner, controllers.ReplyParam("id"), threadHandler.UpdateReply)).Methods("PUT")
+	router.HandleFunc("/thread/{threadId}/reply/{id}", az.Require(ta, controllers.ReplyParam("id"), threadHandler.DeleteReply)).Methods("DELETE")
+
+	// votes: one per user and post, changed with PUT and retracted with DELETE
+	router.HandleFunc("/thread/{id}/vote", az.Require(learner, controllers.ThreadParam("id"), threadHandler.GetVotes)).Methods("GET")
+	router.HandleFunc("/thread/{id}/vote", az.Require(learner, controllers.ThreadParam("id"), threadHandler.VoteOnThread)).Methods("PUT")
+	router.HandleFunc("/thread/{id}/vote", az.Require(learner, controllers.ThreadParam("id"), threadHandler.RetractThreadVote)).Methods("DELETE")
+	router.HandleFunc("/thread/{threadId}/reply/{id}/vote", az.Require(learner, controllers.ThreadParam("threadId"), threadHandler.VoteOnReply)).Methods("PUT")
+	router.HandleFunc("/thread/{threadId}/reply/{id}/vote", az.Require(learner, controllers.ThreadParam("threadId"), threadHandler.RetractReplyVote)).Methods("DELETE")
+
+	// moderation: anyone in the course reports, instructors work through the queue
+	router.HandleFunc("/thread/{id}/report", az.Require(learner, controllers.ThreadParam("id"), moderationHandler.ReportThread)).Methods("POST")
+	router.HandleFunc("/thread/{threadId}/reply/{id}/report", az.Require(learner, controllers.ThreadParam("threadId"), moderationHandler.ReportReply)).Methods("POST")
+	router.HandleFunc("/course/{courseId}/report", az.Require(instructor, controllers.CourseParam("courseId"), moderationHandler.GetReports)).Methods("GET")
+	router.HandleFunc("/thread/{id}/hide", az.Require(instructor, controllers.ThreadParam("id"), moderationHandler.HideThread)).Methods("PUT")
+	router.HandleFunc("/thread/{id}/restore", az.Require(instructor, controllers.ThreadParam("id"), moderationHandler.RestoreThread)).Methods("PUT")
+	router.HandleFunc("/thread/{id}/lock", az.Require(instructor, controllers.ThreadParam("id"), moderationHandler.LockThread)).Methods("PUT")
+	router.HandleFunc("/thread/{id}/unlock", az.Require(instructor, controllers.ThreadParam("id"), moderationHandler.UnlockThread)).Methods("PUT")
+	router.HandleFunc("/thread/{id}/pin", az.Require(instructor, controllers.ThreadParam("id"), moderationHandler.PinThread)).Methods("PUT")
+	router.HandleFunc("/thread/{id}/unpin", az.Require(instructor, controllers.ThreadParam("id"), moderationHandler.UnpinThread)).Methods("PUT")
+	router.HandleFunc("/thread/{id}/dismiss", az.Require(instructor, controllers.ThreadParam("id"), moderationHandler.DismissThreadReports)).Methods("PUT")
+	router.HandleFunc("/thread/{threadId}/reply/{id}/hide", az.Require(instructor, controllers.ThreadParam("threadId"), moderationHandler.HideReply)).Methods("PUT")
+	router.HandleFunc("/thread/{threadId}/reply/{id}/restore", az.Require(instructor, controllers.ThreadParam("threadId"), moderationHandler.RestoreReply)).Methods("PUT")
+	router.HandleFunc("/thread/{threadId}/reply/{id}/dismiss", az.Require(instructor, controllers.ThreadParam("threadId"), moderationHandler.DismissReplyReports)).Methods("PUT")
 
 	// element routes - tested OK
 	router.HandleFunc("/element", elementHandler.CreateElement).Methods("POST")
-	router.HandleFunc("/element", elementHandler.GetAllElements).Methods("GET")
-	router.HandleFunc("/element/{id}", elementHandler.DeleteElement).Methods("DELETE")
-	router.HandleFunc("/element/{id}", elementHandler.UpdateElement).Methods("PUT")
-	router.HandleFunc("/element/{id}", elementHandler.GetElementByID).Methods("GET")
+	router.HandleFunc("/element", az.RequireAdmin(elementHandler.GetAllElements)).Methods("GET")
+	router.HandleFunc("/element/{id}", az.Require(instructor, controllers.ElementParam("id"), elementHandler.DeleteElement)).Methods("DELETE")
+	router.HandleFunc("/element/{id}", az.Require(instructor, controllers.ElementParam("id"), elementHandler.UpdateElement)).Methods("PUT")
+	router.HandleFunc("/element/{id}", az.Require(ta, controllers.ElementParam("id"), elementHandler.GetElementByID)).Methods("GET")
 
 	// moduleElement routes - tested OK
-	router.HandleFunc("/moduleelement", moduleElementHandler.CreateModuleElement).Methods("POST")
-	router.HandleFunc("/moduleelement", moduleElementHandler.GetAllModuleElements).Methods("GET")
-	router.HandleFunc("/moduleelement/{id}", moduleElementHandler.DeleteModuleElement).Methods("DELETE")
-	router.HandleFunc("/moduleelement/{id}", moduleElementHandler.UpdateModuleElement).Methods("PUT")
-	router.HandleFunc("/moduleelement/{moduleID}/{elementID}", moduleElementHandler.GetModuleElementByModuleIDAndElementID).Methods("GET")
+	router.HandleFunc("/moduleelement", userHandler.ValidateSession(moduleElementHandler.CreateModuleElement)).Methods("POST")
+	router.HandleFunc("/moduleelement", az.RequireAdmin(moduleElementHandler.GetAllModuleElements)).Methods("GET")
+	router.HandleFunc("/moduleelement/{id}", userHandler.ValidateSession(az.Require(instructor, controllers.ModuleElementParam("id"), moduleElementHandler.DeleteModuleElement))).Methods("DELETE")
+	router.HandleFunc("/moduleelement/{id}", userHandler.ValidateSession(az.Require(instructor, controllers.ModuleElementParam("id"), moduleElementHandler.UpdateModuleElement))).Methods("PUT")
+	router.HandleFunc("/moduleelement/{moduleID}/{elementID}", az.Require(ta, controllers.ModuleParam("moduleID"), moduleElementHandler.GetModuleElementByModuleIDAndElementID)).Methods("GET")
 
 	// gets by ModuleID - tested OK
-	router.HandleFunc("/module/{id}/element", moduleElementHandler.GetElementsByModuleID).Methods("GET")
-	router.HandleFunc("/module/{id}/moduleelement", moduleElementHandler.GetModuleElementsByModuleID).Methods("GET")
+	router.HandleFunc("/module/{id}/element", az.Require(ta, controllers.ModuleParam("id"), moduleElementHandler.GetElementsByModuleID)).Methods("GET")
+	router.HandleFunc("/module/{id}/moduleelement", az.Require(ta, controllers.ModuleParam("id"), moduleElementHandler.GetModuleElementsByModuleID)).Methods("GET")
+
+	// question bank (QTI 2.1, GIFT) import into a module
+	router.HandleFunc("/module/{id}/import", userHandler.ValidateSession(az.Require(instructor, controllers.ModuleParam("id"), importHandler.ImportQuestions))).Methods("POST")
 
 	// gets by ElementID - tested OK
-	router.HandleFunc("/element/{id}/module", moduleElementHandler.GetModulesByElementID).Methods("GET")
-	router.HandleFunc("/element/{id}/moduleelement", moduleElementHandler.GetModuleElementsByElementID).Methods("GET")
+	router.HandleFunc("/element/{id}/module", az.Require(ta, controllers.ElementParam("id"), moduleElementHandler.GetModulesByElementID)).Methods("GET")
+	router.HandleFunc("/element/{id}/moduleelement", az.Require(ta, controllers.ElementParam("id"), moduleElementHandler.GetModuleElementsByElementID)).Methods("GET")
 
 	// project routes - TODO: test
 	router.HandleFunc("/project", projectHandler.CreateProject).Methods("POST")
-	router.HandleFunc("/project", projectHandler.GetAllProjects).Methods("GET")
-	router.HandleFunc("/project/{id}", projectHandler.DeleteProject).Methods("DELETE")
-	router.HandleFunc("/project/{id}", projectHandler.UpdateProject).Methods("PUT")
-	router.HandleFunc("/project/{id}", projectHandler.GetProjectByID).Methods("GET")
-	router.HandleFunc("/user/{id}/project", projectHandler.GetProjectsByUserID).Methods("GET")
+	router.HandleFunc("/project", az.RequireAdmin(projectHandler.GetAllProjects)).Methods("GET")
+	router.HandleFunc("/project/{id}", az.Require(instructor, controllers.ProjectParam("id"), projectHandler.DeleteProject)).Methods("DELETE")
+	router.HandleFunc("/project/{id}", az.Require(instructor, controllers.ProjectParam("id"), projectHandler.UpdateProject)).Methods("PUT")
+	router.HandleFunc("/project/{id}", az.Require(ta, controllers.ProjectParam("id"), projectHandler.GetProjectByID)).Methods("GET")
+	router.HandleFunc("/user/{id}/project", az.Require(owner, controllers.LearnerParam("id"), projectHandler.GetProjectsByUserID)).Methods("GET")
+
+	// full-text search, filtered by what the user may see
+	router.HandleFunc("/search", searchHandler.Search).Methods("GET")
+
+	// the current user's notifications and where they are sent
+	router.HandleFunc("/notification", userHandler.ValidateSession(notificationHandler.GetNotifications)).Methods("GET")
+	router.HandleFunc("/notification/read", userHandler.ValidateSession(notificationHandler.MarkNotificationsRead)).Methods("PUT")
+	router.HandleFunc("/notification/preferences", userHandler.ValidateSession(notificationHandler.GetNotificationPreferences)).Methods("GET")
+	router.HandleFunc("/notification/preferences", userHandler.ValidateSession(notificationHandler.UpdateNotificationPreferences)).Methods("PUT")
+
+	// Server-Sent Events: discussions, the user's grades and course announcements as they happen
+	router.HandleFunc("/events", userHandler.ValidateSession(eventHandler.StreamEvents)).Methods("GET")
+	router.HandleFunc("/course/{courseId}/announcement", userHandler.ValidateSession(az.Require(instructor, controllers.CourseParam("courseId"), courseHandler.Announce))).Methods("POST")
+
+	// private messages: only participants see a conversation, instructors can write to a whole course
+	router.HandleFunc("/conversation", userHandler.ValidateSession(messageHandler.GetConversations)).Methods("GET")
+	router.HandleFunc("/conversation", userHandler.ValidateSession(messageHandler.CreateConversation)).Methods("POST")
+	router.HandleFunc("/conversation/unread", userHandler.ValidateSession(messageHandler.GetUnreadMessageCount)).Methods("GET")
+	router.HandleFunc("/conversation/{id}", userHandler.ValidateSession(messageHandler.GetConversation)).Methods("GET")
+	router.HandleFunc("/conversation/{id}/message", userHandler.ValidateSession(messageHandler.GetMessages)).Methods("GET")
+	router.HandleFunc("/conversation/{id}/message", userHandler.ValidateSession(messageHandler.SendMessage)).Methods("POST")
+	router.HandleFunc("/conversation/{id}/read", userHandler.ValidateSession(messageHandler.MarkConversationRead)).Methods("PUT")
+	router.HandleFunc("/conversation/{id}/message/{messageId}/attachment/{index}", userHandler.ValidateSession(messageHandler.GetAttachment)).Methods("GET")
+	router.HandleFunc("/conversation/{id}/message/{messageId}/report", userHandler.ValidateSession(messageHandler.ReportMessage)).Methods("POST")
+	router.HandleFunc("/course/{courseId}/broadcast", userHandler.ValidateSession(az.Require(instructor, controllers.CourseParam("courseId"), messageHandler.Broadcast))).Methods("POST")
+	router.HandleFunc("/message/{id}/dismiss", userHandler.ValidateSession(az.Require(instructor, controllers.MessageParam("id"), moderationHandler.DismissMessageReports))).Methods("PUT")
+	router.HandleFunc("/block", userHandler.ValidateSession(messageHandler.GetBlocks)).Methods("GET")
+	router.HandleFunc("/block/{userId}", userHandler.ValidateSession(messageHandler.BlockUser)).Methods("PUT")
+	router.HandleFunc("/block/{userId}", userHandler.ValidateSession(messageHandler.UnblockUser)).Methods("DELETE")
 
 	// login/auth routes
 	router.HandleFunc("/login", userHandler.Login).Methods("POST")
 	router.HandleFunc("/sso", controllers.SSO).Methods("GET")
 	router.HandleFunc("/callback", userHandler.Callback).Methods("GET")
 	router.HandleFunc("/logout", userHandler.Logout).Methods("GET")
+	router.HandleFunc("/session", impersonationHandler.GetSessionInfo).Methods("GET")
 
 	// genetic algorithm routes - TODO: test
 	router.HandleFunc("/genetic", geneticHandler.RunGenetic).Methods("POST")
 
+	// Prometheus metrics and health checks
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
+	router.HandleFunc("/healthz", healthHandler.Liveness).Methods("GET")
+	router.HandleFunc("/readyz", healthHandler.Readiness).Methods("GET")
+
 	// admin routes
 	router.HandleFunc("/admin/stats", userHandler.ValidateSession(adminHandler.GetSystemStats)).Methods("GET")
 	router.HandleFunc("/admin/user/{id}/stats", userHandler.ValidateSession(adminHandler.GetUserStats)).Methods("GET")
 	router.HandleFunc("/admin/course/{id}/stats", userHandler.ValidateSession(adminHandler.GetCourseStats)).Methods("GET")
+	router.HandleFunc("/admin/permissions", userHandler.ValidateSession(permissionHandler.GetPermissionMatrix)).Methods("GET")
+
+	// audit log routes (read-only; entries cannot be changed through the API)
+	router.HandleFunc("/admin/audit", userHandler.ValidateSession(auditHandler.GetAuditLog)).Methods("GET")
+	router.HandleFunc("/admin/audit/export", userHandler.ValidateSession(auditHandler.ExportAuditLog)).Methods("GET")
+	router.HandleFunc("/admin/audit/verify", userHandler.ValidateSession(auditHandler.VerifyAuditLog)).Methods("GET")
+
+	// impersonation routes (support staff seeing what a user sees)
+	router.HandleFunc("/admin/impersonate/{id}", userHandler.ValidateSession(impersonationHandler.StartImpersonation)).Methods("POST")
+	router.HandleFunc("/admin/impersonate", userHandler.ValidateSession(impersonationHandler.EndImpersonation)).Methods("DELETE")
 
 	// progress tracking routes
-	router.HandleFunc("/user/{userId}/progress", userHandler.ValidateSession(progressHandler.GetUserProgress)).Methods("GET")
-	router.HandleFunc("/user/{userId}/course/{courseId}/progress", userHandler.ValidateSession(progressHandler.GetCourseProgress)).Methods("GET")
-	router.HandleFunc("/user/{userId}/course/{courseId}/progress", userHandler.ValidateSession(progressHandler.UpdateUserCourseProgress)).Methods("PUT")
+	router.HandleFunc("/user/{userId}/progress", userHandler.ValidateSession(az.Require(owner, controllers.LearnerParam("userId"), progressHandler.GetUserProgress))).Methods("GET")
+	router.HandleFunc("/user/{userId}/course/{courseId}/progress", userHandler.ValidateSession(az.Require(ta, controllers.LearnerInCourse("userId", "courseId"), progressHandler.GetCourseProgress))).Methods("GET")
+	router.HandleFunc("/user/{userId}/course/{courseId}/progress", userHandler.ValidateSession(az.Require(instructor, controllers.CourseParam("courseId"), progressHandler.UpdateUserCourseProgress))).Methods("PUT")
 
 	// module attempt routes
-	router.HandleFunc("/module/{id}/start", userHandler.ValidateSession(moduleAttemptHandler.StartModule)).Methods("GET")
-	router.HandleFunc("/user/{userId}/module/{id}/submit", userHandler.ValidateSession(moduleAttemptHandler.SubmitModule)).Methods("POST")
-	router.HandleFunc("/user/{userId}/module/{id}/results", userHandler.ValidateSession(moduleAttemptHandler.GetModuleResults)).Methods("GET")
-	router.HandleFunc("/module/{id}/analytics", userHandler.ValidateSession(moduleAttemptHandler.GetModuleAnalytics)).Methods("GET")
-	router.HandleFunc("/user/{userId}/module/{id}/reset", userHandler.ValidateSession(moduleAttemptHandler.ResetModuleAttempt)).Methods("POST")
+	router.HandleFunc("/module/{id}/start", userHandler.ValidateSession(az.Require(learner, controllers.ModuleParam("id"), moduleAttemptHandler.StartModule))).Methods("GET")
+	router.HandleFunc("/user/{userId}/module/{id}/submit", userHandler.ValidateSession(az.Require(instructor, controllers.LearnerInModule("userId", "id"), moduleAttemptHandler.SubmitModule))).Methods("POST")
+	router.HandleFunc("/user/{userId}/module/{id}/results", userHandler.ValidateSession(az.Require(ta, controllers.LearnerInModule("userId", "id"), moduleAttemptHandler.GetModuleResults))).Methods("GET")
+	router.HandleFunc("/module/{id}/analytics", userHandler.ValidateSession(az.Require(ta, controllers.ModuleParam("id"), moduleAttemptHandler.GetModuleAnalytics))).Methods("GET")
+	router.HandleFunc("/user/{userId}/module/{id}/reset", userHandler.ValidateSession(az.Require(instructor, controllers.ModuleParam("id"), moduleAttemptHandler.ResetModuleAttempt))).Methods("POST")
+
+	// SCORM packages and the runtime data their SCOs store during an attempt
+	router.HandleFunc("/element/{id}/scorm", userHandler.ValidateSession(az.Require(instructor, controllers.ElementParam("id"), scormHandler.UploadPackage))).Methods("POST")
+	router.HandleFunc("/user/{userId}/module/{moduleId}/scorm/{elementId}", userHandler.ValidateSession(az.Require(owner, controllers.LearnerInModule("userId", "moduleId"), scormHandler.GetRuntimeData))).Methods("GET")
+	router.HandleFunc("/user/{userId}/module/{moduleId}/scorm/{elementId}", userHandler.ValidateSession(az.Require(owner, controllers.LearnerInModule("userId", "moduleId"), scormHandler.SaveRuntimeData))).Methods("PUT")
+	router.HandleFunc("/scorm/runtime.js", controllers.ScormRuntime).Methods("GET")
+
+	// LTI 1.3: platforms launch modules, and admins register the platforms
+	router.HandleFunc("/lti/tool", ltiHandler.GetTool).Methods("GET")
+	router.HandleFunc("/lti/jwks", ltiHandler.GetKeySet).Methods("GET")
+	router.HandleFunc("/lti/login", ltiHandler.Login).Methods("GET")
+	router.HandleFunc("/lti/login", ltiHandler.Login).Methods("POST")
+	router.HandleFunc("/lti/launch", ltiHandler.Launch).Methods("POST")
+	router.HandleFunc("/lti/deeplink", ltiHandler.DeepLink).Methods("POST")
+	router.HandleFunc("/lti/platform", userHandler.ValidateSession(ltiHandler.GetPlatforms)).Methods("GET")
+	router.HandleFunc("/lti/platform", userHandler.ValidateSession(ltiHandler.CreatePlatform)).Methods("POST")
+	router.HandleFunc("/lti/platform/{id}", userHandler.ValidateSession(ltiHandler.UpdatePlatform)).Methods("PUT")
+	router.HandleFunc("/lti/platform/{id}", userHandler.ValidateSession(ltiHandler.DeletePlatform)).Methods("DELETE")
 
 	// file upload routes
 	router.HandleFunc("/upload/project", userHandler.ValidateSession(fileUploadHandler.UploadProject)).Methods("POST")
-	router.HandleFunc("/project/{id}/file", userHandler.ValidateSession(fileUploadHandler.GetProjectFile)).Methods("GET")
-	router.HandleFunc("/project/{id}/download", userHandler.ValidateSession(fileUploadHandler.DownloadProjectFile)).Methods("GET")
-	router.HandleFunc("/project/{id}", userHandler.ValidateSession(fileUploadHandler.DeleteProject)).Methods("DELETE")
-	router.HandleFunc("/user/{userId}/projects", userHandler.ValidateSession(fileUploadHandler.ListUserProjects)).Methods("GET")
-	router.HandleFunc("/module/{moduleId}/projects", userHandler.ValidateSession(fileUploadHandler.ListModuleProjects)).Methods("GET")
-
-	staticDir := os.Getenv("STATIC_DIR")
-	if staticDir == "" {
-		staticDir = "./static"
-	}
-
-	var dir string // command-line flag will override environment variable
-	flag.StringVar(&dir, "dir", staticDir, "the directory to serve files from")
-	flag.Parse()
-
-	// This will serve files under http://localhost:8000/<filename> in the 'dir' directory.
-	router.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir(dir))))
-
-	// TODO: cache these routes!
-	c.UpdateRoutes(router)
-
+	router.HandleFunc("/project/{id}/file", userHandler.ValidateSession(az.Require(ta, controllers.ProjectParam("id"), fileUploadHandler.GetProjectFile))).Methods("GET")
+	router.HandleFunc("/project/{id}/download", userHandler.ValidateSession(az.Require(ta, controllers.ProjectParam("id"), fileUploadHandler.DownloadProjectFile))).Methods("GET")
+	router.HandleFunc("/user/{userId}/projects", userHandler.ValidateSession(az.Require(owner, controllers.LearnerParam("userId"), fileUploadHandler.ListUserProjects))).Methods("GET")
+	router.HandleFunc("/module/{moduleId}/projects", userHandler.ValidateSession(az.Require(ta, controllers.ModuleParam("moduleId"), fileUploadHandler.ListModuleProjects))).Methods("GET")
+
+	// resumable uploads, sent in chunks, and course storage quotas
+	router.HandleFunc("/course/{courseId}/upload", userHandler.ValidateSession(az.Require(learner, controllers.CourseParam("courseId"), uploadHandler.CreateUpload))).Methods("POST")
+	router.HandleFunc("/upload/{id}", userHandler.ValidateSession(uploadHandler.GetUpload)).Methods("GET")
+	router.HandleFunc("/upload/{id}", userHandler.ValidateSession(uploadHandler.SendChunk)).Methods("PUT")
+	router.HandleFunc("/upload/{id}", userHandler.ValidateSession(uploadHandler.CancelUpload)).Methods("DELETE")
+	router.HandleFunc("/course/{courseId}/storage", userHandler.ValidateSession(az.Require(instructor, controllers.CourseParam("courseId"), uploadHandler.GetCourseStorage))).Methods("GET")
+	router.HandleFunc("/admin/course/{id}/storage", userHandler.ValidateSession(uploadHandler.SetCourseStorageLimit)).Methods("PUT")
+
+	// API documentation, generated from the routes above and apiSpecs
+	router.HandleFunc("/openapi.json", OpenAPIHandler(router)).Methods("GET")
+	router.HandleFunc("/docs", APIExplorer).Methods("GET")
+
+	// project files left in the static directory by earlier versions are not
+	// handed out until they are moved into the blob store
+	router.PathPrefix("/uploads/projects/").Handler(http.NotFoundHandler())
+
+	// media imported by earlier versions is served sandboxed
+	router.PathPrefix("/uploads/media/").Handler(controllers.LegacyMedia(staticDir))
+
+	// SCORM packages hold instructors' HTML and JavaScript, and run sandboxed
+	router.PathPrefix("/uploads/scorm/").Handler(controllers.ScormPackages(staticDir))
+
+	// This will serve files under http://localhost:8000/<filename> in the static directory.
+	router.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir(staticDir))))
 }
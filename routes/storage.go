@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"context"
+	"log"
+	"restAPI/config"
+	"restAPI/scan"
+	"restAPI/storage"
+)
+
+// blobStore is where uploaded files are kept: the bucket if there is one,
+// which App Engine's read-only filesystem needs, otherwise STORAGE_DIR
+func blobStore(ctx context.Context, cfg *config.Config) storage.BlobStore {
+	if cfg.Storage.Bucket == "" {
+		return storage.NewLocal(cfg.Storage.Dir)
+	}
+	blobs, err := storage.NewGCS(ctx, cfg.Storage.Bucket, cfg.CredentialsFile)
+	if err != nil {
+		log.Fatalf("Could not connect to the storage bucket: %v", err)
+	}
+	return blobs
+}
+
+// malwareScanner scans uploads with clamd at CLAMAV_ADDR, or not at all
+func malwareScanner(cfg *config.Config) scan.Scanner {
+	if cfg.Storage.ClamAVAddr == "" {
+		log.Println("CLAMAV_ADDR is not set: uploads are not scanned for malware")
+		return scan.NoOp{}
+	}
+	return scan.NewClamAV(cfg.Storage.ClamAVAddr, cfg.Storage.ScanTimeout)
+}
@@ -0,0 +1,91 @@
+package scan
+
+import (
+	"archive/zip"
+	"io"
+	"path"
+	"strings"
+)
+
+// Limits bound what an archive may unpack to
+type Limits struct {
+	MaxFiles int
+	// MaxSize is the total size of the files unpacked
+	MaxSize int64
+	// MaxRatio is how many times larger than its compressed size a file may
+	// unpack to; zip bombs compress far better than real files
+	MaxRatio int64
+}
+
+// DefaultLimits are the limits Check applies
+var DefaultLimits = Limits{MaxFiles: 10000, MaxSize: 1 << 30, MaxRatio: 100}
+
+// InspectZip rejects zip archives with entries which would unpack outside
+// the directory they are unpacked in, symlinks, or more than limits allow.
+// Declared sizes may lie, so every entry is decompressed and counted.
+func InspectZip(r io.ReaderAt, size int64, limits Limits) error {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return rejectf("invalid zip archive: %v", err)
+	}
+	if len(archive.File) > limits.MaxFiles {
+		return rejectf("the archive has more than %d files", limits.MaxFiles)
+	}
+
+	for _, f := range archive.File {
+		name := strings.ReplaceAll(f.Name, `\`, "/")
+		clean := path.Clean(name)
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(name, ":") {
+			return rejectf("%s in the archive is outside it", f.Name)
+		}
+		if !f.Mode().IsRegular() && !f.FileInfo().IsDir() {
+			return rejectf("%s in the archive is not a regular file", f.Name)
+		}
+	}
+
+	var total int64
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		n, err := unpackedSize(f, limits.MaxSize-total)
+		if err != nil {
+			return err
+		}
+		if total += n; total > limits.MaxSize {
+			return rejectf("the archive unpacks to more than %d MB", limits.MaxSize>>20)
+		}
+		if n > 1<<20 && n > int64(f.CompressedSize64)*limits.MaxRatio {
+			return rejectf("%s in the archive is compressed suspiciously well", f.Name)
+		}
+	}
+	return nil
+}
+
+// unpackedSize decompresses f, stopping after max+1 bytes
+func unpackedSize(f *zip.File, max int64) (int64, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return 0, rejectf("%s in the archive cannot be read: %v", f.Name, err)
+	}
+	defer rc.Close()
+	n, err := io.Copy(io.Discard, io.LimitReader(rc, max+1))
+	if err != nil {
+		return 0, rejectf("%s in the archive cannot be read: %v", f.Name, err)
+	}
+	return n, nil
+}
+
+// hasEntry reports whether the zip archive has a file called name
+func hasEntry(r io.ReaderAt, size int64, name string) bool {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return false
+	}
+	for _, f := range archive.File {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
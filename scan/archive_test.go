@@ -0,0 +1,124 @@
+package scan
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+// entry is a file to put in a test archive
+type entry struct {
+	name string
+	body string
+	mode os.FileMode
+	// declared overrides the uncompressed size written in the archive
+	declared uint64
+}
+
+func makeZip(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if e.mode != 0 {
+			header.SetMode(e.mode)
+		}
+		if e.declared == 0 {
+			w, err := archive.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(e.body))
+			continue
+		}
+
+		// compress by hand, so that the header can lie about the size
+		var compressed bytes.Buffer
+		fw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+		fw.Write([]byte(e.body))
+		fw.Close()
+		header.CRC32 = crc32.ChecksumIEEE([]byte(e.body))
+		header.CompressedSize64 = uint64(compressed.Len())
+		header.UncompressedSize64 = e.declared
+		w, err := archive.CreateRaw(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(compressed.Bytes())
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// noise returns n bytes which barely compress
+func noise(n int) string {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(b)
+	return string(b)
+}
+
+func TestInspectZip(t *testing.T) {
+	limits := Limits{MaxFiles: 3, MaxSize: 4 << 20, MaxRatio: 100}
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{name: "files and directories", data: makeZip(t, entry{name: "docs/"}, entry{name: "docs/a.txt", body: text}, entry{name: "b.txt", body: "b"})},
+		{name: "empty archive", data: makeZip(t)},
+		{name: "parent directory", data: makeZip(t, entry{name: "../evil.sh", body: "x"}), wantErr: "is outside it"},
+		{name: "parent directory further in", data: makeZip(t, entry{name: "a/../../evil.sh", body: "x"}), wantErr: "is outside it"},
+		{name: "backslashes", data: makeZip(t, entry{name: `..\evil.sh`, body: "x"}), wantErr: "is outside it"},
+		{name: "absolute path", data: makeZip(t, entry{name: "/etc/passwd", body: "x"}), wantErr: "is outside it"},
+		{name: "drive letter", data: makeZip(t, entry{name: "C:evil.exe", body: "x"}), wantErr: "is outside it"},
+		{name: "symlink", data: makeZip(t, entry{name: "link", body: "/etc/passwd", mode: os.ModeSymlink | 0777}), wantErr: "is not a regular file"},
+		{
+			name:    "too many files",
+			data:    makeZip(t, entry{name: "1", body: "1"}, entry{name: "2", body: "2"}, entry{name: "3", body: "3"}, entry{name: "4", body: "4"}),
+			wantErr: "more than 3 files",
+		},
+		{
+			name:    "too large unpacked",
+			data:    makeZip(t, entry{name: "a", body: noise(3 << 20)}, entry{name: "b", body: noise(3 << 20)}),
+			wantErr: "unpacks to more than 4 MB",
+		},
+		{
+			name:    "zip bomb",
+			data:    makeZip(t, entry{name: "zeros", body: strings.Repeat("\x00", 2<<20)}),
+			wantErr: "compressed suspiciously well",
+		},
+		{
+			name:    "declared size smaller than the content",
+			data:    makeZip(t, entry{name: "liar", body: text, declared: 10}),
+			wantErr: "cannot be read",
+		},
+		{name: "not an archive", data: []byte("just some text"), wantErr: "invalid zip archive"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := InspectZip(bytes.NewReader(test.data), int64(len(test.data)), limits)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("InspectZip: %v", err)
+				}
+				return
+			}
+			var rejected *Rejected
+			if !errors.As(err, &rejected) || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want a rejection containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Package scan decides whether an upload may be kept: its content must be
+// of a type allowed for what it is uploaded for, archives must be safe to
+// unpack, and a malware scanner must pass it while it waits in quarantine
+package scan
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Content types of the files which can be uploaded
+const (
+	PDF       = "application/pdf"
+	DOC       = "application/msword"
+	DOCX      = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	Text      = "text/plain"
+	JPEG      = "image/jpeg"
+	PNG       = "image/png"
+	GIF       = "image/gif"
+	ZIP       = "application/zip"
+	RAR       = "application/vnd.rar"
+	SevenZip  = "application/x-7z-compressed"
+	MP4       = "video/mp4"
+	QuickTime = "video/quicktime"
+	AVI       = "video/x-msvideo"
+	MP3       = "audio/mpeg"
+	WAV       = "audio/wav"
+)
+
+// Extensions maps the extensions uploads may have to the content they must have
+var Extensions = map[string]string{
+	".pdf": PDF, ".doc": DOC, ".docx": DOCX, ".txt": Text,
+	".jpg": JPEG, ".jpeg": JPEG, ".png": PNG, ".gif": GIF,
+	".zip": ZIP, ".rar": RAR, ".7z": SevenZip,
+	".mp4": MP4, ".mov": QuickTime, ".avi": AVI,
+	".mp3": MP3, ".wav": WAV,
+}
+
+// Allowed lists the content types accepted for each use: the element types
+// which take uploads, message attachments, and the images and videos of
+// imported courses
+var Allowed = map[string][]string{
+	"project": {PDF, DOC, DOCX, Text, JPEG, PNG, GIF, ZIP, RAR, SevenZip, MP4, QuickTime, AVI, MP3, WAV},
+	"file":    {PDF, DOC, DOCX, Text, JPEG, PNG, GIF, MP4, QuickTime, MP3, WAV},
+	"message": {PDF, DOC, DOCX, Text, JPEG, PNG, GIF, ZIP},
+	"media":   {JPEG, PNG, GIF, MP4, QuickTime},
+}
+
+// Rejected is the error for an upload which must not be kept; its reason
+// can be shown to the uploader
+type Rejected struct {
+	Reason string
+}
+
+func (e *Rejected) Error() string {
+	return e.Reason
+}
+
+func rejectf(format string, args ...interface{}) error {
+	return &Rejected{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Check reads the start of an upload of size bytes called filename and
+// returns its content type if it is allowed for use, or a *Rejected. The
+// extension must agree with the content, and zip archives (including .docx)
+// must pass InspectZip; RAR and 7z archives are left to the scanner.
+func Check(r io.ReaderAt, size int64, filename string, use string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	want, known := Extensions[ext]
+	if !known || !contains(Allowed[use], want) {
+		return "", rejectf("file type not allowed: %s", ext)
+	}
+
+	head := make([]byte, 512)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	got := Sniff(head[:n])
+
+	switch {
+	case want == DOCX && got == ZIP:
+		if err := InspectZip(r, size, DefaultLimits); err != nil {
+			return "", err
+		}
+		if !hasEntry(r, size, "word/document.xml") {
+			return "", rejectf("%s is not a Word document", filepath.Base(filename))
+		}
+	case got != want:
+		return "", rejectf("the content of %s does not match its %s extension", filepath.Base(filename), ext)
+	case got == ZIP:
+		if err := InspectZip(r, size, DefaultLimits); err != nil {
+			return "", err
+		}
+	}
+	return want, nil
+}
+
+// Sniff names the content type of a file from its first bytes, or returns ""
+func Sniff(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return PDF
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG
+	case bytes.HasPrefix(head, []byte("\xff\xd8\xff")):
+		return JPEG
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return GIF
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return ZIP
+	case bytes.HasPrefix(head, []byte("Rar!\x1a\x07")):
+		return RAR
+	case bytes.HasPrefix(head, []byte("7z\xbc\xaf\x27\x1c")):
+		return SevenZip
+	case bytes.HasPrefix(head, []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1")):
+		return DOC
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		if string(head[8:12]) == "qt  " {
+			return QuickTime
+		}
+		return MP4
+	case len(head) >= 8 && (string(head[4:8]) == "moov" || string(head[4:8]) == "mdat" || string(head[4:8]) == "wide"):
+		return QuickTime
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "AVI ":
+		return AVI
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return WAV
+	case bytes.HasPrefix(head, []byte("ID3")), len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0:
+		return MP3
+	case strings.HasPrefix(http.DetectContentType(head), "text/plain"):
+		// HTML and other markup browsers would render is not plain text
+		return Text
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package scan
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{name: "pdf", head: "%PDF-1.7\n", want: PDF},
+		{name: "png", head: "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", want: PNG},
+		{name: "jpeg", head: "\xff\xd8\xff\xe0\x00\x10JFIF", want: JPEG},
+		{name: "gif87a", head: "GIF87a\x01\x00", want: GIF},
+		{name: "gif89a", head: "GIF89a\x01\x00", want: GIF},
+		{name: "zip", head: "PK\x03\x04\x14\x00", want: ZIP},
+		{name: "empty zip", head: "PK\x05\x06\x00\x00", want: ZIP},
+		{name: "rar", head: "Rar!\x1a\x07\x01\x00", want: RAR},
+		{name: "7z", head: "7z\xbc\xaf\x27\x1c\x00\x04", want: SevenZip},
+		{name: "doc", head: "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1\x00", want: DOC},
+		{name: "mp4", head: "\x00\x00\x00\x20ftypisom\x00\x00\x02\x00", want: MP4},
+		{name: "quicktime brand", head: "\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00", want: QuickTime},
+		{name: "quicktime atom", head: "\x00\x00\x00\x08wide\x00\x00", want: QuickTime},
+		{name: "avi", head: "RIFF\x24\x00\x00\x00AVI LIST", want: AVI},
+		{name: "wav", head: "RIFF\x24\x00\x00\x00WAVEfmt ", want: WAV},
+		{name: "mp3 with a tag", head: "ID3\x03\x00\x00", want: MP3},
+		{name: "mp3 frame", head: "\xff\xfb\x90\x64\x00", want: MP3},
+		{name: "text", head: "Dear diary,\nToday I learned Go.\n", want: Text},
+		{name: "html", head: "<!DOCTYPE html><html><script>alert(1)</script>", want: ""},
+		{name: "svg", head: `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg">`, want: ""},
+		{name: "binary", head: "\x00\x01\x02\x03\x04\x05\x06\x07", want: ""},
+		{name: "short riff", head: "RIFF\x24\x00", want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Sniff([]byte(test.head)); got != test.want {
+				t.Errorf("Sniff(%q) = %q, want %q", test.head, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	word := makeZip(t, entry{name: "[Content_Types].xml", body: "<Types/>"}, entry{name: "word/document.xml", body: "<w:document/>"})
+	notWord := makeZip(t, entry{name: "readme.txt", body: "hello"})
+	escaping := makeZip(t, entry{name: "../../etc/cron.d/job", body: "* * * * * root sh"})
+
+	tests := []struct {
+		name     string
+		filename string
+		use      string
+		content  []byte
+		want     string
+		wantErr  string
+	}{
+		{name: "text", filename: "notes.txt", use: "project", content: []byte("Some notes"), want: Text},
+		{name: "upper case extension", filename: "REPORT.PDF", use: "file", content: []byte("%PDF-1.4"), want: PDF},
+		{name: "image for media", filename: "photo.png", use: "media", content: []byte("\x89PNG\r\n\x1a\n"), want: PNG},
+		{name: "word document", filename: "essay.docx", use: "message", content: word, want: DOCX},
+		{name: "zip archive", filename: "data.zip", use: "project", content: notWord, want: ZIP},
+		{name: "unknown extension", filename: "setup.exe", use: "project", content: []byte("MZ"), wantErr: "file type not allowed: .exe"},
+		{name: "no extension", filename: "README", use: "project", content: []byte("hello"), wantErr: "file type not allowed"},
+		{name: "type not allowed for the use", filename: "data.zip", use: "media", content: notWord, wantErr: "file type not allowed: .zip"},
+		{name: "unknown use", filename: "notes.txt", use: "avatar", content: []byte("hello"), wantErr: "file type not allowed"},
+		{name: "content of another type", filename: "photo.png", use: "media", content: []byte("\xff\xd8\xff\xe0"), wantErr: "does not match its .png extension"},
+		{name: "html as text", filename: "page.txt", use: "project", content: []byte("<html><script>alert(1)</script></html>"), wantErr: "does not match its .txt extension"},
+		{name: "zip which is not a word document", filename: "essay.docx", use: "file", content: notWord, wantErr: "is not a Word document"},
+		{name: "word document which is not a zip", filename: "essay.docx", use: "file", content: []byte("%PDF-1.4"), wantErr: "does not match its .docx extension"},
+		{name: "zip escaping its directory", filename: "data.zip", use: "message", content: escaping, wantErr: "is outside it"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Check(bytes.NewReader(test.content), int64(len(test.content)), test.filename, test.use)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Check: %v", err)
+				}
+				if got != test.want {
+					t.Errorf("got %q, want %q", got, test.want)
+				}
+				return
+			}
+			var rejected *Rejected
+			if !errors.As(err, &rejected) || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got %q, %v, want a rejection containing %q", got, err, test.wantErr)
+			}
+		})
+	}
+}
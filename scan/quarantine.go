@@ -0,0 +1,65 @@
+package scan
+
+import (
+	"context"
+	"io"
+	"log"
+	"path"
+	"restAPI/storage"
+)
+
+// quarantinePrefix is where uploads wait in the blob store until they are
+// scanned; nothing is served from there
+const quarantinePrefix = "quarantine"
+
+// Quarantine holds uploads apart until the scanner passes them, so that a
+// file is never at its own name in the blob store before it was scanned
+type Quarantine struct {
+	blobs   storage.BlobStore
+	scanner Scanner
+}
+
+// NewQuarantine holds uploads in blobs while scanner scans them
+func NewQuarantine(blobs storage.BlobStore, scanner Scanner) *Quarantine {
+	return &Quarantine{blobs: blobs, scanner: scanner}
+}
+
+// Admit stores r in quarantine, scans it there and moves it to name once it
+// passes. A file which does not pass is deleted and a *Rejected returned;
+// if it could not be scanned, it is deleted too and ErrScanFailed returned.
+func (q *Quarantine) Admit(ctx context.Context, name string, r io.Reader, info storage.Info) error {
+	held := quarantinePrefix + "/" + path.Base(name)
+	if err := q.blobs.Put(ctx, held, r, info); err != nil {
+		return err
+	}
+	defer func() {
+		if err := q.blobs.Delete(context.Background(), held); err != nil {
+			log.Printf("Error removing %s from quarantine: %v", held, err)
+		}
+	}()
+
+	if err := q.scan(ctx, held); err != nil {
+		return err
+	}
+	return q.release(ctx, held, name, info)
+}
+
+func (q *Quarantine) scan(ctx context.Context, held string) error {
+	reader, _, err := q.blobs.Open(ctx, held)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return Verify(ctx, q.scanner, reader)
+}
+
+// release copies the scanned file to its name; the blob store has no
+// rename that works across backends
+func (q *Quarantine) release(ctx context.Context, held string, name string, info storage.Info) error {
+	reader, _, err := q.blobs.Open(ctx, held)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return q.blobs.Put(ctx, name, reader, info)
+}
@@ -0,0 +1,131 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Scanner looks for malware. Scan reads r to the end and returns a
+// *Rejected if it found any, or another error if it could not tell.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) error
+}
+
+// ErrScanFailed is returned when the scanner could not tell whether a file
+// is safe; the upload can be tried again later
+var ErrScanFailed = errors.New("the file could not be scanned")
+
+// Verify scans r, returning nil if it is clean, a *Rejected if it is not,
+// or ErrScanFailed if the scanner could not tell
+func Verify(ctx context.Context, scanner Scanner, r io.Reader) error {
+	err := scanner.Scan(ctx, r)
+	var rejected *Rejected
+	if err != nil && !errors.As(err, &rejected) {
+		return fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	return err
+}
+
+// NoOp passes everything; it stands in when no scanner is configured, and in tests
+type NoOp struct{}
+
+func (NoOp) Scan(ctx context.Context, r io.Reader) error {
+	return nil
+}
+
+// ClamAV asks a clamd daemon to scan files, streaming them with INSTREAM
+type ClamAV struct {
+	network string
+	addr    string
+	timeout time.Duration
+}
+
+// NewClamAV scans with the clamd listening on addr: a unix socket path
+// such as /var/run/clamav/clamd.ctl, or host:port. A scan gives up after
+// timeout.
+func NewClamAV(addr string, timeout time.Duration) *ClamAV {
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+	return &ClamAV{network: network, addr: addr, timeout: timeout}
+}
+
+// Scan streams r to clamd in chunks; clamd answers "stream: OK" or
+// "stream: <signature> FOUND"
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sendErr := stream(conn, r)
+	// clamd hangs up on streams over its StreamMaxLength, saying why
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	reply = strings.TrimSpace(strings.TrimSuffix(reply, "\x00"))
+	if reply == "" {
+		if sendErr != nil {
+			return fmt.Errorf("clamd: %w", sendErr)
+		}
+		return fmt.Errorf("clamd: %w", err)
+	}
+
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return rejectf("malware found: %s", strings.TrimSuffix(result, " FOUND"))
+	}
+	return fmt.Errorf("clamd: %s", reply)
+}
+
+func (c *ClamAV) dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	return conn, nil
+}
+
+// stream sends r as INSTREAM chunks, each prefixed by its length, ending
+// with an empty chunk
+func stream(w io.Writer, r io.Reader) error {
+	if _, err := w.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+	buf := make([]byte, 4+32<<10)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,99 @@
+package scorm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits on the CMI data stored for one attempt
+const (
+	MaxElements = 1000
+	MaxValue    = 64000
+)
+
+// ValidateCMI checks data reported through the runtime API: keys are CMI
+// data model elements (cmi.*, adl.*) and values are bounded
+func ValidateCMI(data map[string]string) error {
+	if len(data) > MaxElements {
+		return fmt.Errorf("more than %d data model elements", MaxElements)
+	}
+	for key, value := range data {
+		if !strings.HasPrefix(key, "cmi.") && !strings.HasPrefix(key, "adl.") {
+			return fmt.Errorf("%s is not a CMI data model element", key)
+		}
+		if len(key) > 255 {
+			return fmt.Errorf("%s… is too long", key[:32])
+		}
+		if len(value) > MaxValue {
+			return fmt.Errorf("%s is longer than %d characters", key, MaxValue)
+		}
+	}
+	return nil
+}
+
+// Status summarises an attempt's CMI data
+type Status struct {
+	Completed bool
+	Passed    bool
+	Failed    bool
+	// Score is scaled to 0..1, if the SCO reported one
+	Score    float64
+	HasScore bool
+}
+
+// StatusOf reads the completion, success and score of either version's data
+func StatusOf(version string, cmi map[string]string) Status {
+	var status Status
+	if version == Version2004 {
+		status.Completed = cmi["cmi.completion_status"] == "completed"
+		status.Passed = cmi["cmi.success_status"] == "passed"
+		status.Failed = cmi["cmi.success_status"] == "failed"
+		if scaled, err := strconv.ParseFloat(cmi["cmi.score.scaled"], 64); err == nil {
+			status.Score, status.HasScore = clamp(scaled), true
+		} else {
+			status.Score, status.HasScore = scaledScore(cmi["cmi.score.raw"], cmi["cmi.score.min"], cmi["cmi.score.max"])
+		}
+		return status
+	}
+
+	switch cmi["cmi.core.lesson_status"] {
+	case "passed":
+		status.Completed, status.Passed = true, true
+	case "failed":
+		status.Completed, status.Failed = true, true
+	case "completed":
+		status.Completed = true
+	}
+	status.Score, status.HasScore = scaledScore(cmi["cmi.core.score.raw"], cmi["cmi.core.score.min"], cmi["cmi.core.score.max"])
+	return status
+}
+
+// scaledScore scales raw between min and max, which default to 0 and 100
+func scaledScore(raw, min, max string) (float64, bool) {
+	r, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, false
+	}
+	lo, hi := 0.0, 100.0
+	if v, err := strconv.ParseFloat(min, 64); err == nil {
+		lo = v
+	}
+	if v, err := strconv.ParseFloat(max, 64); err == nil {
+		hi = v
+	}
+	if hi <= lo {
+		return 0, false
+	}
+	return clamp((r - lo) / (hi - lo)), true
+}
+
+func clamp(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
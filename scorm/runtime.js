@@ -0,0 +1,173 @@
+// SCORM runtime API adapter, served at /scorm/runtime.js.
+//
+// Packages are served sandboxed, in an origin of their own, so a SCO cannot
+// reach the page that frames it. Install the adapter in that page with the
+// iframe the SCO is to run in:
+//
+//   ScormRuntime.install({
+//     endpoint: "/user/1/module/2/scorm/3", // GET and PUT CMI data
+//     version: "1.2",                       // the element's scorm_version
+//     frame: document.getElementById("sco"),
+//     src: element.package_location,
+//   });
+//
+// install loads the CMI data and opens src in the frame with the data in its
+// URL fragment. Every page of a package loads this script too, which defines
+// window.API (1.2) and window.API_1484_11 (2004) in the SCO's own window from
+// that data and posts what the SCO commits back to the framing page. Without
+// a frame, install defines the API in the current window and calls the
+// endpoint itself. The runtime API is synchronous, so requests are too.
+(function () {
+  "use strict";
+
+  var PREFIX = "#scorm=";
+
+  function request(method, endpoint, body) {
+    var xhr = new XMLHttpRequest();
+    xhr.open(method, endpoint, false);
+    xhr.withCredentials = true;
+    xhr.setRequestHeader("Accept", "application/json");
+    if (body) xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.send(body ? JSON.stringify(body) : null);
+    if (xhr.status < 200 || xhr.status >= 300) throw new Error(xhr.status + " " + xhr.responseText);
+    return xhr.responseText ? JSON.parse(xhr.responseText) : {};
+  }
+
+  // define defines the runtime API in the current window; load returns the
+  // stored CMI data and save stores the changed elements
+  function define(version, load, save) {
+    var v2004 = version === "2004";
+    var data = {}, changed = {}, state = "new", lastError = "0";
+
+    function fail(code) { lastError = code; return "false"; }
+    function ok() { lastError = "0"; return "true"; }
+
+    function initialize() {
+      if (state === "running") return fail(v2004 ? "103" : "101");
+      try {
+        data = load() || {};
+      } catch (e) {
+        return fail("101");
+      }
+      var resume = !!data["cmi.suspend_data"];
+      if (v2004) {
+        data["cmi.entry"] = resume ? "resume" : "ab-initio";
+        if (!data["cmi.completion_status"]) data["cmi.completion_status"] = "unknown";
+        if (!data["cmi.success_status"]) data["cmi.success_status"] = "unknown";
+      } else {
+        data["cmi.core.entry"] = resume ? "resume" : "ab-initio";
+        if (!data["cmi.core.lesson_status"]) data["cmi.core.lesson_status"] = "not attempted";
+      }
+      state = "running";
+      return ok();
+    }
+
+    function getValue(key) {
+      if (state !== "running") { lastError = v2004 ? "122" : "301"; return ""; }
+      var match = /^(.*)\._count$/.exec(key);
+      if (match) {
+        var prefix = match[1] + ".", indexes = {};
+        Object.keys(data).forEach(function (k) {
+          if (k.indexOf(prefix) === 0) indexes[k.slice(prefix.length).split(".")[0]] = true;
+        });
+        lastError = "0";
+        return String(Object.keys(indexes).length);
+      }
+      lastError = "0";
+      return key in data ? data[key] : "";
+    }
+
+    function setValue(key, value) {
+      if (state !== "running") return fail(v2004 ? "132" : "301");
+      if (!/^(cmi|adl)\./.test(key)) return fail(v2004 ? "401" : "201");
+      data[key] = changed[key] = String(value);
+      return ok();
+    }
+
+    function commit(finish) {
+      if (state !== "running") return fail(v2004 ? "142" : "301");
+      try {
+        save(changed, !!finish);
+      } catch (e) {
+        return fail(v2004 ? "391" : "101");
+      }
+      changed = {};
+      return ok();
+    }
+
+    function terminate() {
+      var result = commit(true);
+      if (result === "true") state = "terminated";
+      return result;
+    }
+
+    function errorString(code) {
+      return { "0": "No error", "101": "General exception", "103": "Already initialized",
+        "122": "Retrieve data before initialization", "132": "Store data before initialization",
+        "142": "Commit before initialization", "201": "Invalid argument", "301": "Not initialized",
+        "391": "General commit failure", "401": "Undefined data model element" }[code] || "";
+    }
+
+    window.API = {
+      LMSInitialize: initialize,
+      LMSFinish: terminate,
+      LMSGetValue: getValue,
+      LMSSetValue: setValue,
+      LMSCommit: function () { return commit(false); },
+      LMSGetLastError: function () { return lastError; },
+      LMSGetErrorString: errorString,
+      LMSGetDiagnostic: function () { return ""; },
+    };
+    window.API_1484_11 = {
+      Initialize: initialize,
+      Terminate: terminate,
+      GetValue: getValue,
+      SetValue: setValue,
+      Commit: function () { return commit(false); },
+      GetLastError: function () { return lastError; },
+      GetErrorString: errorString,
+      GetDiagnostic: function () { return ""; },
+    };
+  }
+
+  function install(options) {
+    if (!options.frame) {
+      define(options.version, function () {
+        return request("GET", options.endpoint).cmi;
+      }, function (changed, finish) {
+        request("PUT", options.endpoint, { cmi: changed, finish: finish });
+      });
+      return;
+    }
+
+    var frame = options.frame;
+    window.addEventListener("message", function (event) {
+      var message = event.data;
+      if (event.source !== frame.contentWindow || !message || message.scorm !== "commit") return;
+      try {
+        request("PUT", options.endpoint, { cmi: message.cmi || {}, finish: !!message.finish });
+      } catch (e) {
+        console.error("SCORM commit failed", e);
+      }
+    });
+
+    var launch = {
+      version: options.version,
+      origin: window.location.origin,
+      cmi: request("GET", options.endpoint).cmi || {},
+    };
+    frame.src = options.src.split("#")[0] + PREFIX + encodeURIComponent(JSON.stringify(launch));
+  }
+
+  // inside a package page opened by install
+  if (window.parent !== window && window.location.hash.indexOf(PREFIX) === 0) {
+    var launch = JSON.parse(decodeURIComponent(window.location.hash.slice(PREFIX.length)));
+    define(launch.version, function () {
+      return launch.cmi;
+    }, function (changed, finish) {
+      window.parent.postMessage({ scorm: "commit", cmi: changed, finish: finish }, launch.origin);
+    });
+  }
+
+  window.ScormRuntime = { install: install };
+})();
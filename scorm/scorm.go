@@ -0,0 +1,257 @@
+// Package scorm unpacks SCORM 1.2 and 2004 content packages and interprets
+// the CMI data their runtime API reports
+package scorm
+
+import (
+	"archive/zip"
+	"bytes"
+	_ "embed"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SCORM versions
+const (
+	Version12   = "1.2"
+	Version2004 = "2004"
+)
+
+// Limits on the packages Extract accepts
+const (
+	MaxFiles    = 10000
+	MaxFileSize = 200 << 20
+	MaxSize     = 1 << 30
+)
+
+// Package describes a content package from its imsmanifest.xml
+type Package struct {
+	Version string `json:"version"`
+	Title   string `json:"title"`
+	// Launch is the path, inside the package, of the first SCO
+	Launch string `json:"launch"`
+}
+
+type manifest struct {
+	Metadata struct {
+		SchemaVersion string `xml:"schemaversion"`
+	} `xml:"metadata"`
+	Organizations struct {
+		Default       string         `xml:"default,attr"`
+		Organizations []organization `xml:"organization"`
+	} `xml:"organizations"`
+	Resources []resource `xml:"resources>resource"`
+}
+
+type organization struct {
+	Identifier string `xml:"identifier,attr"`
+	Title      string `xml:"title"`
+	Items      []item `xml:"item"`
+}
+
+type item struct {
+	IdentifierRef string `xml:"identifierref,attr"`
+	Items         []item `xml:"item"`
+}
+
+type resource struct {
+	Identifier string `xml:"identifier,attr"`
+	Href       string `xml:"href,attr"`
+	Base       string `xml:"base,attr"`
+	// adlcp:scormtype in 1.2 and adlcp:scormType in 2004
+	ScormType12   string `xml:"scormtype,attr"`
+	ScormType2004 string `xml:"scormType,attr"`
+}
+
+// ReadManifest finds the version and launch page of a zipped package
+func ReadManifest(data []byte) (*Package, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip: %w", err)
+	}
+
+	var manifestFile *zip.File
+	for _, f := range archive.File {
+		if f.Name == "imsmanifest.xml" {
+			manifestFile = f
+		}
+	}
+	if manifestFile == nil {
+		return nil, fmt.Errorf("no imsmanifest.xml at the root of the package")
+	}
+	if manifestFile.UncompressedSize64 > 10<<20 {
+		return nil, fmt.Errorf("imsmanifest.xml is too large")
+	}
+	r, err := manifestFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var m manifest
+	if err := xml.NewDecoder(io.LimitReader(r, 10<<20)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("imsmanifest.xml: %w", err)
+	}
+
+	pkg := &Package{Version: Version12}
+	if version := strings.TrimSpace(m.Metadata.SchemaVersion); strings.Contains(version, "2004") || strings.HasPrefix(version, "CAM 1.3") {
+		pkg.Version = Version2004
+	}
+
+	resources := map[string]resource{}
+	for _, res := range m.Resources {
+		resources[res.Identifier] = res
+	}
+
+	// the default organization, or the first
+	var org *organization
+	for i := range m.Organizations.Organizations {
+		if org == nil || m.Organizations.Organizations[i].Identifier == m.Organizations.Default {
+			org = &m.Organizations.Organizations[i]
+		}
+	}
+	if org != nil {
+		pkg.Title = strings.TrimSpace(org.Title)
+		if res, ok := firstSCO(org.Items, resources); ok {
+			pkg.Launch = path.Join(res.Base, res.Href)
+		}
+	}
+	if pkg.Launch == "" {
+		// no organization: the first SCO resource
+		for _, res := range m.Resources {
+			if isSCO(res) && res.Href != "" {
+				pkg.Launch = path.Join(res.Base, res.Href)
+				break
+			}
+		}
+	}
+	if pkg.Launch == "" {
+		return nil, fmt.Errorf("the manifest has no SCO to launch")
+	}
+
+	// launch pages may carry a query string
+	launchFile, _, _ := strings.Cut(pkg.Launch, "?")
+	if _, err := archive.Open(launchFile); err != nil {
+		return nil, fmt.Errorf("launch page %s is not in the package", launchFile)
+	}
+	return pkg, nil
+}
+
+func firstSCO(items []item, resources map[string]resource) (resource, bool) {
+	for _, it := range items {
+		if res, ok := resources[it.IdentifierRef]; ok && isSCO(res) && res.Href != "" {
+			return res, true
+		}
+		if res, ok := firstSCO(it.Items, resources); ok {
+			return res, true
+		}
+	}
+	return resource{}, false
+}
+
+func isSCO(res resource) bool {
+	return strings.EqualFold(res.ScormType12, "sco") || strings.EqualFold(res.ScormType2004, "sco")
+}
+
+// Extract unpacks a zipped package into dir, refusing entries which would
+// land outside it and packages over the size limits
+func Extract(data []byte, dir string) error {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("invalid zip: %w", err)
+	}
+	if len(archive.File) > MaxFiles {
+		return fmt.Errorf("more than %d files", MaxFiles)
+	}
+
+	var total uint64
+	for _, f := range archive.File {
+		name := path.Clean(strings.ReplaceAll(f.Name, `\`, "/"))
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("%s is outside the package", f.Name)
+		}
+		if f.UncompressedSize64 > MaxFileSize {
+			return fmt.Errorf("%s is larger than %d MB", f.Name, MaxFileSize>>20)
+		}
+		if total += f.UncompressedSize64; total > MaxSize {
+			return fmt.Errorf("the package is larger than %d MB unpacked", MaxSize>>20)
+		}
+	}
+
+	for _, f := range archive.File {
+		name := path.Clean(strings.ReplaceAll(f.Name, `\`, "/"))
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			continue // no symlinks
+		}
+		if err := extractFile(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	// the declared size may lie
+	n, err := io.Copy(out, io.LimitReader(r, MaxFileSize+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > MaxFileSize {
+		err = fmt.Errorf("%s is larger than %d MB", f.Name, MaxFileSize>>20)
+	}
+	return err
+}
+
+//go:embed runtime.js
+var Runtime []byte
+
+// RuntimeScript is the tag InjectRuntime adds to the pages of a package
+const RuntimeScript = `<script src="/scorm/runtime.js"></script>`
+
+var (
+	headTag = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+	htmlTag = regexp.MustCompile(`(?i)<html(\s[^>]*)?>`)
+	doctype = regexp.MustCompile(`(?i)^\s*<!doctype[^>]*>`)
+)
+
+// InjectRuntime loads the runtime API adapter at the top of an HTML page of
+// a package, so the API is defined before any of the SCO's own scripts run
+func InjectRuntime(page []byte) []byte {
+	at := 0
+	if loc := headTag.FindIndex(page); loc != nil {
+		at = loc[1]
+	} else if loc := htmlTag.FindIndex(page); loc != nil {
+		at = loc[1]
+	} else if loc := doctype.FindIndex(page); loc != nil {
+		at = loc[1]
+	}
+	out := make([]byte, 0, len(page)+len(RuntimeScript))
+	out = append(out, page[:at]...)
+	out = append(out, RuntimeScript...)
+	return append(out, page[at:]...)
+}
@@ -0,0 +1,92 @@
+package search
+
+import "restAPI/models"
+
+// Field weights: a match in a title counts three times a match in the body
+const (
+	titleWeight = 3
+	bodyWeight  = 1
+)
+
+// CourseDocument indexes a course's name, description and home page.
+// Approved courses are public.
+func CourseDocument(course *models.Course) *Document {
+	return &Document{
+		Kind:  KindCourse,
+		ID:    course.KeyID,
+		Title: course.Name,
+		Fields: []Field{
+			{Name: "name", Text: course.Name, Weight: titleWeight},
+			{Name: "description", Text: plainText(course.Description), Weight: bodyWeight},
+			{Name: "home_content", Text: plainText(course.HomeContent), Weight: bodyWeight},
+		},
+		CourseIDs: []int64{course.KeyID},
+		OwnerID:   course.OwnerID,
+		Public:    course.Approved,
+	}
+}
+
+// ModuleDocument indexes a module's name and description
+func ModuleDocument(module *models.Module) *Document {
+	return &Document{
+		Kind:  KindModule,
+		ID:    module.KeyID,
+		Title: module.Name,
+		Fields: []Field{
+			{Name: "name", Text: module.Name, Weight: titleWeight},
+			{Name: "description", Text: plainText(module.Description), Weight: bodyWeight},
+		},
+		CourseIDs: []int64{module.CourseID},
+		OwnerID:   module.OwnerID,
+	}
+}
+
+// ElementDocument indexes an element's text. Elements can be shared between
+// modules, so the document belongs to the courses of every module using it.
+func ElementDocument(element *models.Element, courseIDs []int64) *Document {
+	text := plainText(element.Text)
+	return &Document{
+		Kind:      KindElement,
+		ID:        element.KeyID,
+		Title:     title(text),
+		Fields:    []Field{{Name: "text", Text: text, Weight: bodyWeight}},
+		CourseIDs: courseIDs,
+		OwnerID:   element.OwnerID,
+	}
+}
+
+// ThreadDocument indexes a thread's title, body and visible replies,
+// including any still embedded in the thread, in the course of its module
+// (0 if it has none)
+func ThreadDocument(thread *models.Thread, courseID int64, replies []*models.Reply) *Document {
+	doc := &Document{
+		Kind:  KindThread,
+		ID:    thread.KeyID,
+		Title: thread.Title,
+		Fields: []Field{
+			{Name: "title", Text: thread.Title, Weight: titleWeight},
+			{Name: "body", Text: plainText(thread.Body), Weight: bodyWeight},
+		},
+	}
+	if courseID != 0 {
+		doc.CourseIDs = []int64{courseID}
+	}
+	for _, reply := range thread.Replies {
+		doc.Fields = append(doc.Fields, Field{Name: "reply", Text: plainText(reply.Body), Weight: bodyWeight})
+	}
+	for _, reply := range replies {
+		if !reply.Deleted && !reply.Hidden {
+			doc.Fields = append(doc.Fields, Field{Name: "reply", Text: plainText(reply.Body), Weight: bodyWeight})
+		}
+	}
+	return doc
+}
+
+// title shortens text to its first words, for documents without a title
+func title(text string) string {
+	const length = 60
+	if len(text) <= length {
+		return text
+	}
+	return text[:wordBoundary(text, length)] + "…"
+}
@@ -0,0 +1,390 @@
+// Package search keeps an in-memory full-text index of courses, modules,
+// elements and discussion threads. Each instance builds its index from
+// Datastore at startup and keeps it current as content is written through
+// the repositories returned by IndexCourses, IndexModules, IndexElements
+// and IndexThreads.
+package search
+
+import (
+	"html"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kinds of indexed documents
+const (
+	KindCourse  = "course"
+	KindModule  = "module"
+	KindElement = "element"
+	KindThread  = "thread"
+)
+
+// Field is a piece of a document's text; matches in heavier fields rank higher
+type Field struct {
+	Name   string
+	Text   string
+	Weight float64
+}
+
+// Document is an indexed entity. CourseIDs, OwnerID and Public are kept so
+// that results can be checked against the searcher's permissions without
+// looking anything up.
+type Document struct {
+	Kind      string
+	ID        int64
+	Title     string
+	Fields    []Field
+	CourseIDs []int64
+	OwnerID   int64
+	Public    bool
+}
+
+// Hit is a ranked match, with a snippet of the matching text in which the
+// matched words are wrapped in <mark> (the rest is HTML-escaped)
+type Hit struct {
+	Document *Document
+	Score    float64
+	Snippet  string
+}
+
+type docKey struct {
+	kind string
+	id   int64
+}
+
+// BM25 parameters
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Index is safe for concurrent use
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[docKey]*Document
+	lengths  map[docKey]float64
+	postings map[string]map[docKey]float64 // term -> document -> weighted frequency
+	total    float64                       // sum of lengths
+}
+
+// NewIndex creates an empty Index
+func NewIndex() *Index {
+	return &Index{
+		docs:     map[docKey]*Document{},
+		lengths:  map[docKey]float64{},
+		postings: map[string]map[docKey]float64{},
+	}
+}
+
+// Len returns the number of indexed documents
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// Put adds or replaces a document
+func (ix *Index) Put(doc *Document) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(docKey{doc.Kind, doc.ID})
+	ix.add(doc)
+}
+
+// OwnedCourses returns the IDs of the indexed courses ownerID owns
+func (ix *Index) OwnedCourses(ownerID int64) []int64 {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	var ids []int64
+	for key, doc := range ix.docs {
+		if key.kind == KindCourse && doc.OwnerID == ownerID {
+			ids = append(ids, key.id)
+		}
+	}
+	return ids
+}
+
+// Delete removes a document, if it is indexed
+func (ix *Index) Delete(kind string, id int64) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(docKey{kind, id})
+}
+
+// Replace swaps the whole index for docs, e.g. after reloading from Datastore
+func (ix *Index) Replace(docs []*Document) {
+	fresh := NewIndex()
+	for _, doc := range docs {
+		fresh.add(doc)
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.docs, ix.lengths, ix.postings, ix.total = fresh.docs, fresh.lengths, fresh.postings, fresh.total
+}
+
+func (ix *Index) add(doc *Document) {
+	key := docKey{doc.Kind, doc.ID}
+	length := 0.0
+	for _, field := range doc.Fields {
+		for _, token := range tokenize(field.Text) {
+			terms := ix.postings[token.term]
+			if terms == nil {
+				terms = map[docKey]float64{}
+				ix.postings[token.term] = terms
+			}
+			terms[key] += field.Weight
+			length += field.Weight
+		}
+	}
+	ix.docs[key] = doc
+	ix.lengths[key] = length
+	ix.total += length
+}
+
+func (ix *Index) remove(key docKey) {
+	doc, ok := ix.docs[key]
+	if !ok {
+		return
+	}
+	for _, field := range doc.Fields {
+		for _, token := range tokenize(field.Text) {
+			if terms := ix.postings[token.term]; terms != nil {
+				delete(terms, key)
+				if len(terms) == 0 {
+					delete(ix.postings, token.term)
+				}
+			}
+		}
+	}
+	ix.total -= ix.lengths[key]
+	delete(ix.lengths, key)
+	delete(ix.docs, key)
+}
+
+// Search ranks the documents matching any word of query with BM25, favouring
+// documents which match every word. The last word also matches as a prefix,
+// so results follow the user's typing. Only documents of the given kinds (all
+// if none) for which allow returns true are returned, at most limit of them.
+func (ix *Index) Search(query string, kinds []string, limit int, allow func(doc *Document) bool) []Hit {
+	words := uniqueTerms(tokenize(query))
+	if len(words) == 0 {
+		return nil
+	}
+
+	wanted := map[string]bool{}
+	for _, kind := range kinds {
+		wanted[kind] = true
+	}
+
+	ix.mu.RLock()
+	scores := map[docKey]float64{}
+	matched := map[docKey]int{}
+	matchedTerms := map[docKey][]string{}
+	n := float64(len(ix.docs))
+	avg := 1.0
+	if n > 0 && ix.total > 0 {
+		avg = ix.total / n
+	}
+
+	for i, word := range words {
+		terms := []string{word}
+		if i == len(words)-1 && utf8.RuneCountInString(word) >= 3 {
+			terms = ix.prefixed(word)
+		}
+
+		seen := map[docKey]bool{}
+		for _, term := range terms {
+			postings := ix.postings[term]
+			idf := math.Log(1 + (n-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+			boost := 1.0
+			if term != word {
+				boost = 0.5
+			}
+			for key, tf := range postings {
+				if len(wanted) > 0 && !wanted[key.kind] {
+					continue
+				}
+				norm := tf * (k1 + 1) / (tf + k1*(1-b+b*ix.lengths[key]/avg))
+				scores[key] += boost * idf * norm
+				matchedTerms[key] = append(matchedTerms[key], term)
+				if !seen[key] {
+					seen[key] = true
+					matched[key]++
+				}
+			}
+		}
+	}
+
+	candidates := make([]Hit, 0, len(scores))
+	terms := map[*Document][]string{}
+	for key, score := range scores {
+		doc := ix.docs[key]
+		coverage := float64(matched[key]) / float64(len(words))
+		candidates = append(candidates, Hit{Document: doc, Score: score * coverage * coverage})
+		terms[doc] = matchedTerms[key]
+	}
+	ix.mu.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		if candidates[i].Document.Kind != candidates[j].Document.Kind {
+			return candidates[i].Document.Kind < candidates[j].Document.Kind
+		}
+		return candidates[i].Document.ID < candidates[j].Document.ID
+	})
+
+	hits := []Hit{}
+	for _, hit := range candidates {
+		if len(hits) == limit {
+			break
+		}
+		if allow != nil && !allow(hit.Document) {
+			continue
+		}
+		hit.Snippet = snippet(hit.Document, terms[hit.Document])
+		hits = append(hits, hit)
+	}
+	return hits
+}
+
+// prefixed returns the indexed terms starting with prefix
+func (ix *Index) prefixed(prefix string) []string {
+	var terms []string
+	for term := range ix.postings {
+		if strings.HasPrefix(term, prefix) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+type token struct {
+	term       string
+	start, end int // byte offsets in the text
+}
+
+// tokenize splits text into lower-cased words of letters and digits
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+func uniqueTerms(tokens []token) []string {
+	seen := map[string]bool{}
+	var terms []string
+	for _, token := range tokens {
+		if !seen[token.term] {
+			seen[token.term] = true
+			terms = append(terms, token.term)
+		}
+	}
+	return terms
+}
+
+// snippet length, in bytes, either side of the first match
+const snippetRadius = 80
+
+// snippet highlights the matched terms around the first match in the field
+// with the most matches, preferring lighter (body) fields to the title
+func snippet(doc *Document, terms []string) string {
+	match := map[string]bool{}
+	for _, term := range terms {
+		match[term] = true
+	}
+
+	var best Field
+	var bestTokens []token
+	bestCount := 0
+	for _, field := range doc.Fields {
+		tokens := tokenize(field.Text)
+		count := 0
+		for _, token := range tokens {
+			if match[token.term] {
+				count++
+			}
+		}
+		if count > bestCount || count == bestCount && count > 0 && field.Weight < best.Weight {
+			best, bestTokens, bestCount = field, tokens, count
+		}
+	}
+	if bestCount == 0 {
+		return ""
+	}
+
+	text := best.Text
+	first := 0
+	for _, token := range bestTokens {
+		if match[token.term] {
+			first = token.start
+			break
+		}
+	}
+	from, to := wordBoundary(text, first-snippetRadius), wordBoundary(text, first+snippetRadius)
+	if from > first {
+		from = first
+	}
+
+	var out strings.Builder
+	if from > 0 {
+		out.WriteString("…")
+	}
+	pos := from
+	for _, token := range bestTokens {
+		if token.start < from || token.end > to || !match[token.term] {
+			continue
+		}
+		out.WriteString(html.EscapeString(text[pos:token.start]))
+		out.WriteString("<mark>" + html.EscapeString(text[token.start:token.end]) + "</mark>")
+		pos = token.end
+	}
+	out.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		out.WriteString("…")
+	}
+	return out.String()
+}
+
+// wordBoundary moves i within text to the nearest space at or after it
+func wordBoundary(text string, i int) int {
+	if i <= 0 {
+		return 0
+	}
+	if i >= len(text) {
+		return len(text)
+	}
+	if space := strings.IndexByte(text[i:], ' '); space >= 0 {
+		return i + space
+	}
+	return len(text)
+}
+
+var tags = regexp.MustCompile(`<[^>]*>`)
+
+// plainText strips HTML tags and entities from content written in the editor
+func plainText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(tags.ReplaceAllString(s, " "))), " ")
+}
@@ -0,0 +1,428 @@
+package search
+
+import (
+	"log"
+	"restAPI/models"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// IndexCourses wraps a CourseRepository so that every write is indexed
+func IndexCourses(repository models.CourseRepository, index *Index) models.CourseRepository {
+	return &indexedCourses{CourseRepository: repository, index: index}
+}
+
+type indexedCourses struct {
+	models.CourseRepository
+	index *Index
+}
+
+func (r *indexedCourses) CreateCourse(course *models.Course) (*datastore.Key, error) {
+	key, err := r.CourseRepository.CreateCourse(course)
+	if err == nil {
+		indexed := *course
+		indexed.KeyID = key.ID
+		r.index.Put(CourseDocument(&indexed))
+	}
+	return key, err
+}
+
+func (r *indexedCourses) UpdateCourse(id int64, course *models.Course) (*datastore.Key, error) {
+	key, err := r.CourseRepository.UpdateCourse(id, course)
+	if err == nil {
+		indexed := *course
+		indexed.KeyID = id
+		r.index.Put(CourseDocument(&indexed))
+	}
+	return key, err
+}
+
+func (r *indexedCourses) DeleteCourse(id int64) error {
+	err := r.CourseRepository.DeleteCourse(id)
+	if err == nil {
+		r.index.Delete(KindCourse, id)
+	}
+	return err
+}
+
+// IndexModules wraps a ModuleRepository so that every write is indexed
+func IndexModules(repository models.ModuleRepository, index *Index) models.ModuleRepository {
+	return &indexedModules{ModuleRepository: repository, index: index}
+}
+
+type indexedModules struct {
+	models.ModuleRepository
+	index *Index
+}
+
+func (r *indexedModules) CreateModule(module *models.Module) (*datastore.Key, error) {
+	key, err := r.ModuleRepository.CreateModule(module)
+	if err == nil {
+		indexed := *module
+		indexed.KeyID = key.ID
+		r.index.Put(ModuleDocument(&indexed))
+	}
+	return key, err
+}
+
+func (r *indexedModules) UpdateModule(id int64, module *models.Module) (*datastore.Key, error) {
+	key, err := r.ModuleRepository.UpdateModule(id, module)
+	if err == nil {
+		indexed := *module
+		indexed.KeyID = id
+		r.index.Put(ModuleDocument(&indexed))
+	}
+	return key, err
+}
+
+func (r *indexedModules) DeleteModule(id int64) error {
+	err := r.ModuleRepository.DeleteModule(id)
+	if err == nil {
+		r.index.Delete(KindModule, id)
+	}
+	return err
+}
+
+// IndexElements wraps an ElementRepository so that every write is indexed,
+// with the courses of the modules the element is placed in
+func IndexElements(repository models.ElementRepository, moduleElements models.ModuleElementRepository, index *Index) models.ElementRepository {
+	return &indexedElements{ElementRepository: repository, moduleElements: moduleElements, index: index}
+}
+
+type indexedElements struct {
+	models.ElementRepository
+	moduleElements models.ModuleElementRepository
+	index          *Index
+}
+
+func (r *indexedElements) CreateElement(element *models.Element) (*datastore.Key, error) {
+	key, err := r.ElementRepository.CreateElement(element)
+	if err == nil {
+		// a new element is not placed in any module yet
+		indexed := *element
+		indexed.KeyID = key.ID
+		r.index.Put(ElementDocument(&indexed, nil))
+	}
+	return key, err
+}
+
+func (r *indexedElements) UpdateElement(id int64, element *models.Element) (*datastore.Key, error) {
+	key, err := r.ElementRepository.UpdateElement(id, element)
+	if err == nil {
+		indexed := *element
+		indexed.KeyID = id
+		r.index.Put(ElementDocument(&indexed, elementCourses(r.moduleElements, id)))
+	}
+	return key, err
+}
+
+func (r *indexedElements) DeleteElement(id int64) error {
+	err := r.ElementRepository.DeleteElement(id)
+	if err == nil {
+		r.index.Delete(KindElement, id)
+	}
+	return err
+}
+
+// elementCourses returns the courses of the modules an element is placed
+// in, or none if they cannot be read
+func elementCourses(moduleElements models.ModuleElementRepository, elementID int64) []int64 {
+	modules, err := moduleElements.GetModulesByElementID(elementID)
+	if err != nil {
+		log.Printf("Error indexing the courses of element %d: %v", elementID, err)
+		return nil
+	}
+	var courseIDs []int64
+	for _, module := range modules {
+		courseIDs = appendCourse(courseIDs, module.CourseID)
+	}
+	return courseIDs
+}
+
+func appendCourse(courseIDs []int64, courseID int64) []int64 {
+	for _, id := range courseIDs {
+		if id == courseID {
+			return courseIDs
+		}
+	}
+	return append(courseIDs, courseID)
+}
+
+// IndexModuleElements wraps a ModuleElementRepository so that placing an
+// element in a module, or removing it, re-indexes the element
+func IndexModuleElements(repository models.ModuleElementRepository, elements models.ElementRepository, index *Index) models.ModuleElementRepository {
+	return &indexedModuleElements{ModuleElementRepository: repository, elements: elements, index: index}
+}
+
+type indexedModuleElements struct {
+	models.ModuleElementRepository
+	elements models.ElementRepository
+	index    *Index
+}
+
+func (r *indexedModuleElements) CreateModuleElement(placement *models.ModuleElement) (*datastore.Key, error) {
+	key, err := r.ModuleElementRepository.CreateModuleElement(placement)
+	if err == nil {
+		r.reindex(placement.ElementID)
+	}
+	return key, err
+}
+
+func (r *indexedModuleElements) UpdateModuleElement(id int64, placement *models.ModuleElement) (*datastore.Key, error) {
+	key, err := r.ModuleElementRepository.UpdateModuleElement(id, placement)
+	if err == nil {
+		r.reindex(placement.ElementID)
+	}
+	return key, err
+}
+
+func (r *indexedModuleElements) DeleteModuleElement(id int64) error {
+	placement, err := r.ModuleElementRepository.GetModuleElementByID(id)
+	if err != nil {
+		return err
+	}
+	err = r.ModuleElementRepository.DeleteModuleElement(id)
+	if err == nil {
+		r.reindex(placement.ElementID)
+	}
+	return err
+}
+
+// reindex reads an element back into the index with its current courses
+func (r *indexedModuleElements) reindex(elementID int64) {
+	element, err := r.elements.GetElementByID(elementID)
+	if err != nil {
+		log.Printf("Error indexing element %d: %v", elementID, err)
+		return
+	}
+	element.KeyID = elementID
+	r.index.Put(ElementDocument(element, elementCourses(r.ModuleElementRepository, elementID)))
+}
+
+// IndexThreads wraps a ThreadRepository and a ReplyRepository so that every
+// write to a thread or its replies re-indexes the thread, in the course of
+// its module
+func IndexThreads(repository models.ThreadRepository, replies models.ReplyRepository, modules models.ModuleRepository,
+	index *Index) (models.ThreadRepository, models.ReplyRepository) {
+	threads := &indexedThreads{ThreadRepository: repository, replies: replies, modules: modules, index: index}
+	return threads, &indexedReplies{ReplyRepository: replies, threads: threads}
+}
+
+type indexedThreads struct {
+	models.ThreadRepository
+	replies models.ReplyRepository
+	modules models.ModuleRepository
+	index   *Index
+}
+
+// course returns the course of a thread's module, or 0 if it has none
+func (r *indexedThreads) course(moduleID int64) int64 {
+	if moduleID == 0 {
+		return 0
+	}
+	module, err := r.modules.GetModuleByID(moduleID)
+	if err != nil {
+		log.Printf("Error indexing the course of module %d: %v", moduleID, err)
+		return 0
+	}
+	return module.CourseID
+}
+
+func (r *indexedThreads) CreateThread(thread *models.Thread) (*datastore.Key, error) {
+	key, err := r.ThreadRepository.CreateThread(thread)
+	if err == nil {
+		indexed := *thread
+		indexed.KeyID = key.ID
+		r.index.Put(ThreadDocument(&indexed, r.course(thread.ModuleID), nil))
+	}
+	return key, err
+}
+
+func (r *indexedThreads) UpdateThread(id int64, thread *models.Thread) (*datastore.Key, error) {
+	key, err := r.ThreadRepository.UpdateThread(id, thread)
+	if err == nil {
+		r.reindex(id)
+	}
+	return key, err
+}
+
+func (r *indexedThreads) DeleteThread(id int64) error {
+	err := r.ThreadRepository.DeleteThread(id)
+	if err == nil {
+		r.index.Delete(KindThread, id)
+	}
+	return err
+}
+
+// reindex reads a thread and its replies back into the index
+func (r *indexedThreads) reindex(id int64) {
+	thread, err := r.ThreadRepository.GetThreadByID(id)
+	if err != nil {
+		log.Printf("Error indexing thread %d: %v", id, err)
+		return
+	}
+	replies, err := r.replies.GetRepliesByThreadID(id)
+	if err != nil {
+		log.Printf("Error indexing the replies of thread %d: %v", id, err)
+		return
+	}
+	thread.KeyID = id
+	if thread.Hidden {
+		r.index.Delete(KindThread, id)
+		return
+	}
+	r.index.Put(ThreadDocument(thread, r.course(thread.ModuleID), replies))
+}
+
+func (r *indexedThreads) ModerateThread(id int64, moderate func(thread *models.Thread)) (*models.Thread, error) {
+	thread, err := r.ThreadRepository.ModerateThread(id, moderate)
+	if err == nil {
+		r.reindex(id)
+	}
+	return thread, err
+}
+
+type indexedReplies struct {
+	models.ReplyRepository
+	threads *indexedThreads
+}
+
+func (r *indexedReplies) CreateReply(reply *models.Reply) (*datastore.Key, error) {
+	key, err := r.ReplyRepository.CreateReply(reply)
+	if err == nil {
+		r.threads.reindex(reply.ThreadID)
+	}
+	return key, err
+}
+
+func (r *indexedReplies) EditReply(id int64, body string, editorID int64) (*models.Reply, error) {
+	reply, err := r.ReplyRepository.EditReply(id, body, editorID)
+	if err == nil {
+		r.threads.reindex(reply.ThreadID)
+	}
+	return reply, err
+}
+
+func (r *indexedReplies) DeleteReply(id int64) error {
+	reply, err := r.ReplyRepository.GetReplyByID(id)
+	if err != nil {
+		return err
+	}
+	err = r.ReplyRepository.DeleteReply(id)
+	if err == nil {
+		r.threads.reindex(reply.ThreadID)
+	}
+	return err
+}
+
+func (r *indexedReplies) ModerateReply(id int64, moderate func(reply *models.Reply)) (*models.Reply, error) {
+	reply, err := r.ReplyRepository.ModerateReply(id, moderate)
+	if err == nil {
+		r.threads.reindex(reply.ThreadID)
+	}
+	return reply, err
+}
+
+func (r *indexedReplies) MigrateReplies(threadID int64) (int, error) {
+	moved, err := r.ReplyRepository.MigrateReplies(threadID)
+	if err == nil && moved > 0 {
+		r.threads.reindex(threadID)
+	}
+	return moved, err
+}
+
+// Rebuild reloads the whole index from the repositories. It also brings
+// documents up to date with modules which moved to another course.
+func Rebuild(index *Index, courses models.CourseRepository, modules models.ModuleRepository, elements models.ElementRepository,
+	moduleElements models.ModuleElementRepository, threads models.ThreadRepository, replies models.ReplyRepository) error {
+	var docs []*Document
+
+	allCourses, err := courses.GetAllCourses()
+	if err != nil {
+		return err
+	}
+	for _, course := range allCourses {
+		docs = append(docs, CourseDocument(course))
+	}
+
+	allModules, err := modules.GetAllModules()
+	if err != nil {
+		return err
+	}
+	moduleCourses := map[int64]int64{}
+	for _, module := range allModules {
+		moduleCourses[module.KeyID] = module.CourseID
+		docs = append(docs, ModuleDocument(module))
+	}
+
+	allPlacements, err := moduleElements.GetAllModuleElements()
+	if err != nil {
+		return err
+	}
+	placedIn := map[int64][]int64{}
+	for _, placement := range allPlacements {
+		if courseID, ok := moduleCourses[placement.ModuleID]; ok {
+			placedIn[placement.ElementID] = appendCourse(placedIn[placement.ElementID], courseID)
+		}
+	}
+
+	allElements, err := elements.GetAllElements()
+	if err != nil {
+		return err
+	}
+	for _, element := range allElements {
+		docs = append(docs, ElementDocument(element, placedIn[element.KeyID]))
+	}
+
+	allReplies, err := replies.GetAllReplies()
+	if err != nil {
+		return err
+	}
+	threadReplies := map[int64][]*models.Reply{}
+	for _, reply := range allReplies {
+		threadReplies[reply.ThreadID] = append(threadReplies[reply.ThreadID], reply)
+	}
+
+	allThreads, err := threads.GetAllThreads()
+	if err != nil {
+		return err
+	}
+	for _, thread := range allThreads {
+		if !thread.Hidden {
+			docs = append(docs, ThreadDocument(thread, moduleCourses[thread.ModuleID], threadReplies[thread.KeyID]))
+		}
+	}
+
+	index.Replace(docs)
+	return nil
+}
+
+// RebuildEvery rebuilds the index now and then every interval until done is
+// closed, picking up content written through other instances
+func RebuildEvery(interval time.Duration, done <-chan struct{}, index *Index, courses models.CourseRepository,
+	modules models.ModuleRepository, elements models.ElementRepository, moduleElements models.ModuleElementRepository,
+	threads models.ThreadRepository, replies models.ReplyRepository) {
+	rebuild := func() {
+		started := time.Now()
+		if err := Rebuild(index, courses, modules, elements, moduleElements, threads, replies); err != nil {
+			log.Printf("Error rebuilding the search index: %v", err)
+			return
+		}
+		log.Printf("Rebuilt the search index: %d documents in %s", index.Len(), time.Since(started).Round(time.Millisecond))
+	}
+
+	go func() {
+		rebuild()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rebuild()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
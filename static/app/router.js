@@ -29,10 +29,10 @@ Router.prototype.add = function (path, handler) {
 Router.prototype.navigateTo = function (path, query, pop) {
 
   // debugger;
-  // get cookie via browser
+  // the session token itself is HttpOnly; session_expires is set beside it
   let sessionToken = document.cookie
     .split(";")
-    .find((c) => c.trim().startsWith("session_token="));
+    .find((c) => c.trim().startsWith("session_expires="));
 
   if (path != "/" && !sessionToken) {
       // Redirect to login page
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// GCS keeps blobs in a Google Cloud Storage bucket, which should not be
+// public; downloads go through URLs signed by the service account
+type GCS struct {
+	bucket *gcs.BucketHandle
+}
+
+// NewGCS connects to bucket with the service account in credentialsFile,
+// which also signs URLs
+func NewGCS(ctx context.Context, bucket string, credentialsFile string) (*GCS, error) {
+	client, err := gcs.NewClient(ctx, option.WithCredentialsFile(credentialsFile))
+	if err != nil {
+		return nil, err
+	}
+	return &GCS{bucket: client.Bucket(bucket)}, nil
+}
+
+// Put uploads the blob; the object is only created once the upload completes
+func (g *GCS) Put(ctx context.Context, name string, r io.Reader, info Info) error {
+	if !ValidName(name) {
+		return ErrInvalidName
+	}
+	w := g.bucket.Object(name).NewWriter(ctx)
+	w.ContentType = info.ContentType
+	if info.Filename != "" {
+		// signed URLs cannot carry headers here, so downloads are named by the object
+		w.ContentDisposition = mime.FormatMediaType("attachment", map[string]string{"filename": info.Filename})
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.CloseWithError(err)
+		return err
+	}
+	return w.Close()
+}
+
+// Open streams the object, which cannot seek
+func (g *GCS) Open(ctx context.Context, name string) (io.ReadCloser, Info, error) {
+	if !ValidName(name) {
+		return nil, Info{}, ErrInvalidName
+	}
+	attrs, err := g.attrs(ctx, name)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	reader, err := g.bucket.Object(name).Generation(attrs.Generation).NewReader(ctx)
+	if err != nil {
+		return nil, Info{}, notFound(err)
+	}
+	return reader, gcsInfo(attrs), nil
+}
+
+func (g *GCS) Stat(ctx context.Context, name string) (Info, error) {
+	if !ValidName(name) {
+		return Info{}, ErrInvalidName
+	}
+	attrs, err := g.attrs(ctx, name)
+	if err != nil {
+		return Info{}, err
+	}
+	return gcsInfo(attrs), nil
+}
+
+func (g *GCS) Delete(ctx context.Context, name string) error {
+	if !ValidName(name) {
+		return ErrInvalidName
+	}
+	if err := g.bucket.Object(name).Delete(ctx); err != nil && !errors.Is(err, gcs.ErrObjectNotExist) {
+		return err
+	}
+	return nil
+}
+
+// SignedURL signs a V4 URL to GET the object
+func (g *GCS) SignedURL(name string, expires time.Duration) (string, error) {
+	if !ValidName(name) {
+		return "", ErrInvalidName
+	}
+	signed, err := g.bucket.SignedURL(name, &gcs.SignedURLOptions{
+		Method:  http.MethodGet,
+		Expires: time.Now().Add(expires),
+		Scheme:  gcs.SigningSchemeV4,
+	})
+	if err != nil {
+		return "", fmt.Errorf("signing a URL for %s: %w", name, err)
+	}
+	return signed, nil
+}
+
+func (g *GCS) attrs(ctx context.Context, name string) (*gcs.ObjectAttrs, error) {
+	attrs, err := g.bucket.Object(name).Attrs(ctx)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return attrs, nil
+}
+
+func notFound(err error) error {
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func gcsInfo(attrs *gcs.ObjectAttrs) Info {
+	info := Info{Size: attrs.Size, ContentType: attrs.ContentType, Updated: attrs.Updated}
+	if _, params, err := mime.ParseMediaType(attrs.ContentDisposition); err == nil {
+		info.Filename = params["filename"]
+	}
+	return info
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Local keeps blobs in a directory on disk, which must not be served
+// statically. It cannot sign URLs.
+type Local struct {
+	dir string
+}
+
+// NewLocal creates a store in dir, which is created when the first blob is put
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+func (l *Local) path(name string) (string, error) {
+	if !ValidName(name) {
+		return "", ErrInvalidName
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(name)), nil
+}
+
+// Put writes the blob to a temporary file first, so that a failed write
+// does not leave half a file behind
+func (l *Local) Put(ctx context.Context, name string, r io.Reader, info Info) error {
+	target, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// Open returns the file, which can seek
+func (l *Local) Open(ctx context.Context, name string) (io.ReadCloser, Info, error) {
+	target, err := l.path(name)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	file, err := os.Open(target)
+	if os.IsNotExist(err) {
+		return nil, Info{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, Info{}, err
+	}
+	return file, localInfo(name, stat), nil
+}
+
+func (l *Local) Stat(ctx context.Context, name string) (Info, error) {
+	target, err := l.path(name)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		return Info{}, ErrNotFound
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return localInfo(name, stat), nil
+}
+
+func (l *Local) Delete(ctx context.Context, name string) error {
+	target, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) SignedURL(name string, expires time.Duration) (string, error) {
+	return "", ErrNoSignedURLs
+}
+
+// localInfo describes a file; plain files keep no content type or filename,
+// so they are guessed from the name
+func localInfo(name string, stat os.FileInfo) Info {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return Info{Size: stat.Size(), ContentType: contentType, Filename: path.Base(name), Updated: stat.ModTime()}
+}
@@ -0,0 +1,55 @@
+// Package storage keeps uploaded files out of the static directory, on local
+// disk or in Google Cloud Storage, to be served by handlers which check who
+// is asking or through short-lived signed URLs
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for a blob which does not exist
+	ErrNotFound = errors.New("blob not found")
+	// ErrNoSignedURLs is returned by stores which cannot sign URLs; their
+	// blobs are served by the application instead
+	ErrNoSignedURLs = errors.New("signed URLs are not supported")
+	// ErrInvalidName is returned for names which are not a clean relative path
+	ErrInvalidName = errors.New("invalid blob name")
+)
+
+// Info describes a blob
+type Info struct {
+	Size        int64
+	ContentType string
+	// Filename is what a download of the blob is saved as
+	Filename string
+	Updated  time.Time
+}
+
+// BlobStore stores files by name. Names are slash-separated relative paths,
+// such as "projects/6f1c….pdf".
+type BlobStore interface {
+	// Put stores r under name, replacing any blob there
+	Put(ctx context.Context, name string, r io.Reader, info Info) error
+	// Open reads a blob; the reader is an io.ReadSeeker when the store can seek
+	Open(ctx context.Context, name string) (io.ReadCloser, Info, error)
+	// Stat describes a blob without reading it
+	Stat(ctx context.Context, name string) (Info, error)
+	// Delete removes a blob; deleting one which does not exist is not an error
+	Delete(ctx context.Context, name string) error
+	// SignedURL returns a URL anyone can download the blob from until
+	// expires has passed, or ErrNoSignedURLs
+	SignedURL(name string, expires time.Duration) (string, error)
+}
+
+// ValidName reports whether name is a clean relative path which cannot
+// escape the store
+func ValidName(name string) bool {
+	return name != "" && name != "." && path.Clean(name) == name && !path.IsAbs(name) &&
+		name != ".." && !strings.HasPrefix(name, "../") && !strings.Contains(name, "\\")
+}
@@ -0,0 +1,185 @@
+// Package validation checks structs against their `validate` tags, e.g.
+//
+//	Name  string `json:"name" validate:"required,max=200"`
+//	Type  string `json:"type" validate:"oneof=content single multiple"`
+//	Email string `json:"email" validate:"email"`
+//
+// Rules are comma separated: required, min=N, max=N (length for strings and
+// slices, value for numbers), oneof=a b c, email and url. Apart from
+// required, rules are skipped for zero values. Nested structs and slices of
+// structs are validated too. Fields are reported by their JSON names.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError describes one invalid field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Struct validates v, a struct or a pointer to one, and returns every field error
+func Struct(v interface{}) []FieldError {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errors []FieldError
+	validateStruct(value, "", &errors)
+	return errors
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func validateStruct(value reflect.Value, prefix string, errors *[]FieldError) {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+
+		name := jsonName(field)
+		if name == "-" {
+			continue
+		}
+		path := prefix + name
+		fieldValue := value.Field(i)
+
+		if tag := field.Tag.Get("validate"); tag != "" {
+			for _, rule := range strings.Split(tag, ",") {
+				if message := check(fieldValue, strings.TrimSpace(rule)); message != "" {
+					*errors = append(*errors, FieldError{Field: path, Message: message})
+					break
+				}
+			}
+		}
+
+		validateNested(fieldValue, path, errors)
+	}
+}
+
+func validateNested(value reflect.Value, path string, errors *[]FieldError) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			validateNested(value.Elem(), path, errors)
+		}
+	case reflect.Struct:
+		if value.Type() != timeType {
+			validateStruct(value, path+".", errors)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			item := value.Index(i)
+			for item.Kind() == reflect.Ptr && !item.IsNil() {
+				item = item.Elem()
+			}
+			if item.Kind() == reflect.Struct && item.Type() != timeType {
+				validateStruct(item, fmt.Sprintf("%s[%d].", path, i), errors)
+			}
+		}
+	}
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// check returns a message if value breaks rule, or ""
+func check(value reflect.Value, rule string) string {
+	name, arg, _ := strings.Cut(rule, "=")
+
+	if name == "required" {
+		if isBlank(value) {
+			return "is required"
+		}
+		return ""
+	}
+	if isBlank(value) {
+		return ""
+	}
+
+	switch name {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return ""
+		}
+		size, unit := measure(value)
+		if name == "min" && size < limit {
+			return "must be at least " + arg + unit
+		}
+		if name == "max" && size > limit {
+			return "must be at most " + arg + unit
+		}
+	case "oneof":
+		options := strings.Fields(arg)
+		for _, option := range options {
+			if fmt.Sprint(value.Interface()) == option {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(options, ", ")
+	case "email":
+		address, err := mail.ParseAddress(value.String())
+		if err != nil || address.Address != value.String() {
+			return "must be an email address"
+		}
+	case "url":
+		parsed, err := url.ParseRequestURI(value.String())
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return "must be an http or https URL"
+		}
+	}
+	return ""
+}
+
+// measure returns what min and max compare against, and its unit
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(value.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), ""
+	}
+	return 0, ""
+}
+
+func isBlank(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return value.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	}
+	return value.IsZero()
+}
@@ -0,0 +1,160 @@
+package xapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// how many statements wait to be sent before new ones are dropped
+const queueSize = 1000
+
+// Emitter sends statements to an LRS in the background, retrying failures.
+// A nil Emitter, or one without an endpoint, sends nothing.
+type Emitter struct {
+	endpoint string
+	username string
+	password string
+	// HomePage is the base of actor accounts and activity IDs
+	HomePage string
+
+	client *http.Client
+	queue  chan Statement
+}
+
+// NewEmitter creates an emitter posting to endpoint (the LRS base URL, to
+// which /statements is added), or a disabled one if endpoint is ""
+func NewEmitter(endpoint, username, password, homePage string) *Emitter {
+	return &Emitter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		username: username,
+		password: password,
+		HomePage: strings.TrimSuffix(homePage, "/"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan Statement, queueSize),
+	}
+}
+
+// Enabled reports whether statements are sent
+func (e *Emitter) Enabled() bool {
+	return e != nil && e.endpoint != ""
+}
+
+// Start sends queued statements until done is closed
+func (e *Emitter) Start(done <-chan struct{}) {
+	if !e.Enabled() {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case statement := <-e.queue:
+				e.deliver(statement)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Send queues a statement, filling in its ID and timestamp
+func (e *Emitter) Send(statement Statement) {
+	if !e.Enabled() {
+		return
+	}
+	if statement.ID == "" {
+		statement.ID = uuid.NewString()
+	}
+	if statement.Timestamp.IsZero() {
+		statement.Timestamp = time.Now().UTC()
+	}
+	select {
+	case e.queue <- statement:
+	default:
+		log.Printf("xAPI queue full: dropping statement %s", statement.ID)
+	}
+}
+
+// deliver posts a statement, retrying with backoff; the LRS ignores a
+// statement ID it already has, so retries are safe
+func (e *Emitter) deliver(statement Statement) {
+	body, err := json.Marshal(statement)
+	if err != nil {
+		log.Printf("Error encoding xAPI statement: %v", err)
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := e.post(body)
+		if err == nil {
+			return
+		}
+		if attempt == 4 {
+			log.Printf("Error sending xAPI statement %s: %v", statement.ID, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (e *Emitter) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.endpoint+"/statements", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Experience-API-Version", Version)
+	if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	// 409: the LRS has a different statement with this ID; retrying won't help
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("LRS answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Learner is the actor for a user of this application
+func (e *Emitter) Learner(userID int64, username string) Actor {
+	return Actor{ObjectType: "Agent", Name: username, Account: Account{HomePage: e.HomePage, Name: strconv.FormatInt(userID, 10)}}
+}
+
+// Module is the activity of a module
+func (e *Emitter) Module(moduleID int64, name string) Activity {
+	return e.activity("/module/"+strconv.FormatInt(moduleID, 10), name, ModuleActivity)
+}
+
+// Course is the activity of a course
+func (e *Emitter) Course(courseID int64, name string) Activity {
+	return e.activity("/course/"+strconv.FormatInt(courseID, 10), name, CourseActivity)
+}
+
+func (e *Emitter) activity(path, name, kind string) Activity {
+	definition := ActivityDefinition{Type: kind}
+	if name != "" {
+		definition.Name = map[string]string{"en-US": name}
+	}
+	return Activity{ObjectType: "Activity", ID: e.HomePage + path, Definition: definition}
+}
+
+// Duration formats seconds as an ISO 8601 duration
+func Duration(seconds int) string {
+	return "PT" + strconv.Itoa(seconds) + "S"
+}
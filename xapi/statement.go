@@ -0,0 +1,89 @@
+// Package xapi sends xAPI (Experience API 1.0.3) statements about learners'
+// attempts to a Learning Record Store
+package xapi
+
+import (
+	"time"
+)
+
+// Version is sent as X-Experience-API-Version
+const Version = "1.0.3"
+
+// Verbs the application reports
+var (
+	Attempted = Verb{ID: "http://adlnet.gov/expapi/verbs/attempted", Display: map[string]string{"en-US": "attempted"}}
+	Completed = Verb{ID: "http://adlnet.gov/expapi/verbs/completed", Display: map[string]string{"en-US": "completed"}}
+	Passed    = Verb{ID: "http://adlnet.gov/expapi/verbs/passed", Display: map[string]string{"en-US": "passed"}}
+	Failed    = Verb{ID: "http://adlnet.gov/expapi/verbs/failed", Display: map[string]string{"en-US": "failed"}}
+)
+
+// Activity types of the objects
+const (
+	ModuleActivity = "http://adlnet.gov/expapi/activities/module"
+	CourseActivity = "http://adlnet.gov/expapi/activities/course"
+)
+
+// Statement is an xAPI statement: actor, verb, object and optional result
+type Statement struct {
+	ID        string    `json:"id"`
+	Actor     Actor     `json:"actor"`
+	Verb      Verb      `json:"verb"`
+	Object    Activity  `json:"object"`
+	Result    *Result   `json:"result,omitempty"`
+	Context   *Context  `json:"context,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Actor identifies a learner by their account in this application
+type Actor struct {
+	ObjectType string  `json:"objectType"`
+	Name       string  `json:"name,omitempty"`
+	Account    Account `json:"account"`
+}
+
+// Account is an account on HomePage
+type Account struct {
+	HomePage string `json:"homePage"`
+	Name     string `json:"name"`
+}
+
+// Verb is what the actor did
+type Verb struct {
+	ID      string            `json:"id"`
+	Display map[string]string `json:"display"`
+}
+
+// Activity is what the actor did it to
+type Activity struct {
+	ObjectType string             `json:"objectType"`
+	ID         string             `json:"id"`
+	Definition ActivityDefinition `json:"definition"`
+}
+
+// ActivityDefinition names an activity
+type ActivityDefinition struct {
+	Name map[string]string `json:"name,omitempty"`
+	Type string            `json:"type"`
+}
+
+// Result is the outcome of an attempt
+type Result struct {
+	Score      *Score `json:"score,omitempty"`
+	Success    *bool  `json:"success,omitempty"`
+	Completion *bool  `json:"completion,omitempty"`
+	// Duration is ISO 8601, e.g. PT1M30S
+	Duration string `json:"duration,omitempty"`
+}
+
+// Score of a result; Scaled is between -1 and 1
+type Score struct {
+	Scaled float64 `json:"scaled"`
+	Raw    float64 `json:"raw"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+// Context places the activity, e.g. a module in its course
+type Context struct {
+	ContextActivities map[string][]Activity `json:"contextActivities,omitempty"`
+}
@@ -0,0 +1,99 @@
+package xapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// StubLRS is an in-memory LRS for development and testing. It accepts
+// statements at POST /statements (one or an array) and lists them, newest
+// first, at GET /statements, optionally filtered by ?verb=.
+type StubLRS struct {
+	mu         sync.Mutex
+	statements []json.RawMessage
+	ids        map[string]bool
+}
+
+// NewStubLRS ..
+func NewStubLRS() *StubLRS {
+	return &StubLRS{ids: map[string]bool{}}
+}
+
+func (s *StubLRS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimSuffix(r.URL.Path, "/") != "/statements" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("X-Experience-API-Version", Version)
+
+	switch r.Method {
+	case http.MethodPost:
+		s.store(w, r)
+	case http.MethodGet:
+		s.list(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *StubLRS) store(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	batch := []json.RawMessage{body}
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		if err := json.Unmarshal(body, &batch); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, raw := range batch {
+		var statement struct {
+			ID    string `json:"id"`
+			Actor *Actor `json:"actor"`
+			Verb  *Verb  `json:"verb"`
+		}
+		if err := json.Unmarshal(raw, &statement); err != nil || statement.Actor == nil || statement.Verb == nil {
+			http.Error(w, "a statement needs an actor and a verb", http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, statement.ID)
+		if statement.ID != "" && s.ids[statement.ID] {
+			continue
+		}
+		s.ids[statement.ID] = true
+		s.statements = append(s.statements, raw)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ids)
+}
+
+func (s *StubLRS) list(w http.ResponseWriter, r *http.Request) {
+	verb := r.URL.Query().Get("verb")
+
+	s.mu.Lock()
+	statements := []json.RawMessage{}
+	for i := len(s.statements) - 1; i >= 0; i-- {
+		if verb != "" {
+			var statement Statement
+			if json.Unmarshal(s.statements[i], &statement) != nil || statement.Verb.ID != verb {
+				continue
+			}
+		}
+		statements = append(statements, s.statements[i])
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"statements": statements, "more": ""})
+}